@@ -2,15 +2,70 @@ package channels
 
 import (
 	"sync"
+	"time"
 )
 
 /*
 	Structure of a channel
+	Keeps track of granual timestamps for changes
 */
+type keyIdRecord struct {
+	KeyId     string    `json:"keyId"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
 type channelRecord struct {
-	id    string
-	keyId string
-	lock  *sync.RWMutex
+	Id        string      `json:"id"`
+	KeyId     keyIdRecord `json:"keyId"`
+	CreatedAt time.Time   `json:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+	lock      *sync.RWMutex
+}
+
+func makeSearchByIdChannelRecord(id string) *channelRecord {
+	return &channelRecord{
+		Id: id,
+	}
+}
+
+/*
+	Record creation/update from requests
+*/
+func (rec *channelRecord) create(rq *ChannelRequest) {
+	rec.Id = rq.Data.Id
+	rec.KeyId = keyIdRecord{
+		KeyId:     rq.Data.KeyId,
+		UpdatedAt: rq.Timestamp,
+	}
+	rec.CreatedAt = rq.Timestamp
+	rec.UpdatedAt = rq.Timestamp
+}
+
+/*
+	Applies update if it's more recent than the last one (returns whether it was applied)
+	Record lock should be held
+*/
+func (rec *channelRecord) applyUpdateRequest(rq *ChannelRequest) bool {
+	if !rq.Timestamp.After(rec.KeyId.UpdatedAt) {
+		return false
+	}
+	rec.KeyId = keyIdRecord{
+		KeyId:     rq.Data.KeyId,
+		UpdatedAt: rq.Timestamp,
+	}
+	rec.UpdatedAt = rq.Timestamp
+	return true
+}
+
+/*
+	Record -> external object
+	Record lock should be held
+*/
+func (obj *ChannelObject) createFromRecord(rec *channelRecord) {
+	obj.Id = rec.Id
+	obj.KeyId = rec.KeyId.KeyId
+	obj.CreatedAt = rec.CreatedAt
+	obj.UpdatedAt = rec.UpdatedAt
 }
 
 /*
@@ -19,9 +74,9 @@ type channelRecord struct {
 func (rec *channelRecord) Less(index string, than interface{}) bool {
 	switch index {
 	case channelIndexId:
-		return rec.id < than.(*channelRecord).id
+		return rec.Id < than.(*channelRecord).Id
 	case channelIndexKeyId:
-		return rec.keyId < than.(*channelRecord).keyId
+		return rec.KeyId.KeyId < than.(*channelRecord).KeyId.KeyId
 	}
 	return false
 }
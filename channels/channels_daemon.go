@@ -54,6 +54,72 @@ func shutdownChannelsServer() {
 	Functional API
 */
 
+/*
+	Lambda to send a request to channels subsystem
+*/
+type Requester func([]byte) (chan *ChannelResponse, []error)
+
+/*
+	Error returned when a request can't be handed to the server (not running or shutting down)
+	Unlike errors in the request itself, making the same request later can succeed
+*/
+type UnavailableError struct {
+	Err error
+}
+
+func (err *UnavailableError) Error() string {
+	return err.Err.Error()
+}
+
+/*
+	Determines if errors returned by a requester only come from the server being unavailable
+*/
+func IsUnavailable(errs []error) bool {
+	if len(errs) == 0 {
+		return false
+	}
+	for _, err := range errs {
+		if _, ok := err.(*UnavailableError); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func MakeRequest(rawRequest []byte) (chan *ChannelResponse, []error) {
+	log.Debugf(channelsReceivedRequestLogMsg)
+
+	// Build request object
+	rq := &ChannelRequest{}
+	if err := rq.Decode(rawRequest); err != nil {
+		return nil, []error{err}
+	}
+
+	// Sanitize request
+	if errs := rq.sanitizeAndCheckParams(); len(errs) != 0 {
+		return nil, errs
+	}
+
+	// Make request to server
+	nativeResponseChannel, err := channelsServerHandler.MakeRequest(rq)
+	if err != nil {
+		return nil, []error{&UnavailableError{Err: err}}
+	}
+
+	// Pass through result
+	responseChannel := make(chan *ChannelResponse)
+	go func() {
+		nativeResponse, ok := <-nativeResponseChannel
+		if ok {
+			responseChannel <- (*nativeResponse).(*ChannelResponse)
+		} else {
+			close(responseChannel)
+		}
+	}()
+
+	return responseChannel, nil
+}
+
 /*
 	Server implementation
 */
@@ -72,10 +138,58 @@ func (sv *channelsServer) Shutdown() error {
 	return nil
 }
 
-func (sv *channelsServer) Work(rq *gofarm.Request) (dummyReturnVal *gofarm.Response) {
+func (sv *channelsServer) Work(request *gofarm.Request) *gofarm.Response {
 	log.Debugf(channelsRunningRequestLogMsg)
 
-	dummyReturnVal = nil
+	rq := (*request).(*ChannelRequest)
+
+	switch rq.Type {
+	case CreateRequest:
+		// Add record unless channel already exists
+		newChannel := &channelRecord{
+			lock: &sync.RWMutex{},
+		}
+		newChannel.create(rq)
+		if channelsStore.AddOrGet(newChannel) != newChannel {
+			return failChannelsRequest(ChannelExistsError)
+		}
+		return successChannelsRequest(newChannel)
+
+	case UpdateRequest:
+		// Atomically apply request to record in memstore
+		updateFunc := func(obj memstore.Item) (memstore.Item, bool) {
+			record := obj.(*channelRecord)
+			record.Lock()
+			defer record.Unlock()
+			record.applyUpdateRequest(rq)
+			return record, true
+		}
+		modifiedRecord := channelsStore.UpdateWithIndexes(makeSearchByIdChannelRecord(rq.Data.Id), channelIndexId, updateFunc)
+		if modifiedRecord == nil {
+			return failChannelsRequest(ChannelUnknownError)
+		}
+		return successChannelsRequest(modifiedRecord.(*channelRecord))
+	}
+
+	return nil
+}
 
-	return
+func failChannelsRequest(responseCode int) *gofarm.Response {
+	var nativeResp gofarm.Response = &ChannelResponse{
+		Result: responseCode,
+		Data:   []ChannelObject{},
+	}
+	return &nativeResp
+}
+
+func successChannelsRequest(record *channelRecord) *gofarm.Response {
+	record.RLock()
+	defer record.RUnlock()
+	channelObject := ChannelObject{}
+	channelObject.createFromRecord(record)
+	var nativeResp gofarm.Response = &ChannelResponse{
+		Result: Success,
+		Data:   []ChannelObject{channelObject},
+	}
+	return &nativeResp
 }
@@ -2,6 +2,7 @@ package channels
 
 import (
 	"testing"
+	"time"
 )
 
 func TestChannelsStartShutdown(t *testing.T) {
@@ -10,3 +11,58 @@ func TestChannelsStartShutdown(t *testing.T) {
 	}
 	shutdownChannelsServer()
 }
+
+func makeChannelRequest(t *testing.T, requestType int, id string, keyId string, timestamp time.Time) *ChannelResponse {
+	rq := &ChannelRequest{
+		Type: requestType,
+		Data: ChannelObject{
+			Id:    id,
+			KeyId: keyId,
+		},
+		Timestamp: timestamp,
+	}
+	rqEncoded, _ := rq.Encode()
+	channel, errs := MakeRequest(rqEncoded)
+	if len(errs) != 0 {
+		t.Errorf("Channel request should not fail. errs=%v", errs)
+		return nil
+	}
+	return <-channel
+}
+
+func TestChannelsCreateUpdate(t *testing.T) {
+	if !resetAndStartChannelsServer(t, multipleWorkersChannelsConfig()) {
+		return
+	}
+	defer shutdownChannelsServer()
+
+	createdAt := time.Now()
+	resp := makeChannelRequest(t, CreateRequest, "CHANNEL", "KEY_1", createdAt)
+	if resp == nil || resp.Result != Success || len(resp.Data) != 1 ||
+		resp.Data[0].KeyId != "KEY_1" || !resp.Data[0].CreatedAt.Equal(createdAt) {
+		t.Errorf("Channel creation should succeed. resp=%+v", resp)
+	}
+
+	// Duplicate creation
+	resp = makeChannelRequest(t, CreateRequest, "CHANNEL", "KEY_2", createdAt)
+	if resp == nil || resp.Result != ChannelExistsError {
+		t.Errorf("Duplicate channel creation should fail. resp=%+v", resp)
+	}
+
+	// Update (stale updates are ignored)
+	updatedAt := createdAt.Add(time.Second)
+	resp = makeChannelRequest(t, UpdateRequest, "CHANNEL", "KEY_2", updatedAt)
+	if resp == nil || resp.Result != Success || resp.Data[0].KeyId != "KEY_2" || !resp.Data[0].UpdatedAt.Equal(updatedAt) {
+		t.Errorf("Channel update should succeed. resp=%+v", resp)
+	}
+	resp = makeChannelRequest(t, UpdateRequest, "CHANNEL", "KEY_3", createdAt)
+	if resp == nil || resp.Result != Success || resp.Data[0].KeyId != "KEY_2" {
+		t.Errorf("Stale channel update should be ignored. resp=%+v", resp)
+	}
+
+	// Unknown channel
+	resp = makeChannelRequest(t, UpdateRequest, "UNKNOWN", "KEY_4", updatedAt)
+	if resp == nil || resp.Result != ChannelUnknownError {
+		t.Errorf("Update of unknown channel should fail. resp=%+v", resp)
+	}
+}
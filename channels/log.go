@@ -5,9 +5,10 @@ package channels
 */
 const (
 	// Channels daemon
-	channelsDaemonStartLogMsg    string = "Channels daemon started"
-	channelsDaemonShutdownLogMsg string = "Channels daemon shutdown"
-	channelsRunningRequestLogMsg string = "Channels running request"
+	channelsDaemonStartLogMsg     string = "Channels daemon started"
+	channelsDaemonShutdownLogMsg  string = "Channels daemon shutdown"
+	channelsRunningRequestLogMsg  string = "Channels running request"
+	channelsReceivedRequestLogMsg string = "Channels received request"
 
 	// Messages daemon
 	messagesDaemonStartLogMsg    string = "Channel messages daemon started"
@@ -0,0 +1,99 @@
+package channels
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mngharbi/DMPC/core"
+	"time"
+)
+
+/*
+	External structure of a channel
+*/
+type ChannelObject struct {
+	Id        string    `json:"id"`
+	KeyId     string    `json:"keyId"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+/*
+	External structure of a channel related request
+*/
+const (
+	CreateRequest = iota
+	UpdateRequest
+)
+
+type ChannelRequest struct {
+	Type      int           `json:"type"`
+	Data      ChannelObject `json:"data"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+/*
+	External structure of a channel related response
+*/
+const (
+	Success = iota
+	ChannelExistsError
+	ChannelUnknownError
+)
+
+type ChannelResponse struct {
+	Result int             `json:"result"`
+	Data   []ChannelObject `json:"data"`
+}
+
+/*
+	Errors
+*/
+var (
+	unknownRequestTypeError error = errors.New("Unknown channel request type.")
+	channelIdMissingError   error = errors.New("Channel id missing.")
+	keyIdMissingError       error = errors.New("Channel key id missing.")
+)
+
+/*
+	Channel request decoding/checking
+*/
+
+// Json -> *ChannelRequest
+func (rq *ChannelRequest) Decode(stream []byte) error {
+	return json.Unmarshal(stream, rq)
+}
+
+// *ChannelRequest -> Json (canonical since requests are signed as operation payloads)
+func (rq *ChannelRequest) Encode() ([]byte, error) {
+	return core.CanonicalJSON(rq)
+}
+
+func (rq *ChannelRequest) sanitizeAndCheckParams() []error {
+	res := []error{}
+
+	if !(CreateRequest <= rq.Type && rq.Type <= UpdateRequest) {
+		res = append(res, unknownRequestTypeError)
+	}
+	if len(rq.Data.Id) == 0 {
+		res = append(res, channelIdMissingError)
+	}
+	if len(rq.Data.KeyId) == 0 {
+		res = append(res, keyIdMissingError)
+	}
+
+	return res
+}
+
+/*
+	Channel response encoding
+*/
+
+// *ChannelResponse -> Json
+func (resp *ChannelResponse) Encode() ([]byte, error) {
+	return json.Marshal(resp)
+}
+
+// Json -> *ChannelResponse
+func (resp *ChannelResponse) Decode(stream []byte) error {
+	return json.Unmarshal(stream, resp)
+}
@@ -0,0 +1,31 @@
+/*
+	Deterministic JSON encoding of structured data that gets signed
+*/
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+/*
+	Encodes a value as JSON with object keys sorted at every level
+	(the output only depends on the JSON values, not on field declaration order,
+	and numbers are kept as they were encoded)
+*/
+func CanonicalJSON(v interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects decode into maps, which are encoded with sorted keys
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
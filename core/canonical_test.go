@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	type nested struct {
+		Zeta  int    `json:"zeta"`
+		Alpha string `json:"alpha"`
+	}
+	type first struct {
+		Payload string            `json:"payload"`
+		Count   float64           `json:"count"`
+		Nested  nested            `json:"nested"`
+		Labels  map[string]string `json:"labels"`
+		List    []int             `json:"list"`
+	}
+	type second struct {
+		List   []int             `json:"list"`
+		Labels map[string]string `json:"labels"`
+		Nested struct {
+			Alpha string `json:"alpha"`
+			Zeta  int    `json:"zeta"`
+		} `json:"nested"`
+		Count   float64 `json:"count"`
+		Payload string  `json:"payload"`
+	}
+
+	a := first{
+		Payload: "PAYLOAD",
+		Count:   12345678901234,
+		Nested:  nested{Zeta: 1, Alpha: "A"},
+		Labels:  map[string]string{"b": "2", "a": "1"},
+		List:    []int{3, 1, 2},
+	}
+	b := second{
+		List:    []int{3, 1, 2},
+		Labels:  map[string]string{"a": "1", "b": "2"},
+		Count:   12345678901234,
+		Payload: "PAYLOAD",
+	}
+	b.Nested.Alpha = "A"
+	b.Nested.Zeta = 1
+
+	aCanonical, errA := CanonicalJSON(a)
+	bCanonical, errB := CanonicalJSON(b)
+	expected := `{"count":12345678901234,"labels":{"a":"1","b":"2"},"list":[3,1,2],"nested":{"alpha":"A","zeta":1},"payload":"PAYLOAD"}`
+	if errA != nil || errB != nil || string(aCanonical) != expected || string(bCanonical) != expected {
+		t.Errorf("Equal values should have the same canonical encoding.\n a=%s\n b=%s\n expected=%s\n errs=%v %v", aCanonical, bCanonical, expected, errA, errB)
+	}
+
+	if _, err := CanonicalJSON(func() {}); err == nil {
+		t.Errorf("Values that can't be encoded should fail.")
+	}
+}
@@ -0,0 +1,62 @@
+/*
+	Sources of current time for time based logic
+*/
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+/*
+	Clock reading the system time (used by default)
+*/
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var SystemClock Clock = systemClock{}
+
+/*
+	Returns the clock given, or the system clock if nil
+*/
+func ClockOrSystem(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
+
+/*
+	Clock only moving forward when advanced (used for testing)
+*/
+type ManualClock struct {
+	now  time.Time
+	lock *sync.Mutex
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{
+		now:  start,
+		lock: &sync.Mutex{},
+	}
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *ManualClock) Advance(duration time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(duration)
+}
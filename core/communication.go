@@ -24,6 +24,6 @@ type UsersSignKeyRequester func([]string) ([]*rsa.PublicKey, error)
 type KeyAdder func(keyId string, key []byte) error
 
 /*
-	Function to decrypt by key id
+	Function to decrypt by key id with the symmetric algorithm and associated data given
 */
-type Decryptor func(keyId string, nonce []byte, ciphertext []byte) ([]byte, error)
+type Decryptor func(keyId string, algorithm AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error)
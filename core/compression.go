@@ -0,0 +1,121 @@
+/*
+	Payload compression applied before encryption
+*/
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+)
+
+/*
+	Compression algorithms
+	(no compression is the default when no algorithm is specified)
+*/
+type CompressionAlgorithm int
+
+const (
+	NoCompression CompressionAlgorithm = iota
+	GzipCompression
+	ZstdCompression
+)
+
+/*
+	Errors
+*/
+var (
+	invalidCompressionError error = errors.New("Invalid compression algorithm provided.")
+	compressionError        error = errors.New("Payload compression failed.")
+	decompressionError      error = errors.New("Payload decompression failed.")
+)
+
+/*
+	Decompressed payload exceeding the maximum size (exported so callers can tell it from corrupt payloads)
+*/
+var ErrDecompressedTooLarge error = errors.New("Decompressed payload exceeds maximum size.")
+
+/*
+	Maximum size of decompressed payloads when no limit is given
+	(a few kilobytes of compressed payload can otherwise expand to gigabytes)
+*/
+const DefaultMaxDecompressedBytes int = 64 << 20
+
+func Compress(algorithm CompressionAlgorithm, payload []byte) ([]byte, error) {
+	switch algorithm {
+	case NoCompression:
+		return payload, nil
+	case GzipCompression:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(payload); err != nil {
+			return nil, compressionError
+		}
+		if err := writer.Close(); err != nil {
+			return nil, compressionError
+		}
+		return buf.Bytes(), nil
+	case ZstdCompression:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, compressionError
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(payload, nil), nil
+	}
+	return nil, invalidCompressionError
+}
+
+func Decompress(algorithm CompressionAlgorithm, payload []byte) ([]byte, error) {
+	return DecompressWithLimit(algorithm, payload, 0)
+}
+
+/*
+	Decompresses without ever holding more than the maximum size (default maximum if not positive)
+	(uncompressed payloads are returned as is)
+*/
+func DecompressWithLimit(algorithm CompressionAlgorithm, payload []byte, maxBytes int) ([]byte, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxDecompressedBytes
+	}
+
+	switch algorithm {
+	case NoCompression:
+		return payload, nil
+	case GzipCompression:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, decompressionError
+		}
+		defer reader.Close()
+
+		// Read one byte past the maximum to detect oversized payloads
+		decompressed, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxBytes)+1))
+		if err != nil {
+			return nil, decompressionError
+		}
+		if len(decompressed) > maxBytes {
+			return nil, ErrDecompressedTooLarge
+		}
+		return decompressed, nil
+	case ZstdCompression:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxBytes)))
+		if err != nil {
+			return nil, decompressionError
+		}
+		defer decoder.Close()
+		decompressed, err := decoder.DecodeAll(payload, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || len(decompressed) > maxBytes {
+			return nil, ErrDecompressedTooLarge
+		}
+		if err != nil {
+			return nil, decompressionError
+		}
+		return decompressed, nil
+	}
+	return nil, invalidCompressionError
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"field":"value"}`), 100)
+	for _, algorithm := range []CompressionAlgorithm{NoCompression, GzipCompression, ZstdCompression} {
+		compressed, err := Compress(algorithm, payload)
+		if err != nil {
+			t.Errorf("Compression failed. algorithm=%v, err=%v", algorithm, err)
+			continue
+		}
+		if algorithm != NoCompression && len(compressed) >= len(payload) {
+			t.Errorf("Compression should reduce payload size. algorithm=%v, found=%v, original=%v", algorithm, len(compressed), len(payload))
+		}
+		decompressed, err := Decompress(algorithm, compressed)
+		if err != nil || !bytes.Equal(decompressed, payload) {
+			t.Errorf("Compress/decompress test failed. algorithm=%v, err=%v", algorithm, err)
+		}
+	}
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	for _, algorithm := range []CompressionAlgorithm{GzipCompression, ZstdCompression} {
+		if _, err := Decompress(algorithm, []byte("NOT COMPRESSED")); err != decompressionError {
+			t.Errorf("Decompression should fail with invalid payload. algorithm=%v, err=%v", algorithm, err)
+		}
+	}
+
+	if _, err := Compress(ZstdCompression+1, []byte{}); err != invalidCompressionError {
+		t.Errorf("Compression should fail with unknown algorithm. err=%v", err)
+	}
+	if _, err := Decompress(ZstdCompression+1, []byte{}); err != invalidCompressionError {
+		t.Errorf("Decompression should fail with unknown algorithm. err=%v", err)
+	}
+}
+
+func TestPermanentCompressedOperation(t *testing.T) {
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	requestPayload := bytes.Repeat([]byte("REQUEST_PAYLOAD"), 100)
+	for _, algorithm := range []CompressionAlgorithm{GzipCompression, ZstdCompression} {
+		encryptedOperation, _, _, err := GenerateOperationWithEncryptionAndTracker(
+			"KEY_ID",
+			permanentKey,
+			generateRandomBytes(SymmetricNonceSize),
+			1,
+			requestPayload,
+			"ISSUER",
+			dummyByteToByteTransformer,
+			"CERTIFIER",
+			dummyByteToByteTransformer,
+			algorithm,
+			nil,
+		)
+		if err != nil {
+			t.Errorf("Compressed operation generation failed. algorithm=%v, err=%v", algorithm, err)
+			continue
+		}
+
+		// Encode/decode should keep compression field
+		encodedOperation, _ := encryptedOperation.Encode()
+		var decodedOperation Operation
+		if err := decodedOperation.Decode(encodedOperation); err != nil ||
+			decodedOperation.Encryption.Compression != algorithm {
+			t.Errorf("Compression field should survive encoding. algorithm=%v, err=%v", algorithm, err)
+		}
+
+		decryptedPayload, err := decodedOperation.Decrypt(
+			DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+		)
+		if err != nil || !reflect.DeepEqual(decryptedPayload, requestPayload) {
+			t.Errorf("Compressed operation decryption failed. algorithm=%v, err=%v", algorithm, err)
+		}
+	}
+}
+
+func TestPermanentMissingCompression(t *testing.T) {
+	var op Operation
+	if err := op.Decode([]byte(`{"encryption":{"encrypted":false},"payload":"UEFZTE9BRA=="}`)); err != nil ||
+		op.Encryption.Compression != NoCompression {
+		t.Errorf("Missing compression field should default to no compression. err=%v", err)
+		return
+	}
+
+	payload, err := op.Decrypt(DecryptorFunctor(nil, false))
+	if err != nil || string(payload) != "PAYLOAD" {
+		t.Errorf("Operation without compression should decrypt as is. found=%v, err=%v", payload, err)
+	}
+}
+
+func TestDecompressionBomb(t *testing.T) {
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	bomb := make([]byte, 1<<20)
+	for _, algorithm := range []CompressionAlgorithm{GzipCompression, ZstdCompression} {
+		compressed, _ := Compress(algorithm, bomb)
+		if _, err := DecompressWithLimit(algorithm, compressed, 64<<10); err != ErrDecompressedTooLarge {
+			t.Errorf("Decompression over the limit should fail. algorithm=%v, err=%v", algorithm, err)
+		}
+		if decompressed, err := DecompressWithLimit(algorithm, compressed, len(bomb)); err != nil || len(decompressed) != len(bomb) {
+			t.Errorf("Decompression up to the limit should pass. algorithm=%v, err=%v", algorithm, err)
+		}
+
+		// Limit applies while decrypting operations
+		encryptedOperation, _, _, err := GenerateOperationWithEncryptionAndTracker(
+			"KEY_ID",
+			permanentKey,
+			generateRandomBytes(SymmetricNonceSize),
+			1,
+			bomb,
+			"ISSUER",
+			dummyByteToByteTransformer,
+			"CERTIFIER",
+			dummyByteToByteTransformer,
+			algorithm,
+			nil,
+		)
+		if err != nil {
+			t.Errorf("Compressed operation generation failed. algorithm=%v, err=%v", algorithm, err)
+			continue
+		}
+		if len(encryptedOperation.Payload) > 16<<10 {
+			t.Errorf("Bomb payload should be small once compressed. algorithm=%v, size=%v", algorithm, len(encryptedOperation.Payload))
+		}
+		_, err = encryptedOperation.DecryptWithLimit(
+			DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+			64<<10,
+		)
+		if err != ErrDecompressedTooLarge {
+			t.Errorf("Operation decryption over the limit should fail. algorithm=%v, err=%v", algorithm, err)
+		}
+	}
+}
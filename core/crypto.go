@@ -1,15 +1,27 @@
 package core
 
 import (
+	"context"
 	"crypto"
+	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/chacha20poly1305"
 	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -18,6 +30,7 @@ import (
 
 const (
 	AsymmetricKeySizeBits         = 2048
+	minAsymmetricKeySizeBits      = 2048
 	AsymmetricKeySizeBytes        = 256
 	maxAsymmetricCiphertextLength = AsymmetricKeySizeBytes - 11
 	HashingAlgorithm              = crypto.SHA256
@@ -26,6 +39,19 @@ const (
 	CorrectChallenge              = "Nizar Gharbi"
 )
 
+/*
+	Symmetric (AEAD) algorithms
+	(ChaCha20-Poly1305 is the default when no algorithm is specified)
+*/
+type AeadAlgorithm int
+
+const (
+	ChaCha20Poly1305AeadAlgorithm AeadAlgorithm = iota
+	Aes256GcmAeadAlgorithm
+)
+
+const aes256KeySize = 32
+
 /*
 	Errors
 */
@@ -34,7 +60,6 @@ var (
 	invalidNonceError              error = errors.New("Invalid nonce provided.")
 	invalidSymmetricKeyError       error = errors.New("Invalid key provided.")
 	aeadCreationError              error = errors.New("Aead creation failed.")
-	noSymmetricKeyFoundError       error = errors.New("No symmetric key passed the challenge.")
 	signError                      error = errors.New("Signing failed.")
 	asymmetrictEncryptionError     error = errors.New("Asymmetric encryption failed.")
 	asymmetrictDecryptionError     error = errors.New("Asymmetric decryption failed.")
@@ -46,26 +71,112 @@ var (
 	invalidSignatureEncodingError  error = errors.New("Invalid signature encoding.")
 	invalidIssuerSignatureError    error = errors.New("Invalid issuer signature provided.")
 	invalidCertifierSignatureError error = errors.New("Invalid certifier signature provided.")
+	invalidPublicKeyError          error = errors.New("Invalid public key provided.")
+	publicKeyMarshalError          error = errors.New("Public key marshalling failed.")
+	invalidAsymmetricKeySizeError  error = errors.New("Invalid asymmetric key size provided.")
+	invalidAeadAlgorithmError      error = errors.New("Invalid symmetric algorithm provided.")
+	invalidPrivateKeyError         error = errors.New("Invalid private key provided.")
+	privateKeyMarshalError         error = errors.New("Private key marshalling failed.")
+	keyDerivationError             error = errors.New("Passphrase key derivation failed.")
+	wrongPassphraseError           error = errors.New("Wrong passphrase provided.")
+	encryptedPrivateKeyDecodeError error = errors.New("Encrypted private key decoding failed.")
+	invalidOperationError          error = errors.New("Invalid operation provided.")
+	unsupportedVersionError        error = errors.New("Unsupported transaction version.")
+	encryptedPayloadError          error = errors.New("Operation payload is encrypted.")
+	signerKeyNotFoundError         error = errors.New("Signer key not found by ID.")
+	invalidHashAlgorithmError      error = errors.New("Invalid hash algorithm provided.")
+	notEncryptedError              error = errors.New("Operation payload is not encrypted.")
+	boundSignaturesError           error = errors.New("Operation signatures are bound to its key id and nonce.")
+	reEncryptionMismatchError      error = errors.New("Re-encrypted payload does not match the original.")
+	unsignedMetadataError          error = errors.New("Validity window and dry run are only signed by current operation versions.")
+	trailingPemDataError           error = errors.New("Unexpected extra data after PEM block.")
 )
 
+/*
+	Transaction decryption errors
+	(exported so relays can skip transactions not addressed to them,
+	challenge mismatches are only reported for keys wrapped with a label)
+*/
+var (
+	ErrNotARecipient     error = errors.New("No challenge entry decrypts with the key provided.")
+	ErrChallengeMismatch error = errors.New("Symmetric key decrypted but did not pass the challenge.")
+	ErrPayloadAuth       error = errors.New("Payload authentication failed.")
+	ErrNoChallenges      error = errors.New("Encrypted transaction has no challenges.")
+	ErrTooManyChallenges error = errors.New("Transaction has more challenges than allowed.")
+)
+
+/*
+	Nonce size mismatch error (identifies expected and actual sizes)
+*/
+type NonceSizeError struct {
+	Expected int
+	Actual   int
+}
+
+func (err *NonceSizeError) Error() string {
+	return fmt.Sprintf("Invalid nonce size: expected %v bytes, got %v bytes.", err.Expected, err.Actual)
+}
+
+func validateAeadNonce(aead cipher.AEAD, nonce []byte) error {
+	if len(nonce) != aead.NonceSize() {
+		return &NonceSizeError{
+			Expected: aead.NonceSize(),
+			Actual:   len(nonce),
+		}
+	}
+	return nil
+}
+
 /*
 	Primitives
 */
 
+/*
+	Source of randomness used by primitives and generators
+	(only overridden by tests, overriding it anywhere else is unsafe)
+*/
 var rng io.Reader = rand.Reader
 
 func Base64EncodeToString(src []byte) string {
-	return base64.StdEncoding.EncodeToString(src)
+	return Base64EncodeToStringWithEncoding(src, base64.StdEncoding)
 }
 
 func Base64DecodeString(src string) (res []byte, err error) {
-	res, err = base64.StdEncoding.DecodeString(src)
+	return Base64DecodeStringWithEncoding(src, base64.StdEncoding)
+}
+
+func Base64EncodeToStringWithEncoding(src []byte, encoding *base64.Encoding) string {
+	return encoding.EncodeToString(src)
+}
+
+func Base64DecodeStringWithEncoding(src string, encoding *base64.Encoding) (res []byte, err error) {
+	res, err = encoding.DecodeString(src)
 	if err != nil {
 		return nil, base64DecodeError
 	}
 	return
 }
 
+/*
+	Encodings accepted when decoding received operations and transactions
+	(standard, and URL-safe with or without padding; unpadded input is decoded
+	strictly so that truncated standard strings are not silently accepted)
+*/
+var acceptedBase64Encodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.RawURLEncoding.Strict(),
+	base64.URLEncoding,
+}
+
+func base64DecodeAnyString(src string) ([]byte, error) {
+	for _, encoding := range acceptedBase64Encodings {
+		if res, err := Base64DecodeStringWithEncoding(src, encoding); err == nil {
+			return res, nil
+		}
+	}
+	return nil, base64DecodeError
+}
+
 func ValidateNonce(nonce []byte) error {
 	if len(nonce) != SymmetricNonceSize {
 		return invalidNonceError
@@ -81,23 +192,101 @@ func ValidateSymmetricKey(key []byte) error {
 }
 
 func Hash(plaintext []byte) []byte {
-	hashed := sha256.Sum256(plaintext)
-	return hashed[:]
+	return HashWithAlgorithm(plaintext, Sha256HashAlgorithm)
+}
+
+/*
+	Hashes data with the algorithm specified (nil if the algorithm is unknown)
+*/
+func HashWithAlgorithm(data []byte, alg HashAlgorithm) []byte {
+	switch alg {
+	case Sha256HashAlgorithm:
+		hashed := sha256.Sum256(data)
+		return hashed[:]
+	case Sha512HashAlgorithm:
+		hashed := sha512.Sum512(data)
+		return hashed[:]
+	}
+	return nil
+}
+
+func cryptoHashForAlgorithm(alg HashAlgorithm) crypto.Hash {
+	if alg == Sha512HashAlgorithm {
+		return crypto.SHA512
+	}
+	return HashingAlgorithm
 }
 
 func Sign(key *rsa.PrivateKey, plaintext []byte) ([]byte, error) {
-	signature, err := rsa.SignPKCS1v15(rng, key, HashingAlgorithm, plaintext[:])
+	return SignWithHashAlgorithm(key, Sha256HashAlgorithm, plaintext)
+}
+
+func Verify(key *rsa.PublicKey, plaintext []byte, signature []byte) bool {
+	return VerifyWithHashAlgorithm(key, Sha256HashAlgorithm, plaintext, signature)
+}
+
+/*
+	RSA signing of data hashed with the algorithm specified
+*/
+func SignWithHashAlgorithm(key *rsa.PrivateKey, alg HashAlgorithm, hashed []byte) ([]byte, error) {
+	signature, err := rsa.SignPKCS1v15(rng, key, cryptoHashForAlgorithm(alg), hashed[:])
 	if err != nil {
 		return nil, signError
 	}
 	return signature, nil
 }
 
-func Verify(key *rsa.PublicKey, plaintext []byte, signature []byte) bool {
-	err := rsa.VerifyPKCS1v15(key, HashingAlgorithm, plaintext[:], signature)
+/*
+	Signatures need to be exactly as long as the key modulus
+	(signatures with trailing bytes or made with a different key size are rejected)
+*/
+func VerifyWithHashAlgorithm(key *rsa.PublicKey, alg HashAlgorithm, hashed []byte, signature []byte) bool {
+	if key == nil || key.N == nil || len(signature) != key.Size() {
+		return false
+	}
+	err := rsa.VerifyPKCS1v15(key, cryptoHashForAlgorithm(alg), hashed[:], signature)
 	return err == nil
 }
 
+func SignEd25519(key ed25519.PrivateKey, plaintext []byte) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, signError
+	}
+	return ed25519.Sign(key, plaintext), nil
+}
+
+func VerifyEd25519(key ed25519.PublicKey, plaintext []byte, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(key, plaintext, signature)
+}
+
+/*
+	Verifies a signature using the algorithm specified
+	(fails if the key doesn't match the algorithm)
+*/
+func VerifyWithAlgorithm(algorithm SigningAlgorithm, key crypto.PublicKey, plaintext []byte, signature []byte) bool {
+	return verifyWithAlgorithms(algorithm, Sha256HashAlgorithm, key, plaintext, signature)
+}
+
+func verifyWithAlgorithms(algorithm SigningAlgorithm, hashAlgorithm HashAlgorithm, key crypto.PublicKey, plaintext []byte, signature []byte) bool {
+	switch algorithm {
+	case RsaSigningAlgorithm:
+		if rsaKey, ok := key.(*rsa.PublicKey); ok && rsaKey != nil {
+			return VerifyWithHashAlgorithm(rsaKey, hashAlgorithm, plaintext, signature)
+		}
+	case Ed25519SigningAlgorithm:
+		if ed25519Key, ok := key.(ed25519.PublicKey); ok {
+			return VerifyEd25519(ed25519Key, plaintext, signature)
+		}
+	}
+	return false
+}
+
+/*
+	Asymmetric encryption used for legacy transaction key wrapping (RSA PKCS #1 v1.5, no label)
+*/
 func AsymmetricEncrypt(key *rsa.PublicKey, plaintext []byte) ([]byte, error) {
 	ciphertext, err := rsa.EncryptPKCS1v15(rng, key, plaintext)
 	if err != nil {
@@ -114,29 +303,92 @@ func AsymmetricDecrypt(key *rsa.PrivateKey, ciphertext []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-func NewAead(key []byte) (cipher.AEAD, error) {
-	aead, err := chacha20poly1305.New(key)
+/*
+	Asymmetric encryption binding a label to the ciphertext (RSA-OAEP with SHA-256)
+	(decryption fails unless the label matches the one used for encryption,
+	and an empty label is the same as no label)
+*/
+func AsymmetricEncryptWithLabel(key *rsa.PublicKey, plaintext []byte, label []byte) ([]byte, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rng, key, plaintext, label)
 	if err != nil {
-		return nil, aeadCreationError
+		return nil, asymmetrictEncryptionError
 	}
-	return aead, nil
+	return ciphertext, nil
 }
 
-func SymmetricEncrypt(aead cipher.AEAD, dst []byte, nonce []byte, plaintext []byte) []byte {
+func AsymmetricDecryptWithLabel(key *rsa.PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rng, key, ciphertext, label)
+	if err != nil {
+		return nil, asymmetrictDecryptionError
+	}
+	return plaintext, nil
+}
+
+/*
+	AEADs returned are safe for concurrent use, but callers sharing one across goroutines
+	must still never reuse a nonce (wrap with NewSafeAead to enforce it)
+*/
+func NewAead(key []byte) (cipher.AEAD, error) {
+	return NewAeadWithAlgorithm(key, ChaCha20Poly1305AeadAlgorithm)
+}
+
+func NewAeadWithAlgorithm(key []byte, algorithm AeadAlgorithm) (cipher.AEAD, error) {
+	switch algorithm {
+	case ChaCha20Poly1305AeadAlgorithm:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, aeadCreationError
+		}
+		return aead, nil
+	case Aes256GcmAeadAlgorithm:
+		if len(key) != aes256KeySize {
+			return nil, aeadCreationError
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, aeadCreationError
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, aeadCreationError
+		}
+		return aead, nil
+	}
+	return nil, invalidAeadAlgorithmError
+}
+
+func SymmetricEncrypt(aead cipher.AEAD, dst []byte, nonce []byte, plaintext []byte) ([]byte, error) {
+	return SymmetricEncryptWithAssociatedData(aead, dst, nonce, plaintext, []byte{})
+}
+
+func SymmetricEncryptWithAssociatedData(aead cipher.AEAD, dst []byte, nonce []byte, plaintext []byte, associatedData []byte) ([]byte, error) {
+	if err := validateAeadNonce(aead, nonce); err != nil {
+		return nil, err
+	}
+	if safeAead, ok := aead.(*SafeAead); ok {
+		return safeAead.TrySeal(dst, nonce, plaintext, associatedData)
+	}
 	return aead.Seal(
 		dst,
 		nonce,
 		plaintext,
-		[]byte{},
-	)
+		associatedData,
+	), nil
 }
 
 func SymmetricDecrypt(aead cipher.AEAD, dst []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	return SymmetricDecryptWithAssociatedData(aead, dst, nonce, ciphertext, []byte{})
+}
+
+func SymmetricDecryptWithAssociatedData(aead cipher.AEAD, dst []byte, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
+	if err := validateAeadNonce(aead, nonce); err != nil {
+		return nil, err
+	}
 	plaintext, err := aead.Open(
 		dst,
 		nonce,
 		ciphertext,
-		[]byte{},
+		associatedData,
 	)
 	if err != nil {
 		return nil, symmetrictDecryptionError
@@ -144,91 +396,252 @@ func SymmetricDecrypt(aead cipher.AEAD, dst []byte, nonce []byte, ciphertext []b
 	return plaintext, nil
 }
 
+/*
+	Checks challenge in constant time to avoid leaking matching prefix length
+*/
+func isCorrectChallenge(challenge []byte) bool {
+	return subtle.ConstantTimeCompare(challenge, []byte(CorrectChallenge)) == 1
+}
+
+/*
+	Recipient specific challenges are the correct challenge followed by data for the recipient
+	(returns the recipient data if the challenge is correct)
+*/
+func recipientChallengeData(challenge []byte) ([]byte, bool) {
+	if len(challenge) < len(CorrectChallenge) || !isCorrectChallenge(challenge[:len(CorrectChallenge)]) {
+		return nil, false
+	}
+	return challenge[len(CorrectChallenge):], true
+}
+
+/*
+	Nonce of a recipient specific challenge
+	(derived from the transaction nonce and the encrypted key of the recipient so that
+	challenges with different plaintexts never share a nonce)
+*/
+func recipientChallengeNonce(nonce []byte, symKeyCipher []byte) []byte {
+	digest := sha256.Sum256(append(append([]byte{}, nonce...), symKeyCipher...))
+	return digest[:SymmetricNonceSize]
+}
+
+/*
+	Trial decryption of challenges
+	(challenges are tried in parallel by a bounded number of workers, and the first passing challenge
+	in sorted order is used so that the result does not depend on map iteration order)
+	Workers stop trying challenges once the context is done, and the context error is returned
+*/
+type challengeResult struct {
+	aead        cipher.AEAD
+	challenge   []byte // Recipient data of recipient specific challenges
+	isRecipient bool
+}
+
+func findChallengeAead(ctx context.Context, version float64, asymKey *rsa.PrivateKey, challenges map[string]string, nonce []byte) (cipher.AEAD, []byte, bool, error) {
+	symKeyCiphers := make([]string, 0, len(challenges))
+	for symKeyCipher := range challenges {
+		symKeyCiphers = append(symKeyCiphers, symKeyCipher)
+	}
+	sort.Strings(symKeyCiphers)
+	results := make([]challengeResult, len(symKeyCiphers))
+
+	// Bound number of workers by available cores
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(symKeyCiphers) {
+		numWorkers = len(symKeyCiphers)
+	}
+
+	indexes := make(chan int, len(symKeyCiphers))
+	for index := range symKeyCiphers {
+		indexes <- index
+	}
+	close(indexes)
+
+	// Skip challenges after the first passing one found so far
+	firstPassing := int64(len(symKeyCiphers))
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for worker := 0; worker < numWorkers; worker++ {
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				if ctx.Err() != nil {
+					return
+				}
+				if int64(index) > atomic.LoadInt64(&firstPassing) {
+					continue
+				}
+				symKeyCipher := symKeyCiphers[index]
+				results[index] = tryChallenge(version, asymKey, symKeyCipher, challenges[symKeyCipher], nonce)
+				if results[index].aead == nil {
+					continue
+				}
+				for {
+					current := atomic.LoadInt64(&firstPassing)
+					if int64(index) >= current || atomic.CompareAndSwapInt64(&firstPassing, current, int64(index)) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, false, err
+	}
+	if firstPassing < int64(len(symKeyCiphers)) {
+		return results[firstPassing].aead, results[firstPassing].challenge, true, nil
+	}
+	isRecipient := false
+	for _, result := range results {
+		isRecipient = isRecipient || result.isRecipient
+	}
+	return nil, nil, isRecipient, nil
+}
+
+func tryChallenge(version float64, asymKey *rsa.PrivateKey, symKeyCipher string, symKeyChallenge string, nonce []byte) (result challengeResult) {
+	// Decode symmetric key ciphertext
+	symKeyCipherBytes, err := base64DecodeAnyString(symKeyCipher)
+	if err != nil {
+		return
+	}
+
+	// Decrypt symmetric key (zeroed once the aead is made)
+	symKeyPlainBytes, err := unwrapTransactionKey(version, asymKey, symKeyCipherBytes, nonce)
+	defer ZeroBytes(symKeyPlainBytes)
+	if err == nil {
+		err = ValidateSymmetricKey(symKeyPlainBytes)
+	}
+	if err != nil {
+		return
+	}
+	result.isRecipient = version != LegacyTransactionVersion
+
+	// Decode challenge
+	symKeyAead, _ := NewAead(symKeyPlainBytes)
+	symKeyChallengeBytes, err := base64DecodeAnyString(symKeyChallenge)
+	if err != nil {
+		return
+	}
+
+	// Decrypt challenge shared by all recipients
+	decryptedChallenge, decryptedChallengeErr := SymmetricDecrypt(
+		symKeyAead,
+		nil,
+		nonce,
+		symKeyChallengeBytes,
+	)
+	if decryptedChallengeErr == nil &&
+		isCorrectChallenge(decryptedChallenge) {
+		result.aead = symKeyAead
+		return
+	}
+
+	// Decrypt recipient specific challenge
+	decryptedChallenge, decryptedChallengeErr = SymmetricDecrypt(
+		symKeyAead,
+		nil,
+		recipientChallengeNonce(nonce, symKeyCipherBytes),
+		symKeyChallengeBytes,
+	)
+	if decryptedChallengeErr == nil {
+		if recipientData, ok := recipientChallengeData(decryptedChallenge); ok {
+			result.aead = symKeyAead
+			result.challenge = recipientData
+		}
+	}
+	return
+}
+
 /*
 	Transaction decryption
 */
 func (op *Transaction) Decrypt(asymKey *rsa.PrivateKey) (*Operation, error) {
+	return op.DecryptWithContext(context.Background(), asymKey)
+}
+
+/*
+	Transaction decryption that can be cancelled while trying challenges
+	(returns the context error as soon as the context is done)
+*/
+func (op *Transaction) DecryptWithContext(ctx context.Context, asymKey *rsa.PrivateKey) (*Operation, error) {
+	operation, _, err := op.DecryptWithChallenge(ctx, asymKey)
+	return operation, err
+}
+
+/*
+	Transaction decryption returning the recipient data of the challenge that passed
+	(the data is empty unless the transaction has recipient specific challenges)
+*/
+func (op *Transaction) DecryptWithChallenge(ctx context.Context, asymKey *rsa.PrivateKey) (*Operation, []byte, error) {
+	// Check format is understood
+	version := transactionVersion(op.Version)
+	if !supportedTransactionVersions[version] {
+		return nil, nil, unsupportedVersionError
+	}
+
 	// Base64 decode payload
-	payloadBytes, err := Base64DecodeString(op.Payload)
+	payloadBytes, err := base64DecodeAnyString(op.Payload)
 	if err != nil {
-		return nil, payloadDecodeError
+		return nil, nil, payloadDecodeError
 	}
 
 	// Decrypt payload if encrypted
 	var aead cipher.AEAD = nil
+	var challenge []byte
 	if op.Encryption.Encrypted {
 
 		// Check nonce
-		symKeyNonceBytes, err := Base64DecodeString(op.Encryption.Nonce)
+		symKeyNonceBytes, err := base64DecodeAnyString(op.Encryption.Nonce)
 		if err == nil {
 			err = ValidateNonce(symKeyNonceBytes)
 		}
 		if err != nil {
-			return nil, invalidNonceError
+			return nil, nil, invalidNonceError
 		}
 
-		// Find a symmetric key that passes challenge
-		for symKeyCipher, symKeyChallenge := range op.Encryption.Challenges {
-			// Decode symmetric key ciphertext
-			symKeyCipherBytes, err := Base64DecodeString(symKeyCipher)
-			if err != nil {
-				continue
-			}
-
-			// Decrypt symmetric key
-			symKeyPlainBytes, err := AsymmetricDecrypt(asymKey, symKeyCipherBytes)
-			if err == nil {
-				err = ValidateSymmetricKey(symKeyPlainBytes)
-			}
-			if err != nil {
-				continue
-			}
-
-			// Decode challenge
-			symKeyAead, _ := NewAead(symKeyPlainBytes)
-			symKeyChallengeBytes, err := Base64DecodeString(symKeyChallenge)
-			if err != nil {
-				continue
-			}
+		// Check there are challenges to try
+		if len(op.Encryption.Challenges) == 0 {
+			return nil, nil, ErrNoChallenges
+		}
 
-			// Decrypt challenge
-			decryptedChallenge, decryptedChallengeErr := SymmetricDecrypt(
-				symKeyAead,
-				symKeyChallengeBytes[:0],
-				symKeyNonceBytes,
-				symKeyChallengeBytes,
-			)
-
-			// Test if decrypted challenge is correct
-			if decryptedChallengeErr == nil &&
-				string(decryptedChallenge) == CorrectChallenge {
-				aead = symKeyAead
-				break
-			}
+		// Find a symmetric key that passes challenge
+		var isRecipient bool
+		aead, challenge, isRecipient, err = findChallengeAead(ctx, version, asymKey, op.Encryption.Challenges, symKeyNonceBytes)
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// No symmetric keys worked
 		if aead == nil {
-			return nil, noSymmetricKeyFoundError
+			countTransactionDecryptionFailure()
+			if isRecipient {
+				return nil, nil, ErrChallengeMismatch
+			}
+			return nil, nil, ErrNotARecipient
 		}
 
 		// Decrypt payload
-		payloadBytes, _ = SymmetricDecrypt(
+		payloadBytes, err = SymmetricDecrypt(
 			aead,
 			payloadBytes[:0],
 			symKeyNonceBytes,
 			payloadBytes,
 		)
+		if err != nil {
+			countTransactionDecryptionFailure()
+			return nil, nil, ErrPayloadAuth
+		}
 	}
 
 	// Decode payload into structure
 	var decodedOp Operation
 	payloadDecodeErr := decodedOp.Decode(payloadBytes)
 	if payloadDecodeErr != nil {
-		return nil, invalidPayloadError
+		return nil, nil, invalidPayloadError
 	}
 
-	return &decodedOp, nil
+	return &decodedOp, challenge, nil
 }
 
 /*
@@ -236,9 +649,19 @@ func (op *Transaction) Decrypt(asymKey *rsa.PrivateKey) (*Operation, error) {
 */
 func (op *Operation) Decrypt(
 	decrypt Decryptor,
+) ([]byte, error) {
+	return op.DecryptWithLimit(decrypt, 0)
+}
+
+/*
+	Same as Decrypt, with a maximum size of the decompressed payload (default maximum if not positive)
+*/
+func (op *Operation) DecryptWithLimit(
+	decrypt Decryptor,
+	maxBytes int,
 ) ([]byte, error) {
 	// Base64 decode payload
-	payloadBytes, err := Base64DecodeString(op.Payload)
+	payloadBytes, err := base64DecodeAnyString(op.Payload)
 	if err != nil {
 		return nil, payloadDecodeError
 	}
@@ -246,7 +669,7 @@ func (op *Operation) Decrypt(
 	// Decrypt payload
 	if op.Encryption.Encrypted {
 		// Decode nonce
-		nonceBytes, err := Base64DecodeString(op.Encryption.Nonce)
+		nonceBytes, err := base64DecodeAnyString(op.Encryption.Nonce)
 		if err == nil {
 			err = ValidateNonce(nonceBytes)
 		}
@@ -255,50 +678,393 @@ func (op *Operation) Decrypt(
 		}
 
 		// Decrypt
-		payloadBytes, err = decrypt(op.Encryption.KeyId, nonceBytes, payloadBytes)
+		payloadBytes, err = op.decryptCiphertext(decrypt, nonceBytes, payloadBytes)
 		if err != nil {
+			countOperationDecryptionFailure()
 			return nil, keyNotFoundError
 		}
 	}
 
+	// Decompress payload
+	payloadBytes, err = DecompressWithLimit(op.Encryption.Compression, payloadBytes, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
 	return payloadBytes, nil
 }
 
+/*
+	Decrypts the payload with the symmetric key given, skipping issuer and certifier signature verification
+	(UNSAFE for authorization: the payload is not proven to come from its signers,
+	only meant for inspecting operations in forensic and debugging tools)
+*/
+func (op *Operation) DecryptPayloadOnly(key []byte) ([]byte, error) {
+	payloadBytes, err := op.Decrypt(symmetricKeyDecryptor(key))
+	if err == keyNotFoundError {
+		return nil, payloadDecryptionError
+	}
+	return payloadBytes, err
+}
+
+/*
+	Re-encrypts the payload under a new key id, key, and nonce without re-signing
+	(only possible for legacy versions since current signatures cover the key id and nonce,
+	and the operation is left unchanged unless the re-encrypted payload decrypts to the same plaintext)
+*/
+func (op *Operation) ReEncryptPayload(oldKey []byte, newKeyId string, newKey []byte, newNonce []byte) error {
+	if !op.Encryption.Encrypted {
+		return notEncryptedError
+	}
+	bound, err := isBoundOperationVersion(op.Version)
+	if err != nil {
+		return err
+	}
+	if bound {
+		return boundSignaturesError
+	}
+	if err := ValidateSymmetricKey(newKey); err != nil {
+		return err
+	}
+	if err := ValidateNonce(newNonce); err != nil {
+		return err
+	}
+
+	// Decrypt payload with the old key (kept compressed since signatures don't depend on compression)
+	ciphertext, err := base64DecodeAnyString(op.Payload)
+	if err != nil {
+		return payloadDecodeError
+	}
+	oldNonce, err := base64DecodeAnyString(op.Encryption.Nonce)
+	if err != nil {
+		return invalidNonceError
+	}
+	plaintext, err := op.decryptCiphertext(symmetricKeyDecryptor(oldKey), oldNonce, ciphertext)
+	if err != nil {
+		return payloadDecryptionError
+	}
+	defer ZeroBytes(plaintext)
+	plaintextHash := Hash(plaintext)
+
+	// Encrypt under the new key with associated data bound to the new key id
+	newAssociatedData := OperationAssociatedData(newKeyId, op.Meta.RequestType)
+	aead, err := NewAeadWithAlgorithm(newKey, op.Encryption.Algorithm)
+	if err != nil {
+		return err
+	}
+	newCiphertext, err := SymmetricEncryptWithAssociatedData(aead, []byte{}, newNonce, plaintext, newAssociatedData)
+	if err != nil {
+		return err
+	}
+
+	// Check the new payload decrypts to the same plaintext
+	reDecrypted, err := SymmetricDecryptWithAssociatedData(aead, nil, newNonce, newCiphertext, newAssociatedData)
+	defer ZeroBytes(reDecrypted)
+	if err != nil || subtle.ConstantTimeCompare(Hash(reDecrypted), plaintextHash) != 1 {
+		return reEncryptionMismatchError
+	}
+
+	op.Encryption.KeyId = newKeyId
+	op.Encryption.Nonce = Base64EncodeToString(newNonce)
+	op.Payload = Base64EncodeToString(newCiphertext)
+	return nil
+}
+
+/*
+	Decryptor using the same symmetric key regardless of key id
+*/
+func symmetricKeyDecryptor(key []byte) Decryptor {
+	return func(_ string, algorithm AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
+		aead, err := NewAeadWithAlgorithm(key, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return SymmetricDecryptWithAssociatedData(
+			aead,
+			ciphertext[:0],
+			nonce,
+			ciphertext,
+			associatedData,
+		)
+	}
+}
+
+/*
+	Associated data binding unencrypted metadata to the payload ciphertext:
+	| key id length (4 bytes, big endian) | key id | request type (8 bytes, big endian) |
+*/
+func OperationAssociatedData(keyId string, requestType RequestType) []byte {
+	associatedData := make([]byte, 4, 4+len(keyId)+8)
+	binary.BigEndian.PutUint32(associatedData, uint32(len(keyId)))
+	associatedData = append(associatedData, keyId...)
+	requestTypeBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(requestTypeBytes, uint64(requestType))
+	return append(associatedData, requestTypeBytes...)
+}
+
+/*
+	Associated data of current operation versions, also binding the encryption algorithm and compression:
+	| key id length (4 bytes, big endian) | key id | request type (8 bytes, big endian) |
+	| algorithm (8 bytes, big endian) | compression (8 bytes, big endian) |
+*/
+func OperationAssociatedDataWithEncryption(keyId string, requestType RequestType, algorithm AeadAlgorithm, compression CompressionAlgorithm) []byte {
+	associatedData := OperationAssociatedData(keyId, requestType)
+	encryptionBytes := make([]byte, 16)
+	binary.BigEndian.PutUint64(encryptionBytes, uint64(algorithm))
+	binary.BigEndian.PutUint64(encryptionBytes[8:], uint64(compression))
+	return append(associatedData, encryptionBytes...)
+}
+
+/*
+	Associated data the payload is encrypted with, depending on the operation version
+*/
+func (op *Operation) AssociatedData() []byte {
+	if op.Version == OperationVersion {
+		return OperationAssociatedDataWithEncryption(op.Encryption.KeyId, op.Meta.RequestType, op.Encryption.Algorithm, op.Encryption.Compression)
+	}
+	return OperationAssociatedData(op.Encryption.KeyId, op.Meta.RequestType)
+}
+
+/*
+	Associated data accepted when decrypting the payload, in order
+	(legacy operations may also have been encrypted without associated data)
+*/
+func (op *Operation) acceptedAssociatedData() [][]byte {
+	bound, err := isBoundOperationVersion(op.Version)
+	if err == nil && !bound {
+		return [][]byte{op.AssociatedData(), {}}
+	}
+	return [][]byte{op.AssociatedData()}
+}
+
+/*
+	Decrypts the payload ciphertext with each accepted associated data until one succeeds
+	(ciphertext is copied before every attempt but the last since decryption may overwrite it)
+*/
+func (op *Operation) decryptCiphertext(decrypt Decryptor, nonce []byte, ciphertext []byte) ([]byte, error) {
+	var err error
+	acceptedAssociatedData := op.acceptedAssociatedData()
+	for i, associatedData := range acceptedAssociatedData {
+		attemptCiphertext := ciphertext
+		if i != len(acceptedAssociatedData)-1 {
+			attemptCiphertext = append([]byte{}, ciphertext...)
+		}
+		var plaintext []byte
+		plaintext, err = decrypt(op.Encryption.KeyId, op.Encryption.Algorithm, nonce, attemptCiphertext, associatedData)
+		if err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
+
+/*
+	Signed fields of current operation versions (encoded as canonical JSON)
+	(timestamps are in unix nanoseconds, and zero if unbounded)
+*/
+type operationSignedFields struct {
+	Version   float64 `json:"version"`
+	KeyId     string  `json:"keyId"`
+	Nonce     string  `json:"nonce"`
+	Payload   []byte  `json:"payload"`
+	NotBefore int64   `json:"notBefore"`
+	NotAfter  int64   `json:"notAfter"`
+	DryRun    bool    `json:"dryRun"`
+}
+
+func unixNanoOrZero(timestamp time.Time) int64 {
+	if timestamp.IsZero() {
+		return 0
+	}
+	return timestamp.UnixNano()
+}
+
+/*
+	Data covered by operation signatures of current versions is the canonical JSON of the signed fields
+
+	Data covered by operation signatures of older versions:
+	| key id length (4 bytes, big endian) | key id | nonce length (4 bytes, big endian) | nonce | payload |
+	(key id and encoded nonce are omitted for legacy versions)
+
+	Older versions can't have a validity window or be dry runs: the payload isn't length prefixed,
+	so fields appended after it could be forged from the end of a signed payload
+*/
+func OperationSignedData(version float64, keyId string, nonce string, payload []byte, window ValidityWindow, dryRun bool) ([]byte, error) {
+	bound, err := isBoundOperationVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == OperationVersion {
+		return CanonicalJSON(operationSignedFields{
+			Version:   version,
+			KeyId:     keyId,
+			Nonce:     nonce,
+			Payload:   payload,
+			NotBefore: unixNanoOrZero(window.NotBefore),
+			NotAfter:  unixNanoOrZero(window.NotAfter),
+			DryRun:    dryRun,
+		})
+	}
+
+	if !window.IsUnbounded() || dryRun {
+		return nil, unsignedMetadataError
+	}
+
+	signedData := []byte{}
+	if bound {
+		for _, field := range []string{keyId, nonce} {
+			fieldLength := make([]byte, 4)
+			binary.BigEndian.PutUint32(fieldLength, uint32(len(field)))
+			signedData = append(signedData, fieldLength...)
+			signedData = append(signedData, field...)
+		}
+	}
+	return append(signedData, payload...), nil
+}
+
+func (op *Operation) SignedData(payload []byte) ([]byte, error) {
+	return OperationSignedData(op.Version, op.Encryption.KeyId, op.Encryption.Nonce, payload, op.Meta.ValidityWindow, op.Meta.DryRun)
+}
+
 /*
 	Signature verification
 */
 func (op *Operation) Verify(
-	issuerSigningKey *rsa.PublicKey,
-	certifierSigningKey *rsa.PublicKey,
+	issuerSigningKey crypto.PublicKey,
+	certifierSigningKey crypto.PublicKey,
 	payload []byte,
 ) (verified error) {
-	verified = decodeAndVerifySignature(issuerSigningKey, op.Issue.Signature, payload, invalidIssuerSignatureError)
+	verified = op.VerifyIssuer(issuerSigningKey, payload)
 	if verified != nil {
 		return
 	}
-	verified = decodeAndVerifySignature(certifierSigningKey, op.Certification.Signature, payload, invalidCertifierSignatureError)
+	verified = op.VerifyCertifier(certifierSigningKey, payload)
 	return
 }
+func (op *Operation) VerifyIssuer(issuerSigningKey crypto.PublicKey, payload []byte) error {
+	signedData, err := op.SignedData(payload)
+	if err != nil {
+		return err
+	}
+	return decodeAndVerifySignature(issuerSigningKey, &op.Issue, signedData, invalidIssuerSignatureError)
+}
+func (op *Operation) VerifyCertifier(certifierSigningKey crypto.PublicKey, payload []byte) error {
+	signedData, err := op.SignedData(payload)
+	if err != nil {
+		return err
+	}
+	return decodeAndVerifySignature(certifierSigningKey, &op.Certification, signedData, invalidCertifierSignatureError)
+}
 func decodeAndVerifySignature(
-	signingKey *rsa.PublicKey,
-	signatureEncoded string,
-	payload []byte,
+	signingKey crypto.PublicKey,
+	authentication *OperationAuthenticationFields,
+	signedData []byte,
 	invalidSignatureError error,
 ) error {
 	// Decode signature
 	var signature []byte
 	var err error
-	if signature, err = Base64DecodeString(signatureEncoded); err != nil {
+	if signature, err = base64DecodeAnyString(authentication.Signature); err != nil {
 		return invalidSignatureEncodingError
 	}
 
+	// Hash with the algorithm recorded alongside the signature
+	hashed := HashWithAlgorithm(signedData, authentication.HashAlgorithm)
+	if hashed == nil {
+		return invalidHashAlgorithmError
+	}
+
 	// Verify signature
-	if verified := Verify(signingKey, Hash(payload), signature); !verified {
+	if verified := verifyWithAlgorithms(authentication.Algorithm, authentication.HashAlgorithm, signingKey, hashed, signature); !verified {
+		countSignatureVerificationFailure()
 		return invalidSignatureError
 	}
 	return nil
 }
 
+/*
+	Batch signature verification
+	(payloads must be unencrypted, keys are RSA or Ed25519 public keys matching the signing algorithms,
+	errors are index aligned with operations)
+*/
+func VerifySignaturesBatch(ops []*Operation, keys map[string]crypto.PublicKey) []error {
+	errs := make([]error, len(ops))
+
+	// Bound number of workers by available cores
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(ops) {
+		numWorkers = len(ops)
+	}
+
+	indexes := make(chan int, len(ops))
+	for index := range ops {
+		indexes <- index
+	}
+	close(indexes)
+
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for worker := 0; worker < numWorkers; worker++ {
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				errs[index] = verifyOperationSignatures(ops[index], keys)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func verifyOperationSignatures(op *Operation, keys map[string]crypto.PublicKey) error {
+	if op == nil {
+		return invalidOperationError
+	}
+	if op.Encryption.Encrypted {
+		return encryptedPayloadError
+	}
+
+	// Find signer keys
+	issuerKey, issuerOk := keys[op.Issue.Id]
+	certifierKey, certifierOk := keys[op.Certification.Id]
+	if !issuerOk || !certifierOk {
+		return signerKeyNotFoundError
+	}
+
+	return verifyOperationWithKeys(op, issuerKey, certifierKey)
+}
+
+/*
+	Verifies an operation signed elsewhere is well formed and signed by both keys, without running it
+	(signatures cover the plaintext, so encrypted operations are rejected)
+*/
+func VerifyOperation(op *Operation, issuerKey crypto.PublicKey, certifierKey crypto.PublicKey) error {
+	if op == nil {
+		return invalidOperationError
+	}
+	if op.Encryption.Encrypted {
+		return encryptedPayloadError
+	}
+	if issuerKey == nil || certifierKey == nil {
+		return signerKeyNotFoundError
+	}
+	if err := op.Validate(); err != nil {
+		return err
+	}
+	return verifyOperationWithKeys(op, issuerKey, certifierKey)
+}
+
+func verifyOperationWithKeys(op *Operation, issuerKey crypto.PublicKey, certifierKey crypto.PublicKey) error {
+	// Decode payload and verify
+	payload, err := base64DecodeAnyString(op.Payload)
+	if err != nil {
+		return payloadDecodeError
+	}
+	return op.Verify(issuerKey, certifierKey, payload)
+}
+
 /*
 	Defines the set of signers that were verified
 */
@@ -0,0 +1,262 @@
+package core
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+	Streaming symmetric encryption
+
+	The plaintext is split into chunks of SymmetricStreamChunkSize bytes (the last chunk may be shorter or empty).
+	Each chunk is sealed independently and written as a frame:
+
+		| ciphertext length (4 bytes, big endian) | ciphertext (chunk + authentication tag) |
+
+	The nonce of chunk i is the base nonce with its last 12 bytes XORed with i (big endian 96-bit counter).
+	Chunk counters never wrap around, so a stream has at most 2^96 chunks (about 2^112 bytes),
+	and writing or reading past that fails instead of reusing a nonce.
+	A base nonce should only be used for one stream per key, since streams sharing it reuse chunk nonces.
+	The additional data of each chunk is a single byte set to 1 for the final chunk and 0 otherwise,
+	which ensures truncated and extended streams are rejected.
+	The stream always ends with exactly one final chunk, even if the plaintext is empty.
+*/
+
+const (
+	SymmetricStreamChunkSize  = 64 * 1024
+	streamFrameHeaderSize     = 4
+	streamCounterSize         = 12
+	streamIntermediateChunkAd = 0
+	streamFinalChunkAd        = 1
+)
+
+/*
+	Errors
+*/
+var (
+	streamInvalidNonceError  error = errors.New("Invalid stream nonce provided.")
+	streamClosedError        error = errors.New("Stream is closed.")
+	streamInvalidFrameError  error = errors.New("Invalid stream frame.")
+	streamTruncatedError     error = errors.New("Stream ended before final chunk.")
+	streamTrailingDataError  error = errors.New("Stream has data after final chunk.")
+	streamTooManyChunksError error = errors.New("Stream has too many chunks.")
+)
+
+func validateStreamNonce(aead cipher.AEAD, nonce []byte) error {
+	if len(nonce) != aead.NonceSize() || len(nonce) < streamCounterSize {
+		return streamInvalidNonceError
+	}
+	return nil
+}
+
+/*
+	96-bit chunk counter
+*/
+type streamCounter struct {
+	high      uint32
+	low       uint64
+	exhausted bool
+}
+
+/*
+	Returns the nonce of the next chunk, and fails once every counter value was used instead of wrapping around
+*/
+func (counter *streamCounter) nextNonce(baseNonce []byte) ([]byte, error) {
+	if counter.exhausted {
+		return nil, streamTooManyChunksError
+	}
+	nonce := streamChunkNonce(baseNonce, counter.high, counter.low)
+	counter.low++
+	if counter.low == 0 {
+		counter.high++
+		counter.exhausted = counter.high == 0
+	}
+	return nonce, nil
+}
+
+func streamChunkNonce(baseNonce []byte, counterHigh uint32, counterLow uint64) []byte {
+	nonce := make([]byte, len(baseNonce))
+	copy(nonce, baseNonce)
+	counterOffset := len(nonce) - streamCounterSize
+	binary.BigEndian.PutUint32(
+		nonce[counterOffset:],
+		binary.BigEndian.Uint32(nonce[counterOffset:])^counterHigh,
+	)
+	binary.BigEndian.PutUint64(
+		nonce[counterOffset+4:],
+		binary.BigEndian.Uint64(nonce[counterOffset+4:])^counterLow,
+	)
+	return nonce
+}
+
+func streamChunkAd(final bool) []byte {
+	if final {
+		return []byte{streamFinalChunkAd}
+	}
+	return []byte{streamIntermediateChunkAd}
+}
+
+/*
+	Encryption writer
+*/
+type symmetricEncryptWriter struct {
+	aead    cipher.AEAD
+	nonce   []byte
+	w       io.Writer
+	buffer  []byte
+	counter streamCounter
+	err     error
+}
+
+func NewSymmetricEncryptWriter(aead cipher.AEAD, nonce []byte, w io.Writer) io.WriteCloser {
+	writer := &symmetricEncryptWriter{
+		aead:   aead,
+		nonce:  nonce,
+		w:      w,
+		buffer: make([]byte, 0, SymmetricStreamChunkSize),
+	}
+	writer.err = validateStreamNonce(aead, nonce)
+	return writer
+}
+
+func (writer *symmetricEncryptWriter) writeChunk(final bool) error {
+	chunkNonce, err := writer.counter.nextNonce(writer.nonce)
+	if err != nil {
+		return err
+	}
+
+	ciphertext := writer.aead.Seal(
+		nil,
+		chunkNonce,
+		writer.buffer,
+		streamChunkAd(final),
+	)
+	writer.buffer = writer.buffer[:0]
+
+	frame := make([]byte, streamFrameHeaderSize, streamFrameHeaderSize+len(ciphertext))
+	binary.BigEndian.PutUint32(frame, uint32(len(ciphertext)))
+	frame = append(frame, ciphertext...)
+	_, err = writer.w.Write(frame)
+	return err
+}
+
+func (writer *symmetricEncryptWriter) Write(plaintext []byte) (int, error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+
+	written := 0
+	for len(plaintext) > 0 {
+		// Only flush a full chunk once more data arrives (last chunk is flushed on close)
+		if len(writer.buffer) == SymmetricStreamChunkSize {
+			if err := writer.writeChunk(false); err != nil {
+				writer.err = err
+				return written, err
+			}
+		}
+		n := copy(writer.buffer[len(writer.buffer):SymmetricStreamChunkSize], plaintext)
+		writer.buffer = writer.buffer[:len(writer.buffer)+n]
+		plaintext = plaintext[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (writer *symmetricEncryptWriter) Close() error {
+	if writer.err != nil {
+		return writer.err
+	}
+	err := writer.writeChunk(true)
+	writer.err = streamClosedError
+	return err
+}
+
+/*
+	Decryption reader
+*/
+type symmetricDecryptReader struct {
+	aead      cipher.AEAD
+	nonce     []byte
+	r         io.Reader
+	plaintext []byte
+	counter   streamCounter
+	done      bool
+	err       error
+}
+
+func NewSymmetricDecryptReader(aead cipher.AEAD, nonce []byte, r io.Reader) io.Reader {
+	reader := &symmetricDecryptReader{
+		aead:  aead,
+		nonce: nonce,
+		r:     r,
+	}
+	reader.err = validateStreamNonce(aead, nonce)
+	return reader
+}
+
+func (reader *symmetricDecryptReader) readChunk() error {
+	// Read frame header
+	header := make([]byte, streamFrameHeaderSize)
+	if _, err := io.ReadFull(reader.r, header); err != nil {
+		if err == io.EOF {
+			return streamTruncatedError
+		}
+		return streamInvalidFrameError
+	}
+	ciphertextLength := binary.BigEndian.Uint32(header)
+	if ciphertextLength < uint32(reader.aead.Overhead()) ||
+		ciphertextLength > uint32(SymmetricStreamChunkSize+reader.aead.Overhead()) {
+		return streamInvalidFrameError
+	}
+
+	// Read ciphertext
+	ciphertext := make([]byte, ciphertextLength)
+	if _, err := io.ReadFull(reader.r, ciphertext); err != nil {
+		return streamInvalidFrameError
+	}
+
+	// Try intermediate chunk first, then final chunk
+	chunkNonce, err := reader.counter.nextNonce(reader.nonce)
+	if err != nil {
+		return err
+	}
+	plaintext, err := reader.aead.Open(ciphertext[:0:0], chunkNonce, ciphertext, streamChunkAd(false))
+	if err != nil {
+		plaintext, err = reader.aead.Open(ciphertext[:0:0], chunkNonce, ciphertext, streamChunkAd(true))
+		if err != nil {
+			return symmetrictDecryptionError
+		}
+		reader.done = true
+	}
+	reader.plaintext = plaintext
+
+	// Final chunk must be the last frame
+	if reader.done {
+		if n, _ := reader.r.Read(make([]byte, 1)); n != 0 {
+			return streamTrailingDataError
+		}
+	}
+	return nil
+}
+
+func (reader *symmetricDecryptReader) Read(p []byte) (int, error) {
+	for len(reader.plaintext) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		if reader.done {
+			return 0, io.EOF
+		}
+		if err := reader.readChunk(); err != nil {
+			reader.err = err
+			reader.plaintext = nil
+			return 0, err
+		}
+	}
+
+	n := copy(p, reader.plaintext)
+	reader.plaintext = reader.plaintext[n:]
+	return n, nil
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+/*
+	Test helpers
+*/
+
+func encryptStream(t *testing.T, key []byte, nonce []byte, plaintext []byte) []byte {
+	aead, _ := NewAead(key)
+	var ciphertext bytes.Buffer
+	writer := NewSymmetricEncryptWriter(aead, nonce, &ciphertext)
+
+	// Write in uneven pieces to exercise buffering
+	for len(plaintext) > 0 {
+		pieceSize := 1000
+		if pieceSize > len(plaintext) {
+			pieceSize = len(plaintext)
+		}
+		if n, err := writer.Write(plaintext[:pieceSize]); err != nil || n != pieceSize {
+			t.Errorf("Stream write failed. n=%v, err=%v", n, err)
+		}
+		plaintext = plaintext[pieceSize:]
+	}
+	if err := writer.Close(); err != nil {
+		t.Errorf("Stream close failed. err=%v", err)
+	}
+	return ciphertext.Bytes()
+}
+
+func decryptStream(key []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	aead, _ := NewAead(key)
+	return ioutil.ReadAll(NewSymmetricDecryptReader(aead, nonce, bytes.NewReader(ciphertext)))
+}
+
+/*
+	Tests
+*/
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := generateRandomBytes(SymmetricKeySize)
+	nonce := generateRandomBytes(SymmetricNonceSize)
+
+	for _, size := range []int{0, 1, SymmetricStreamChunkSize, 3*SymmetricStreamChunkSize + 17} {
+		plaintext := generateRandomBytes(size)
+		ciphertext := encryptStream(t, key, nonce, plaintext)
+		decrypted, err := decryptStream(key, nonce, ciphertext)
+		if err != nil || !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Stream round trip failed. size=%v, err=%v", size, err)
+		}
+	}
+}
+
+func TestStreamChunkFraming(t *testing.T) {
+	key := generateRandomBytes(SymmetricKeySize)
+	nonce := generateRandomBytes(SymmetricNonceSize)
+	aead, _ := NewAead(key)
+
+	// Two full chunks and one partial chunk should produce three frames
+	plaintext := generateRandomBytes(2*SymmetricStreamChunkSize + 5)
+	ciphertext := encryptStream(t, key, nonce, plaintext)
+	expectedLength := 3*(streamFrameHeaderSize+aead.Overhead()) + len(plaintext)
+	if len(ciphertext) != expectedLength {
+		t.Errorf("Stream framing mismatch. found=%v, expected=%v", len(ciphertext), expectedLength)
+	}
+
+	// First chunk should be sealed with the base nonce
+	firstChunk, err := aead.Open(
+		nil,
+		nonce,
+		ciphertext[streamFrameHeaderSize:streamFrameHeaderSize+SymmetricStreamChunkSize+aead.Overhead()],
+		[]byte{streamIntermediateChunkAd},
+	)
+	if err != nil || !reflect.DeepEqual(firstChunk, plaintext[:SymmetricStreamChunkSize]) {
+		t.Errorf("First stream chunk should be decryptable with base nonce. err=%v", err)
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := generateRandomBytes(SymmetricKeySize)
+	nonce := generateRandomBytes(SymmetricNonceSize)
+	plaintext := generateRandomBytes(2*SymmetricStreamChunkSize + 5)
+	ciphertext := encryptStream(t, key, nonce, plaintext)
+	aead, _ := NewAead(key)
+	firstFrameSize := streamFrameHeaderSize + SymmetricStreamChunkSize + aead.Overhead()
+
+	// Truncated after a full frame
+	if _, err := decryptStream(key, nonce, ciphertext[:firstFrameSize]); err != streamTruncatedError {
+		t.Errorf("Stream decryption should fail with truncated stream. err=%v", err)
+	}
+
+	// Truncated mid frame
+	if _, err := decryptStream(key, nonce, ciphertext[:firstFrameSize+10]); err != streamInvalidFrameError {
+		t.Errorf("Stream decryption should fail with partial frame. err=%v", err)
+	}
+
+	// Trailing data
+	extended := append(append([]byte{}, ciphertext...), 0)
+	if _, err := decryptStream(key, nonce, extended); err != streamTrailingDataError {
+		t.Errorf("Stream decryption should fail with trailing data. err=%v", err)
+	}
+
+	// Modified ciphertext
+	modified := append([]byte{}, ciphertext...)
+	modified[firstFrameSize+streamFrameHeaderSize] ^= 1
+	if _, err := decryptStream(key, nonce, modified); err != symmetrictDecryptionError {
+		t.Errorf("Stream decryption should fail with modified ciphertext. err=%v", err)
+	}
+
+	// Wrong key
+	if _, err := decryptStream(generateRandomBytes(SymmetricKeySize), nonce, ciphertext); err != symmetrictDecryptionError {
+		t.Errorf("Stream decryption should fail with wrong key. err=%v", err)
+	}
+}
+
+func TestStreamInvalidNonce(t *testing.T) {
+	aead, _ := NewAead(generateRandomBytes(SymmetricKeySize))
+	nonce := generateRandomBytes(SymmetricNonceSize - 1)
+
+	writer := NewSymmetricEncryptWriter(aead, nonce, ioutil.Discard)
+	if _, err := writer.Write([]byte("PLAINTEXT")); err != streamInvalidNonceError {
+		t.Errorf("Stream write should fail with invalid nonce. err=%v", err)
+	}
+	if err := writer.Close(); err != streamInvalidNonceError {
+		t.Errorf("Stream close should fail with invalid nonce. err=%v", err)
+	}
+
+	reader := NewSymmetricDecryptReader(aead, nonce, bytes.NewReader([]byte{}))
+	if _, err := reader.Read(make([]byte, 1)); err != streamInvalidNonceError {
+		t.Errorf("Stream read should fail with invalid nonce. err=%v", err)
+	}
+}
+
+func TestStreamWriteAfterClose(t *testing.T) {
+	aead, _ := NewAead(generateRandomBytes(SymmetricKeySize))
+	writer := NewSymmetricEncryptWriter(aead, generateRandomBytes(SymmetricNonceSize), ioutil.Discard)
+	writer.Close()
+	if _, err := writer.Write([]byte("PLAINTEXT")); err != streamClosedError {
+		t.Errorf("Stream write should fail after close. err=%v", err)
+	}
+}
+
+func TestStreamCounterLimit(t *testing.T) {
+	// Counter carries into its high bits
+	baseNonce := generateRandomBytes(SymmetricNonceSize)
+	counter := streamCounter{low: ^uint64(0)}
+	lastLowNonce, _ := counter.nextNonce(baseNonce)
+	firstHighNonce, err := counter.nextNonce(baseNonce)
+	expectedNonce := streamChunkNonce(baseNonce, 1, 0)
+	if err != nil || !reflect.DeepEqual(firstHighNonce, expectedNonce) || reflect.DeepEqual(firstHighNonce, lastLowNonce) {
+		t.Errorf("Counter should carry into high bits. found=%v, expected=%v, err=%v", firstHighNonce, expectedNonce, err)
+	}
+
+	// Writing past the last counter value fails instead of wrapping around
+	key := generateRandomBytes(SymmetricKeySize)
+	aead, _ := NewAead(key)
+	nearLimit := streamCounter{high: ^uint32(0), low: ^uint64(0) - 1}
+	var ciphertext bytes.Buffer
+	writer := NewSymmetricEncryptWriter(aead, baseNonce, &ciphertext).(*symmetricEncryptWriter)
+	writer.counter = nearLimit
+	plaintext := generateRandomBytes(2*SymmetricStreamChunkSize + 1)
+	if n, err := writer.Write(plaintext); n != len(plaintext) || err != nil {
+		t.Fatalf("Writing chunks up to the last counter value should succeed. n=%v, err=%v", n, err)
+	}
+	if err := writer.Close(); err != streamTooManyChunksError {
+		t.Errorf("Writing past the last counter value should fail. err=%v", err)
+	}
+
+	// Reading past the last counter value fails too (before trying to decrypt the extra frame)
+	extraFrame := append([]byte{0, 0, 0, byte(aead.Overhead())}, make([]byte, aead.Overhead())...)
+	tooLong := append(append([]byte{}, ciphertext.Bytes()...), extraFrame...)
+	reader := NewSymmetricDecryptReader(aead, baseNonce, bytes.NewReader(tooLong)).(*symmetricDecryptReader)
+	reader.counter = nearLimit
+	if _, err := ioutil.ReadAll(reader); err != streamTooManyChunksError {
+		t.Errorf("Reading past the last counter value should fail. err=%v", err)
+	}
+
+	// Stream ending on the last counter value is read
+	ciphertext.Reset()
+	writer = NewSymmetricEncryptWriter(aead, baseNonce, &ciphertext).(*symmetricEncryptWriter)
+	writer.counter = nearLimit
+	writer.Write(plaintext[:SymmetricStreamChunkSize+1])
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Stream ending on the last counter value should be written. err=%v", err)
+	}
+	reader = NewSymmetricDecryptReader(aead, baseNonce, bytes.NewReader(ciphertext.Bytes())).(*symmetricDecryptReader)
+	reader.counter = nearLimit
+	if decrypted, err := ioutil.ReadAll(reader); err != nil || !bytes.Equal(decrypted, plaintext[:SymmetricStreamChunkSize+1]) {
+		t.Errorf("Stream ending on the last counter value should be read. err=%v", err)
+	}
+}
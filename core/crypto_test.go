@@ -1,8 +1,17 @@
 package core
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 /*
@@ -53,6 +62,204 @@ func TestValidTransaction(t *testing.T) {
 	}
 }
 
+func TestTransactionForRecipients(t *testing.T) {
+	// Make valid encrypted operation
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		generateRandomBytes(SymmetricKeySize),
+		generateRandomBytes(SymmetricNonceSize),
+		1,
+		[]byte("REQUEST_PAYLOAD"),
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+	)
+	innerOperationJson, _ := encryptedOperation.Encode()
+
+	// Address transaction to three recipients
+	recipientKeys := []*rsa.PrivateKey{
+		GeneratePrivateKey(),
+		GeneratePrivateKey(),
+		GeneratePrivateKey(),
+	}
+	recipients := []*rsa.PublicKey{}
+	for _, recipientKey := range recipientKeys {
+		recipients = append(recipients, &recipientKey.PublicKey)
+	}
+	transaction := GenerateTransactionForRecipients(
+		innerOperationJson,
+		[]byte(CorrectChallenge),
+		recipients,
+	)
+	if len(transaction.Encryption.Challenges) != len(recipients) {
+		t.Errorf("Transaction should have one challenge per recipient. found=%v, expected=%v", len(transaction.Encryption.Challenges), len(recipients))
+	}
+
+	// Every recipient should be able to decrypt
+	for recipientIndex, recipientKey := range recipientKeys {
+		decryptedTransaction, err := transaction.Decrypt(recipientKey)
+		if err != nil ||
+			!reflect.DeepEqual(encryptedOperation, decryptedTransaction) {
+			t.Errorf("Transaction decryption failed for recipient %v. err=%v", recipientIndex, err)
+		}
+	}
+
+	// Other keys should fail
+	_, err := transaction.Decrypt(GeneratePrivateKey())
+	if err != ErrNotARecipient {
+		t.Errorf("Transaction decryption should fail for non recipient. err=%v", err)
+	}
+}
+
+func TestTransactionRecipientChallenges(t *testing.T) {
+	innerOperationJson, _ := GenerateOperation(false, "", []byte{}, false, "ISSUER", []byte{}, false, "CERTIFIER", []byte{}, false, 1, []byte("REQUEST_PAYLOAD"), false).Encode()
+	recipientKeys := []*rsa.PrivateKey{GeneratePrivateKey(), GeneratePrivateKey(), GeneratePrivateKey()}
+	recipients := []*rsa.PublicKey{}
+	tokens := map[*rsa.PublicKey][]byte{}
+	for recipientIndex, recipientKey := range recipientKeys {
+		recipients = append(recipients, &recipientKey.PublicKey)
+		tokens[&recipientKey.PublicKey] = []byte("TOKEN_" + string(rune('A'+recipientIndex)))
+	}
+	transaction := GenerateTransactionForRecipientsWithChallenges(
+		innerOperationJson,
+		func(recipient *rsa.PublicKey) []byte {
+			return tokens[recipient]
+		},
+		recipients,
+	)
+
+	// Every recipient recovers its own challenge
+	for recipientIndex, recipientKey := range recipientKeys {
+		decryptedTransaction, challenge, err := transaction.DecryptWithChallenge(context.Background(), recipientKey)
+		if err != nil || decryptedTransaction == nil || decryptedTransaction.Issue.Id != "ISSUER" {
+			t.Errorf("Transaction decryption failed for recipient %v. err=%v", recipientIndex, err)
+		}
+		if expected := tokens[&recipientKey.PublicKey]; !reflect.DeepEqual(challenge, expected) {
+			t.Errorf("Recipient should recover its challenge. recipient=%v, found=%s, expected=%s", recipientIndex, challenge, expected)
+		}
+	}
+
+	// Other keys should fail
+	if _, _, err := transaction.DecryptWithChallenge(context.Background(), GeneratePrivateKey()); err != ErrNotARecipient {
+		t.Errorf("Transaction decryption should fail for non recipient. err=%v", err)
+	}
+
+	// Shared challenges have no recipient data
+	sharedTransaction := GenerateTransactionForRecipients(innerOperationJson, []byte(CorrectChallenge), recipients)
+	if _, challenge, err := sharedTransaction.DecryptWithChallenge(context.Background(), recipientKeys[0]); err != nil || len(challenge) != 0 {
+		t.Errorf("Shared challenge should have no recipient data. challenge=%s, err=%v", challenge, err)
+	}
+}
+
+func TestURLSafeEncoding(t *testing.T) {
+	// Make operation with URL-safe encoding without padding
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	encryptedOperation, issuerKey, certifierKey := GenerateOperationWithEncryptionAndEncoding(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		requestPayload,
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+		base64.RawURLEncoding,
+	)
+	if encryptedOperation.Encryption.Nonce != base64.RawURLEncoding.EncodeToString(permanentNonce) ||
+		strings.ContainsAny(encryptedOperation.Payload, "+/=") {
+		t.Errorf("Operation should be URL-safe encoded. nonce=%v, payload=%v", encryptedOperation.Encryption.Nonce, encryptedOperation.Payload)
+	}
+	nonceDecoded, err := Base64DecodeStringWithEncoding(encryptedOperation.Encryption.Nonce, base64.RawURLEncoding)
+	if err != nil || !reflect.DeepEqual(nonceDecoded, permanentNonce) {
+		t.Errorf("Nonce should round trip through URL-safe encoding. err=%v", err)
+	}
+
+	// Wrap in URL-safe transaction
+	innerOperationJson, _ := encryptedOperation.Encode()
+	recipientKey := GeneratePrivateKey()
+	transaction := GenerateTransactionForRecipientsWithEncoding(
+		innerOperationJson,
+		[]byte(CorrectChallenge),
+		[]*rsa.PublicKey{&recipientKey.PublicKey},
+		base64.RawURLEncoding,
+	)
+	for symKeyCipher, symKeyChallenge := range transaction.Encryption.Challenges {
+		if strings.ContainsAny(symKeyCipher+symKeyChallenge, "+/=") {
+			t.Errorf("Challenges should be URL-safe encoded. challenges=%v", transaction.Encryption.Challenges)
+		}
+	}
+
+	// Decrypt transaction and operation
+	decryptedTransaction, err := transaction.Decrypt(recipientKey)
+	if err != nil || !reflect.DeepEqual(encryptedOperation, decryptedTransaction) {
+		t.Errorf("URL-safe transaction decryption failed. err=%v", err)
+		return
+	}
+	decryptedPayload, err := decryptedTransaction.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil || !reflect.DeepEqual(decryptedPayload, requestPayload) {
+		t.Errorf("URL-safe operation decryption failed. found=%v, expected=%v, err=%v", decryptedPayload, requestPayload, err)
+	}
+	if err := decryptedTransaction.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, decryptedPayload); err != nil {
+		t.Errorf("URL-safe operation signatures should verify. err=%v", err)
+	}
+
+	// Standard encoding is still accepted
+	standardOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		requestPayload,
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+	)
+	if _, err := standardOperation.Decrypt(DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true)); err != nil {
+		t.Errorf("Standard operation decryption failed. err=%v", err)
+	}
+}
+
+func TestUnsupportedTransactionVersion(t *testing.T) {
+	// Make valid encrypted transaction with a bumped version
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		generateRandomBytes(SymmetricKeySize),
+		generateRandomBytes(SymmetricNonceSize),
+		1,
+		[]byte("REQUEST_PAYLOAD"),
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+	)
+	innerOperationJson, _ := encryptedOperation.Encode()
+	transaction, recipientKey := GenerateTransactionWithEncryption(
+		innerOperationJson,
+		[]byte(CorrectChallenge),
+		func(map[string]string) {},
+		nil,
+	)
+	transaction.Version = 0.3
+
+	_, err := transaction.Decrypt(recipientKey)
+	if err != unsupportedVersionError {
+		t.Errorf("Transaction decryption should fail with unsupported version. err=%v", err)
+	}
+
+	// Supported version should still be accepted
+	transaction.Version = TransactionVersion
+	if _, err = transaction.Decrypt(recipientKey); err != nil {
+		t.Errorf("Transaction decryption should succeed with supported version. err=%v", err)
+	}
+}
+
 func TestInavlidTransactionPayloadEncoding(t *testing.T) {
 	// Use invalid base64 string for payload
 	transaction := GenerateTransaction(
@@ -165,7 +372,7 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		false,
 	)
 	_, err := transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrNotARecipient {
 		t.Errorf("Transaction decryption should fail with invalid temp key encoding. err=%v", err)
 		return
 	}
@@ -185,7 +392,7 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		false,
 	)
 	_, err = transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrNotARecipient {
 		t.Errorf("Transaction decryption should fail with invalid temp key ciphertext. err=%v", err)
 		return
 	}
@@ -205,7 +412,7 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		false,
 	)
 	_, err = transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrNotARecipient {
 		t.Errorf("Transaction decryption should fail with invalid temp key ciphertext. err=%v", err)
 		return
 	}
@@ -225,7 +432,7 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		false,
 	)
 	_, err = transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrNotARecipient {
 		t.Errorf("Transaction decryption should fail with invalid temp key ciphertext. err=%v", err)
 		return
 	}
@@ -245,7 +452,7 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		false,
 	)
 	_, err = transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrNotARecipient {
 		t.Errorf("Transaction decryption should fail with invalid challenge encoding. err=%v", err)
 		return
 	}
@@ -267,7 +474,7 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		false,
 	)
 	_, err = transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrNotARecipient {
 		t.Errorf("Transaction decryption should fail with invalid challenge ciphertext. err=%v", err)
 		return
 	}
@@ -280,11 +487,30 @@ func TestInavlidTransactionChallenges(t *testing.T) {
 		privateKey,
 	)
 	_, err = transaction.Decrypt(privateKey)
-	if err != noSymmetricKeyFoundError {
+	if err != ErrChallengeMismatch {
 		t.Errorf("Transaction decryption should fail with incorrect challenge. err=%v", err)
 		return
 	}
 
+	// Valid challenge ciphertext, challenge string with different length
+	for _, wrongChallenge := range []string{
+		"",
+		CorrectChallenge[:len(CorrectChallenge)-1],
+		CorrectChallenge + "!",
+	} {
+		transaction, _ = GenerateTransactionWithEncryption(
+			innerOperationJson,
+			[]byte(wrongChallenge),
+			func(map[string]string) {},
+			privateKey,
+		)
+		_, err = transaction.Decrypt(privateKey)
+		if err != ErrChallengeMismatch {
+			t.Errorf("Transaction decryption should fail with challenge of different length. challenge=%v, err=%v", wrongChallenge, err)
+			return
+		}
+	}
+
 	// Skipping wrong challenge
 	transaction, _ = GenerateTransactionWithEncryption(
 		innerOperationJson,
@@ -316,9 +542,235 @@ func TestInavlidTransactionPayloadStruncture(t *testing.T) {
 	}
 }
 
+func TestTransactionDecryptionErrors(t *testing.T) {
+	transaction, privateKey := GenerateTransactionWithEncryption(
+		[]byte("{}"),
+		[]byte(CorrectChallenge),
+		func(map[string]string) {},
+		nil,
+	)
+
+	// Key not addressed by any challenge
+	if _, err := transaction.Decrypt(GeneratePrivateKey()); err != ErrNotARecipient {
+		t.Errorf("Transaction decryption should fail for non recipient. err=%v", err)
+	}
+
+	// Key addressed, but challenge doesn't pass
+	wrongChallengeTransaction, _ := GenerateTransactionWithEncryption(
+		[]byte("{}"),
+		[]byte("WRONG CHALLENGE"),
+		func(map[string]string) {},
+		privateKey,
+	)
+	if _, err := wrongChallengeTransaction.Decrypt(privateKey); err != ErrChallengeMismatch {
+		t.Errorf("Transaction decryption should fail with challenge mismatch. err=%v", err)
+	}
+
+	// Tampered payload
+	payloadBytes, _ := Base64DecodeString(transaction.Payload)
+	payloadBytes[0] ^= 0xff
+	transaction.Payload = Base64EncodeToString(payloadBytes)
+	if _, err := transaction.Decrypt(privateKey); err != ErrPayloadAuth {
+		t.Errorf("Transaction decryption should fail with tampered payload. err=%v", err)
+	}
+
+	// Structurally empty challenges
+	for _, challenges := range []map[string]string{nil, {}} {
+		transaction.Encryption.Challenges = challenges
+		if _, err := transaction.Decrypt(privateKey); err != ErrNoChallenges {
+			t.Errorf("Transaction decryption should fail without challenges. challenges=%v, err=%v", challenges, err)
+		}
+	}
+}
+
 /*
 	Permanent decryption
 */
+/*
+	Makes a transaction addressed to the recipient with the given number of challenges for other keys
+	(other keys are reused to keep generation fast)
+*/
+func generateTransactionWithManyChallenges(recipientKey *rsa.PrivateKey, otherChallenges int) *Transaction {
+	innerOperationJson, _ := GenerateOperation(false, "", []byte{}, false, "ISSUER", []byte{}, false, "CERTIFIER", []byte{}, false, 1, []byte("REQUEST_PAYLOAD"), false).Encode()
+	otherKeys := []*rsa.PublicKey{GeneratePublicKey(), GeneratePublicKey(), GeneratePublicKey()}
+	recipients := []*rsa.PublicKey{&recipientKey.PublicKey}
+	for i := 0; i < otherChallenges; i++ {
+		recipients = append(recipients, otherKeys[i%len(otherKeys)])
+	}
+	return GenerateTransactionForRecipients(innerOperationJson, []byte(CorrectChallenge), recipients)
+}
+
+func TestTransactionDecryptionManyChallenges(t *testing.T) {
+	recipientKey := GeneratePrivateKey()
+	transaction := generateTransactionWithManyChallenges(recipientKey, 32)
+	if len(transaction.Encryption.Challenges) != 33 {
+		t.Errorf("Transaction should have one challenge per recipient. found=%v", len(transaction.Encryption.Challenges))
+	}
+	if _, err := transaction.Decrypt(recipientKey); err != nil {
+		t.Errorf("Transaction decryption should succeed among many challenges. err=%v", err)
+	}
+	if _, err := transaction.Decrypt(GeneratePrivateKey()); err != ErrNotARecipient {
+		t.Errorf("Transaction decryption should fail for non recipient. err=%v", err)
+	}
+}
+
+func TestTransactionDecryptionCancelled(t *testing.T) {
+	recipientKey := GeneratePrivateKey()
+	transaction := generateTransactionWithManyChallenges(recipientKey, 4096)
+
+	// Cancel while challenges are being tried by a non recipient
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := transaction.DecryptWithContext(ctx, GeneratePrivateKey()); err != context.Canceled {
+		t.Errorf("Cancelled decryption should fail with context error. err=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Cancelled decryption should return promptly. elapsed=%v", elapsed)
+	}
+
+	// Context done before decryption
+	if _, err := transaction.DecryptWithContext(ctx, recipientKey); err != context.Canceled {
+		t.Errorf("Decryption with a done context should fail with context error. err=%v", err)
+	}
+}
+
+func TestDecodeMaxChallenges(t *testing.T) {
+	recipientKey := GeneratePrivateKey()
+	transaction := generateTransactionWithManyChallenges(recipientKey, 4)
+	encoded, _ := transaction.Encode()
+
+	// Limit counts every challenge
+	var decoded Transaction
+	if err := decoded.DecodeWithMaxChallenges(encoded, 4); err != ErrTooManyChallenges {
+		t.Errorf("Decoding should fail with more challenges than allowed. err=%v", err)
+	}
+	for _, maxChallenges := range []int{5, 0} {
+		decoded = Transaction{}
+		if err := decoded.DecodeWithMaxChallenges(encoded, maxChallenges); err != nil || len(decoded.Encryption.Challenges) != 5 {
+			t.Errorf("Decoding within limit should succeed. maxChallenges=%v, err=%v", maxChallenges, err)
+		}
+	}
+
+	// Default limit applies to regular decoding
+	challenges := map[string]string{}
+	for i := 0; i <= DefaultMaxChallenges; i++ {
+		challenges[strconv.Itoa(i)] = "CHALLENGE"
+	}
+	encoded, _ = GenerateTransaction(true, challenges, []byte("NONCE"), false, []byte("PAYLOAD"), false).Encode()
+	decoded = Transaction{}
+	if err := decoded.Decode(encoded); err != ErrTooManyChallenges || decoded.Encryption.Challenges != nil {
+		t.Errorf("Decoding should fail with more than the default number of challenges. err=%v", err)
+	}
+	if _, err := DecodeOperation(encoded); err != ErrTooManyChallenges {
+		t.Errorf("Decoding operation should fail with more than the default number of challenges. err=%v", err)
+	}
+}
+
+func TestTransactionDecryptionDeterministic(t *testing.T) {
+	// Make transaction with two passing challenges, only one of which decrypts the payload
+	recipientKey := GeneratePrivateKey()
+	innerOperationJson, _ := GenerateOperation(false, "", []byte{}, false, "ISSUER", []byte{}, false, "CERTIFIER", []byte{}, false, 1, []byte("REQUEST_PAYLOAD"), false).Encode()
+	nonce := generateRandomBytes(SymmetricNonceSize)
+	challenges := map[string]string{}
+	var payloadCiphertext []byte
+	for i := 0; i < 2; i++ {
+		symKey := generateRandomBytes(SymmetricKeySize)
+		aead, _ := NewAead(symKey)
+		if i == 0 {
+			payloadCiphertext, _ = SymmetricEncrypt(aead, []byte{}, nonce, innerOperationJson)
+		}
+		challengeCiphertext, _ := SymmetricEncrypt(aead, []byte{}, nonce, []byte(CorrectChallenge))
+		symKeyEncrypted, _ := wrapTransactionKey(TransactionVersion, &recipientKey.PublicKey, symKey, nonce)
+		challenges[Base64EncodeToString(symKeyEncrypted)] = Base64EncodeToString(challengeCiphertext)
+	}
+	transaction := GenerateTransaction(true, challenges, nonce, false, payloadCiphertext, false)
+
+	// Same challenge should always be picked
+	expectedOperation, expectedErr := transaction.Decrypt(recipientKey)
+	for i := 0; i < 20; i++ {
+		decryptedOperation, err := transaction.Decrypt(recipientKey)
+		if err != expectedErr || !reflect.DeepEqual(decryptedOperation, expectedOperation) {
+			t.Errorf("Transaction decryption should be deterministic. err=%v, expectedErr=%v", err, expectedErr)
+			return
+		}
+	}
+}
+
+func TestTransactionKeyWrapVersions(t *testing.T) {
+	recipientKey := GeneratePrivateKey()
+	innerOperationJson, _ := GenerateOperation(false, "", []byte{}, false, "ISSUER", []byte{}, false, "CERTIFIER", []byte{}, false, 1, []byte("REQUEST_PAYLOAD"), false).Encode()
+
+	// Current transactions wrap keys with a label bound to the nonce
+	transaction, _ := GenerateTransactionWithEncryption(innerOperationJson, []byte(CorrectChallenge), func(map[string]string) {}, recipientKey)
+	if transaction.Version != TransactionVersion {
+		t.Errorf("Generated transaction should have current version. version=%v", transaction.Version)
+	}
+	if _, err := transaction.Decrypt(recipientKey); err != nil {
+		t.Errorf("Current transaction decryption should succeed. err=%v", err)
+	}
+	labeledTransaction := *transaction
+	labeledTransaction.Version = LegacyTransactionVersion
+	if _, err := labeledTransaction.Decrypt(recipientKey); err != ErrNotARecipient {
+		t.Errorf("Labeled key should not unwrap as a legacy key. err=%v", err)
+	}
+	labeledTransaction = *transaction
+	labeledTransaction.Encryption.Nonce = Base64EncodeToString(generateRandomBytes(SymmetricNonceSize))
+	if _, err := labeledTransaction.Decrypt(recipientKey); err != ErrNotARecipient {
+		t.Errorf("Labeled key should not unwrap with another nonce. err=%v", err)
+	}
+
+	// Legacy transactions still decrypt
+	nonce := generateRandomBytes(SymmetricNonceSize)
+	symKey := generateRandomBytes(SymmetricKeySize)
+	aead, _ := NewAead(symKey)
+	payloadCiphertext, _ := SymmetricEncrypt(aead, []byte{}, nonce, innerOperationJson)
+	challengeCiphertext, _ := SymmetricEncrypt(aead, []byte{}, nonce, []byte(CorrectChallenge))
+	symKeyEncrypted, _ := wrapTransactionKey(LegacyTransactionVersion, &recipientKey.PublicKey, symKey, nonce)
+	legacyTransaction := GenerateTransaction(
+		true,
+		map[string]string{Base64EncodeToString(symKeyEncrypted): Base64EncodeToString(challengeCiphertext)},
+		nonce,
+		false,
+		payloadCiphertext,
+		false,
+	)
+	legacyTransaction.Version = LegacyTransactionVersion
+	if _, err := legacyTransaction.Decrypt(recipientKey); err != nil {
+		t.Errorf("Legacy transaction decryption should succeed. err=%v", err)
+	}
+
+	// Transactions without a version are legacy
+	unversionedTransaction := *legacyTransaction
+	unversionedTransaction.Version = 0
+	if err := unversionedTransaction.Validate(); err != nil {
+		t.Errorf("Transaction without version should be valid. err=%v", err)
+	}
+	if _, err := unversionedTransaction.Decrypt(recipientKey); err != nil {
+		t.Errorf("Transaction without version should decrypt as legacy. err=%v", err)
+	}
+
+	// Legacy keys failing the challenge or the padding check fail the same way
+	wrongChallengeCiphertext, _ := SymmetricEncrypt(aead, []byte{}, nonce, []byte("WRONG CHALLENGE"))
+	legacyTransaction.Encryption.Challenges = map[string]string{Base64EncodeToString(symKeyEncrypted): Base64EncodeToString(wrongChallengeCiphertext)}
+	if _, err := legacyTransaction.Decrypt(recipientKey); err != ErrNotARecipient {
+		t.Errorf("Legacy transaction with wrong challenge should not reveal the key unwrapped. err=%v", err)
+	}
+	legacyTransaction.Encryption.Challenges = map[string]string{Base64EncodeToString(generateRandomBytes(AsymmetricKeySizeBytes)): Base64EncodeToString(challengeCiphertext)}
+	if _, err := legacyTransaction.Decrypt(recipientKey); err != ErrNotARecipient {
+		t.Errorf("Legacy transaction with invalid key padding should not be a recipient. err=%v", err)
+	}
+}
+
+func BenchmarkTransactionDecryptManyChallenges(b *testing.B) {
+	recipientKey := GeneratePrivateKey()
+	transaction := generateTransactionWithManyChallenges(recipientKey, 256)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transaction.Decrypt(recipientKey)
+	}
+}
+
 func TestPermanentValidOperation(t *testing.T) {
 	// Make valid encrypted operation
 	permanentKey := generateRandomBytes(SymmetricKeySize)
@@ -346,64 +798,89 @@ func TestPermanentValidOperation(t *testing.T) {
 	}
 }
 
-func TestPermanentInvalidPayload(t *testing.T) {
-	// Make valid encrypted operation
-	encryptedOperation := GenerateOperation(
-		true,
+func TestPermanentDecryptPayloadOnly(t *testing.T) {
+	// Make valid encrypted operation with unknown signers
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
 		"KEY_ID",
+		permanentKey,
 		generateRandomBytes(SymmetricNonceSize),
-		true,
+		1,
+		requestPayload,
 		"ISSUER",
-		[]byte(validBase64string),
-		true,
+		dummyByteToByteTransformer,
 		"CERTIFIER",
-		[]byte(validBase64string),
-		true,
-		1,
-		[]byte(invalidBase64string),
-		true,
+		dummyByteToByteTransformer,
 	)
 
-	_, err := encryptedOperation.Decrypt(
-		DecryptorFunctor(map[string][]byte{"KEY_ID": generateRandomBytes(SymmetricKeySize)}, true),
-	)
-	if err != payloadDecodeError {
-		t.Error("Permanent decryption should fail with invalid base64 payload.")
-		return
+	// Decrypt with only the symmetric key
+	decryptedPayload, err := encryptedOperation.DecryptPayloadOnly(permanentKey)
+	if err != nil || !reflect.DeepEqual(decryptedPayload, requestPayload) {
+		t.Errorf("Payload only decryption failed. found=%v, expected=%v, err=%v", decryptedPayload, requestPayload, err)
+	}
+
+	// Wrong key
+	if _, err := encryptedOperation.DecryptPayloadOnly(generateRandomBytes(SymmetricKeySize)); err != payloadDecryptionError {
+		t.Errorf("Payload only decryption should fail with the wrong key. err=%v", err)
+	}
+
+	// Signatures are still rejected on the verified path
+	if err := encryptedOperation.Verify(GeneratePublicKey(), GeneratePublicKey(), decryptedPayload); err == nil {
+		t.Errorf("Signature verification should still fail with unknown signer keys.")
 	}
 }
 
-func TestPermanentInvalidNonce(t *testing.T) {
-	// Make operation with invalid nonce encoding
-	encryptedOperation := GenerateOperation(
-		true,
+func TestPermanentTamperedMetadata(t *testing.T) {
+	// Make valid encrypted operation
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
 		"KEY_ID",
-		[]byte(invalidBase64string),
-		true,
+		permanentKey,
+		generateRandomBytes(SymmetricNonceSize),
+		1,
+		[]byte("REQUEST_PAYLOAD"),
 		"ISSUER",
-		[]byte(validBase64string),
-		true,
+		dummyByteToByteTransformer,
 		"CERTIFIER",
-		[]byte(validBase64string),
-		true,
-		1,
-		[]byte(validBase64string),
-		true,
+		dummyByteToByteTransformer,
 	)
 
+	// Swap request type
+	encryptedOperation.Meta.RequestType = 0
 	_, err := encryptedOperation.Decrypt(
-		DecryptorFunctor(map[string][]byte{"KEY_ID": generateRandomBytes(SymmetricKeySize)}, true),
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
 	)
-	if err != invalidNonceError {
-		t.Errorf("Permanent decryption should fail with invalid base64 nonce.")
-		return
+	if err != keyNotFoundError {
+		t.Errorf("Permanent decryption should fail with tampered request type. err=%v", err)
 	}
 
-	// Make valid encrypted operation
-	encryptedOperation = GenerateOperation(
+	// Swap key id (same key under a different id)
+	encryptedOperation.Meta.RequestType = 1
+	encryptedOperation.Encryption.KeyId = "OTHER_KEY_ID"
+	_, err = encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"OTHER_KEY_ID": permanentKey}, true),
+	)
+	if err != keyNotFoundError {
+		t.Errorf("Permanent decryption should fail with tampered key id. err=%v", err)
+	}
+}
+
+func TestPermanentValidOperationWithAlgorithm(t *testing.T) {
+	// Make operation encrypted with AES-256-GCM
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	aead, err := NewAeadWithAlgorithm(permanentKey, Aes256GcmAeadAlgorithm)
+	if err != nil {
+		t.Errorf("AES-256-GCM aead creation failed. err=%v", err)
+		return
+	}
+	encryptedPayload, _ := SymmetricEncryptWithAssociatedData(aead, []byte{}, permanentNonce, requestPayload, OperationAssociatedData("KEY_ID", 1))
+	encryptedOperation := GenerateOperation(
 		true,
 		"KEY_ID",
-		generateRandomBytes(1+SymmetricNonceSize),
+		permanentNonce,
 		false,
 		"ISSUER",
 		[]byte(validBase64string),
@@ -412,7 +889,97 @@ func TestPermanentInvalidNonce(t *testing.T) {
 		[]byte(validBase64string),
 		true,
 		1,
-		[]byte(validBase64string),
+		encryptedPayload,
+		false,
+	)
+	encryptedOperation.Encryption.Algorithm = Aes256GcmAeadAlgorithm
+
+	decryptedDecodedPayload, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil ||
+		!reflect.DeepEqual(decryptedDecodedPayload, requestPayload) {
+		t.Errorf("Permanent decryption with AES-256-GCM failed. found=%+v, expected=%v", decryptedDecodedPayload, requestPayload)
+		return
+	}
+
+	// Missing algorithm should default to ChaCha20-Poly1305
+	encryptedOperation.Encryption.Algorithm = ChaCha20Poly1305AeadAlgorithm
+	_, err = encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != keyNotFoundError {
+		t.Errorf("Permanent decryption should fail with the default algorithm. err=%v", err)
+	}
+}
+
+func TestPermanentInvalidPayload(t *testing.T) {
+	// Make valid encrypted operation
+	encryptedOperation := GenerateOperation(
+		true,
+		"KEY_ID",
+		generateRandomBytes(SymmetricNonceSize),
+		true,
+		"ISSUER",
+		[]byte(validBase64string),
+		true,
+		"CERTIFIER",
+		[]byte(validBase64string),
+		true,
+		1,
+		[]byte(invalidBase64string),
+		true,
+	)
+
+	_, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": generateRandomBytes(SymmetricKeySize)}, true),
+	)
+	if err != payloadDecodeError {
+		t.Error("Permanent decryption should fail with invalid base64 payload.")
+		return
+	}
+}
+
+func TestPermanentInvalidNonce(t *testing.T) {
+	// Make operation with invalid nonce encoding
+	encryptedOperation := GenerateOperation(
+		true,
+		"KEY_ID",
+		[]byte(invalidBase64string),
+		true,
+		"ISSUER",
+		[]byte(validBase64string),
+		true,
+		"CERTIFIER",
+		[]byte(validBase64string),
+		true,
+		1,
+		[]byte(validBase64string),
+		true,
+	)
+
+	_, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": generateRandomBytes(SymmetricKeySize)}, true),
+	)
+	if err != invalidNonceError {
+		t.Errorf("Permanent decryption should fail with invalid base64 nonce.")
+		return
+	}
+
+	// Make valid encrypted operation
+	encryptedOperation = GenerateOperation(
+		true,
+		"KEY_ID",
+		generateRandomBytes(1+SymmetricNonceSize),
+		false,
+		"ISSUER",
+		[]byte(validBase64string),
+		true,
+		"CERTIFIER",
+		[]byte(validBase64string),
+		true,
+		1,
+		[]byte(validBase64string),
 		true,
 	)
 
@@ -574,3 +1141,701 @@ func TestPermanentInvalidCertifierSignature(t *testing.T) {
 		t.Errorf("Verify should fail with invalid base64 certifier signature. err=%v", err)
 	}
 }
+
+func TestPermanentEd25519Signatures(t *testing.T) {
+	// Make operation signed with ed25519 keys
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	issuerKey := GenerateEd25519PrivateKey()
+	certifierKey := GenerateEd25519PrivateKey()
+	ed25519SignatureTransformer := func(key ed25519.PrivateKey) func([]byte) ([]byte, bool) {
+		return func([]byte) ([]byte, bool) {
+			signedData, _ := OperationSignedData(OperationVersion, "KEY_ID", Base64EncodeToString(permanentNonce), requestPayload, ValidityWindow{}, false)
+			signature, _ := SignEd25519(key, Hash(signedData))
+			return signature, false
+		}
+	}
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		requestPayload,
+		"ISSUER",
+		ed25519SignatureTransformer(issuerKey),
+		"CERTIFIER",
+		ed25519SignatureTransformer(certifierKey),
+	)
+	encryptedOperation.Issue.Algorithm = Ed25519SigningAlgorithm
+	encryptedOperation.Certification.Algorithm = Ed25519SigningAlgorithm
+
+	payload, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil {
+		t.Errorf("Permanent decryption should not fail with ed25519 signatures. err=%v", err)
+		return
+	}
+	err = encryptedOperation.Verify(
+		issuerKey.Public(),
+		certifierKey.Public(),
+		payload,
+	)
+	if err != nil {
+		t.Errorf("Verify should succeed with valid ed25519 signatures. err=%v", err)
+	}
+
+	// Signatures with wrong keys
+	err = encryptedOperation.Verify(
+		certifierKey.Public(),
+		certifierKey.Public(),
+		payload,
+	)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with wrong ed25519 issuer key. err=%v", err)
+	}
+
+	// RSA key used for ed25519 signature
+	err = encryptedOperation.Verify(
+		issuerKey.Public(),
+		GeneratePublicKey(),
+		payload,
+	)
+	if err != invalidCertifierSignatureError {
+		t.Errorf("Verify should fail with key not matching the signing algorithm. err=%v", err)
+	}
+
+	// Missing algorithm should default to RSA
+	encryptedOperation.Issue.Algorithm = RsaSigningAlgorithm
+	err = encryptedOperation.Verify(
+		issuerKey.Public(),
+		certifierKey.Public(),
+		payload,
+	)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should use RSA when no signing algorithm is specified. err=%v", err)
+	}
+}
+
+func TestPermanentHashAlgorithmSignatures(t *testing.T) {
+	// Make operation signed over SHA-512
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	issuerKey := GeneratePrivateKey()
+	certifierKey := GeneratePrivateKey()
+	sha512SignatureTransformer := func(key *rsa.PrivateKey) func([]byte) ([]byte, bool) {
+		return func([]byte) ([]byte, bool) {
+			signedData, _ := OperationSignedData(OperationVersion, "KEY_ID", Base64EncodeToString(permanentNonce), requestPayload, ValidityWindow{}, false)
+			signature, _ := SignWithHashAlgorithm(key, Sha512HashAlgorithm, HashWithAlgorithm(signedData, Sha512HashAlgorithm))
+			return signature, false
+		}
+	}
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		requestPayload,
+		"ISSUER",
+		sha512SignatureTransformer(issuerKey),
+		"CERTIFIER",
+		sha512SignatureTransformer(certifierKey),
+	)
+	encryptedOperation.Issue.HashAlgorithm = Sha512HashAlgorithm
+	encryptedOperation.Certification.HashAlgorithm = Sha512HashAlgorithm
+
+	payload, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil {
+		t.Errorf("Permanent decryption should not fail. err=%v", err)
+		return
+	}
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != nil {
+		t.Errorf("Verify should use stored hash algorithm. err=%v", err)
+	}
+
+	// Tampered hash algorithm
+	encryptedOperation.Issue.HashAlgorithm = Sha256HashAlgorithm
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with tampered hash algorithm. err=%v", err)
+	}
+
+	// Unknown hash algorithm
+	encryptedOperation.Issue.HashAlgorithm = Sha512HashAlgorithm + 1
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidHashAlgorithmError {
+		t.Errorf("Verify should fail with unknown hash algorithm. err=%v", err)
+	}
+}
+
+func TestPermanentValidityWindowSignatures(t *testing.T) {
+	// Make operation signed with validity window
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	window := ValidityWindow{
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	issuerKey := GeneratePrivateKey()
+	certifierKey := GeneratePrivateKey()
+	windowSignatureTransformer := func(key *rsa.PrivateKey) func([]byte) ([]byte, bool) {
+		return func([]byte) ([]byte, bool) {
+			signedData, _ := OperationSignedData(OperationVersion, "KEY_ID", Base64EncodeToString(permanentNonce), requestPayload, window, false)
+			signature, _ := Sign(key, Hash(signedData))
+			return signature, false
+		}
+	}
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		requestPayload,
+		"ISSUER",
+		windowSignatureTransformer(issuerKey),
+		"CERTIFIER",
+		windowSignatureTransformer(certifierKey),
+	)
+	encryptedOperation.Meta.ValidityWindow = window
+
+	payload, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil {
+		t.Errorf("Permanent decryption should not fail with validity window. err=%v", err)
+		return
+	}
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != nil {
+		t.Errorf("Verify should succeed with signed validity window. err=%v", err)
+	}
+
+	// Extended window
+	encryptedOperation.Meta.NotAfter = window.NotAfter.Add(time.Hour)
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with tampered validity window. err=%v", err)
+	}
+
+	// Removed window
+	encryptedOperation.Meta.ValidityWindow = ValidityWindow{}
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with removed validity window. err=%v", err)
+	}
+}
+
+func TestPermanentDryRunSignatures(t *testing.T) {
+	// Make dry run operation
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	requestPayload := []byte("REQUEST_PAYLOAD")
+	issuerKey := GeneratePrivateKey()
+	certifierKey := GeneratePrivateKey()
+	dryRunSignatureTransformer := func(key *rsa.PrivateKey) func([]byte) ([]byte, bool) {
+		return func([]byte) ([]byte, bool) {
+			signedData, _ := OperationSignedData(OperationVersion, "KEY_ID", Base64EncodeToString(permanentNonce), requestPayload, ValidityWindow{}, true)
+			signature, _ := Sign(key, Hash(signedData))
+			return signature, false
+		}
+	}
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		requestPayload,
+		"ISSUER",
+		dryRunSignatureTransformer(issuerKey),
+		"CERTIFIER",
+		dryRunSignatureTransformer(certifierKey),
+	)
+	encryptedOperation.Meta.DryRun = true
+
+	payload, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil {
+		t.Errorf("Permanent decryption should not fail for dry run. err=%v", err)
+		return
+	}
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != nil {
+		t.Errorf("Verify should succeed with signed dry run flag. err=%v", err)
+	}
+
+	// Removed flag
+	encryptedOperation.Meta.DryRun = false
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with removed dry run flag. err=%v", err)
+	}
+}
+
+func TestPermanentBoundSignatures(t *testing.T) {
+	// Make valid encrypted operation
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	encryptedOperation, issuerKey, certifierKey := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		generateRandomBytes(SymmetricNonceSize),
+		1,
+		[]byte("REQUEST_PAYLOAD"),
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+	)
+	payload, err := encryptedOperation.Decrypt(
+		DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true),
+	)
+	if err != nil {
+		t.Errorf("Permanent decryption should not fail. err=%v", err)
+		return
+	}
+	if encryptedOperation.Version != OperationVersion {
+		t.Errorf("Generated operation should have current version. version=%v", encryptedOperation.Version)
+	}
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != nil {
+		t.Errorf("Verify should succeed with bound signatures. err=%v", err)
+	}
+
+	// Re-framed under a different nonce
+	originalNonce := encryptedOperation.Encryption.Nonce
+	encryptedOperation.Encryption.Nonce = Base64EncodeToString(generateRandomBytes(SymmetricNonceSize))
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with modified nonce. err=%v", err)
+	}
+
+	// Re-framed under a different key id
+	encryptedOperation.Encryption.Nonce = originalNonce
+	encryptedOperation.Encryption.KeyId = "OTHER_KEY_ID"
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with modified key id. err=%v", err)
+	}
+
+	// Downgraded to bound version
+	encryptedOperation.Encryption.KeyId = "KEY_ID"
+	encryptedOperation.Version = BoundOperationVersion
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with downgraded bound version. err=%v", err)
+	}
+
+	// Downgraded to legacy version
+	encryptedOperation.Version = LegacyOperationVersion
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != invalidIssuerSignatureError {
+		t.Errorf("Verify should fail with downgraded version. err=%v", err)
+	}
+
+	// Unknown version
+	encryptedOperation.Version = OperationVersion + 1
+	err = encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, payload)
+	if err != unsupportedOperationVersionError {
+		t.Errorf("Verify should fail with unsupported version. err=%v", err)
+	}
+}
+
+func TestCanonicalSignedData(t *testing.T) {
+	payload := []byte("REQUEST_PAYLOAD")
+	signedData, err := OperationSignedData(OperationVersion, "KEY_ID", "NONCE", payload, ValidityWindow{}, false)
+	expected := `{"dryRun":false,"keyId":"KEY_ID","nonce":"NONCE","notAfter":0,"notBefore":0,"payload":"` +
+		Base64EncodeToString(payload) + `","version":0.3}`
+	if err != nil || string(signedData) != expected {
+		t.Errorf("Signed data of current version should be canonical JSON of every field. found=%s, expected=%s", signedData, expected)
+	}
+
+	// Every field is encoded even if unset, so fields can't be moved between each other
+	window := ValidityWindow{NotAfter: time.Unix(0, 1)}
+	windowSignedData, _ := OperationSignedData(OperationVersion, "KEY_ID", "NONCE", payload, window, true)
+	if bytes.Equal(windowSignedData, signedData) || !bytes.Contains(windowSignedData, []byte(`"notAfter":1,`)) || !bytes.Contains(windowSignedData, []byte(`"dryRun":true`)) {
+		t.Errorf("Signed data should cover validity window and dry run flag. found=%s", windowSignedData)
+	}
+
+	// Bound version keeps its format
+	boundSignedData, _ := OperationSignedData(BoundOperationVersion, "KEY_ID", "NONCE", payload, ValidityWindow{}, false)
+	if !bytes.HasSuffix(boundSignedData, payload) || len(boundSignedData) != 4+len("KEY_ID")+4+len("NONCE")+len(payload) {
+		t.Errorf("Signed data of bound version should keep its format. found=%v", boundSignedData)
+	}
+}
+
+func TestAmbiguousSignedData(t *testing.T) {
+	// Payload of a bound operation ending with bytes that would decode as a validity window
+	window := ValidityWindow{NotBefore: time.Unix(0, 1), NotAfter: time.Unix(0, 2)}
+	payload := append([]byte("REQUEST_PAYLOAD"), 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2)
+	issuerKey := GeneratePrivateKey()
+	signedData, _ := OperationSignedData(BoundOperationVersion, "KEY_ID", "NONCE", payload, ValidityWindow{}, false)
+	issuerSignature, _ := Sign(issuerKey, Hash(signedData))
+	operation := &Operation{
+		Version:    BoundOperationVersion,
+		Encryption: OperationEncryptionFields{KeyId: "KEY_ID", Nonce: "NONCE"},
+		Issue:      OperationAuthenticationFields{Signature: Base64EncodeToString(issuerSignature)},
+	}
+	if err := operation.VerifyIssuer(&issuerKey.PublicKey, payload); err != nil {
+		t.Errorf("Verify should succeed with original payload. err=%v", err)
+	}
+
+	// Signature can't be reused for a shorter payload with a window, or with a dry run flag
+	operation.Meta.ValidityWindow = window
+	if err := operation.VerifyIssuer(&issuerKey.PublicKey, payload[:len(payload)-16]); err != unsignedMetadataError {
+		t.Errorf("Verify should fail with a window forged from the payload. err=%v", err)
+	}
+	operation.Meta.ValidityWindow = ValidityWindow{}
+	operation.Meta.DryRun = true
+	if err := operation.VerifyIssuer(&issuerKey.PublicKey, payload); err != unsignedMetadataError {
+		t.Errorf("Verify should fail with a dry run flag on an older version. err=%v", err)
+	}
+	for _, version := range []float64{0, LegacyOperationVersion, BoundOperationVersion} {
+		if _, err := OperationSignedData(version, "KEY_ID", "NONCE", payload, window, false); err != unsignedMetadataError {
+			t.Errorf("Older versions should not sign validity windows. version=%v, err=%v", version, err)
+		}
+	}
+
+	// Current version keeps payload and window apart
+	signedData, _ = OperationSignedData(OperationVersion, "KEY_ID", "NONCE", payload, ValidityWindow{}, false)
+	forgedSignedData, _ := OperationSignedData(OperationVersion, "KEY_ID", "NONCE", payload[:len(payload)-16], window, false)
+	if bytes.Equal(signedData, forgedSignedData) {
+		t.Errorf("Signed data of current version should not be ambiguous.")
+	}
+}
+
+func TestReEncryptPayload(t *testing.T) {
+	oldKey := generateRandomBytes(SymmetricKeySize)
+	oldNonce := generateRandomBytes(SymmetricNonceSize)
+	payload := []byte("REQUEST_PAYLOAD")
+	encryptedOperation, issuerKey, certifierKey := GenerateOperationWithEncryption(
+		"KEY_ID",
+		oldKey,
+		oldNonce,
+		1,
+		payload,
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+	)
+	newKey := generateRandomBytes(SymmetricKeySize)
+	newNonce := generateRandomBytes(SymmetricNonceSize)
+
+	// Current versions can't be re-encrypted without invalidating signatures
+	original := *encryptedOperation
+	if err := encryptedOperation.ReEncryptPayload(oldKey, "NEW_KEY_ID", newKey, newNonce); err != boundSignaturesError || *encryptedOperation != original {
+		t.Errorf("Re-encrypting operation with bound signatures should fail. err=%v", err)
+	}
+
+	// Encrypt and sign as legacy operations are
+	encryptedOperation.Version = LegacyOperationVersion
+	aead, _ := NewAead(oldKey)
+	ciphertext, _ := SymmetricEncryptWithAssociatedData(aead, []byte{}, oldNonce, payload, OperationAssociatedData("KEY_ID", 1))
+	encryptedOperation.Payload = Base64EncodeToString(ciphertext)
+	signedData, _ := encryptedOperation.SignedData(payload)
+	issuerSignature, _ := Sign(issuerKey, Hash(signedData))
+	certifierSignature, _ := Sign(certifierKey, Hash(signedData))
+	encryptedOperation.Issue.Signature = Base64EncodeToString(issuerSignature)
+	encryptedOperation.Certification.Signature = Base64EncodeToString(certifierSignature)
+
+	// Wrong old key leaves operation unchanged
+	original = *encryptedOperation
+	if err := encryptedOperation.ReEncryptPayload(newKey, "NEW_KEY_ID", newKey, newNonce); err != payloadDecryptionError || *encryptedOperation != original {
+		t.Errorf("Re-encrypting with the wrong key should fail. err=%v", err)
+	}
+
+	if err := encryptedOperation.ReEncryptPayload(oldKey, "NEW_KEY_ID", newKey, newNonce); err != nil {
+		t.Fatalf("Re-encrypting legacy operation should succeed. err=%v", err)
+	}
+	if encryptedOperation.Encryption.KeyId != "NEW_KEY_ID" || encryptedOperation.Encryption.Nonce != Base64EncodeToString(newNonce) {
+		t.Errorf("Re-encrypting should update key id and nonce. encryption=%+v", encryptedOperation.Encryption)
+	}
+
+	// Only the new key decrypts, and original signatures still verify
+	if _, err := encryptedOperation.Decrypt(DecryptorFunctor(map[string][]byte{"KEY_ID": oldKey}, true)); err == nil {
+		t.Errorf("Old key should not decrypt re-encrypted payload.")
+	}
+	decrypted, err := encryptedOperation.Decrypt(DecryptorFunctor(map[string][]byte{"NEW_KEY_ID": newKey}, true))
+	if err != nil || string(decrypted) != string(payload) {
+		t.Errorf("New key should decrypt re-encrypted payload. decrypted=%s, err=%v", decrypted, err)
+	}
+	if err := encryptedOperation.Verify(&issuerKey.PublicKey, &certifierKey.PublicKey, decrypted); err != nil {
+		t.Errorf("Original signatures should verify after re-encryption. err=%v", err)
+	}
+}
+
+func TestAssociatedDataVersions(t *testing.T) {
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+	payload := []byte("REQUEST_PAYLOAD")
+	decryptor := DecryptorFunctor(map[string][]byte{"KEY_ID": permanentKey}, true)
+	encryptedOperation, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		permanentKey,
+		permanentNonce,
+		1,
+		payload,
+		"ISSUER",
+		dummyByteToByteTransformer,
+		"CERTIFIER",
+		dummyByteToByteTransformer,
+	)
+	if decrypted, err := encryptedOperation.Decrypt(decryptor); err != nil || !bytes.Equal(decrypted, payload) {
+		t.Fatalf("Current operation should decrypt. decrypted=%s, err=%v", decrypted, err)
+	}
+
+	// Current versions bind compression
+	encryptedOperation.Encryption.Compression = GzipCompression
+	if _, err := encryptedOperation.Decrypt(decryptor); err != keyNotFoundError {
+		t.Errorf("Decryption should fail with tampered compression. err=%v", err)
+	}
+	encryptedOperation.Encryption.Compression = NoCompression
+
+	// Bound versions don't accept current associated data
+	encryptedOperation.Version = BoundOperationVersion
+	if _, err := encryptedOperation.Decrypt(decryptor); err != keyNotFoundError {
+		t.Errorf("Decryption should fail with associated data of another version. err=%v", err)
+	}
+
+	// Only legacy versions accept payloads encrypted without associated data
+	aead, _ := NewAead(permanentKey)
+	ciphertext, _ := SymmetricEncryptWithAssociatedData(aead, []byte{}, permanentNonce, payload, []byte{})
+	encryptedOperation.Payload = Base64EncodeToString(ciphertext)
+	if _, err := encryptedOperation.Decrypt(decryptor); err != keyNotFoundError {
+		t.Errorf("Bound operation without associated data should not decrypt. err=%v", err)
+	}
+	encryptedOperation.Version = LegacyOperationVersion
+	if decrypted, err := encryptedOperation.Decrypt(decryptor); err != nil || !bytes.Equal(decrypted, payload) {
+		t.Errorf("Legacy operation without associated data should decrypt. decrypted=%s, err=%v", decrypted, err)
+	}
+}
+
+func TestSignatureLengthChecks(t *testing.T) {
+	key := GeneratePrivateKey()
+	hashed := Hash([]byte("PLAINTEXT"))
+	signature, err := Sign(key, hashed)
+	if err != nil || !Verify(&key.PublicKey, hashed, signature) {
+		t.Errorf("Valid signature should verify. err=%v", err)
+		return
+	}
+
+	// Trailing bytes after the expected length
+	for _, padding := range [][]byte{{0}, []byte("GARBAGE")} {
+		if Verify(&key.PublicKey, hashed, append(append([]byte{}, signature...), padding...)) {
+			t.Errorf("Over-length signature should be rejected. padding=%v", padding)
+		}
+	}
+
+	// Key modulus size doesn't match signature length
+	smallKey, _ := rsa.GenerateKey(rng, 1024)
+	smallSignature, _ := Sign(smallKey, hashed)
+	if Verify(&key.PublicKey, hashed, smallSignature) {
+		t.Errorf("Signature shorter than key modulus should be rejected.")
+	}
+	if Verify(&smallKey.PublicKey, hashed, signature) {
+		t.Errorf("Signature longer than key modulus should be rejected.")
+	}
+
+	// Missing key
+	if Verify(nil, hashed, signature) || Verify(&rsa.PublicKey{}, hashed, signature) {
+		t.Errorf("Verification without a key modulus should fail.")
+	}
+}
+
+func TestSymmetricInvalidNonceSize(t *testing.T) {
+	aead, _ := NewAead(generateRandomBytes(SymmetricKeySize))
+	for _, nonceSize := range []int{0, SymmetricNonceSize - 1, SymmetricNonceSize + 1} {
+		nonce := generateRandomBytes(nonceSize)
+		expectedErr := &NonceSizeError{Expected: SymmetricNonceSize, Actual: nonceSize}
+
+		_, err := SymmetricEncrypt(aead, []byte{}, nonce, []byte("PLAINTEXT"))
+		if !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("Symmetric encryption should fail with invalid nonce size. found=%v, expected=%v", err, expectedErr)
+		}
+
+		_, err = SymmetricDecrypt(aead, []byte{}, nonce, generateRandomBytes(aead.Overhead()))
+		if !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("Symmetric decryption should fail with invalid nonce size. found=%v, expected=%v", err, expectedErr)
+		}
+	}
+}
+
+/*
+	Batch signature verification
+*/
+
+func generateSignedOperations(count int) ([]*Operation, map[string]crypto.PublicKey) {
+	issuerKey := GeneratePrivateKey()
+	certifierKey := GeneratePrivateKey()
+	keys := map[string]crypto.PublicKey{
+		"ISSUER":    &issuerKey.PublicKey,
+		"CERTIFIER": &certifierKey.PublicKey,
+	}
+
+	ops := []*Operation{}
+	for i := 0; i < count; i++ {
+		payload := generateRandomBytes(100)
+		issuerSignature, _ := Sign(issuerKey, Hash(payload))
+		certifierSignature, _ := Sign(certifierKey, Hash(payload))
+		ops = append(ops, GenerateOperation(
+			false,
+			"",
+			[]byte{},
+			false,
+			"ISSUER",
+			issuerSignature,
+			false,
+			"CERTIFIER",
+			certifierSignature,
+			false,
+			1,
+			payload,
+			false,
+		))
+	}
+	return ops, keys
+}
+
+func TestAsymmetricLabels(t *testing.T) {
+	key := GeneratePrivateKey()
+	plaintext := generateRandomBytes(SymmetricKeySize)
+
+	// Matching labels
+	ciphertext, err := AsymmetricEncryptWithLabel(&key.PublicKey, plaintext, []byte("LABEL"))
+	if err != nil {
+		t.Errorf("Asymmetric encryption with label should not fail. err=%v", err)
+		return
+	}
+	decrypted, err := AsymmetricDecryptWithLabel(key, ciphertext, []byte("LABEL"))
+	if err != nil || !reflect.DeepEqual(decrypted, plaintext) {
+		t.Errorf("Asymmetric decryption should succeed with matching label. err=%v", err)
+	}
+
+	// Mismatched labels
+	if _, err = AsymmetricDecryptWithLabel(key, ciphertext, []byte("OTHER_LABEL")); err != asymmetrictDecryptionError {
+		t.Errorf("Asymmetric decryption should fail with mismatched label. err=%v", err)
+	}
+	if _, err = AsymmetricDecryptWithLabel(key, ciphertext, nil); err != asymmetrictDecryptionError {
+		t.Errorf("Asymmetric decryption should fail with missing label. err=%v", err)
+	}
+
+	// Empty label
+	ciphertext, _ = AsymmetricEncryptWithLabel(&key.PublicKey, plaintext, nil)
+	decrypted, err = AsymmetricDecryptWithLabel(key, ciphertext, []byte{})
+	if err != nil || !reflect.DeepEqual(decrypted, plaintext) {
+		t.Errorf("Asymmetric decryption should treat empty and missing labels the same. err=%v", err)
+	}
+}
+
+func TestVerifySignaturesBatch(t *testing.T) {
+	ops, keys := generateSignedOperations(8)
+
+	// Tamper with some operations
+	ops[1].Issue.Signature = invalidBase64string
+	ops[3].Certification.Signature = ops[2].Certification.Signature
+	ops[4].Issue.Id = "UNKNOWN"
+	ops[5].Encryption.Encrypted = true
+	ops[6] = nil
+
+	errs := VerifySignaturesBatch(ops, keys)
+	expected := []error{
+		nil,
+		invalidSignatureEncodingError,
+		nil,
+		invalidCertifierSignatureError,
+		signerKeyNotFoundError,
+		encryptedPayloadError,
+		invalidOperationError,
+		nil,
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Batch verification errors mismatch. found=%v, expected=%v", errs, expected)
+	}
+
+	if errs := VerifySignaturesBatch([]*Operation{}, keys); len(errs) != 0 {
+		t.Errorf("Batch verification of no operations should return no errors. found=%v", errs)
+	}
+
+	// Operations signed with ed25519 keys alongside RSA ones
+	ed25519Issuer := GenerateEd25519PrivateKey()
+	ed25519Certifier := GenerateEd25519PrivateKey()
+	keys["ED25519_ISSUER"] = ed25519Issuer.Public()
+	keys["ED25519_CERTIFIER"] = ed25519Certifier.Public()
+	ops, _ = generateSignedOperations(1)
+	for _, certifierId := range []string{"ED25519_CERTIFIER", "CERTIFIER"} {
+		payload := generateRandomBytes(100)
+		issuerSignature, _ := SignEd25519(ed25519Issuer, Hash(payload))
+		certifierSignature, _ := SignEd25519(ed25519Certifier, Hash(payload))
+		op := GenerateOperation(false, "", []byte{}, false, "ED25519_ISSUER", issuerSignature, false, certifierId, certifierSignature, false, 1, payload, false)
+		op.Issue.Algorithm = Ed25519SigningAlgorithm
+		op.Certification.Algorithm = Ed25519SigningAlgorithm
+		ops = append(ops, op)
+	}
+	ops[0].Issue.Id = "ED25519_ISSUER"
+	errs = VerifySignaturesBatch(ops, keys)
+	expected = []error{
+		invalidIssuerSignatureError,
+		nil,
+		invalidCertifierSignatureError,
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Mixed batch verification errors mismatch. found=%v, expected=%v", errs, expected)
+	}
+}
+
+func TestVerifyOperation(t *testing.T) {
+	ops, keys := generateSignedOperations(4)
+	issuerKey := keys["ISSUER"]
+	certifierKey := keys["CERTIFIER"]
+
+	// Tamper with some operations
+	ops[1].Issue.Signature = ops[1].Certification.Signature
+	ops[2].Certification.Signature = ops[0].Certification.Signature
+	ops[3].Encryption.Encrypted = true
+
+	if err := VerifyOperation(ops[0], issuerKey, certifierKey); err != nil {
+		t.Errorf("Verification of operation with valid signatures should pass. err=%v", err)
+	}
+	if err := VerifyOperation(ops[0], certifierKey, issuerKey); err != invalidIssuerSignatureError {
+		t.Errorf("Verification with swapped keys should fail on the issuer signature. err=%v", err)
+	}
+	if err := VerifyOperation(ops[1], issuerKey, certifierKey); err != invalidIssuerSignatureError {
+		t.Errorf("Verification of operation with bad issuer signature should fail. err=%v", err)
+	}
+	if err := VerifyOperation(ops[2], issuerKey, certifierKey); err != invalidCertifierSignatureError {
+		t.Errorf("Verification of operation with bad certifier signature should fail. err=%v", err)
+	}
+	if err := VerifyOperation(ops[3], issuerKey, certifierKey); err != encryptedPayloadError {
+		t.Errorf("Verification of encrypted operation should fail. err=%v", err)
+	}
+	if err := VerifyOperation(ops[0], nil, certifierKey); err != signerKeyNotFoundError {
+		t.Errorf("Verification without issuer key should fail. err=%v", err)
+	}
+	if err := VerifyOperation(nil, issuerKey, certifierKey); err != invalidOperationError {
+		t.Errorf("Verification of nil operation should fail. err=%v", err)
+	}
+}
+
+func BenchmarkVerifySignaturesSequential(b *testing.B) {
+	ops, keys := generateSignedOperations(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, op := range ops {
+			verifyOperationSignatures(op, keys)
+		}
+	}
+}
+
+func BenchmarkVerifySignaturesBatch(b *testing.B) {
+	ops, keys := generateSignedOperations(64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		VerifySignaturesBatch(ops, keys)
+	}
+}
@@ -6,25 +6,43 @@ package core
 
 import (
 	"bytes"
-	"crypto/rand"
+	"crypto"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
 )
 
 func generateRandomBytes(nbBytes int) (bytes []byte) {
 	bytes = make([]byte, nbBytes)
-	rand.Read(bytes)
+	io.ReadFull(rng, bytes)
 	return
 }
 
+/*
+	Overwrites key material with zeros once it's no longer needed
+	(best effort only: the garbage collector may have moved or copied the bytes before,
+	and copies held by ciphers are not affected)
+*/
+func ZeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 /*
 	Generator of decryptor functions (used for testing)
 */
 func DecryptorFunctor(keys map[string][]byte, success bool) Decryptor {
 	decryptorError := errors.New("Could not find key")
-	return func(keyId string, nonce []byte, ciphertext []byte) ([]byte, error) {
+	return func(keyId string, algorithm AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
 		if !success {
 			return nil, decryptorError
 		}
@@ -34,13 +52,7 @@ func DecryptorFunctor(keys map[string][]byte, success bool) Decryptor {
 			return nil, decryptorError
 		}
 
-		aead, _ := NewAead(key)
-		return SymmetricDecrypt(
-			aead,
-			ciphertext[:0],
-			nonce,
-			ciphertext,
-		)
+		return symmetricKeyDecryptor(key)(keyId, algorithm, nonce, ciphertext, associatedData)
 	}
 }
 
@@ -62,18 +74,58 @@ func pemEncodeBlock(keyBytes []byte, typeString string) string {
 	return string(pem.EncodeToMemory(block))
 }
 
-func PublicAsymKeyToString(key *rsa.PublicKey) string {
+/*
+	Key decoding
+	(strings must hold exactly one PEM block, trailing data hides caller bugs like concatenated keys)
+*/
+func pemDecodeSingleBlock(keyString string) (*pem.Block, error) {
+	block, rest := pem.Decode([]byte(keyString))
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the public key")
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, trailingPemDataError
+	}
+	return block, nil
+}
+
+/*
+	Public key encoding (RSA or Ed25519 keys)
+*/
+func PublicAsymKeyToString(key crypto.PublicKey) (string, error) {
+	var typeString string
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key == nil {
+			return "", invalidPublicKeyError
+		}
+		typeString = "RSA PUBLIC KEY"
+	case ed25519.PublicKey:
+		if len(key) != ed25519.PublicKeySize {
+			return "", invalidPublicKeyError
+		}
+		typeString = "ED25519 PUBLIC KEY"
+	default:
+		return "", invalidPublicKeyError
+	}
+
 	// Break into bytes
-	keyBytes, _ := x509.MarshalPKIXPublicKey(key)
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", publicKeyMarshalError
+	}
 
 	// Encode block
-	return pemEncodeBlock(keyBytes, "RSA PUBLIC KEY")
+	return pemEncodeBlock(keyBytes, typeString), nil
 }
 
-func PublicStringToAsymKey(rsaString string) (*rsa.PublicKey, error) {
-	block, _ := pem.Decode([]byte(rsaString))
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the public key")
+/*
+	Public key decoding (returns *rsa.PublicKey or ed25519.PublicKey)
+*/
+func PublicStringToAsymKey(keyString string) (crypto.PublicKey, error) {
+	block, err := pemDecodeSingleBlock(keyString)
+	if err != nil {
+		return nil, err
 	}
 
 	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
@@ -84,11 +136,43 @@ func PublicStringToAsymKey(rsaString string) (*rsa.PublicKey, error) {
 	switch pub := pub.(type) {
 	case *rsa.PublicKey:
 		return pub, nil
+	case ed25519.PublicKey:
+		return pub, nil
 	default:
-		return nil, errors.New("unknown type of public key" + err.Error())
+		return nil, fmt.Errorf("unexpected type of public key: %T", pub)
 	}
 }
 
+/*
+	Same as PublicStringToAsymKey, but only accepts RSA keys (required for encryption)
+*/
+func PublicStringToRsaKey(rsaString string) (*rsa.PublicKey, error) {
+	pub, err := PublicStringToAsymKey(rsaString)
+	if err != nil {
+		return nil, err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type of public key: %T", pub)
+	}
+	return rsaPub, nil
+}
+
+/*
+	Hex encoded SHA-256 of the DER encoded public key (empty if key can't be marshalled)
+*/
+func KeyFingerprint(key *rsa.PublicKey) string {
+	if key == nil {
+		return ""
+	}
+	keyBytes, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	fingerprint := sha256.Sum256(keyBytes)
+	return hex.EncodeToString(fingerprint[:])
+}
+
 func PrivateAsymKeyToString(key *rsa.PrivateKey) string {
 	// Break into bytes
 	keyBytes := x509.MarshalPKCS1PrivateKey(key)
@@ -98,9 +182,9 @@ func PrivateAsymKeyToString(key *rsa.PrivateKey) string {
 }
 
 func PrivateStringToAsymKey(rsaString string) (*rsa.PrivateKey, error) {
-	block, _ := pem.Decode([]byte(rsaString))
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the public key")
+	block, err := pemDecodeSingleBlock(rsaString)
+	if err != nil {
+		return nil, err
 	}
 
 	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
@@ -110,19 +194,142 @@ func PrivateStringToAsymKey(rsaString string) (*rsa.PrivateKey, error) {
 	return priv, nil
 }
 
+/*
+	Passphrase protected private keys
+	(PKCS#8 sealed with ChaCha20-Poly1305 under a scrypt derived key,
+	salt and nonce are base64 encoded in the PEM headers)
+*/
+const (
+	encryptedPrivateKeyPemType     = "DMPC ENCRYPTED PRIVATE KEY"
+	encryptedPrivateKeySaltHeader  = "Salt"
+	encryptedPrivateKeyNonceHeader = "Nonce"
+	passphraseSaltSize             = 16
+	passphraseScryptN              = 1 << 15
+	passphraseScryptR              = 8
+	passphraseScryptP              = 1
+)
+
+func derivePassphraseKey(passphrase []byte, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, passphraseScryptN, passphraseScryptR, passphraseScryptP, SymmetricKeySize)
+}
+
+func PrivateKeyToEncryptedString(priv *rsa.PrivateKey, passphrase []byte) (string, error) {
+	if priv == nil {
+		return "", invalidPrivateKeyError
+	}
+
+	// Break into bytes
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", privateKeyMarshalError
+	}
+
+	// Derive key from passphrase
+	salt := generateRandomBytes(passphraseSaltSize)
+	nonce := generateRandomBytes(SymmetricNonceSize)
+	passphraseKey, err := derivePassphraseKey(passphrase, salt)
+	if err != nil {
+		return "", keyDerivationError
+	}
+	aead, err := NewAead(passphraseKey)
+	ZeroBytes(passphraseKey)
+	if err != nil {
+		return "", err
+	}
+
+	keyCiphertext, err := SymmetricEncrypt(aead, []byte{}, nonce, keyBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// Encode block
+	block := &pem.Block{
+		Type: encryptedPrivateKeyPemType,
+		Headers: map[string]string{
+			encryptedPrivateKeySaltHeader:  Base64EncodeToString(salt),
+			encryptedPrivateKeyNonceHeader: Base64EncodeToString(nonce),
+		},
+		Bytes: keyCiphertext,
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+func EncryptedStringToPrivateKey(encryptedString string, passphrase []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encryptedString))
+	if block == nil || block.Type != encryptedPrivateKeyPemType {
+		return nil, encryptedPrivateKeyDecodeError
+	}
+
+	// Decode salt and nonce
+	salt, err := Base64DecodeString(block.Headers[encryptedPrivateKeySaltHeader])
+	if err != nil || len(salt) == 0 {
+		return nil, encryptedPrivateKeyDecodeError
+	}
+	nonce, err := Base64DecodeString(block.Headers[encryptedPrivateKeyNonceHeader])
+	if err == nil {
+		err = ValidateNonce(nonce)
+	}
+	if err != nil {
+		return nil, encryptedPrivateKeyDecodeError
+	}
+
+	// Derive key from passphrase and decrypt
+	passphraseKey, err := derivePassphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, keyDerivationError
+	}
+	aead, err := NewAead(passphraseKey)
+	ZeroBytes(passphraseKey)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := SymmetricDecrypt(aead, []byte{}, nonce, block.Bytes)
+	if err != nil {
+		return nil, wrongPassphraseError
+	}
+
+	// Parse private key
+	priv, err := x509.ParsePKCS8PrivateKey(keyBytes)
+	if err != nil {
+		return nil, encryptedPrivateKeyDecodeError
+	}
+	rsaPriv, ok := priv.(*rsa.PrivateKey)
+	if !ok {
+		return nil, encryptedPrivateKeyDecodeError
+	}
+	return rsaPriv, nil
+}
+
 /*
 	Key generation
 */
 func GeneratePrivateKey() *rsa.PrivateKey {
-	priv, _ := rsa.GenerateKey(rand.Reader, AsymmetricKeySizeBits)
+	priv, _ := GeneratePrivateKeyWithSize(AsymmetricKeySizeBits)
 	return priv
 }
 
+func GeneratePrivateKeyWithSize(bits int) (*rsa.PrivateKey, error) {
+	if bits < minAsymmetricKeySizeBits || bits%8 != 0 {
+		return nil, invalidAsymmetricKeySizeError
+	}
+
+	priv, err := rsa.GenerateKey(rng, bits)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
 func GeneratePublicKey() *rsa.PublicKey {
 	priv := GeneratePrivateKey()
 	return &priv.PublicKey
 }
 
+func GenerateEd25519PrivateKey() ed25519.PrivateKey {
+	_, priv, _ := ed25519.GenerateKey(rng)
+	return priv
+}
+
 func GenerateTransaction(
 	encrypted bool,
 	challenges map[string]string,
@@ -130,18 +337,41 @@ func GenerateTransaction(
 	nonceEncoded bool,
 	payload []byte,
 	payloadEncoded bool,
+) *Transaction {
+	return GenerateTransactionWithEncoding(
+		encrypted,
+		challenges,
+		nonce,
+		nonceEncoded,
+		payload,
+		payloadEncoded,
+		base64.StdEncoding,
+	)
+}
+
+/*
+	Same as GenerateTransaction, encodes nonce and payload with the base64 encoding given
+*/
+func GenerateTransactionWithEncoding(
+	encrypted bool,
+	challenges map[string]string,
+	nonce []byte,
+	nonceEncoded bool,
+	payload []byte,
+	payloadEncoded bool,
+	encoding *base64.Encoding,
 ) *Transaction {
 	nonceResult := string(nonce)
 	payloadResult := string(payload)
 	if !nonceEncoded {
-		nonceResult = Base64EncodeToString(nonce)
+		nonceResult = Base64EncodeToStringWithEncoding(nonce, encoding)
 	}
 	if !payloadEncoded {
-		payloadResult = Base64EncodeToString(payload)
+		payloadResult = Base64EncodeToStringWithEncoding(payload, encoding)
 	}
 
 	return &Transaction{
-		Version: 0.1,
+		Version: TransactionVersion,
 		Encryption: TransactionEncryptionFields{
 			Encrypted:  encrypted,
 			Challenges: challenges,
@@ -157,47 +387,157 @@ func GenerateTransactionWithEncryption(
 	modifyChallenges func(map[string]string),
 	recipientKey *rsa.PrivateKey,
 ) (*Transaction, *rsa.PrivateKey) {
+	// Make RSA key if nil
+	if recipientKey == nil {
+		recipientKey = GeneratePrivateKey()
+	}
+
+	transaction := generateTransactionForRecipients(
+		plainPayload,
+		plaintextChallenge,
+		nil,
+		modifyChallenges,
+		[]*rsa.PublicKey{&recipientKey.PublicKey},
+		base64.StdEncoding,
+	)
+
+	return transaction, recipientKey
+}
+
+func GenerateTransactionForRecipients(
+	plainPayload []byte,
+	plaintextChallenge []byte,
+	recipients []*rsa.PublicKey,
+) *Transaction {
+	return GenerateTransactionForRecipientsWithEncoding(
+		plainPayload,
+		plaintextChallenge,
+		recipients,
+		base64.StdEncoding,
+	)
+}
+
+func GenerateTransactionForRecipientsWithEncoding(
+	plainPayload []byte,
+	plaintextChallenge []byte,
+	recipients []*rsa.PublicKey,
+	encoding *base64.Encoding,
+) *Transaction {
+	return generateTransactionForRecipients(
+		plainPayload,
+		plaintextChallenge,
+		nil,
+		func(map[string]string) {},
+		recipients,
+		encoding,
+	)
+}
+
+/*
+	Makes a transaction where each recipient gets its own challenge
+	(recipient data is appended to the correct challenge, and is returned to the recipient on decryption)
+*/
+func GenerateTransactionForRecipientsWithChallenges(
+	plainPayload []byte,
+	recipientChallenge func(recipient *rsa.PublicKey) []byte,
+	recipients []*rsa.PublicKey,
+) *Transaction {
+	return generateTransactionForRecipients(
+		plainPayload,
+		[]byte(CorrectChallenge),
+		recipientChallenge,
+		func(map[string]string) {},
+		recipients,
+		base64.StdEncoding,
+	)
+}
+
+func generateTransactionForRecipients(
+	plainPayload []byte,
+	plaintextChallenge []byte,
+	recipientChallenge func(*rsa.PublicKey) []byte,
+	modifyChallenges func(map[string]string),
+	recipients []*rsa.PublicKey,
+	encoding *base64.Encoding,
+) *Transaction {
 	// Make temporary key and nonce
 	temporaryNonce := generateRandomBytes(SymmetricNonceSize)
 	temporaryKey := generateRandomBytes(SymmetricKeySize)
 
+	return encryptTransactionWithKey(
+		temporaryKey,
+		temporaryNonce,
+		plainPayload,
+		plaintextChallenge,
+		recipientChallenge,
+		modifyChallenges,
+		recipients,
+		encoding,
+	)
+}
+
+/*
+	Encrypts transaction with the temporary key given (zeroed once done)
+	(recipients share the plaintext challenge unless a recipient challenge function is given)
+*/
+func encryptTransactionWithKey(
+	temporaryKey []byte,
+	temporaryNonce []byte,
+	plainPayload []byte,
+	plaintextChallenge []byte,
+	recipientChallenge func(*rsa.PublicKey) []byte,
+	modifyChallenges func(map[string]string),
+	recipients []*rsa.PublicKey,
+	encoding *base64.Encoding,
+) *Transaction {
+	defer ZeroBytes(temporaryKey)
+
 	// Encrypt challenge string and payload using temporary symmetric key
 	aead, _ := NewAead(temporaryKey)
-	payloadCiphertext := SymmetricEncrypt(
+	payloadCiphertext, _ := SymmetricEncrypt(
 		aead,
 		[]byte{},
 		temporaryNonce,
 		plainPayload,
 	)
-	challengeCiphertext := SymmetricEncrypt(
+	challengeCiphertext, _ := SymmetricEncrypt(
 		aead,
 		[]byte{},
 		temporaryNonce,
 		[]byte(plaintextChallenge),
 	)
 
-	// Make RSA key if nil and use it to encrypt temporary key
-	if recipientKey == nil {
-		recipientKey = GeneratePrivateKey()
-	}
-	symKeyEncrypted, _ := AsymmetricEncrypt(&recipientKey.PublicKey, temporaryKey[:])
+	// Make challenges map with temporary key encrypted for every recipient
+	challengeCiphertextBase64 := Base64EncodeToStringWithEncoding(challengeCiphertext, encoding)
+	challenges := map[string]string{}
+	for _, recipient := range recipients {
+		symKeyEncrypted, _ := wrapTransactionKey(TransactionVersion, recipient, temporaryKey[:], temporaryNonce)
+		symKeyEncryptedBase64 := Base64EncodeToStringWithEncoding(symKeyEncrypted, encoding)
+		if recipientChallenge == nil {
+			challenges[symKeyEncryptedBase64] = challengeCiphertextBase64
+			continue
+		}
 
-	// Make challenges map
-	challengeCiphertextBase64 := Base64EncodeToString(challengeCiphertext)
-	symKeyEncryptedBase64 := Base64EncodeToString(symKeyEncrypted)
-	challenges := map[string]string{
-		symKeyEncryptedBase64: challengeCiphertextBase64,
+		// Recipient specific challenges use their own nonce
+		recipientChallengeCiphertext, _ := SymmetricEncrypt(
+			aead,
+			[]byte{},
+			recipientChallengeNonce(temporaryNonce, symKeyEncrypted),
+			append([]byte(CorrectChallenge), recipientChallenge(recipient)...),
+		)
+		challenges[symKeyEncryptedBase64] = Base64EncodeToStringWithEncoding(recipientChallengeCiphertext, encoding)
 	}
 	modifyChallenges(challenges)
 
-	return GenerateTransaction(
+	return GenerateTransactionWithEncoding(
 		true,
 		challenges,
 		temporaryNonce,
 		false,
 		payloadCiphertext,
 		false,
-	), recipientKey
+		encoding,
+	)
 }
 
 /*
@@ -217,6 +557,43 @@ func GenerateOperation(
 	requestType RequestType,
 	payload []byte,
 	payloadEncoded bool,
+) *Operation {
+	return GenerateOperationWithEncoding(
+		encrypted,
+		keyId,
+		nonce,
+		nonceEncoded,
+		issuerId,
+		issuerSignature,
+		issuerSignatureEncoded,
+		certifierId,
+		certifierSignature,
+		certifierSignatureEncoded,
+		requestType,
+		payload,
+		payloadEncoded,
+		base64.StdEncoding,
+	)
+}
+
+/*
+	Same as GenerateOperation, encodes nonce, signatures and payload with the base64 encoding given
+*/
+func GenerateOperationWithEncoding(
+	encrypted bool,
+	keyId string,
+	nonce []byte,
+	nonceEncoded bool,
+	issuerId string,
+	issuerSignature []byte,
+	issuerSignatureEncoded bool,
+	certifierId string,
+	certifierSignature []byte,
+	certifierSignatureEncoded bool,
+	requestType RequestType,
+	payload []byte,
+	payloadEncoded bool,
+	encoding *base64.Encoding,
 ) *Operation {
 	// Encode or convert to string
 	nonceResult := string(nonce)
@@ -224,16 +601,16 @@ func GenerateOperation(
 	certifierSignatureResult := string(certifierSignature)
 	payloadResult := string(payload)
 	if !nonceEncoded {
-		nonceResult = Base64EncodeToString(nonce)
+		nonceResult = Base64EncodeToStringWithEncoding(nonce, encoding)
 	}
 	if !issuerSignatureEncoded {
-		issuerSignatureResult = Base64EncodeToString(issuerSignature)
+		issuerSignatureResult = Base64EncodeToStringWithEncoding(issuerSignature, encoding)
 	}
 	if !certifierSignatureEncoded {
-		certifierSignatureResult = Base64EncodeToString(certifierSignature)
+		certifierSignatureResult = Base64EncodeToStringWithEncoding(certifierSignature, encoding)
 	}
 	if !payloadEncoded {
-		payloadResult = Base64EncodeToString(payload)
+		payloadResult = Base64EncodeToStringWithEncoding(payload, encoding)
 	}
 
 	// Create operation
@@ -269,26 +646,134 @@ func GenerateOperationWithEncryption(
 	certifierId string,
 	modifyCertifierSignature func([]byte) ([]byte, bool),
 ) (*Operation, *rsa.PrivateKey, *rsa.PrivateKey) {
+	return GenerateOperationWithEncryptionAndEncoding(
+		keyId,
+		permanentKey,
+		permanentNonce,
+		requestType,
+		plainPayload,
+		issuerId,
+		modifyIssuerSignature,
+		certifierId,
+		modifyCertifierSignature,
+		base64.StdEncoding,
+	)
+}
+
+/*
+	Same as GenerateOperationWithEncryption, encodes operation fields with the base64 encoding given
+*/
+func GenerateOperationWithEncryptionAndEncoding(
+	keyId string,
+	permanentKey []byte,
+	permanentNonce []byte,
+	requestType RequestType,
+	plainPayload []byte,
+	issuerId string,
+	modifyIssuerSignature func([]byte) ([]byte, bool),
+	certifierId string,
+	modifyCertifierSignature func([]byte) ([]byte, bool),
+	encoding *base64.Encoding,
+) (*Operation, *rsa.PrivateKey, *rsa.PrivateKey) {
+	op, issuerKey, certifierKey, _ := generateOperationWithEncryption(
+		keyId,
+		permanentKey,
+		permanentNonce,
+		requestType,
+		plainPayload,
+		issuerId,
+		modifyIssuerSignature,
+		certifierId,
+		modifyCertifierSignature,
+		NoCompression,
+		nil,
+		encoding,
+	)
+	return op, issuerKey, certifierKey
+}
+
+/*
+	Same as GenerateOperationWithEncryption, compresses payload before encryption
+	and rejects nonces reused with the same key id if a tracker is given
+*/
+func GenerateOperationWithEncryptionAndTracker(
+	keyId string,
+	permanentKey []byte,
+	permanentNonce []byte,
+	requestType RequestType,
+	plainPayload []byte,
+	issuerId string,
+	modifyIssuerSignature func([]byte) ([]byte, bool),
+	certifierId string,
+	modifyCertifierSignature func([]byte) ([]byte, bool),
+	compression CompressionAlgorithm,
+	nonceTracker *NonceTracker,
+) (*Operation, *rsa.PrivateKey, *rsa.PrivateKey, error) {
+	return generateOperationWithEncryption(
+		keyId,
+		permanentKey,
+		permanentNonce,
+		requestType,
+		plainPayload,
+		issuerId,
+		modifyIssuerSignature,
+		certifierId,
+		modifyCertifierSignature,
+		compression,
+		nonceTracker,
+		base64.StdEncoding,
+	)
+}
+
+func generateOperationWithEncryption(
+	keyId string,
+	permanentKey []byte,
+	permanentNonce []byte,
+	requestType RequestType,
+	plainPayload []byte,
+	issuerId string,
+	modifyIssuerSignature func([]byte) ([]byte, bool),
+	certifierId string,
+	modifyCertifierSignature func([]byte) ([]byte, bool),
+	compression CompressionAlgorithm,
+	nonceTracker *NonceTracker,
+	encoding *base64.Encoding,
+) (*Operation, *rsa.PrivateKey, *rsa.PrivateKey, error) {
+	// Check nonce was never used with key
+	if nonceTracker != nil {
+		if err := nonceTracker.Track(keyId, permanentNonce); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Compress payload
+	compressedPayload, err := Compress(compression, plainPayload)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	// Encrypt payload with symmetric permanent key
 	aead, _ := NewAead(permanentKey)
-	ciphertextPayload := SymmetricEncrypt(
+	ciphertextPayload, _ := SymmetricEncryptWithAssociatedData(
 		aead,
 		[]byte{},
 		permanentNonce,
-		plainPayload,
+		compressedPayload,
+		OperationAssociatedDataWithEncryption(keyId, requestType, ChaCha20Poly1305AeadAlgorithm, compression),
 	)
 
-	// Hash and sign plaintext payload with new RSA keys
-	plainPayloadHashed := Hash(plainPayload)
+	// Hash and sign plaintext payload bound to nonce and key id with new RSA keys
+	signedData, _ := OperationSignedData(OperationVersion, keyId, Base64EncodeToStringWithEncoding(permanentNonce, encoding), plainPayload, ValidityWindow{}, false)
+	signedDataHashed := Hash(signedData)
 	issuerKey := GeneratePrivateKey()
 	certifierKey := GeneratePrivateKey()
-	issuerSignature, _ := Sign(issuerKey, plainPayloadHashed[:])
+	issuerSignature, _ := Sign(issuerKey, signedDataHashed[:])
 	issuerSignature, issuerSignatureEncoded := modifyIssuerSignature(issuerSignature)
 
-	certifierSignature, _ := Sign(certifierKey, plainPayloadHashed[:])
+	certifierSignature, _ := Sign(certifierKey, signedDataHashed[:])
 	certifierSignature, certifierSignatureEncoded := modifyCertifierSignature(certifierSignature)
 
-	return GenerateOperation(
+	op := GenerateOperationWithEncoding(
 		true,
 		keyId,
 		permanentNonce,
@@ -302,5 +787,10 @@ func GenerateOperationWithEncryption(
 		requestType,
 		ciphertextPayload,
 		false,
-	), issuerKey, certifierKey
+		encoding,
+	)
+	op.Version = OperationVersion
+	op.Encryption.Compression = compression
+
+	return op, issuerKey, certifierKey, nil
 }
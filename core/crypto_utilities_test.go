@@ -1,7 +1,15 @@
 package core
 
 import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"math/big"
+	mathrand "math/rand"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -9,13 +17,73 @@ const invalidPemString string = "INVALID"
 
 func TestEncodeDecodePublicKey(t *testing.T) {
 	key := GeneratePublicKey()
-	keyEncoded := PublicAsymKeyToString(key)
+	keyEncoded, err := PublicAsymKeyToString(key)
+	if err != nil {
+		t.Errorf("Public key encoding failed. err=%v", err)
+		return
+	}
 	keyDecoded, err := PublicStringToAsymKey(keyEncoded)
 	if err != nil || !reflect.DeepEqual(key, keyDecoded) {
 		t.Errorf("Public key encode/decode test failed.")
 	}
 }
 
+func TestDecodeConcatenatedKeys(t *testing.T) {
+	firstEncoded, _ := PublicAsymKeyToString(GeneratePublicKey())
+	secondEncoded, _ := PublicAsymKeyToString(GeneratePublicKey())
+	if _, err := PublicStringToAsymKey(firstEncoded + secondEncoded); err != trailingPemDataError {
+		t.Errorf("Public key decoding should fail with two PEM blocks. err=%v", err)
+	}
+	if _, err := PublicStringToAsymKey(firstEncoded + "TRAILING"); err != trailingPemDataError {
+		t.Errorf("Public key decoding should fail with trailing data. err=%v", err)
+	}
+	if _, err := PublicStringToAsymKey("\n" + firstEncoded + "\n\t \n"); err != nil {
+		t.Errorf("Public key decoding should ignore surrounding whitespace. err=%v", err)
+	}
+
+	privateEncoded := PrivateAsymKeyToString(GeneratePrivateKey())
+	if _, err := PrivateStringToAsymKey(privateEncoded + privateEncoded); err != trailingPemDataError {
+		t.Errorf("Private key decoding should fail with two PEM blocks. err=%v", err)
+	}
+	if _, err := PrivateStringToAsymKey(privateEncoded); err != nil {
+		t.Errorf("Private key decoding should pass with a single PEM block. err=%v", err)
+	}
+}
+
+func TestEncodeMalformedPublicKey(t *testing.T) {
+	keyEncoded, err := PublicAsymKeyToString(nil)
+	if err != invalidPublicKeyError || keyEncoded != "" {
+		t.Errorf("Public key encoding should fail with nil key. err=%v", err)
+	}
+
+	keyEncoded, err = PublicAsymKeyToString((*rsa.PublicKey)(nil))
+	if err != invalidPublicKeyError || keyEncoded != "" {
+		t.Errorf("Public key encoding should fail with nil RSA key. err=%v", err)
+	}
+
+	keyEncoded, err = PublicAsymKeyToString(&rsa.PublicKey{})
+	if err != publicKeyMarshalError || keyEncoded != "" {
+		t.Errorf("Public key encoding should fail with missing modulus. err=%v", err)
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	key := GeneratePublicKey()
+	keyCopy := &rsa.PublicKey{N: new(big.Int).Set(key.N), E: key.E}
+	fingerprint := KeyFingerprint(key)
+	if len(fingerprint) != 2*sha256.Size || fingerprint != KeyFingerprint(keyCopy) {
+		t.Errorf("Identical keys should have identical fingerprints. found=%v, expected=%v", KeyFingerprint(keyCopy), fingerprint)
+	}
+
+	if fingerprint == KeyFingerprint(GeneratePublicKey()) {
+		t.Errorf("Different keys should have different fingerprints.")
+	}
+
+	if KeyFingerprint(nil) != "" || KeyFingerprint(&rsa.PublicKey{}) != "" {
+		t.Errorf("Fingerprint of malformed key should be empty.")
+	}
+}
+
 func TestEncodeDecodePrivateKey(t *testing.T) {
 	key := GeneratePrivateKey()
 	keyEncoded := PrivateAsymKeyToString(key)
@@ -24,3 +92,156 @@ func TestEncodeDecodePrivateKey(t *testing.T) {
 		t.Errorf("Private key encode/decode test failed.")
 	}
 }
+
+func TestEncryptDecryptPrivateKey(t *testing.T) {
+	key := GeneratePrivateKey()
+	passphrase := []byte("PASSPHRASE")
+	keyEncrypted, err := PrivateKeyToEncryptedString(key, passphrase)
+	if err != nil {
+		t.Errorf("Private key encryption failed. err=%v", err)
+		return
+	}
+
+	keyDecrypted, err := EncryptedStringToPrivateKey(keyEncrypted, passphrase)
+	if err != nil || !reflect.DeepEqual(key.D, keyDecrypted.D) || !reflect.DeepEqual(key.PublicKey, keyDecrypted.PublicKey) {
+		t.Errorf("Private key encrypt/decrypt test failed. err=%v", err)
+	}
+
+	_, err = EncryptedStringToPrivateKey(keyEncrypted, []byte("WRONG PASSPHRASE"))
+	if err != wrongPassphraseError {
+		t.Errorf("Private key decryption should fail with wrong passphrase. err=%v", err)
+	}
+
+	_, err = EncryptedStringToPrivateKey(invalidPemString, passphrase)
+	if err != encryptedPrivateKeyDecodeError {
+		t.Errorf("Private key decryption should fail with invalid PEM. err=%v", err)
+	}
+
+	_, err = EncryptedStringToPrivateKey(PrivateAsymKeyToString(key), passphrase)
+	if err != encryptedPrivateKeyDecodeError {
+		t.Errorf("Private key decryption should fail with unencrypted PEM. err=%v", err)
+	}
+
+	_, err = PrivateKeyToEncryptedString(nil, passphrase)
+	if err != invalidPrivateKeyError {
+		t.Errorf("Private key encryption should fail with nil key. err=%v", err)
+	}
+}
+
+func TestGeneratePrivateKeyWithSize(t *testing.T) {
+	for _, bits := range []int{0, 1024, 2047, 2049, 2052} {
+		if _, err := GeneratePrivateKeyWithSize(bits); err != invalidAsymmetricKeySizeError {
+			t.Errorf("Key generation should fail with invalid size. bits=%v, err=%v", bits, err)
+		}
+	}
+
+	for _, bits := range []int{2048, 2056} {
+		key, err := GeneratePrivateKeyWithSize(bits)
+		if err != nil || key.N.BitLen() != bits {
+			t.Errorf("Key generation should succeed with valid size. bits=%v, err=%v", bits, err)
+		}
+	}
+}
+
+func TestNewAeadWithAlgorithm(t *testing.T) {
+	for _, algorithm := range []AeadAlgorithm{ChaCha20Poly1305AeadAlgorithm, Aes256GcmAeadAlgorithm} {
+		if _, err := NewAeadWithAlgorithm(generateRandomBytes(SymmetricKeySize), algorithm); err != nil {
+			t.Errorf("Aead creation should succeed with valid key. algorithm=%v, err=%v", algorithm, err)
+		}
+		if _, err := NewAeadWithAlgorithm(generateRandomBytes(SymmetricKeySize-1), algorithm); err != aeadCreationError {
+			t.Errorf("Aead creation should fail with invalid key length. algorithm=%v, err=%v", algorithm, err)
+		}
+	}
+
+	if _, err := NewAeadWithAlgorithm(generateRandomBytes(SymmetricKeySize), Aes256GcmAeadAlgorithm+1); err != invalidAeadAlgorithmError {
+		t.Errorf("Aead creation should fail with unknown algorithm. err=%v", err)
+	}
+}
+
+func TestEncodeDecodeEd25519PublicKey(t *testing.T) {
+	key := GenerateEd25519PrivateKey().Public().(ed25519.PublicKey)
+	keyEncoded, err := PublicAsymKeyToString(key)
+	if err != nil {
+		t.Errorf("Ed25519 public key encoding failed. err=%v", err)
+		return
+	}
+	keyDecoded, err := PublicStringToAsymKey(keyEncoded)
+	if err != nil || !reflect.DeepEqual(key, keyDecoded) {
+		t.Errorf("Ed25519 public key encode/decode test failed.")
+	}
+
+	_, err = PublicStringToAsymKey(invalidPemString)
+	if err == nil {
+		t.Errorf("Ed25519 public key decoding should fail with invalid PEM.")
+	}
+
+	_, err = PublicAsymKeyToString(ed25519.PublicKey(nil))
+	if err != invalidPublicKeyError {
+		t.Errorf("Ed25519 public key encoding should fail with nil key. err=%v", err)
+	}
+}
+
+func TestDecodeNonRsaPublicKey(t *testing.T) {
+	ed25519KeyEncoded, _ := PublicAsymKeyToString(GenerateEd25519PrivateKey().Public())
+	key, err := PublicStringToRsaKey(ed25519KeyEncoded)
+	if key != nil || err == nil || !strings.Contains(err.Error(), "ed25519.PublicKey") {
+		t.Errorf("RSA public key decoding should fail naming the unexpected key type. err=%v", err)
+	}
+
+	rsaKey := GeneratePublicKey()
+	rsaKeyEncoded, _ := PublicAsymKeyToString(rsaKey)
+	if key, err := PublicStringToRsaKey(rsaKeyEncoded); err != nil || !reflect.DeepEqual(key, rsaKey) {
+		t.Errorf("RSA public key decoding should pass with an RSA key. err=%v", err)
+	}
+}
+
+func TestDeterministicRandomSource(t *testing.T) {
+	defer (func(original io.Reader) {
+		rng = original
+	})(rng)
+
+	generate := func() ([]byte, ed25519.PrivateKey) {
+		rng = mathrand.New(mathrand.NewSource(1))
+		return generateRandomBytes(SymmetricKeySize), GenerateEd25519PrivateKey()
+	}
+	firstBytes, firstKey := generate()
+	secondBytes, secondKey := generate()
+	if !reflect.DeepEqual(firstBytes, secondBytes) {
+		t.Errorf("Random bytes should be reproducible with a deterministic source. first=%v, second=%v", firstBytes, secondBytes)
+	}
+	if !reflect.DeepEqual(firstKey, secondKey) {
+		t.Errorf("Keys should be reproducible with a deterministic source.")
+	}
+}
+
+func TestZeroBytes(t *testing.T) {
+	key := generateRandomBytes(SymmetricKeySize)
+	ZeroBytes(key)
+	if !reflect.DeepEqual(key, make([]byte, SymmetricKeySize)) {
+		t.Errorf("Key should be zeroed. key=%v", key)
+	}
+	ZeroBytes(nil)
+}
+
+func TestTransactionTemporaryKeyZeroed(t *testing.T) {
+	recipientKey := GeneratePrivateKey()
+	temporaryKey := generateRandomBytes(SymmetricKeySize)
+	transaction := encryptTransactionWithKey(
+		temporaryKey,
+		generateRandomBytes(SymmetricNonceSize),
+		[]byte("{}"),
+		[]byte(CorrectChallenge),
+		nil,
+		func(map[string]string) {},
+		[]*rsa.PublicKey{&recipientKey.PublicKey},
+		base64.StdEncoding,
+	)
+	if !reflect.DeepEqual(temporaryKey, make([]byte, SymmetricKeySize)) {
+		t.Errorf("Temporary key should be zeroed once the transaction is encrypted. key=%v", temporaryKey)
+	}
+
+	// Transaction still decrypts with the key zeroed
+	if _, err := transaction.Decrypt(recipientKey); err != nil {
+		t.Errorf("Transaction should decrypt after temporary key is zeroed. err=%v", err)
+	}
+}
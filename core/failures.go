@@ -0,0 +1,51 @@
+/*
+	Counters of failed decryptions and signature verifications for intrusion detection
+*/
+
+package core
+
+import (
+	"sync/atomic"
+)
+
+/*
+	Snapshot of failure totals since startup (or the last reset)
+	(a spike in failures against a recipient key can indicate an attack)
+*/
+type FailureStats struct {
+	TransactionDecryptions uint64
+	OperationDecryptions   uint64
+	SignatureVerifications uint64
+}
+
+var failureCounters FailureStats
+
+func countTransactionDecryptionFailure() {
+	atomic.AddUint64(&failureCounters.TransactionDecryptions, 1)
+}
+
+func countOperationDecryptionFailure() {
+	atomic.AddUint64(&failureCounters.OperationDecryptions, 1)
+}
+
+func countSignatureVerificationFailure() {
+	atomic.AddUint64(&failureCounters.SignatureVerifications, 1)
+}
+
+/*
+	Returns failure totals
+	(only cryptographic failures are counted, not malformed inputs)
+*/
+func Failures() FailureStats {
+	return FailureStats{
+		TransactionDecryptions: atomic.LoadUint64(&failureCounters.TransactionDecryptions),
+		OperationDecryptions:   atomic.LoadUint64(&failureCounters.OperationDecryptions),
+		SignatureVerifications: atomic.LoadUint64(&failureCounters.SignatureVerifications),
+	}
+}
+
+func ResetFailures() {
+	atomic.StoreUint64(&failureCounters.TransactionDecryptions, 0)
+	atomic.StoreUint64(&failureCounters.OperationDecryptions, 0)
+	atomic.StoreUint64(&failureCounters.SignatureVerifications, 0)
+}
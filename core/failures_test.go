@@ -0,0 +1,57 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestFailureCounters(t *testing.T) {
+	ResetFailures()
+
+	// Transaction addressed to another recipient
+	transaction, _ := GenerateTransactionWithEncryption([]byte("PAYLOAD"), []byte(CorrectChallenge), func(map[string]string) {}, nil)
+	wrongKey := GeneratePrivateKey()
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := transaction.Decrypt(wrongKey); err != ErrNotARecipient {
+			t.Fatalf("Transaction decryption should fail. err=%v", err)
+		}
+	}
+
+	// Operation encrypted with another key, and signed by other signers
+	op, _, _ := GenerateOperationWithEncryption(
+		"KEY_ID",
+		generateRandomBytes(SymmetricKeySize),
+		generateRandomBytes(SymmetricNonceSize),
+		UsersRequestType,
+		[]byte("PAYLOAD"),
+		"ISSUER",
+		func(b []byte) ([]byte, bool) { return b, false },
+		"CERTIFIER",
+		func(b []byte) ([]byte, bool) { return b, false },
+	)
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := op.DecryptPayloadOnly(generateRandomBytes(SymmetricKeySize)); err == nil {
+			t.Fatalf("Operation decryption should fail.")
+		}
+	}
+	if err := op.VerifyIssuer(&wrongKey.PublicKey, []byte("PAYLOAD")); err != invalidIssuerSignatureError {
+		t.Fatalf("Signature verification should fail. err=%v", err)
+	}
+
+	// Malformed inputs are not counted
+	op.Issue.Signature = "%SIGNATURE%"
+	op.VerifyIssuer(&wrongKey.PublicKey, []byte("PAYLOAD"))
+
+	expected := FailureStats{
+		TransactionDecryptions: 3,
+		OperationDecryptions:   2,
+		SignatureVerifications: 1,
+	}
+	if found := Failures(); found != expected {
+		t.Errorf("Failures should be counted. found=%+v, expected=%+v", found, expected)
+	}
+
+	ResetFailures()
+	if found := Failures(); found != (FailureStats{}) {
+		t.Errorf("Failures should be reset. found=%+v", found)
+	}
+}
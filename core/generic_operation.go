@@ -0,0 +1,73 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+/*
+	Operation of either kind (transaction or operation)
+*/
+type GenericOperation interface {
+	Encode() ([]byte, error)
+	IsEncrypted() bool
+}
+
+func (op *Transaction) IsEncrypted() bool {
+	return op.Encryption.Encrypted
+}
+
+func (op *Operation) IsEncrypted() bool {
+	return op.Encryption.Encrypted
+}
+
+/*
+	Errors
+*/
+var (
+	unknownOperationKindError   error = errors.New("Unable to determine operation kind.")
+	ambiguousOperationKindError error = errors.New("Operation has both transaction and operation fields.")
+)
+
+/*
+	Fields used to tell operation kinds apart
+	(transactions have challenges, operations have signers)
+*/
+type operationKindFields struct {
+	Encryption struct {
+		Challenges json.RawMessage `json:"challenges"`
+	} `json:"encryption"`
+	Issue         json.RawMessage `json:"issue"`
+	Certification json.RawMessage `json:"certification"`
+}
+
+/*
+	Decodes a transaction or an operation depending on the fields present
+*/
+func DecodeOperation(stream []byte) (GenericOperation, error) {
+	var kind operationKindFields
+	if err := json.Unmarshal(stream, &kind); err != nil {
+		return nil, err
+	}
+
+	isTransaction := len(kind.Encryption.Challenges) != 0
+	isOperation := len(kind.Issue) != 0 || len(kind.Certification) != 0
+	switch {
+	case isTransaction && isOperation:
+		return nil, ambiguousOperationKindError
+	case isTransaction:
+		var transaction Transaction
+		if err := transaction.Decode(stream); err != nil {
+			return nil, err
+		}
+		return &transaction, nil
+	case isOperation:
+		var operation Operation
+		if err := operation.Decode(stream); err != nil {
+			return nil, err
+		}
+		return &operation, nil
+	default:
+		return nil, unknownOperationKindError
+	}
+}
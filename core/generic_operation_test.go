@@ -0,0 +1,48 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeOperationKinds(t *testing.T) {
+	// Transaction
+	transaction := GenerateTransaction(true, map[string]string{"CIPHER": "CHALLENGE_CIPHER"}, []byte("NONCE"), false, []byte("PAYLOAD"), false)
+	transactionEncoded, _ := transaction.Encode()
+	decoded, err := DecodeOperation(transactionEncoded)
+	if decodedTransaction, ok := decoded.(*Transaction); err != nil || !ok || !reflect.DeepEqual(decodedTransaction.Encryption, transaction.Encryption) || decodedTransaction.Payload != transaction.Payload {
+		t.Errorf("Transaction should decode through single entry point. decoded=%+v, err=%v", decoded, err)
+	} else if !decoded.IsEncrypted() {
+		t.Errorf("Decoded transaction should be encrypted.")
+	}
+
+	// Operation
+	operation := GenerateOperation(false, "KEY_ID", []byte{}, false, "ISSUER", []byte("SIGNATURE"), false, "CERTIFIER", []byte("SIGNATURE"), false, UsersRequestType, []byte("PAYLOAD"), false)
+	operationEncoded, _ := operation.Encode()
+	decoded, err = DecodeOperation(operationEncoded)
+	if decodedOperation, ok := decoded.(*Operation); err != nil || !ok || !reflect.DeepEqual(decodedOperation, operation) {
+		t.Errorf("Operation should decode through single entry point. decoded=%+v, err=%v", decoded, err)
+	} else if decoded.IsEncrypted() {
+		t.Errorf("Decoded operation should not be encrypted.")
+	}
+
+	// Re-encoding gives back the same stream
+	if reencoded, _ := decoded.Encode(); !reflect.DeepEqual(reencoded, operationEncoded) {
+		t.Errorf("Decoded operation should encode to the original stream. found=%s, expected=%s", reencoded, operationEncoded)
+	}
+}
+
+func TestDecodeOperationInvalid(t *testing.T) {
+	invalidStreams := map[string]error{
+		`{"version": 0.1, "payload": "PAYLOAD"}`:                                             unknownOperationKindError,
+		`{"encryption": {"challenges": {"CIPHER": "CHALLENGE"}}, "issue": {"id": "ISSUER"}}`: ambiguousOperationKindError,
+	}
+	for stream, expectedErr := range invalidStreams {
+		if _, err := DecodeOperation([]byte(stream)); err != expectedErr {
+			t.Errorf("Decoding should fail. stream=%v, err=%v, expected=%v", stream, err, expectedErr)
+		}
+	}
+	if _, err := DecodeOperation([]byte(`{"issue": `)); err == nil {
+		t.Errorf("Decoding malformed stream should fail.")
+	}
+}
@@ -5,12 +5,15 @@ package core
 */
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 )
 
 /*
-   Log levels
+	Log levels
 */
 type LogLevel int
 
@@ -27,10 +30,31 @@ const (
 	with new stdout/stderr streams
 */
 func InitializeLogging() *LoggingHandler {
+	return NewLoggingHandler(os.Stdout, os.Stderr)
+}
+
+/*
+	Generates a logging handler writing to the streams given
+*/
+func NewLoggingHandler(stdout io.Writer, stderr io.Writer) *LoggingHandler {
 	return &LoggingHandler{
 		logLevel:     FATAL,
-		stderrStream: log.New(os.Stderr, "", log.LstdFlags),
-		stdoutStream: log.New(os.Stdout, "", log.LstdFlags),
+		stderrStream: log.New(stderr, "", log.LstdFlags),
+		stdoutStream: log.New(stdout, "", log.LstdFlags),
+	}
+}
+
+/*
+	Generates a logging handler with the same streams and log level,
+	that adds a key=value field to every line logged
+*/
+func (logHandler *LoggingHandler) WithField(key string, value interface{}) *LoggingHandler {
+	field := fmt.Sprintf("%v=%v ", key, value)
+	return &LoggingHandler{
+		logLevel:     logHandler.logLevel,
+		stderrStream: logHandler.stderrStream,
+		stdoutStream: logHandler.stdoutStream,
+		fields:       logHandler.fields + strings.Replace(field, "%", "%%", -1),
 	}
 }
 
@@ -42,7 +66,7 @@ func (logHandler *LoggingHandler) SetLogLevel(logLevel LogLevel) {
 }
 
 /*
-   Constants for logging
+	Constants for logging
 */
 const (
 	fatalPrefix string = "FATAL: "
@@ -53,46 +77,49 @@ const (
 )
 
 /*
-   Structure that keeps streams
-   used to use the same streams across packages
+	Structure that keeps streams
+	used to use the same streams across packages
 */
 type LoggingHandler struct {
 	logLevel     LogLevel
 	stderrStream *log.Logger
 	stdoutStream *log.Logger
+
+	// Formatted fields prepended to every message
+	fields string
 }
 
 /*
-   Utilities for logging
+	Utilities for logging
 */
 func (logHandler *LoggingHandler) Fatalf(format string, v ...interface{}) {
-	logHandler.stderrStream.Fatalf(fatalPrefix+format, v...)
+	logHandler.stderrStream.Fatalf(fatalPrefix+logHandler.fields+format, v...)
 }
 
 func (logHandler *LoggingHandler) Errorf(format string, v ...interface{}) {
 	if logHandler.logLevel < ERROR {
 		return
 	}
-	logHandler.stderrStream.Printf(errorPrefix+format, v...)
+	logHandler.stderrStream.Printf(errorPrefix+logHandler.fields+format, v...)
 }
 
 func (logHandler *LoggingHandler) Warnf(format string, v ...interface{}) {
 	if logHandler.logLevel < WARN {
 		return
 	}
-	logHandler.stdoutStream.Printf(warnPrefix+format, v...)
+	logHandler.stdoutStream.Printf(warnPrefix+logHandler.fields+format, v...)
 }
 
 func (logHandler *LoggingHandler) Infof(format string, v ...interface{}) {
 	if logHandler.logLevel < INFO {
 		return
 	}
-	logHandler.stdoutStream.Printf(infoPrefix+format, v...)
+	logHandler.stdoutStream.Printf(infoPrefix+logHandler.fields+format, v...)
 }
 
 func (logHandler *LoggingHandler) Debugf(format string, v ...interface{}) {
 	if logHandler.logLevel < DEBUG {
 		return
 	}
-	logHandler.stdoutStream.Printf(debugPrefix+format, v...)
+	logHandler.stdoutStream.Printf(debugPrefix+logHandler.fields+format, v...)
 }
@@ -0,0 +1,105 @@
+/*
+	Nonce reuse detection for keys that encrypt many payloads
+*/
+
+package core
+
+import (
+	"crypto/cipher"
+	"errors"
+	"sync"
+)
+
+/*
+	Errors
+*/
+var (
+	nonceReusedError error = errors.New("Nonce already used with this key.")
+)
+
+/*
+	Records used (key id, nonce) pairs
+*/
+type NonceTracker struct {
+	used map[string]map[string]bool
+	lock *sync.Mutex
+}
+
+func NewNonceTracker() *NonceTracker {
+	return &NonceTracker{
+		used: map[string]map[string]bool{},
+		lock: &sync.Mutex{},
+	}
+}
+
+/*
+	Records nonce as used with key id and fails if it was already used
+*/
+func (tracker *NonceTracker) Track(keyId string, nonce []byte) error {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	keyNonces, ok := tracker.used[keyId]
+	if !ok {
+		keyNonces = map[string]bool{}
+		tracker.used[keyId] = keyNonces
+	}
+	if keyNonces[string(nonce)] {
+		return nonceReusedError
+	}
+	keyNonces[string(nonce)] = true
+	return nil
+}
+
+/*
+	AEAD that can be shared across goroutines and refuses to seal twice with the same nonce
+	(opening is unrestricted since decrypting with a known nonce is expected)
+*/
+type SafeAead struct {
+	cipher.AEAD
+	used map[string]bool
+	lock *sync.Mutex
+}
+
+func NewSafeAead(aead cipher.AEAD) *SafeAead {
+	return &SafeAead{
+		AEAD: aead,
+		used: map[string]bool{},
+		lock: &sync.Mutex{},
+	}
+}
+
+/*
+	Records nonce as used and fails if it was already used
+*/
+func (safeAead *SafeAead) track(nonce []byte) error {
+	safeAead.lock.Lock()
+	defer safeAead.lock.Unlock()
+	if safeAead.used[string(nonce)] {
+		return nonceReusedError
+	}
+	safeAead.used[string(nonce)] = true
+	return nil
+}
+
+/*
+	Seals plaintext unless nonce was already used with this AEAD
+*/
+func (safeAead *SafeAead) TrySeal(dst []byte, nonce []byte, plaintext []byte, associatedData []byte) ([]byte, error) {
+	if err := safeAead.track(nonce); err != nil {
+		return nil, err
+	}
+	return safeAead.AEAD.Seal(dst, nonce, plaintext, associatedData), nil
+}
+
+/*
+	Seals plaintext and panics if nonce was already used with this AEAD
+	(keeps SafeAead usable as a cipher.AEAD, prefer TrySeal to get an error)
+*/
+func (safeAead *SafeAead) Seal(dst []byte, nonce []byte, plaintext []byte, associatedData []byte) []byte {
+	sealed, err := safeAead.TrySeal(dst, nonce, plaintext, associatedData)
+	if err != nil {
+		panic(err)
+	}
+	return sealed
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNonceTracker(t *testing.T) {
+	tracker := NewNonceTracker()
+	nonce := generateRandomBytes(SymmetricNonceSize)
+
+	if err := tracker.Track("KEY_ID", nonce); err != nil {
+		t.Errorf("Tracking new nonce should not fail. err=%v", err)
+	}
+	if err := tracker.Track("KEY_ID", nonce); err != nonceReusedError {
+		t.Errorf("Tracking reused nonce should fail. err=%v", err)
+	}
+	if err := tracker.Track("OTHER_KEY_ID", nonce); err != nil {
+		t.Errorf("Tracking nonce with a different key should not fail. err=%v", err)
+	}
+	if err := tracker.Track("KEY_ID", generateRandomBytes(SymmetricNonceSize)); err != nil {
+		t.Errorf("Tracking different nonce should not fail. err=%v", err)
+	}
+}
+
+func TestOperationNonceCollision(t *testing.T) {
+	tracker := NewNonceTracker()
+	permanentKey := generateRandomBytes(SymmetricKeySize)
+	permanentNonce := generateRandomBytes(SymmetricNonceSize)
+
+	for i := 0; i < 2; i++ {
+		encryptedOperation, _, _, err := GenerateOperationWithEncryptionAndTracker(
+			"KEY_ID",
+			permanentKey,
+			permanentNonce,
+			1,
+			[]byte("REQUEST_PAYLOAD"),
+			"ISSUER",
+			dummyByteToByteTransformer,
+			"CERTIFIER",
+			dummyByteToByteTransformer,
+			NoCompression,
+			tracker,
+		)
+		if i == 0 && (err != nil || encryptedOperation == nil) {
+			t.Errorf("Operation generation with new nonce should not fail. err=%v", err)
+		}
+		if i == 1 && (err != nonceReusedError || encryptedOperation != nil) {
+			t.Errorf("Operation generation with reused nonce should fail. err=%v", err)
+		}
+	}
+}
+
+func TestSafeAeadConcurrentNonces(t *testing.T) {
+	aead, _ := NewAead(generateRandomBytes(SymmetricKeySize))
+	safeAead := NewSafeAead(aead)
+
+	// Each nonce is sealed by two goroutines, only one of them should succeed
+	nonces := [][]byte{}
+	for i := 0; i < 50; i++ {
+		nonces = append(nonces, generateRandomBytes(SymmetricNonceSize))
+	}
+	successes := make([]int, len(nonces))
+	var successesLock sync.Mutex
+	var wg sync.WaitGroup
+	for i := range nonces {
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go (func(i int) {
+				defer wg.Done()
+				ciphertext, err := SymmetricEncrypt(safeAead, nil, nonces[i], []byte("PLAINTEXT"))
+				if err == nil {
+					successesLock.Lock()
+					successes[i]++
+					successesLock.Unlock()
+					if plaintext, err := SymmetricDecrypt(safeAead, nil, nonces[i], ciphertext); err != nil || string(plaintext) != "PLAINTEXT" {
+						t.Errorf("Sealed payload should open. err=%v", err)
+					}
+				} else if err != nonceReusedError {
+					t.Errorf("Reused nonce should fail with reuse error. err=%v", err)
+				}
+			})(i)
+		}
+	}
+	wg.Wait()
+	for i, count := range successes {
+		if count != 1 {
+			t.Errorf("Each nonce should be sealed exactly once. index=%v, count=%v", i, count)
+		}
+	}
+
+	// Sealing directly with a reused nonce panics
+	defer (func() {
+		if recover() == nil {
+			t.Errorf("Sealing with reused nonce should panic.")
+		}
+	})()
+	safeAead.Seal(nil, nonces[0], []byte("PLAINTEXT"), nil)
+}
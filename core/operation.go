@@ -1,7 +1,11 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
+	"strings"
+	"time"
 )
 
 /*
@@ -12,25 +16,58 @@ type RequestType int
 const (
 	UsersRequestType RequestType = iota
 	AddMessageType
+	ChannelsRequestType
+)
+
+/*
+	Signing algorithms
+	(RSA is the default when no algorithm is specified)
+*/
+type SigningAlgorithm int
+
+const (
+	RsaSigningAlgorithm SigningAlgorithm = iota
+	Ed25519SigningAlgorithm
+)
+
+/*
+	Hash algorithms applied to signed data
+	(SHA-256 is the default when no algorithm is specified)
+*/
+type HashAlgorithm int
+
+const (
+	Sha256HashAlgorithm HashAlgorithm = iota
+	Sha512HashAlgorithm
 )
 
 /*
 	Structure of an operation before permanent encryption
 */
 type OperationEncryptionFields struct {
-	Encrypted bool   `json:"encrypted"`
-	KeyId     string `json:"keyId"`
-	Nonce     string `json:"nonce"`
+	Encrypted   bool                 `json:"encrypted"`
+	KeyId       string               `json:"keyId"`
+	Nonce       string               `json:"nonce"`
+	Algorithm   AeadAlgorithm        `json:"algorithm"`
+	Compression CompressionAlgorithm `json:"compression"`
 }
 type OperationAuthenticationFields struct {
-	Id        string `json:"id"`
-	Signature string `json:"signature"`
+	Id            string           `json:"id"`
+	Signature     string           `json:"signature"`
+	Algorithm     SigningAlgorithm `json:"algorithm"`
+	HashAlgorithm HashAlgorithm    `json:"hashAlgorithm"`
 }
 type OperationMetaFields struct {
-	RequestType RequestType `json:"requestType"`
-	Buffered    bool
+	RequestType    RequestType `json:"requestType"`
+	Buffered       bool
+	IdempotencyKey string `json:"idempotencyKey"`
+	Priority       int    `json:"priority"`
+	ValidityWindow
+	// Operation is validated without being applied (covered by signatures)
+	DryRun bool `json:"dryRun"`
 }
 type Operation struct {
+	Version       float64                       `json:"version"`
 	Encryption    OperationEncryptionFields     `json:"encryption"`
 	Issue         OperationAuthenticationFields `json:"issue"`
 	Certification OperationAuthenticationFields `json:"certification"`
@@ -38,6 +75,70 @@ type Operation struct {
 	Payload       string                        `json:"payload"`
 }
 
+/*
+	Operation versions
+	(legacy operations are signed over their payload only, bound ones also bind their nonce and key id
+	to the signatures, and current ones sign a canonical structure of every signed field)
+*/
+const (
+	LegacyOperationVersion float64 = 0.1
+	BoundOperationVersion  float64 = 0.2
+	OperationVersion       float64 = 0.3
+)
+
+/*
+	Determines if the operation signatures bind its nonce and key id
+	(operations without a version are legacy)
+*/
+func isBoundOperationVersion(version float64) (bool, error) {
+	switch version {
+	case 0, LegacyOperationVersion:
+		return false, nil
+	case BoundOperationVersion, OperationVersion:
+		return true, nil
+	}
+	return false, unsupportedOperationVersionError
+}
+
+/*
+	Time window in which an operation can be executed
+	(unbounded on either side if zero, and covered by signatures)
+*/
+type ValidityWindow struct {
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+/*
+	Errors
+*/
+var (
+	operationNotYetValidError        error = errors.New("Operation is not valid yet.")
+	operationExpiredError            error = errors.New("Operation has expired.")
+	unsupportedOperationVersionError error = errors.New("Unsupported operation version.")
+	missingPayloadError              error = errors.New("Payload is missing.")
+	missingSignatureError            error = errors.New("Issuer signature is missing.")
+	invalidSignatureLengthError      error = errors.New("Invalid signature length.")
+	invalidSigningAlgorithmError     error = errors.New("Invalid signing algorithm provided.")
+)
+
+func (window ValidityWindow) IsUnbounded() bool {
+	return window.NotBefore.IsZero() && window.NotAfter.IsZero()
+}
+
+/*
+	Checks if an operation can be executed at a given time
+*/
+func (window ValidityWindow) Check(at time.Time) error {
+	if !window.NotBefore.IsZero() && at.Before(window.NotBefore) {
+		return operationNotYetValidError
+	}
+	if !window.NotAfter.IsZero() && at.After(window.NotAfter) {
+		return operationExpiredError
+	}
+	return nil
+}
+
 /*
 	Determines if the request should be dropped if decryption/signature verification fails
 */
@@ -45,6 +146,104 @@ func (op *Operation) ShouldDrop() bool {
 	return !(op.Meta.RequestType == AddMessageType && !op.Meta.Buffered)
 }
 
+/*
+	Determines if the operation carries a certifier signature (self-certified by the issuer otherwise)
+*/
+func (op *Operation) HasCertifier() bool {
+	return len(op.Certification.Signature) != 0
+}
+
+/*
+	Checks the operation is well formed before any decryption or verification
+	(issuer signature is required, and certifier signature is checked if present)
+*/
+func (op *Operation) Validate() error {
+	if err := op.validateStructure(); err != nil {
+		return err
+	}
+	if len(op.Issue.Signature) == 0 {
+		return missingSignatureError
+	}
+	if err := op.Issue.validateSignature(); err != nil {
+		return err
+	}
+	if op.HasCertifier() {
+		return op.Certification.validateSignature()
+	}
+	return nil
+}
+
+/*
+	Checks the operation is well formed without requiring signatures (for unverified requests)
+*/
+func (op *Operation) ValidateUnsigned() error {
+	return op.validateStructure()
+}
+
+func (op *Operation) validateStructure() error {
+	if _, err := isBoundOperationVersion(op.Version); err != nil {
+		return err
+	}
+	if err := validatePayload(op.Payload); err != nil {
+		return err
+	}
+	if op.Encryption.Encrypted {
+		if err := validateEncodedNonce(op.Encryption.Nonce); err != nil {
+			return err
+		}
+		if op.Encryption.Algorithm != ChaCha20Poly1305AeadAlgorithm && op.Encryption.Algorithm != Aes256GcmAeadAlgorithm {
+			return invalidAeadAlgorithmError
+		}
+	}
+	return nil
+}
+
+/*
+	Checks the signature decodes to a length possible for its algorithm
+	(RSA signatures are as long as the signing key modulus)
+*/
+func (authentication *OperationAuthenticationFields) validateSignature() error {
+	signature, err := base64DecodeAnyString(authentication.Signature)
+	if err != nil {
+		return invalidSignatureEncodingError
+	}
+	switch authentication.Algorithm {
+	case RsaSigningAlgorithm:
+		if len(signature) < minAsymmetricKeySizeBits/8 || len(signature)%8 != 0 {
+			return invalidSignatureLengthError
+		}
+	case Ed25519SigningAlgorithm:
+		if len(signature) != ed25519.SignatureSize {
+			return invalidSignatureLengthError
+		}
+	default:
+		return invalidSigningAlgorithmError
+	}
+	return nil
+}
+
+func validatePayload(payload string) error {
+	if len(payload) == 0 {
+		return missingPayloadError
+	}
+	payloadBytes, err := base64DecodeAnyString(payload)
+	if err != nil {
+		return payloadDecodeError
+	}
+	if len(payloadBytes) == 0 {
+		return missingPayloadError
+	}
+	return nil
+}
+
+func validateEncodedNonce(nonce string) error {
+	nonceBytes, err := base64DecodeAnyString(nonce)
+	if err != nil {
+		return invalidNonceError
+	}
+	return ValidateNonce(nonceBytes)
+}
+
 /*
 	Decodes an operation
 */
@@ -65,3 +264,19 @@ func (op *Operation) Encode() ([]byte, error) {
 
 	return jsonStream, nil
 }
+
+/*
+	Size of the encoded operation in bytes
+*/
+func (op *Operation) SizeBytes() int {
+	jsonStream, _ := op.Encode()
+	return len(jsonStream)
+}
+
+/*
+	Size of the base64 decoded payload in bytes (computed without decoding)
+*/
+func (op *Operation) PayloadSizeBytes() int {
+	unpadded := strings.TrimRight(op.Payload, "=")
+	return len(unpadded) * 6 / 8
+}
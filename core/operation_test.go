@@ -3,6 +3,7 @@ package core
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 /*
@@ -44,7 +45,8 @@ func TestPermDecodeValid(t *testing.T) {
 		t.Error("Encryption fields not decoded properly")
 	}
 
-	if !(rawOp.Issue.Signature == "ISSUER_SIGNATURE") {
+	if !(rawOp.Issue.Signature == "ISSUER_SIGNATURE" &&
+		rawOp.Issue.Algorithm == RsaSigningAlgorithm) {
 		t.Error("Issuer signature not decoded properly")
 	}
 
@@ -143,3 +145,135 @@ func TestOperationDrop(t *testing.T) {
 		t.Error("Messages should be dropped if decryption fails after buffering")
 	}
 }
+
+func TestValidityWindowCheck(t *testing.T) {
+	now := time.Now()
+	if err := (ValidityWindow{}).Check(now); err != nil {
+		t.Errorf("Unbounded window should always be valid. err=%v", err)
+	}
+	window := ValidityWindow{NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Minute)}
+	if err := window.Check(now); err != nil {
+		t.Errorf("Operation inside window should be valid. err=%v", err)
+	}
+	if err := window.Check(now.Add(-time.Hour)); err != operationNotYetValidError {
+		t.Errorf("Operation before window should not be valid yet. err=%v", err)
+	}
+	if err := window.Check(now.Add(time.Hour)); err != operationExpiredError {
+		t.Errorf("Operation after window should be expired. err=%v", err)
+	}
+}
+
+func TestOperationSize(t *testing.T) {
+	for _, payloadSize := range []int{0, 1, 2, 3, 16, 17} {
+		op := GenerateOperation(false, "", []byte{}, false, "", []byte{}, false, "", []byte{}, false, UsersRequestType, make([]byte, payloadSize), false)
+		encoded, _ := op.Encode()
+		if op.SizeBytes() != len(encoded) {
+			t.Errorf("Operation size should match its encoding. found=%v, expected=%v", op.SizeBytes(), len(encoded))
+		}
+		if op.PayloadSizeBytes() != payloadSize {
+			t.Errorf("Payload size should match decoded payload. found=%v, expected=%v", op.PayloadSizeBytes(), payloadSize)
+		}
+	}
+}
+
+func TestOperationValidate(t *testing.T) {
+	generateValid := func() *Operation {
+		op, _, _ := GenerateOperationWithEncryption(
+			"KEY_ID",
+			generateRandomBytes(SymmetricKeySize),
+			generateRandomBytes(SymmetricNonceSize),
+			UsersRequestType,
+			[]byte("PAYLOAD"),
+			"ISSUER",
+			func(b []byte) ([]byte, bool) { return b, false },
+			"CERTIFIER",
+			func(b []byte) ([]byte, bool) { return b, false },
+		)
+		return op
+	}
+	if err := generateValid().Validate(); err != nil {
+		t.Fatalf("Valid operation should pass validation. err=%v", err)
+	}
+
+	defects := map[string]struct {
+		modify      func(*Operation)
+		expectedErr error
+	}{
+		"missing nonce": {
+			func(op *Operation) { op.Encryption.Nonce = "" },
+			invalidNonceError,
+		},
+		"short nonce": {
+			func(op *Operation) {
+				op.Encryption.Nonce = Base64EncodeToString(generateRandomBytes(SymmetricNonceSize - 1))
+			},
+			invalidNonceError,
+		},
+		"empty payload": {
+			func(op *Operation) { op.Payload = "" },
+			missingPayloadError,
+		},
+		"payload not base64": {
+			func(op *Operation) { op.Payload = "%PAYLOAD%" },
+			payloadDecodeError,
+		},
+		"missing issuer signature": {
+			func(op *Operation) { op.Issue.Signature = "" },
+			missingSignatureError,
+		},
+		"wrong length issuer signature": {
+			func(op *Operation) { op.Issue.Signature = Base64EncodeToString([]byte("SIGNATURE")) },
+			invalidSignatureLengthError,
+		},
+		"wrong length certifier signature": {
+			func(op *Operation) { op.Certification.Signature = Base64EncodeToString(generateRandomBytes(255)) },
+			invalidSignatureLengthError,
+		},
+		"wrong length ed25519 signature": {
+			func(op *Operation) { op.Issue.Algorithm = Ed25519SigningAlgorithm },
+			invalidSignatureLengthError,
+		},
+		"signature not base64": {
+			func(op *Operation) { op.Issue.Signature = "%SIGNATURE%" },
+			invalidSignatureEncodingError,
+		},
+		"unknown signing algorithm": {
+			func(op *Operation) { op.Certification.Algorithm = 5 },
+			invalidSigningAlgorithmError,
+		},
+		"unknown symmetric algorithm": {
+			func(op *Operation) { op.Encryption.Algorithm = 5 },
+			invalidAeadAlgorithmError,
+		},
+		"unsupported version": {
+			func(op *Operation) { op.Version = 5 },
+			unsupportedOperationVersionError,
+		},
+	}
+	for name, defect := range defects {
+		op := generateValid()
+		defect.modify(op)
+		if err := op.Validate(); err != defect.expectedErr {
+			t.Errorf("Validation should fail. defect=%v, err=%v, expected=%v", name, err, defect.expectedErr)
+		}
+	}
+
+	// Signatures are optional when unverified, but the rest is still checked
+	op := generateValid()
+	op.Issue.Signature = ""
+	op.Certification.Signature = ""
+	if err := op.ValidateUnsigned(); err != nil {
+		t.Errorf("Unsigned operation should pass unsigned validation. err=%v", err)
+	}
+	op.Encryption.Nonce = ""
+	if err := op.ValidateUnsigned(); err != invalidNonceError {
+		t.Errorf("Unsigned validation should check nonce. err=%v", err)
+	}
+
+	// Self-certified operations don't need a certifier signature
+	op = generateValid()
+	op.Certification.Signature = ""
+	if err := op.Validate(); err != nil {
+		t.Errorf("Self-certified operation should pass validation. err=%v", err)
+	}
+}
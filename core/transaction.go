@@ -1,9 +1,74 @@
 package core
 
 import (
+	"bytes"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 )
 
+/*
+	Transaction versions understood
+	(legacy transactions wrap the temporary key with RSA PKCS #1 v1.5,
+	current ones with RSA-OAEP labeled with the transaction nonce)
+*/
+const (
+	LegacyTransactionVersion float64 = 0.1
+	TransactionVersion       float64 = 0.2
+)
+
+var supportedTransactionVersions map[float64]bool = map[float64]bool{
+	LegacyTransactionVersion: true,
+	TransactionVersion:       true,
+}
+
+/*
+	Version a transaction is processed as (transactions without a version are legacy)
+*/
+func transactionVersion(version float64) float64 {
+	if version == 0 {
+		return LegacyTransactionVersion
+	}
+	return version
+}
+
+/*
+	Label binding wrapped temporary keys to transactions and to their nonce
+*/
+const transactionKeyLabelPrefix string = "DMPC transaction key"
+
+func transactionKeyLabel(nonce []byte) []byte {
+	return append([]byte(transactionKeyLabelPrefix), nonce...)
+}
+
+/*
+	Wraps and unwraps the temporary key of a transaction for a recipient, depending on its version
+	(legacy keys that fail to unwrap are replaced by a random key so that padding failures
+	can't be told apart from challenge failures)
+*/
+func wrapTransactionKey(version float64, recipient *rsa.PublicKey, key []byte, nonce []byte) ([]byte, error) {
+	if version == LegacyTransactionVersion {
+		return AsymmetricEncrypt(recipient, key)
+	}
+	return AsymmetricEncryptWithLabel(recipient, key, transactionKeyLabel(nonce))
+}
+
+func unwrapTransactionKey(version float64, asymKey *rsa.PrivateKey, wrappedKey []byte, nonce []byte) ([]byte, error) {
+	if version == LegacyTransactionVersion {
+		key := generateRandomBytes(SymmetricKeySize)
+		if err := rsa.DecryptPKCS1v15SessionKey(rng, asymKey, wrappedKey, key); err != nil {
+			return nil, asymmetrictDecryptionError
+		}
+		return key, nil
+	}
+	return AsymmetricDecryptWithLabel(asymKey, wrappedKey, transactionKeyLabel(nonce))
+}
+
+/*
+	Maximum number of challenges of a decoded transaction (used if not configured)
+*/
+const DefaultMaxChallenges int = 1024
+
 /*
 	Structure of a transaction (before temporary decryption)
 */
@@ -23,9 +88,31 @@ type Transaction struct {
 }
 
 /*
-	Decodes a transaction
+	Decodes a transaction with at most the default number of challenges
 */
 func (op *Transaction) Decode(stream []byte) error {
+	return op.DecodeWithMaxChallenges(stream, DefaultMaxChallenges)
+}
+
+/*
+	Decodes a transaction, failing if it has more challenges than the maximum (no limit if not positive)
+	(challenges are counted before the challenges map is built)
+*/
+func (op *Transaction) DecodeWithMaxChallenges(stream []byte, maxChallenges int) error {
+	if maxChallenges > 0 {
+		var challengesField struct {
+			Encryption struct {
+				Challenges json.RawMessage `json:"challenges"`
+			} `json:"encryption"`
+		}
+		if err := json.Unmarshal(stream, &challengesField); err != nil {
+			return err
+		}
+		if err := checkChallengesCount(challengesField.Encryption.Challenges, maxChallenges); err != nil {
+			return err
+		}
+	}
+
 	// Try to decode json into raw operation
 	if err := json.Unmarshal(stream, &op); err != nil {
 		return err
@@ -34,6 +121,65 @@ func (op *Transaction) Decode(stream []byte) error {
 	return nil
 }
 
+/*
+	Counts challenges entries one at a time and stops as soon as there are too many
+	(anything other than an object is left for decoding to reject)
+*/
+func checkChallengesCount(challenges json.RawMessage, maxChallenges int) error {
+	decoder := json.NewDecoder(bytes.NewReader(challenges))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil
+	}
+	for count := 1; decoder.More(); count++ {
+		if count > maxChallenges {
+			return ErrTooManyChallenges
+		}
+		var entry json.RawMessage
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+		if err := decoder.Decode(&entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Errors
+*/
+var invalidChallengeError error = errors.New("Invalid challenge encoding.")
+
+/*
+	Checks the transaction is well formed before trying any challenge
+*/
+func (op *Transaction) Validate() error {
+	if !supportedTransactionVersions[transactionVersion(op.Version)] {
+		return unsupportedVersionError
+	}
+	if err := validatePayload(op.Payload); err != nil {
+		return err
+	}
+	if op.Encryption.Encrypted {
+		if err := validateEncodedNonce(op.Encryption.Nonce); err != nil {
+			return err
+		}
+		if len(op.Encryption.Challenges) == 0 {
+			return ErrNoChallenges
+		}
+		for symKeyCipher, challenge := range op.Encryption.Challenges {
+			symKeyCipherBytes, err := base64DecodeAnyString(symKeyCipher)
+			if err != nil || len(symKeyCipherBytes) < minAsymmetricKeySizeBits/8 {
+				return invalidChallengeError
+			}
+			if _, err := base64DecodeAnyString(challenge); err != nil {
+				return invalidChallengeError
+			}
+		}
+	}
+	return nil
+}
+
 /*
 	Encodes a transaction
 */
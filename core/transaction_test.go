@@ -101,3 +101,57 @@ func TestTransactionDecodeEncodeCycle(t *testing.T) {
 		t.Error("Re-encoding should produce same value")
 	}
 }
+
+func TestTransactionValidate(t *testing.T) {
+	recipientKey := GeneratePrivateKey()
+	generateValid := func() *Transaction {
+		transaction, _ := GenerateTransactionWithEncryption([]byte("PAYLOAD"), []byte(CorrectChallenge), func(map[string]string) {}, recipientKey)
+		return transaction
+	}
+	if err := generateValid().Validate(); err != nil {
+		t.Fatalf("Valid transaction should pass validation. err=%v", err)
+	}
+
+	defects := map[string]struct {
+		modify      func(*Transaction)
+		expectedErr error
+	}{
+		"missing nonce": {
+			func(transaction *Transaction) { transaction.Encryption.Nonce = "" },
+			invalidNonceError,
+		},
+		"empty payload": {
+			func(transaction *Transaction) { transaction.Payload = "" },
+			missingPayloadError,
+		},
+		"no challenges": {
+			func(transaction *Transaction) { transaction.Encryption.Challenges = map[string]string{} },
+			ErrNoChallenges,
+		},
+		"short challenge key": {
+			func(transaction *Transaction) {
+				transaction.Encryption.Challenges = map[string]string{Base64EncodeToString([]byte("KEY")): Base64EncodeToString([]byte("CHALLENGE"))}
+			},
+			invalidChallengeError,
+		},
+		"challenge not base64": {
+			func(transaction *Transaction) {
+				for symKeyCipher := range transaction.Encryption.Challenges {
+					transaction.Encryption.Challenges[symKeyCipher] = "%CHALLENGE%"
+				}
+			},
+			invalidChallengeError,
+		},
+		"unsupported version": {
+			func(transaction *Transaction) { transaction.Version = 5 },
+			unsupportedVersionError,
+		},
+	}
+	for name, defect := range defects {
+		transaction := generateValid()
+		defect.modify(transaction)
+		if err := transaction.Validate(); err != defect.expectedErr {
+			t.Errorf("Validation should fail. defect=%v, err=%v, expected=%v", name, err, defect.expectedErr)
+		}
+	}
+}
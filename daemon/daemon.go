@@ -26,7 +26,9 @@ func startDaemons(conf *startup.Config, shutdownLambda core.ShutdownLambda) {
 	// Start status systems (status update and listeners servers)
 	log.Debugf(startingStatusSubsystemLogMsg)
 	statusUpdateConfig, statusListenersConfig := conf.GetStatusSubsystemConfig()
-	status.StartServers(statusUpdateConfig, statusListenersConfig, log, shutdownLambda)
+	if err := status.StartServers(statusUpdateConfig, statusListenersConfig, log, shutdownLambda); err != nil {
+		log.Fatalf(statusStartErrorMsg, err.Error())
+	}
 
 	// Start keys subsystem
 	log.Debugf(startingKeysSubsystemLogMsg)
@@ -38,12 +40,19 @@ func startDaemons(conf *startup.Config, shutdownLambda core.ShutdownLambda) {
 	executor.InitializeServer(
 		users.MakeRequest,
 		users.MakeUnverifiedRequest,
+		channels.MakeRequest,
 		status.UpdateStatus,
+		status.ReportRetry,
 		status.RequestNewTicket,
 		log,
 		shutdownLambda,
 	)
 	executorSubsystemConfig := conf.GetExecutorSubsystemConfig()
+	executorSubsystemConfig.UsersProbe = users.Probe
+	executorSubsystemConfig.StatusProbe = status.Probe
+	executorSubsystemConfig.UploadDispatcher = decryptor.MakeEncodedTransactionRequest
+	executorSubsystemConfig.UsersDryRunRequester = users.MakeDryRunRequest
+	executorSubsystemConfig.UsersDryRunRequesterUnverified = users.MakeUnverifiedDryRunRequest
 	executor.StartServer(executorSubsystemConfig)
 
 	// Start decryptor subsystem
@@ -61,6 +70,8 @@ func startDaemons(conf *startup.Config, shutdownLambda core.ShutdownLambda) {
 		shutdownLambda,
 	)
 	decryptorSubsystemConfig := conf.GetDecryptorSubsystemConfig()
+	decryptorSubsystemConfig.PayloadTooLarge = executor.PayloadTooLarge
+	decryptorSubsystemConfig.MaxPayloadBytes = executor.MaxPayloadBytes
 	decryptor.StartServer(decryptorSubsystemConfig)
 
 	// Start pipeline subsystem (websocket server)
@@ -69,33 +80,63 @@ func startDaemons(conf *startup.Config, shutdownLambda core.ShutdownLambda) {
 	pipeline.StartServer(pipelineSubsystemConfig, decryptor.MakeTransactionRequest, log)
 }
 
-func shutdownDaemons() {
-	log.Debugf(shutdownPipelineSubsystemLogMsg)
-	pipeline.ShutdownServer()
-
-	log.Debugf(shutdownDecryptorSubsystemLogMsg)
-	decryptor.ShutdownServer()
-
-	log.Debugf(shutdownKeysSubsystemLogMsg)
-	keys.ShutdownServer()
-
-	log.Debugf(shutdownUsersSubsystemLogMsg)
-	users.ShutdownServer()
-
-	log.Debugf(shutdownChannelsSubsystemLogMsg)
-	channels.ShutdownServers()
-
-	log.Debugf(shutdownExecutorSubsystemLogMsg)
-	executor.ShutdownServer()
+/*
+	Subsystems are shut down from the pipeline inwards (status last)
+	(the executor drains before the subsystems its operations run against are stopped)
+*/
+const (
+	statusShutdownHookPriority int = iota
+	keysShutdownHookPriority
+	channelsShutdownHookPriority
+	usersShutdownHookPriority
+	executorShutdownHookPriority
+	decryptorShutdownHookPriority
+	pipelineShutdownHookPriority
+)
 
-	log.Debugf(shutdownStatusSubsystemLogMsg)
-	status.ShutdownServers()
+func registerDaemonShutdownHooks(hooks *shutdownHookRegistry) {
+	hooks.register("pipeline", pipelineShutdownHookPriority, func() {
+		log.Debugf(shutdownPipelineSubsystemLogMsg)
+		pipeline.ShutdownServer()
+	})
+
+	hooks.register("decryptor", decryptorShutdownHookPriority, func() {
+		log.Debugf(shutdownDecryptorSubsystemLogMsg)
+		decryptor.ShutdownServer()
+	})
+
+	hooks.register("keys", keysShutdownHookPriority, func() {
+		log.Debugf(shutdownKeysSubsystemLogMsg)
+		keys.ShutdownServer()
+	})
+
+	hooks.register("users", usersShutdownHookPriority, func() {
+		log.Debugf(shutdownUsersSubsystemLogMsg)
+		users.ShutdownServer()
+	})
+
+	hooks.register("channels", channelsShutdownHookPriority, func() {
+		log.Debugf(shutdownChannelsSubsystemLogMsg)
+		channels.ShutdownServers()
+	})
+
+	hooks.register("executor", executorShutdownHookPriority, func() {
+		log.Debugf(shutdownExecutorSubsystemLogMsg)
+		if err := executor.StopServerGracefully(executorShutdownTimeout); err != nil {
+			log.Warnf(executorShutdownErrorMsg, err)
+		}
+	})
+
+	hooks.register("status", statusShutdownHookPriority, func() {
+		log.Debugf(shutdownStatusSubsystemLogMsg)
+		status.ShutdownServers()
+	})
 }
 
 func Start() {
 	// Setup listening on shutdown signals
 	terminationChannel, shutdownLambda := setupShutdown()
-	go shutdownWhenSignaled(terminationChannel)
+	go shutdownWhenSignaled(terminationChannel, defaultSignalMapping, nil)
 
 	// Parse confuration and setup logging
 	conf := doSetup()
@@ -106,6 +147,7 @@ func Start() {
 	// Start all subsystems
 	log.Infof(startingUpSubsystemsInfoMsg)
 	startDaemons(conf, shutdownLambda)
+	registerDaemonShutdownHooks(shutdownHooks)
 
 	// Make root user request
 	log.Infof(createRootUserInfoMsg)
@@ -21,6 +21,7 @@ const (
 	shutdownExecutorSubsystemLogMsg  string = "Shutting down executor subsystem"
 	shutdownDecryptorSubsystemLogMsg string = "Shutting down decryptor subsystem"
 	shutdownPipelineSubsystemLogMsg  string = "Shutting down pipeline subsystem"
+	shutdownHookRunningLogMsg        string = "Running shutdown hook %v"
 
 	checkingInstallLogMsg      string = "Checking DMPC install configuration"
 	parsingConfigurationLogMsg string = "Parsing configuration"
@@ -39,4 +40,8 @@ const (
 */
 const (
 	inaccessiblePrivateEncryptionKeyErrorMsg string = "Unable to access private encryption key. Error: %v"
+	executorShutdownErrorMsg                 string = "Executor subsystem did not shutdown gracefully. Error: %v"
+	statusStartErrorMsg                      string = "Unable to start status subsystem. Error: %v"
+	shutdownHookTimedOutLogMsg               string = "Shutdown hook %v did not finish before the shutdown timeout"
+	shutdownHookSkippedLogMsg                string = "Shutdown hook %v skipped because the shutdown timeout elapsed"
 )
@@ -15,7 +15,7 @@ import (
 )
 
 /*
-   Error messages
+	Error messages
 */
 const (
 	encodeRootUserOperationError string = "Unable to encode root user operation"
@@ -25,7 +25,7 @@ const (
 )
 
 /*
-   Utilities
+	Utilities
 */
 func buildRootUserOperation(conf *startup.Config) *core.Transaction {
 	// Get root user object from confuration
@@ -9,8 +9,31 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+/*
+	Maximum time to wait for running operations when shutting down
+*/
+const executorShutdownTimeout time.Duration = 10 * time.Second
+
+/*
+	Maximum time to wait for all shutdown hooks
+*/
+const shutdownHooksTimeout time.Duration = 30 * time.Second
+
+/*
+	Hooks run before exiting
+*/
+var shutdownHooks *shutdownHookRegistry = newShutdownHookRegistry(shutdownHooksTimeout)
+
+/*
+	Registers a cleanup function run on termination (higher priorities run first)
+*/
+func RegisterShutdownHook(name string, priority int, hook ShutdownHook) {
+	shutdownHooks.register(name, priority, hook)
+}
+
 /*
 	Termination messages
 */
@@ -20,6 +43,24 @@ var terminationCauseMessageMapping map[TerminationCause]string = map[Termination
 	SystemTerminated: "Detected system termination",
 }
 
+/*
+	Exit codes for terminal causes (requested terminations exit cleanly)
+*/
+const unknownCauseExitCode int = 1
+
+var terminationCauseExitCodeMapping map[TerminationCause]int = map[TerminationCause]int{
+	FatalError:       1,
+	UserInterrupted:  0,
+	SystemTerminated: 0,
+}
+
+func exitCodeForCause(terminationCause TerminationCause) int {
+	if exitCode, ok := terminationCauseExitCodeMapping[terminationCause]; ok {
+		return exitCode
+	}
+	return unknownCauseExitCode
+}
+
 /*
 	Termination causes specification
 */
@@ -33,9 +74,20 @@ const (
 )
 
 /*
-	List of fatal system signals handled and their mapping
+	Mapping of system signals handled to termination causes
+	(signals mapped to NoTermination trigger the non terminal callback instead of exiting)
+*/
+type SignalMapping map[os.Signal]TerminationCause
+
+/*
+	Callback run for non terminal causes (e.g. configuration reload)
+*/
+type NonTerminalCallback func(TerminationCause)
+
+/*
+	List of fatal system signals handled by default and their mapping
 */
-var signalMapping map[os.Signal]TerminationCause = map[os.Signal]TerminationCause{
+var defaultSignalMapping SignalMapping = SignalMapping{
 	os.Interrupt:    UserInterrupted,
 	syscall.SIGHUP:  SystemTerminated,
 	syscall.SIGINT:  UserInterrupted,
@@ -43,36 +95,63 @@ var signalMapping map[os.Signal]TerminationCause = map[os.Signal]TerminationCaus
 	syscall.SIGQUIT: SystemTerminated,
 }
 
-func mapSystemSignal(sig os.Signal) TerminationCause {
-	return signalMapping[sig]
+func (mapping SignalMapping) signals() []os.Signal {
+	signals := []os.Signal{}
+	for sig := range mapping {
+		signals = append(signals, sig)
+	}
+	return signals
 }
+
 func isTerminal(terminationCause TerminationCause) bool {
 	return terminationCause != NoTermination
 }
 
-func listenForSystemTermination(terminationChannel chan TerminationCause) {
-	// Put everything from system into signalChannel
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel)
-
-	// Keep waiting on signals and push them to termination channel
+/*
+	Buffer size for system signals (avoids dropping bursts of signals)
+*/
+const signalChannelBufferSize int = 16
+
+func listenForSystemTermination(
+	terminationChannel chan TerminationCause,
+	signalMapping SignalMapping,
+	nonTerminalCallback NonTerminalCallback,
+) {
+	// Put signals handled from system into signalChannel
+	signalChannel := make(chan os.Signal, signalChannelBufferSize)
+	signal.Notify(signalChannel, signalMapping.signals()...)
+
+	// Keep waiting on signals and push terminal ones to termination channel
 	for {
 		systemSignal := <-signalChannel
-		mappedTerminationCause := mapSystemSignal(systemSignal)
-		terminationChannel <- mappedTerminationCause
+		terminationCause := signalMapping[systemSignal]
+		if isTerminal(terminationCause) {
+			terminationChannel <- terminationCause
+		} else if nonTerminalCallback != nil {
+			nonTerminalCallback(terminationCause)
+		}
 	}
 }
 
-func listenForTermination(terminationChannel chan TerminationCause) {
+func listenForTermination(
+	terminationChannel chan TerminationCause,
+	signalMapping SignalMapping,
+	nonTerminalCallback NonTerminalCallback,
+	hooks *shutdownHookRegistry,
+) TerminationCause {
 	// Setup system termination listening
-	go listenForSystemTermination(terminationChannel)
+	go listenForSystemTermination(terminationChannel, signalMapping, nonTerminalCallback)
 
 	// Keep waiting on causes until a terminal cause is sent
 	for {
 		terminationCause := <-terminationChannel
 		if isTerminal(terminationCause) {
 			log.Errorf(terminationCauseMessageMapping[terminationCause])
-			return
+			hooks.run()
+			return terminationCause
+		}
+		if nonTerminalCallback != nil {
+			nonTerminalCallback(terminationCause)
 		}
 	}
 }
@@ -91,13 +170,14 @@ func setupShutdown() (chan TerminationCause, core.ShutdownLambda) {
 	return terminationChannel, shutdownFunctor(terminationChannel)
 }
 
-func shutdownWhenSignaled(terminationChannel chan TerminationCause) {
-	// Wait until signal to terminate is received
-	listenForTermination(terminationChannel)
-
-	// Soft shutdown all subsystems
-	shutdownDaemons()
+func shutdownWhenSignaled(
+	terminationChannel chan TerminationCause,
+	signalMapping SignalMapping,
+	nonTerminalCallback NonTerminalCallback,
+) {
+	// Wait until signal to terminate is received, and soft shutdown all subsystems
+	terminationCause := listenForTermination(terminationChannel, signalMapping, nonTerminalCallback, shutdownHooks)
 
 	// Terminate program
-	os.Exit(1)
+	os.Exit(exitCodeForCause(terminationCause))
 }
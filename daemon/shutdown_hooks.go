@@ -0,0 +1,93 @@
+package daemon
+
+/*
+	Shutdown hooks
+*/
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	Cleanup function run before the program exits
+*/
+type ShutdownHook func()
+
+type registeredShutdownHook struct {
+	name     string
+	priority int
+	sequence int
+	hook     ShutdownHook
+}
+
+/*
+	Registry of hooks run on termination
+	(higher priorities run first, equal priorities in registration order,
+	and hooks still running when the timeout elapses are skipped)
+*/
+type shutdownHookRegistry struct {
+	hooks   []registeredShutdownHook
+	timeout time.Duration
+	lock    *sync.Mutex
+}
+
+func newShutdownHookRegistry(timeout time.Duration) *shutdownHookRegistry {
+	return &shutdownHookRegistry{
+		hooks:   []registeredShutdownHook{},
+		timeout: timeout,
+		lock:    &sync.Mutex{},
+	}
+}
+
+func (registry *shutdownHookRegistry) register(name string, priority int, hook ShutdownHook) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.hooks = append(registry.hooks, registeredShutdownHook{
+		name:     name,
+		priority: priority,
+		sequence: len(registry.hooks),
+		hook:     hook,
+	})
+}
+
+func (registry *shutdownHookRegistry) sortedHooks() []registeredShutdownHook {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	hooks := append([]registeredShutdownHook{}, registry.hooks...)
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].priority != hooks[j].priority {
+			return hooks[i].priority > hooks[j].priority
+		}
+		return hooks[i].sequence < hooks[j].sequence
+	})
+	return hooks
+}
+
+func (registry *shutdownHookRegistry) run() {
+	deadline := time.NewTimer(registry.timeout)
+	defer deadline.Stop()
+	timedOut := false
+
+	for _, registeredHook := range registry.sortedHooks() {
+		if timedOut {
+			log.Warnf(shutdownHookSkippedLogMsg, registeredHook.name)
+			continue
+		}
+
+		log.Debugf(shutdownHookRunningLogMsg, registeredHook.name)
+		hookDone := make(chan bool)
+		go (func(hook ShutdownHook) {
+			hook()
+			close(hookDone)
+		})(registeredHook.hook)
+
+		select {
+		case <-hookDone:
+		case <-deadline.C:
+			timedOut = true
+			log.Warnf(shutdownHookTimedOutLogMsg, registeredHook.name)
+		}
+	}
+}
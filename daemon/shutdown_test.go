@@ -0,0 +1,174 @@
+package daemon
+
+import (
+	"github.com/mngharbi/DMPC/core"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNonTerminalSignalCallback(t *testing.T) {
+	log = core.InitializeLogging()
+	log.SetLogLevel(core.FATAL)
+
+	signalMapping := SignalMapping{
+		syscall.SIGUSR1: NoTermination,
+		syscall.SIGUSR2: UserInterrupted,
+	}
+	callbackChannel := make(chan TerminationCause, 1)
+	callback := func(terminationCause TerminationCause) {
+		callbackChannel <- terminationCause
+	}
+
+	terminationChannel := make(chan TerminationCause)
+	listeningDone := make(chan TerminationCause, 1)
+	go (func() {
+		listeningDone <- listenForTermination(terminationChannel, signalMapping, callback, newShutdownHookRegistry(time.Second))
+	})()
+	time.Sleep(10 * time.Millisecond)
+
+	// Non terminal signal should run callback without terminating
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	select {
+	case terminationCause := <-callbackChannel:
+		if terminationCause != NoTermination {
+			t.Errorf("Callback should run with non terminal cause. cause=%v", terminationCause)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Callback should run on non terminal signal.")
+	}
+	select {
+	case <-listeningDone:
+		t.Errorf("Listening should not stop on non terminal signal.")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Terminal signal should stop listening
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+	select {
+	case terminationCause := <-listeningDone:
+		if terminationCause != UserInterrupted {
+			t.Errorf("Listening should return terminal cause. cause=%v", terminationCause)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Listening should stop on terminal signal.")
+	}
+}
+
+func TestExitCodeForCause(t *testing.T) {
+	expectedExitCodes := map[TerminationCause]int{
+		FatalError:           1,
+		UserInterrupted:      0,
+		SystemTerminated:     0,
+		SystemTerminated + 1: 1,
+	}
+	for terminationCause, expectedExitCode := range expectedExitCodes {
+		if exitCode := exitCodeForCause(terminationCause); exitCode != expectedExitCode {
+			t.Errorf("Unexpected exit code. cause=%v, found=%v, expected=%v", terminationCause, exitCode, expectedExitCode)
+		}
+	}
+}
+
+func TestShutdownHooksOrder(t *testing.T) {
+	log = core.InitializeLogging()
+	log.SetLogLevel(core.FATAL)
+
+	hooks := newShutdownHookRegistry(100 * time.Millisecond)
+	ranChannel := make(chan string, 5)
+	makeHook := func(name string) ShutdownHook {
+		return func() {
+			ranChannel <- name
+		}
+	}
+	hooks.register("low", 0, makeHook("low"))
+	hooks.register("high", 10, makeHook("high"))
+	hooks.register("medium", 5, makeHook("medium"))
+	hooks.register("medium2", 5, makeHook("medium2"))
+	hooks.register("slow", -1, func() {
+		time.Sleep(time.Second)
+		ranChannel <- "slow"
+	})
+	hooks.register("skipped", -2, makeHook("skipped"))
+
+	// Terminal cause should run hooks before returning
+	terminationChannel := make(chan TerminationCause)
+	listeningDone := make(chan TerminationCause, 1)
+	go (func() {
+		listeningDone <- listenForTermination(terminationChannel, SignalMapping{syscall.SIGUSR2: UserInterrupted}, nil, hooks)
+	})()
+	terminationChannel <- FatalError
+
+	select {
+	case <-listeningDone:
+	case <-time.After(time.Second / 2):
+		t.Fatalf("Listening should stop once shutdown timeout elapses.")
+	}
+
+	expectedOrder := []string{"high", "medium", "medium2", "low"}
+	for _, expectedName := range expectedOrder {
+		select {
+		case name := <-ranChannel:
+			if name != expectedName {
+				t.Errorf("Hooks should run in priority order. found=%v, expected=%v", name, expectedName)
+			}
+		default:
+			t.Errorf("Hook should have run. expected=%v", expectedName)
+		}
+	}
+
+	// Hooks after timeout should be skipped
+	select {
+	case name := <-ranChannel:
+		t.Errorf("No hook should run after timeout. found=%v", name)
+	default:
+	}
+}
+
+func TestDaemonShutdownHooksOrder(t *testing.T) {
+	hooks := newShutdownHookRegistry(time.Second)
+	registerDaemonShutdownHooks(hooks)
+
+	// Executor drains after ingestion stops, and before the subsystems it runs operations against
+	names := []string{}
+	for _, registeredHook := range hooks.sortedHooks() {
+		names = append(names, registeredHook.name)
+	}
+	expectedOrder := []string{"pipeline", "decryptor", "executor", "users", "channels", "keys", "status"}
+	if !reflect.DeepEqual(names, expectedOrder) {
+		t.Errorf("Daemon shutdown hooks should run in dependency order. found=%v, expected=%v", names, expectedOrder)
+	}
+}
+
+func TestSignalBurst(t *testing.T) {
+	log = core.InitializeLogging()
+	log.SetLogLevel(core.FATAL)
+
+	signalMapping := SignalMapping{
+		syscall.SIGUSR1: NoTermination,
+		syscall.SIGUSR2: SystemTerminated,
+	}
+
+	terminationChannel := make(chan TerminationCause)
+	listeningDone := make(chan TerminationCause, 1)
+	go (func() {
+		listeningDone <- listenForTermination(terminationChannel, signalMapping, nil, newShutdownHookRegistry(time.Second))
+	})()
+	time.Sleep(10 * time.Millisecond)
+
+	// Rapid non terminal signals followed by a terminal one
+	for i := 0; i < 10; i++ {
+		syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	}
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+
+	select {
+	case terminationCause := <-listeningDone:
+		if terminationCause != SystemTerminated {
+			t.Errorf("Listening should return terminal cause. cause=%v", terminationCause)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Terminal signal should be handled after a burst of signals.")
+	}
+}
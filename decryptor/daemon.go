@@ -27,6 +27,15 @@ var (
 
 type Config struct {
 	NumWorkers int
+
+	// Determines if an operation is too large to decrypt (no limit if nil)
+	PayloadTooLarge func(*core.Operation) bool
+
+	// Maximum size of decompressed payloads (default maximum of core if nil or not positive)
+	MaxPayloadBytes func() int
+
+	// Maximum number of challenges of a transaction (default if zero, no limit if negative)
+	MaxChallenges int
 }
 
 /*
@@ -65,6 +74,12 @@ func InitializeServer(
 
 func StartServer(conf Config) error {
 	provisionServerOnce()
+	serverSingleton.payloadTooLarge = conf.PayloadTooLarge
+	serverSingleton.maxPayloadBytes = conf.MaxPayloadBytes
+	serverSingleton.maxChallenges = conf.MaxChallenges
+	if serverSingleton.maxChallenges == 0 {
+		serverSingleton.maxChallenges = core.DefaultMaxChallenges
+	}
 	return serverHandler.StartServer(gofarm.Config{NumWorkers: conf.NumWorkers})
 }
 
@@ -87,7 +102,7 @@ func MakeEncodedTransactionRequest(encodedRequest []byte) (chan *gofarm.Response
 func makeEncodedTransactionRequest(encodedRequest []byte, skipPermissions bool) (chan *gofarm.Response, []error) {
 	// Decode payload
 	transaction := &core.Transaction{}
-	err := transaction.Decode(encodedRequest)
+	err := transaction.DecodeWithMaxChallenges(encodedRequest, serverSingleton.maxChallenges)
 	if err != nil {
 		return nil, []error{err}
 	}
@@ -105,6 +120,19 @@ func MakeTransactionRequest(transaction *core.Transaction) (chan *gofarm.Respons
 
 func makeTransactionRequest(transaction *core.Transaction, skipPermissions bool) (chan *gofarm.Response, []error) {
 	log.Debugf(receivedRequestLogMsg)
+
+	// Reject transactions with too many challenges before trying any of them
+	if serverSingleton.maxChallenges > 0 && len(transaction.Encryption.Challenges) > serverSingleton.maxChallenges {
+		log.Debugf(tooManyChallengesLogMsg)
+		return nil, []error{core.ErrTooManyChallenges}
+	}
+
+	// Reject malformed transactions before any decryption
+	if err := transaction.Validate(); err != nil {
+		log.Debugf(invalidRequestLogMsg, err)
+		return nil, []error{err}
+	}
+
 	nativeResponseChannel, err := serverHandler.MakeRequest(&decryptorRequest{
 		isVerified:  !skipPermissions,
 		transaction: transaction,
@@ -149,6 +177,15 @@ type server struct {
 	usersSignKeyRequester core.UsersSignKeyRequester
 	keyDecryptor          core.Decryptor
 	executorRequester     executor.Requester
+
+	// Size limit check (none if nil)
+	payloadTooLarge func(*core.Operation) bool
+
+	// Decompressed size limit (default if nil)
+	maxPayloadBytes func() int
+
+	// Maximum number of challenges (no limit if negative)
+	maxChallenges int
 }
 
 func (sv *server) Start(_ gofarm.Config, _ bool) error {
@@ -172,9 +209,9 @@ func decryptTransaction(transaction *core.Transaction, globalKey *rsa.PrivateKey
 	return operation, true
 }
 
-func decryptOperation(operation *core.Operation, keyDecryptor core.Decryptor) ([]byte, bool) {
-	payload, err := operation.Decrypt(keyDecryptor)
-	return payload, err == nil
+func decryptOperation(operation *core.Operation, keyDecryptor core.Decryptor, maxBytes int) ([]byte, bool, bool) {
+	payload, err := operation.DecryptWithLimit(keyDecryptor, maxBytes)
+	return payload, err == nil, err == core.ErrDecompressedTooLarge
 }
 
 func verifyPayload(operation *core.Operation, payload []byte, usersSignKeyRequester core.UsersSignKeyRequester) bool {
@@ -191,6 +228,13 @@ func verifyPayload(operation *core.Operation, payload []byte, usersSignKeyReques
 	return verification == nil
 }
 
+func validateOperation(operation *core.Operation, isVerified bool) error {
+	if isVerified {
+		return operation.Validate()
+	}
+	return operation.ValidateUnsigned()
+}
+
 func (sv *server) Work(nativeRequest *gofarm.Request) *gofarm.Response {
 	log.Debugf(runningRequestLogMsg)
 	decryptorWrapped := (*nativeRequest).(*decryptorRequest)
@@ -205,8 +249,36 @@ func (sv *server) Work(nativeRequest *gofarm.Request) *gofarm.Response {
 		}
 	}
 
+	// Check operation structure before decryption and verification (only dropped if droppable)
+	if err := validateOperation(operation, decryptorWrapped.isVerified); err != nil && operation.ShouldDrop() {
+		log.Debugf(invalidRequestLogMsg, err)
+		return failRequest(InvalidOperationError)
+	}
+
+	// Forward oversized operations to the executor without decrypting them (they get rejected there)
+	if sv.payloadTooLarge != nil && sv.payloadTooLarge(operation) {
+		ticket, _ := sv.executorRequester(
+			decryptorWrapped.isVerified,
+			operation.Meta.RequestType,
+			nil,
+			nil,
+			operation,
+			executor.RequestOptionsFromMeta(&operation.Meta),
+		)
+		return failRequestWithTicket(PayloadTooLargeError, ticket)
+	}
+
 	// Operation decryption
-	plaintextBytes, decryptionSuccess := decryptOperation(operation, sv.keyDecryptor)
+	var maxPayloadBytes int
+	if sv.maxPayloadBytes != nil {
+		maxPayloadBytes = sv.maxPayloadBytes()
+	}
+	plaintextBytes, decryptionSuccess, decompressedTooLarge := decryptOperation(operation, sv.keyDecryptor, maxPayloadBytes)
+
+	// Never forward payloads expanding past the limit (not even undecrypted, since they can't be buffered safely)
+	if decompressedTooLarge {
+		return failRequest(PayloadTooLargeError)
+	}
 
 	// Determine if we should fail
 	droppable := operation.ShouldDrop()
@@ -249,6 +321,7 @@ func (sv *server) Work(nativeRequest *gofarm.Request) *gofarm.Response {
 		signers,
 		plaintextBytes,
 		failedEncryptedOperation,
+		executor.RequestOptionsFromMeta(&operation.Meta),
 	)
 	if err != nil {
 		return failRequest(ExecutorError)
@@ -258,9 +331,14 @@ func (sv *server) Work(nativeRequest *gofarm.Request) *gofarm.Response {
 }
 
 func failRequest(errorType int) *gofarm.Response {
+	return failRequestWithTicket(errorType, "")
+}
+
+func failRequestWithTicket(errorType int, ticket status.Ticket) *gofarm.Response {
 	log.Infof(failRequestLogMsg)
 	decryptorRespPtr := &DecryptorResponse{
 		Result: errorType,
+		Ticket: ticket,
 	}
 
 	var nativeResp gofarm.Response = decryptorRespPtr
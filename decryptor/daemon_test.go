@@ -2,6 +2,7 @@ package decryptor
 
 import (
 	"crypto/rsa"
+	"errors"
 	"github.com/mngharbi/DMPC/core"
 	"reflect"
 	"testing"
@@ -377,6 +378,7 @@ func TestOperationEncryption(t *testing.T) {
 		Not buffered add message: not correctly signed
 	*/
 	operation.Encryption.KeyId = keyId1
+	issuerSignature := operation.Issue.Signature
 	operation.Issue.Signature = ""
 	operation.Meta.Buffered = false
 
@@ -412,6 +414,7 @@ func TestOperationEncryption(t *testing.T) {
 		Buffered add message: not correctly encrypted
 	*/
 	operation.Encryption.KeyId = ""
+	operation.Issue.Signature = issuerSignature
 	operation.Meta.Buffered = true
 
 	if !resetAndStartServer(t, singleWorkerConfig(), globalKey, createDummyUsersSignKeyRequesterFunctor(signKeyCollection, true), core.DecryptorFunctor(keyCollection, true), executorRequester) {
@@ -539,3 +542,197 @@ func TestInvalidOperationEncoding(t *testing.T) {
 
 	ShutdownServer()
 }
+
+func TestPayloadTooLarge(t *testing.T) {
+	reg, executorRequester := createDummyExecutorRequesterFunctor()
+	keyCollection := getKeysCollection()
+
+	// Make operation over the limit
+	operation, _, _ := core.GenerateOperationWithEncryption(
+		keyId1,
+		keyCollection[keyId1],
+		generateRandomBytes(core.SymmetricNonceSize),
+		core.UsersRequestType,
+		[]byte("PAYLOAD_OVER_LIMIT"),
+		genericIssuerId,
+		func(b []byte) ([]byte, bool) { return b, false },
+		genericCertifierId,
+		func(b []byte) ([]byte, bool) { return b, false },
+	)
+
+	// Start server with a size limit and a decryptor that should never run
+	conf := singleWorkerConfig()
+	conf.PayloadTooLarge = func(operation *core.Operation) bool {
+		return operation.PayloadSizeBytes() > 16
+	}
+	decryptionAttempted := make(chan bool, 1)
+	keyDecryptor := func(keyId string, algorithm core.AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
+		decryptionAttempted <- true
+		return nil, errors.New("Decryption should not run.")
+	}
+	if !resetAndStartServer(t, conf, nil, createDummyUsersSignKeyRequesterFunctor(getSignKeyCollection(), true), keyDecryptor, executorRequester) {
+		return
+	}
+
+	decryptorResp, ok := makeOperationRequestAndGetResult(t, operation)
+	if !ok {
+		return
+	}
+	if decryptorResp.Result != PayloadTooLargeError || len(decryptorResp.Ticket) == 0 {
+		t.Errorf("Operation over the limit should fail with a ticket. decryptorResp=%+v", decryptorResp)
+	}
+	select {
+	case <-decryptionAttempted:
+		t.Errorf("Operation over the limit should be rejected before decryption.")
+	default:
+	}
+
+	// Operation is forwarded to the executor undecrypted
+	executorEntry := reg.getEntry(decryptorResp.Ticket)
+	if executorEntry.failedOperation != operation || executorEntry.payload != nil || executorEntry.signers != nil {
+		t.Errorf("Operation over the limit should be forwarded undecrypted. executorEntry=%+v", executorEntry)
+	}
+
+	ShutdownServer()
+}
+
+func TestDecompressedPayloadTooLarge(t *testing.T) {
+	reg, executorRequester := createDummyExecutorRequesterFunctor()
+	keyCollection := getKeysCollection()
+
+	// Small compressed payload expanding past the limit
+	operation, _, _, err := core.GenerateOperationWithEncryptionAndTracker(
+		keyId1,
+		keyCollection[keyId1],
+		generateRandomBytes(core.SymmetricNonceSize),
+		core.UsersRequestType,
+		make([]byte, 1<<20),
+		genericIssuerId,
+		func(b []byte) ([]byte, bool) { return b, false },
+		genericCertifierId,
+		func(b []byte) ([]byte, bool) { return b, false },
+		core.GzipCompression,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("Compressed operation generation failed. err=%v", err)
+	}
+
+	conf := singleWorkerConfig()
+	conf.MaxPayloadBytes = func() int {
+		return 64 << 10
+	}
+	if !resetAndStartServer(t, conf, nil, createDummyUsersSignKeyRequesterFunctor(getSignKeyCollection(), true), core.DecryptorFunctor(keyCollection, true), executorRequester) {
+		return
+	}
+
+	decryptorResp, ok := makeOperationRequestAndGetResult(t, operation)
+	if !ok {
+		return
+	}
+	if decryptorResp.Result != PayloadTooLargeError || len(decryptorResp.Ticket) != 0 {
+		t.Errorf("Operation expanding past the limit should fail without a ticket. decryptorResp=%+v", decryptorResp)
+	}
+	if len(reg.data) != 0 {
+		t.Errorf("Operation expanding past the limit should not be forwarded. data=%+v", reg.data)
+	}
+
+	ShutdownServer()
+}
+
+func TestTooManyChallenges(t *testing.T) {
+	_, executorRequester := createDummyExecutorRequesterFunctor()
+	globalKey := core.GeneratePrivateKey()
+	decryptionAttempted := make(chan bool, 1)
+	keyDecryptor := func(keyId string, algorithm core.AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
+		decryptionAttempted <- true
+		return nil, errors.New("Decryption should not run.")
+	}
+	conf := singleWorkerConfig()
+	conf.MaxChallenges = 2
+	if !resetAndStartServer(t, conf, globalKey, createDummyUsersSignKeyRequesterFunctor(getSignKeyCollection(), true), keyDecryptor, executorRequester) {
+		return
+	}
+
+	// Transaction addressed to the server among too many recipients
+	recipients := []*rsa.PublicKey{&globalKey.PublicKey, core.GeneratePublicKey(), core.GeneratePublicKey()}
+	transaction := core.GenerateTransactionForRecipients([]byte("{}"), []byte(core.CorrectChallenge), recipients)
+	transactionEncoded, _ := transaction.Encode()
+
+	if channel, errs := MakeEncodedTransactionRequest(transactionEncoded); channel != nil || len(errs) != 1 || errs[0] != core.ErrTooManyChallenges {
+		t.Errorf("Encoded transaction with too many challenges should be rejected. errs=%v", errs)
+	}
+	if channel, errs := MakeTransactionRequest(transaction); channel != nil || len(errs) != 1 || errs[0] != core.ErrTooManyChallenges {
+		t.Errorf("Transaction with too many challenges should be rejected. errs=%v", errs)
+	}
+	select {
+	case <-decryptionAttempted:
+		t.Errorf("Transaction with too many challenges should be rejected before decryption.")
+	default:
+	}
+
+	ShutdownServer()
+}
+
+func TestMalformedRequests(t *testing.T) {
+	_, executorRequester := createDummyExecutorRequesterFunctor()
+	keyCollection := getKeysCollection()
+	globalKey := core.GeneratePrivateKey()
+	decryptionAttempted := make(chan bool, 1)
+	keyDecryptor := func(keyId string, algorithm core.AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
+		decryptionAttempted <- true
+		return nil, errors.New("Decryption should not run.")
+	}
+	if !resetAndStartServer(t, singleWorkerConfig(), globalKey, createDummyUsersSignKeyRequesterFunctor(getSignKeyCollection(), true), keyDecryptor, executorRequester) {
+		return
+	}
+
+	// Transaction without nonce is rejected when making the request
+	transaction, _ := core.GenerateTransactionWithEncryption([]byte("{}"), []byte(core.CorrectChallenge), func(map[string]string) {}, globalKey)
+	transaction.Encryption.Nonce = ""
+	if channel, errs := MakeTransactionRequest(transaction); channel != nil || len(errs) != 1 {
+		t.Errorf("Transaction without nonce should be rejected. errs=%v", errs)
+	}
+
+	// Operation with wrong length signature is dropped after transaction decryption
+	operation, _, _ := core.GenerateOperationWithEncryption(
+		keyId1,
+		keyCollection[keyId1],
+		generateRandomBytes(core.SymmetricNonceSize),
+		core.UsersRequestType,
+		[]byte("PAYLOAD"),
+		genericIssuerId,
+		func(b []byte) ([]byte, bool) { return b, false },
+		genericCertifierId,
+		func(b []byte) ([]byte, bool) { return b, false },
+	)
+	operation.Issue.Signature = core.Base64EncodeToString([]byte("SIGNATURE"))
+	operationEncoded, _ := operation.Encode()
+	transaction, _ = core.GenerateTransactionWithEncryption(operationEncoded, []byte(core.CorrectChallenge), func(map[string]string) {}, globalKey)
+	transactionEncoded, _ := transaction.Encode()
+	decryptorResp, ok := makeTransactionRequestAndGetResult(t, transactionEncoded, true)
+	if !ok {
+		return
+	}
+	if decryptorResp.Result != InvalidOperationError {
+		t.Errorf("Operation with wrong length signature should be dropped. decryptorResp=%+v", decryptorResp)
+	}
+
+	// Operation with empty payload is dropped
+	operation.Payload = ""
+	decryptorResp, ok = makeOperationRequestAndGetResult(t, operation)
+	if !ok {
+		return
+	}
+	if decryptorResp.Result != InvalidOperationError {
+		t.Errorf("Operation with empty payload should be dropped. decryptorResp=%+v", decryptorResp)
+	}
+
+	select {
+	case <-decryptionAttempted:
+		t.Errorf("Malformed operations should be rejected before decryption.")
+	default:
+	}
+
+	ShutdownServer()
+}
@@ -166,7 +166,7 @@ func createDummyExecutorRequesterFunctor() (*dummyExecutorRegistry, executor.Req
 		data: map[status.Ticket]dummyExecutorEntry{},
 		lock: &sync.Mutex{},
 	}
-	requester := func(isVerified bool, requestType core.RequestType, signers *core.VerifiedSigners, payload []byte, failedOperation *core.Operation) (status.Ticket, error) {
+	requester := func(isVerified bool, requestType core.RequestType, signers *core.VerifiedSigners, payload []byte, failedOperation *core.Operation, options executor.RequestOptions) (status.Ticket, error) {
 		reg.lock.Lock()
 		ticketCopy := status.RequestNewTicket()
 		reg.data[ticketCopy] = dummyExecutorEntry{
@@ -4,10 +4,12 @@ package decryptor
 	Logging messages
 */
 const (
-	daemonStartLogMsg     string = "Decryptor daemon started"
-	daemonShutdownLogMsg  string = "Decryptor daemon shutdown"
-	receivedRequestLogMsg string = "Decryptor received request"
-	runningRequestLogMsg  string = "Decryptor running request"
-	successRequestLogMsg  string = "Decryptor request is successful"
-	failRequestLogMsg     string = "Operation is dropped by decryptor"
+	daemonStartLogMsg       string = "Decryptor daemon started"
+	daemonShutdownLogMsg    string = "Decryptor daemon shutdown"
+	receivedRequestLogMsg   string = "Decryptor received request"
+	runningRequestLogMsg    string = "Decryptor running request"
+	successRequestLogMsg    string = "Decryptor request is successful"
+	failRequestLogMsg       string = "Operation is dropped by decryptor"
+	tooManyChallengesLogMsg string = "Decryptor rejected transaction with too many challenges"
+	invalidRequestLogMsg    string = "Decryptor rejected malformed request: %v"
 )
@@ -13,6 +13,8 @@ const (
 	PermanentDecryptionError
 	VerificationError
 	ExecutorError
+	PayloadTooLargeError
+	InvalidOperationError
 )
 
 type DecryptorResponse struct {
@@ -0,0 +1,108 @@
+/*
+	Submission of several operations in one call
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/status"
+)
+
+/*
+	Errors
+*/
+var (
+	encryptedBatchOperationError error = errors.New("Batch operations should be decrypted before submission.")
+	batchPayloadDecodeError      error = errors.New("Batch operation payload decoding failed.")
+)
+
+/*
+	Errors only affecting the operation they were returned for (reported with its ticket)
+*/
+var batchOperationErrors map[error]bool = map[error]bool{
+	encryptedBatchOperationError: true,
+	batchPayloadDecodeError:      true,
+	payloadTooLargeError:         true,
+	invalidRequestTypeError:      true,
+	usersUnavailableError:        true,
+	signersRequestError:          true,
+	issuerUnknownError:           true,
+	certifierUnknownError:        true,
+	issuerInactiveError:          true,
+	certifierInactiveError:       true,
+	issuerKeyRevokedError:        true,
+	certifierKeyRevokedError:     true,
+	issuerKeyExpiredError:        true,
+	certifierKeyExpiredError:     true,
+	certifierMissingError:        true,
+	issuerSignatureError:         true,
+	certifierSignatureError:      true,
+}
+
+/*
+	Queues decrypted operations once their signatures are verified (waits for room if the queue is full)
+	Returns tickets aligned with the operations, and individual failures are only reported with their tickets
+	Fails if the server stops accepting requests or the context is done while verifying signers
+	(operations after the failing one get no ticket)
+*/
+func SubmitBatch(ctx context.Context, ops []core.Operation) ([]status.Ticket, error) {
+	serverLifecycleLock.RLock()
+	running := serverRunning
+	serverLifecycleLock.RUnlock()
+	if !running {
+		return nil, serverNotRunningError
+	}
+
+	tickets := make([]status.Ticket, len(ops))
+	for index := range ops {
+		ticketId, err := submitBatchOperation(ctx, &ops[index])
+		tickets[index] = ticketId
+		if err != nil && !batchOperationErrors[err] {
+			return tickets, err
+		}
+	}
+	return tickets, nil
+}
+
+func submitBatchOperation(ctx context.Context, op *core.Operation) (status.Ticket, error) {
+	if op.Encryption.Encrypted {
+		return rejectBatchOperation(op, encryptedBatchOperationError)
+	}
+	request, err := core.Base64DecodeString(op.Payload)
+	if err != nil {
+		return rejectBatchOperation(op, batchPayloadDecodeError)
+	}
+
+	// Signer ids are only trusted once their signatures are verified against user records
+	if serverSingleton.usersRequesterUnverified.IsNoop() {
+		return rejectBatchOperation(op, usersUnavailableError)
+	}
+	signers, err := VerifySigners(ctx, op, request, serverSingleton.usersRequesterUnverified)
+	if err != nil && ctx.Err() != nil {
+		return "", err
+	}
+	if err != nil {
+		return rejectBatchOperation(op, err)
+	}
+	return MakeRequest(true, op.Meta.RequestType, signers, request, nil, RequestOptionsFromMeta(&op.Meta))
+}
+
+/*
+	Gives a ticket to an operation that can't be queued, and reports it as rejected
+*/
+func rejectBatchOperation(op *core.Operation, err error) (status.Ticket, error) {
+	ticketId := serverSingleton.ticketGenerator()
+	wrappedRequest := &executorRequest{
+		requestType:     op.Meta.RequestType,
+		ticket:          ticketId,
+		failedOperation: op,
+		log:             requestLogger(ticketId),
+	}
+	wrappedRequest.logger().Debugf(batchOperationRejectedLogMsg, err)
+	serverSingleton.reportStatus(wrappedRequest, status.QueuedStatus, status.NoReason, nil, nil)
+	serverSingleton.reportRejection(wrappedRequest, status.RejectedReason, []error{err})
+	return ticketId, err
+}
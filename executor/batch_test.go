@@ -0,0 +1,139 @@
+package executor
+
+import (
+	"context"
+	"crypto/rsa"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/status"
+	"github.com/mngharbi/DMPC/users"
+	"sync"
+	"testing"
+)
+
+func generateBatchOperation(requestType core.RequestType, payload []byte, issuerKey *rsa.PrivateKey, certifierKey *rsa.PrivateKey) core.Operation {
+	operation := createSignedOperation(payload, issuerKey, certifierKey)
+	operation.Meta.RequestType = requestType
+	return *operation
+}
+
+/*
+	Users requester reading signer records, and running other requests successfully (payloads are recorded)
+*/
+func createDummyBatchUsersRequesterFunctor(userObjects map[string]*users.UserObject) (users.Requester, func() []string) {
+	usersReader := createDummyUsersReaderFunctor(userObjects)
+	lock := &sync.Mutex{}
+	ran := []string{}
+	requester := func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		var rq users.UserRequest
+		if rq.Decode(request) == nil && rq.Type == users.ReadRequest {
+			return usersReader(signers, request)
+		}
+		lock.Lock()
+		ran = append(ran, string(request))
+		lock.Unlock()
+		responseChannel := make(chan *users.UserResponse, 1)
+		responseChannel <- &users.UserResponse{Result: users.Success}
+		return responseChannel, nil
+	}
+	getRan := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, ran...)
+	}
+	return requester, getRan
+}
+
+func TestSubmitBatch(t *testing.T) {
+	issuerKey := core.GeneratePrivateKey()
+	certifierKey := core.GeneratePrivateKey()
+	usersRequester, _ := createDummyBatchUsersRequesterFunctor(map[string]*users.UserObject{
+		genericIssuerId:    createUserObject(genericIssuerId, &issuerKey.PublicKey, true),
+		genericCertifierId: createUserObject(genericCertifierId, &certifierKey.PublicKey, true),
+	})
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequester, responseReporter, createDummyTicketGeneratorFunctor()) {
+		return
+	}
+
+	encrypted := generateBatchOperation(UsersRequest, []byte("PAYLOAD"), issuerKey, certifierKey)
+	encrypted.Encryption.Encrypted = true
+	invalidPayload := generateBatchOperation(UsersRequest, []byte("PAYLOAD"), issuerKey, certifierKey)
+	invalidPayload.Payload = "%INVALID_BASE64%"
+	ops := []core.Operation{
+		generateBatchOperation(UsersRequest, []byte("FIRST"), issuerKey, certifierKey),
+		encrypted,
+		invalidPayload,
+		generateBatchOperation(core.RequestType(99), []byte("PAYLOAD"), issuerKey, certifierKey),
+		generateBatchOperation(UsersRequest, []byte("LAST"), issuerKey, certifierKey),
+	}
+	tickets, err := SubmitBatch(context.Background(), ops)
+	if err != nil || len(tickets) != len(ops) {
+		t.Fatalf("Batch should be submitted despite invalid operations. tickets=%v, err=%v", tickets, err)
+	}
+	ShutdownServer()
+
+	// Every operation has its own ticket tracking its own outcome
+	seen := map[status.Ticket]bool{}
+	for _, ticketId := range tickets {
+		if len(ticketId) == 0 || seen[ticketId] {
+			t.Fatalf("Operations should get distinct tickets. tickets=%v", tickets)
+		}
+		seen[ticketId] = true
+	}
+	var expectedReasons []status.FailReasonCode = []status.FailReasonCode{
+		status.NoReason,
+		status.RejectedReason,
+		status.RejectedReason,
+		status.UnknownRequestTypeReason,
+		status.NoReason,
+	}
+	for index, ticketId := range tickets {
+		logs := reg.ticketLogs[ticketId]
+		last := logs[len(logs)-1]
+		if expectedReasons[index] == status.NoReason && last.status != status.SuccessStatus {
+			t.Errorf("Valid operation should succeed. index=%v, logs=%v", index, logs)
+		} else if expectedReasons[index] != status.NoReason && (last.status != status.FailedStatus || last.failureReason != expectedReasons[index]) {
+			t.Errorf("Invalid operation should fail on its own ticket. index=%v, logs=%v", index, logs)
+		}
+	}
+
+	// Batch fails if the server isn't running
+	if tickets, err := SubmitBatch(context.Background(), ops); err != serverNotRunningError || tickets != nil {
+		t.Errorf("Batch should fail while server is not running. tickets=%v, err=%v", tickets, err)
+	}
+}
+
+func TestSubmitBatchForgedSigners(t *testing.T) {
+	issuerKey := core.GeneratePrivateKey()
+	certifierKey := core.GeneratePrivateKey()
+	forgerKey := core.GeneratePrivateKey()
+	usersRequester, getRan := createDummyBatchUsersRequesterFunctor(map[string]*users.UserObject{
+		genericIssuerId:    createUserObject(genericIssuerId, &issuerKey.PublicKey, true),
+		genericCertifierId: createUserObject(genericCertifierId, &certifierKey.PublicKey, true),
+	})
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequester, responseReporter, createDummyTicketGeneratorFunctor()) {
+		return
+	}
+
+	// Claims the generic issuer and certifier ids, without their keys
+	forged := generateBatchOperation(UsersRequest, []byte("FORGED"), forgerKey, forgerKey)
+	unsigned := generateBatchOperation(UsersRequest, []byte("UNSIGNED"), issuerKey, certifierKey)
+	unsigned.Issue.Signature = ""
+	unsigned.Certification.Signature = ""
+	tickets, err := SubmitBatch(context.Background(), []core.Operation{forged, unsigned})
+	if err != nil || len(tickets) != 2 {
+		t.Fatalf("Batch should be submitted despite forged operations. tickets=%v, err=%v", tickets, err)
+	}
+	ShutdownServer()
+
+	for _, ticketId := range tickets {
+		logs := reg.ticketLogs[ticketId]
+		if len(logs) == 0 || logs[len(logs)-1].status != status.FailedStatus {
+			t.Errorf("Operation with forged signers should be rejected. logs=%v", logs)
+		}
+	}
+	if ran := getRan(); len(ran) != 0 {
+		t.Errorf("Operations with forged signers should not run. ran=%v", ran)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+	Execution of channel operations
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mngharbi/DMPC/channels"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/status"
+	"github.com/mngharbi/DMPC/users"
+	"time"
+)
+
+/*
+	Errors
+*/
+var (
+	channelPermissionError error = errors.New("Issuer is not allowed to add channels.")
+)
+
+/*
+	Error attached to tickets of requests that failed in the channels subsystem
+*/
+type ChannelsResponseError struct {
+	Result int
+}
+
+func (err *ChannelsResponseError) Error() string {
+	return fmt.Sprintf("Channels request failed with result code %v.", err.Result)
+}
+
+/*
+	Checks that the issuer is active and allowed to add channels as of the request timestamp
+	(permissions granted after the request timestamp are not taken into account)
+*/
+func checkChannelPermission(ctx context.Context, usersRequester users.Requester, signers *core.VerifiedSigners, at time.Time) error {
+	if signers == nil {
+		return channelPermissionError
+	}
+
+	// Make read request for issuer
+	request := &users.UserRequest{
+		Type:   users.ReadRequest,
+		Fields: []string{signers.IssuerId},
+	}
+	requestEncoded, _ := request.Encode()
+	userResponsePtr, ok, errs := usersRequester.RequestWithContext(ctx, nil, requestEncoded)
+	if ctx.Err() != nil {
+		return operationTimedOutError
+	}
+	if len(errs) != 0 || !ok || userResponsePtr == nil {
+		return signersRequestError
+	}
+	if userResponsePtr.Result != users.Success || len(userResponsePtr.Data) != 1 {
+		return issuerUnknownError
+	}
+
+	if !userResponsePtr.Data[0].Active {
+		return issuerInactiveError
+	}
+	channelPermissions := userResponsePtr.Data[0].Permissions.Channel
+	if !channelPermissions.Add || (!at.IsZero() && channelPermissions.AddUpdatedAt.After(at)) {
+		return channelPermissionError
+	}
+	return nil
+}
+
+func executeChannelsRequest(
+	ctx context.Context,
+	usersRequester users.Requester,
+	channelsRequester channels.Requester,
+	wrappedRequest *executorRequest,
+) executionResult {
+	// Check issuer permissions (unless unverified)
+	if wrappedRequest.isVerified {
+		var channelRequest channels.ChannelRequest
+		channelRequest.Decode(wrappedRequest.request)
+		if err := checkChannelPermission(ctx, usersRequester, wrappedRequest.signers, channelRequest.Timestamp); err != nil {
+			var failReason status.FailReasonCode = status.RejectedReason
+			switch err {
+			case operationTimedOutError:
+				failReason = status.TimedOutReason
+			case channelPermissionError:
+				failReason = status.PermissionDeniedReason
+			}
+			return executionResult{status: status.FailedStatus, failReason: failReason, errs: []error{err}, retryable: err == signersRequestError}
+		}
+	}
+
+	// Make the request to channels subsystem (unless the operation timed out first)
+	commitCtx, committing := wrappedRequest.beginCommit(ctx)
+	if !committing {
+		return executionResult{status: status.FailedStatus, failReason: status.TimedOutReason, errs: []error{operationTimedOutError}}
+	}
+	channel, errs := channelsRequester(wrappedRequest.request)
+	if errs != nil {
+		return executionResult{status: status.FailedStatus, failReason: status.RejectedReason, errs: errs, retryable: channels.IsUnavailable(errs)}
+	}
+
+	// Wait for response from channels subsystem
+	var channelResponsePtr *channels.ChannelResponse
+	var ok bool
+	select {
+	case channelResponsePtr, ok = <-channel:
+	case <-commitCtx.Done():
+		return executionResult{status: status.FailedStatus, failReason: status.TimedOutReason, errs: []error{operationTimedOutError}}
+	}
+	if !ok {
+		return executionResult{status: status.FailedStatus, failReason: status.RejectedReason, errs: []error{subsystemChannelClosed}, retryable: true}
+	}
+
+	// Handle failure after running the request
+	channelResponseEncoded, _ := channelResponsePtr.Encode()
+	if channelResponsePtr.Result != channels.Success {
+		return executionResult{
+			status:     status.FailedStatus,
+			failReason: status.FailedReason,
+			result:     channelResponseEncoded,
+			errs:       []error{&ChannelsResponseError{Result: channelResponsePtr.Result}},
+		}
+	}
+	return executionResult{status: status.SuccessStatus, failReason: status.NoReason, result: channelResponseEncoded}
+}
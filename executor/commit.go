@@ -0,0 +1,62 @@
+/*
+	Mutual exclusion of operation commits and timeouts
+*/
+
+package executor
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+/*
+	States of an operation run
+	(an operation either starts committing or times out, never both)
+*/
+const (
+	runRunning int32 = iota
+	runCommitting
+	runFinished
+	runTimedOut
+)
+
+type commitGuard struct {
+	state int32
+}
+
+/*
+	Starts committing the operation (false if it already timed out)
+*/
+func (guard *commitGuard) beginCommit() bool {
+	return atomic.CompareAndSwapInt32(&guard.state, runRunning, runCommitting)
+}
+
+/*
+	Times out the operation (false if it already started committing or finished)
+*/
+func (guard *commitGuard) timeOut() bool {
+	return atomic.CompareAndSwapInt32(&guard.state, runRunning, runTimedOut)
+}
+
+/*
+	Finishes the operation (false if it timed out, in which case its result is discarded)
+*/
+func (guard *commitGuard) finish() bool {
+	return atomic.CompareAndSwapInt32(&guard.state, runRunning, runFinished) ||
+		atomic.CompareAndSwapInt32(&guard.state, runCommitting, runFinished)
+}
+
+/*
+	Called by handlers right before handing the request to the subsystem applying it
+	(returns the context to wait for the subsystem with, which is never done once committing,
+	or false if the operation timed out and must not be committed)
+*/
+func (rq *executorRequest) beginCommit(ctx context.Context) (context.Context, bool) {
+	if rq.commit == nil {
+		return ctx, ctx.Err() == nil
+	}
+	if !rq.commit.beginCommit() {
+		return ctx, false
+	}
+	return context.Background(), true
+}
@@ -1,33 +1,191 @@
 package executor
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/mngharbi/DMPC/channels"
 	"github.com/mngharbi/DMPC/core"
 	"github.com/mngharbi/DMPC/status"
 	"github.com/mngharbi/DMPC/users"
 	"github.com/mngharbi/gofarm"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
 	Function to send in a decrypted request into the executor and returns a ticket
+	(requests with the same issuer and non empty idempotency key are only executed once,
+	requests with higher priority are executed first,
+	requests outside their validity window are rejected,
+	and dry run requests are validated without being applied)
 */
-type Requester func(bool, core.RequestType, *core.VerifiedSigners, []byte, *core.Operation) (status.Ticket, error)
+type Requester func(bool, core.RequestType, *core.VerifiedSigners, []byte, *core.Operation, RequestOptions) (status.Ticket, error)
+
+/*
+	Optional request settings (all disabled if zero)
+*/
+type RequestOptions struct {
+	IdempotencyKey string
+	Priority       int
+	Validity       core.ValidityWindow
+	DryRun         bool
+}
+
+/*
+	Builds request settings from operation meta fields
+*/
+func RequestOptionsFromMeta(meta *core.OperationMetaFields) RequestOptions {
+	return RequestOptions{
+		IdempotencyKey: meta.IdempotencyKey,
+		Priority:       meta.Priority,
+		Validity:       meta.ValidityWindow,
+		DryRun:         meta.DryRun,
+	}
+}
 
 /*
 	Errors
 */
 
-var invalidRequestTypeError error = errors.New("Invalid request type.")
+var invalidRequestTypeError error = errors.New("Request type has no registered handler.")
 var subsystemChannelClosed error = errors.New("Corresponding subsystem shutdown during the request.")
+var invalidNumWorkersError error = errors.New("Number of workers should be at least 1.")
+var serverNotRunningError error = errors.New("Executor server is not running.")
+var operationTimedOutError error = errors.New("Operation timed out.")
+var serverShuttingDownError error = errors.New("Executor server is shutting down.")
+var operationAbandonedError error = errors.New("Operation abandoned during shutdown.")
+var payloadTooLargeError error = errors.New("Operation payload exceeds maximum size.")
+var dryRunUnsupportedError error = errors.New("Dry runs are not supported for this request.")
+var usersUnavailableError error = errors.New("Request type requires the users subsystem, which is not available.")
+var userUpdatePermissionError error = errors.New("Issuer is not allowed to update some of the requested fields.")
+
+/*
+	Error returned when graceful shutdown times out
+*/
+type AbandonedOperationsError struct {
+	Abandoned int
+}
+
+func (err *AbandonedOperationsError) Error() string {
+	return fmt.Sprintf("Graceful shutdown timed out, %v operations abandoned.", err.Abandoned)
+}
+
+/*
+	Error returned when a request can't be queued without waiting
+*/
+type QueueFullError struct {
+	Capacity int
+}
+
+func (err *QueueFullError) Error() string {
+	return fmt.Sprintf("Executor queue is full (capacity %v).", err.Capacity)
+}
+
+/*
+	Error attached to tickets of requests that failed in the users subsystem
+*/
+type UsersResponseError struct {
+	Result int
+}
+
+func (err *UsersResponseError) Error() string {
+	return fmt.Sprintf("Users request failed with result code %v.", err.Result)
+}
 
 /*
 	Daemon configuration
 */
 
 type Config struct {
-	NumWorkers int
+	NumWorkers           int
+	IdempotencyCacheSize int
+	OperationTimeout     time.Duration
+
+	// Maximum number of requests waiting for a worker (unbounded if zero)
+	QueueCapacity int
+
+	// Maximum size of decoded operation payloads (no limit if zero)
+	MaxPayloadBytes int
+
+	// Receives operations ending in a failed status (runs in its own goroutine)
+	DeadLetterHandler DeadLetterHandler
+
+	// Re-enqueuing of operations failing transiently (never retried if zero)
+	RetryPolicy RetryPolicy
+
+	// Signer requirements by request type (none if missing)
+	RequestTypePolicies RequestTypePolicies
+
+	// Reassembled chunked uploads are sent to the dispatcher (chunked uploads disabled if nil)
+	UploadDispatcher UploadDispatcher
+	UploadTimeout    time.Duration
+
+	// Dependency checks used by health checks (skipped if nil)
+	UsersProbe  HealthProbe
+	StatusProbe HealthProbe
+
+	// Users requesters running requests without applying them (dry runs rejected if nil)
+	UsersDryRunRequester           users.Requester
+	UsersDryRunRequesterUnverified users.Requester
+
+	// Receives operations executed successfully (none logged if nil)
+	ReplayLog ReplayLog
+
+	// Key authenticating replay log entries (required with a replay log)
+	ReplayKey []byte
+
+	// Runs logged operations before starting workers to rebuild state
+	ReplayOnStart bool
+
+	// Fails operations whose queued or running status can't be reported
+	// (status reporting is best effort otherwise)
+	StrictStatusReporting bool
+
+	// Source of current time for validity windows and signing key expiry (system clock if nil)
+	Clock core.Clock
+}
+
+/*
+	Retry policy for operations failing with a retryable error
+	(backoff doubles after each failed attempt, and is capped by MaxBackoff if set)
+*/
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+/*
+	Returns backoff before the next attempt given number of failed attempts so far
+*/
+func (policy RetryPolicy) backoff(attempts int) time.Duration {
+	backoff := policy.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if policy.MaxBackoff > 0 && backoff >= policy.MaxBackoff {
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		return policy.MaxBackoff
+	}
+	return backoff
 }
 
+/*
+	Returns whether a failed attempt can be retried
+*/
+func (policy RetryPolicy) canRetry(attempts int) bool {
+	return attempts+1 < policy.MaxAttempts
+}
+
+/*
+	Function receiving failed operations for debugging and manual replay
+*/
+type DeadLetterHandler func(*core.Operation, status.FailReasonCode)
+
 /*
 	Logging
 */
@@ -49,7 +207,9 @@ func provisionServerOnce() {
 func InitializeServer(
 	usersRequester users.Requester,
 	usersRequesterUnverified users.Requester,
+	channelsRequester channels.Requester,
 	responseReporter status.Reporter,
+	retryReporter status.RetryReporter,
 	ticketGenerator status.TicketGenerator,
 	loggingHandler *core.LoggingHandler,
 	shutdownLambda core.ShutdownLambda,
@@ -57,7 +217,9 @@ func InitializeServer(
 	provisionServerOnce()
 	serverSingleton.usersRequester = usersRequester
 	serverSingleton.usersRequesterUnverified = usersRequesterUnverified
+	serverSingleton.channelsRequester = channelsRequester
 	serverSingleton.responseReporter = responseReporter
+	serverSingleton.retryReporter = retryReporter
 	serverSingleton.ticketGenerator = ticketGenerator
 	log = loggingHandler
 	shutdownProgram = shutdownLambda
@@ -66,56 +228,354 @@ func InitializeServer(
 
 func StartServer(conf Config) error {
 	provisionServerOnce()
-	return serverHandler.StartServer(gofarm.Config{NumWorkers: conf.NumWorkers})
+	serverLifecycleLock.Lock()
+	defer serverLifecycleLock.Unlock()
+	if conf.ReplayLog != nil && len(conf.ReplayKey) == 0 {
+		return replayKeyMissingError
+	}
+	serverSingleton.idempotencyCache = newIdempotencyCache(conf.IdempotencyCacheSize)
+	serverSingleton.requestQueue = newRequestQueue(conf.QueueCapacity)
+	serverSingleton.operationTimeout = conf.OperationTimeout
+	serverSingleton.maxPayloadBytes = conf.MaxPayloadBytes
+	serverSingleton.deadLetterHandler = conf.DeadLetterHandler
+	serverSingleton.retryPolicy = conf.RetryPolicy
+	serverSingleton.requestTypePolicies = conf.RequestTypePolicies
+	serverSingleton.uploads = newUploadAssembler(conf.UploadTimeout, conf.UploadDispatcher)
+	serverSingleton.usersProbe = conf.UsersProbe
+	serverSingleton.statusProbe = conf.StatusProbe
+	serverSingleton.usersDryRunRequester = conf.UsersDryRunRequester
+	serverSingleton.usersDryRunRequesterUnverified = conf.UsersDryRunRequesterUnverified
+	serverSingleton.replayLog = conf.ReplayLog
+	serverSingleton.replayKey = conf.ReplayKey
+	serverSingleton.replayLock = &sync.Mutex{}
+	serverSingleton.strictStatusReporting = conf.StrictStatusReporting
+	serverSingleton.clock = core.ClockOrSystem(conf.Clock)
+	serverSingleton.abandonContext, serverSingleton.abandonOperations = context.WithCancel(context.Background())
+	atomic.StoreInt32(&serverSingleton.abandonedOperations, 0)
+	atomic.StoreInt32(&serverSingleton.draining, 0)
+	serverSingleton.resetStats()
+	if conf.ReplayOnStart && conf.ReplayLog != nil {
+		if err := serverSingleton.replay(); err != nil {
+			return err
+		}
+	}
+	if err := serverHandler.StartServer(gofarm.Config{NumWorkers: conf.NumWorkers}); err != nil {
+		return err
+	}
+	serverSingleton.setNumWorkers(conf.NumWorkers)
+	serverRunning = true
+	return nil
 }
 
 func ShutdownServer() {
 	provisionServerOnce()
+	serverLifecycleLock.Lock()
+	defer serverLifecycleLock.Unlock()
+	serverHandler.ShutdownServer()
+	serverRunning = false
+}
+
+/*
+	Stops accepting requests and waits for workers to finish running operations
+	(queued operations are rejected, and running ones are abandoned after timeout)
+*/
+func StopServerGracefully(timeout time.Duration) error {
+	provisionServerOnce()
+
+	// Reject new and queued requests
+	atomic.StoreInt32(&serverSingleton.draining, 1)
+
+	serverLifecycleLock.Lock()
+	defer serverLifecycleLock.Unlock()
+	if !serverRunning {
+		return serverNotRunningError
+	}
+
+	// Wait for running operations until timeout
+	shutdownDone := make(chan bool)
+	go (func() {
+		serverHandler.ShutdownServer()
+		close(shutdownDone)
+	})()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-shutdownDone:
+	case <-timer.C:
+		serverSingleton.abandonOperations()
+		<-shutdownDone
+		err = &AbandonedOperationsError{
+			Abandoned: int(atomic.LoadInt32(&serverSingleton.abandonedOperations)),
+		}
+	}
+	serverRunning = false
+	return err
+}
+
+/*
+	Changes number of workers of a running server
+	(new requests wait while queued requests are drained by the old workers)
+*/
+func ResizeWorkers(n int) error {
+	if n < 1 {
+		return invalidNumWorkersError
+	}
+
+	provisionServerOnce()
+	serverLifecycleLock.Lock()
+	defer serverLifecycleLock.Unlock()
+	if !serverRunning {
+		return serverNotRunningError
+	}
+
+	log.Debugf(resizingWorkersLogMsg, n)
 	serverHandler.ShutdownServer()
+	if err := serverHandler.StartServer(gofarm.Config{NumWorkers: n}); err != nil {
+		serverRunning = false
+		return err
+	}
+	serverSingleton.setNumWorkers(n)
+	return nil
 }
 
-func (sv *server) reportRejection(ticketId status.Ticket, reason status.FailReasonCode, errs []error) {
-	sv.responseReporter(ticketId, status.FailedStatus, reason, nil, errs)
+/*
+	Reports status of a request (failures are logged, and only returned to let strict mode fail operations)
+*/
+func (sv *server) reportStatus(wrappedRequest *executorRequest, statusCode status.StatusCode, reason status.FailReasonCode, result []byte, errs []error) error {
+	err := sv.responseReporter(wrappedRequest.ticket, statusCode, reason, result, errs)
+	if err != nil {
+		wrappedRequest.logger().Warnf(statusReportFailedLogMsg, statusCode, err)
+	}
+	return err
+}
+
+/*
+	Reports running status (returns false if the operation should not run because strict mode is on)
+*/
+func (sv *server) reportRunning(wrappedRequest *executorRequest) bool {
+	err := sv.reportStatus(wrappedRequest, status.RunningStatus, status.NoReason, nil, nil)
+	if err != nil && sv.strictStatusReporting {
+		sv.reportRejection(wrappedRequest, status.RejectedReason, []error{err})
+		sv.stats.finishOperation(true)
+		return false
+	}
+	return true
+}
+
+func (sv *server) reportRejection(wrappedRequest *executorRequest, reason status.FailReasonCode, errs []error) {
+	sv.reportStatus(wrappedRequest, status.FailedStatus, reason, nil, errs)
+	sv.deadLetter(wrappedRequest, reason)
+}
+
+/*
+	Sends failed operation to dead letter handler if any (without blocking the caller)
+*/
+func (sv *server) deadLetter(wrappedRequest *executorRequest, reason status.FailReasonCode) {
+	if sv.deadLetterHandler == nil {
+		return
+	}
+	go sv.deadLetterHandler(wrappedRequest.operation(), reason)
 }
 
+/*
+	Queues request (waits for room if the queue is full)
+*/
 func MakeRequest(
 	isVerified bool,
 	requestType core.RequestType,
 	signers *core.VerifiedSigners,
 	request []byte,
 	failedOperation *core.Operation,
+	options RequestOptions,
 ) (status.Ticket, error) {
-	log.Debugf(receivedRequestLogMsg)
+	return submitRequest(true, isVerified, requestType, signers, request, failedOperation, options)
+}
 
-	// Check type
-	if !isValidRequestType(requestType) {
-		return "", invalidRequestTypeError
+/*
+	Queues request without waiting (fails with QueueFullError if the queue is full)
+*/
+func TrySubmit(
+	isVerified bool,
+	requestType core.RequestType,
+	signers *core.VerifiedSigners,
+	request []byte,
+	failedOperation *core.Operation,
+	options RequestOptions,
+) (status.Ticket, error) {
+	return submitRequest(false, isVerified, requestType, signers, request, failedOperation, options)
+}
+
+func submitRequest(
+	blocking bool,
+	isVerified bool,
+	requestType core.RequestType,
+	signers *core.VerifiedSigners,
+	request []byte,
+	failedOperation *core.Operation,
+	options RequestOptions,
+) (status.Ticket, error) {
+	// Execute directly if request can't be deduplicated
+	// (dry runs don't count as executions, and unverified issuers can't own idempotency keys)
+	cache := serverSingleton.idempotencyCache
+	if len(options.IdempotencyKey) == 0 || cache == nil || options.DryRun || !isVerified || signers == nil {
+		return makeRequest(blocking, isVerified, requestType, signers, request, failedOperation, options)
 	}
 
-	// Generate ticket
-	ticketId := serverSingleton.ticketGenerator()
-	err := serverSingleton.responseReporter(ticketId, status.QueuedStatus, status.NoReason, nil, nil)
-	if err != nil {
-		return ticketId, err
+	// Return original ticket if the request was already made
+	// (waits for the original request to be queued, and takes its place if it failed to be)
+	cacheKey := idempotencyCacheKey{
+		issuerId:       signers.IssuerId,
+		idempotencyKey: options.IdempotencyKey,
 	}
+	for {
+		cache.lock.Lock()
+		entry, ok := cache.get(cacheKey)
+		if !ok {
+			break
+		}
+		cache.lock.Unlock()
+		<-entry.queued
+		if !entry.failed {
+			requestLogger(entry.ticket).Debugf(duplicateRequestLogMsg)
+			return entry.ticket, nil
+		}
+	}
+
+	// Queue request without holding the cache lock
+	entry := cache.reserve(cacheKey)
+	cache.lock.Unlock()
+	ticketId, err := makeRequest(blocking, isVerified, requestType, signers, request, failedOperation, options)
+	cache.lock.Lock()
+	cache.complete(entry, ticketId, err)
+	cache.lock.Unlock()
+	return ticketId, err
+}
 
-	// Make request
-	_, err = serverHandler.MakeRequest(&executorRequest{
+func makeRequest(
+	blocking bool,
+	isVerified bool,
+	requestType core.RequestType,
+	signers *core.VerifiedSigners,
+	request []byte,
+	failedOperation *core.Operation,
+	options RequestOptions,
+) (status.Ticket, error) {
+	// Generate ticket
+	ticketId := serverSingleton.ticketGenerator()
+	wrappedRequest := &executorRequest{
 		isVerified:      isVerified,
 		requestType:     requestType,
 		signers:         signers,
 		ticket:          ticketId,
 		request:         request,
 		failedOperation: failedOperation,
-	})
-	if err != nil {
-		serverSingleton.reportRejection(ticketId, status.RejectedReason, []error{err})
+		priority:        options.Priority,
+		validity:        options.Validity,
+		dryRun:          options.DryRun,
+		log:             requestLogger(ticketId),
+	}
+	wrappedRequest.logger().Debugf(receivedRequestLogMsg)
+
+	// Queued status is required in strict mode
+	err := serverSingleton.reportStatus(wrappedRequest, status.QueuedStatus, status.NoReason, nil, nil)
+	if err != nil && serverSingleton.strictStatusReporting {
+		return ticketId, err
+	}
+
+	// Reject request if shutting down
+	if serverSingleton.isDraining() {
+		serverSingleton.reportRejection(wrappedRequest, status.RejectedReason, []error{serverShuttingDownError})
+		return ticketId, serverShuttingDownError
+	}
+
+	// Reject request if payload is too large
+	if serverSingleton.isPayloadTooLarge(request, failedOperation) {
+		wrappedRequest.logger().Debugf(payloadTooLargeLogMsg)
+		serverSingleton.reportRejection(wrappedRequest, status.PayloadTooLargeReason, []error{payloadTooLargeError})
+		return ticketId, payloadTooLargeError
+	}
+
+	// Reject request if its type has no handler
+	if !isValidRequestType(requestType) {
+		wrappedRequest.logger().Debugf(unknownRequestTypeLogMsg)
+		serverSingleton.reportRejection(wrappedRequest, status.UnknownRequestTypeReason, []error{invalidRequestTypeError})
+		return ticketId, invalidRequestTypeError
+	}
+
+	// Reject request if it needs users while running without them
+	if serverSingleton.isUsersUnavailable(requestType) {
+		wrappedRequest.logger().Debugf(usersUnavailableLogMsg)
+		serverSingleton.reportRejection(wrappedRequest, status.UsersUnavailableReason, []error{usersUnavailableError})
+		return ticketId, usersUnavailableError
+	}
+
+	if err = enqueueRequest(wrappedRequest, blocking); err != nil {
+		serverSingleton.reportRejection(wrappedRequest, status.RejectedReason, []error{err})
 		return ticketId, err
 	}
 
 	return ticketId, nil
 }
 
+/*
+	Determines if an operation payload exceeds the configured maximum
+	(checked on the encoded operation so that it can be done before decryption)
+*/
+func PayloadTooLarge(operation *core.Operation) bool {
+	return serverSingleton.isPayloadTooLarge(nil, operation)
+}
+
+/*
+	Maximum size of decoded operation payloads (no limit if zero)
+*/
+func MaxPayloadBytes() int {
+	return serverSingleton.maxPayloadBytes
+}
+
+func (sv *server) isPayloadTooLarge(request []byte, failedOperation *core.Operation) bool {
+	if sv.maxPayloadBytes <= 0 {
+		return false
+	}
+	if len(request) > sv.maxPayloadBytes {
+		return true
+	}
+	return failedOperation != nil && failedOperation.PayloadSizeBytes() > sv.maxPayloadBytes
+}
+
+/*
+	Logging handler adding the ticket of an operation to every line
+*/
+func requestLogger(ticketId status.Ticket) *core.LoggingHandler {
+	return log.WithField(ticketLogField, ticketId)
+}
+
+/*
+	Queues request and notifies workers (waits for any resizing to complete,
+	and for room in the queue only if blocking)
+*/
+func enqueueRequest(wrappedRequest *executorRequest, blocking bool) error {
+	serverLifecycleLock.RLock()
+	defer serverLifecycleLock.RUnlock()
+	queue := serverSingleton.requestQueue
+	var queueItem *requestQueueItem
+	if blocking {
+		queueItem = queue.push(wrappedRequest)
+	} else {
+		var ok bool
+		if queueItem, ok = queue.tryPush(wrappedRequest); !ok {
+			wrappedRequest.logger().Debugf(queueFullLogMsg)
+			return &QueueFullError{Capacity: queue.capacity}
+		}
+	}
+	_, err := serverHandler.MakeRequest(wrappedRequest)
+	if err != nil {
+		serverSingleton.requestQueue.remove(queueItem)
+	}
+	return err
+}
+
 /*
 	Server implementation
 */
@@ -123,14 +583,80 @@ func MakeRequest(
 var (
 	serverSingleton server
 	serverHandler   *gofarm.ServerHandler
+
+	// Guards server start/shutdown/resizing against requests being made
+	serverLifecycleLock *sync.RWMutex = &sync.RWMutex{}
+	serverRunning       bool
 )
 
 type server struct {
 	// Requester lambdas
 	usersRequester           users.Requester
 	usersRequesterUnverified users.Requester
+	channelsRequester        channels.Requester
 	responseReporter         status.Reporter
+	retryReporter            status.RetryReporter
 	ticketGenerator          status.TicketGenerator
+
+	// Requester lambdas for dry runs
+	usersDryRunRequester           users.Requester
+	usersDryRunRequesterUnverified users.Requester
+
+	// Requests waiting for a worker
+	requestQueue *requestQueue
+
+	// Tickets generated for idempotency keys
+	idempotencyCache *idempotencyCache
+
+	// Maximum duration of an operation (no limit if zero)
+	operationTimeout time.Duration
+
+	// Maximum size of decoded payloads (no limit if zero)
+	maxPayloadBytes int
+
+	// Receives failed operations (none if nil)
+	deadLetterHandler DeadLetterHandler
+
+	// Re-enqueuing of transient failures
+	retryPolicy RetryPolicy
+
+	// Signer requirements by request type
+	requestTypePolicies RequestTypePolicies
+
+	// Chunked uploads being reassembled
+	uploads *uploadAssembler
+
+	// Dependency checks
+	usersProbe  HealthProbe
+	statusProbe HealthProbe
+
+	// Log of executed operations (none if nil)
+	replayLog  ReplayLog
+	replayKey  []byte
+	replayLock *sync.Mutex
+
+	// Operations fail if their status can't be reported
+	strictStatusReporting bool
+
+	// Source of current time
+	clock core.Clock
+
+	// Graceful shutdown state
+	draining            int32
+	abandonContext      context.Context
+	abandonOperations   context.CancelFunc
+	abandonedOperations int32
+
+	// Metrics
+	stats serverCounters
+}
+
+func (sv *server) now() time.Time {
+	return core.ClockOrSystem(sv.clock).Now()
+}
+
+func (sv *server) isDraining() bool {
+	return atomic.LoadInt32(&sv.draining) == 1
 }
 
 func (sv *server) Start(_ gofarm.Config, _ bool) error {
@@ -143,46 +669,222 @@ func (sv *server) Shutdown() error {
 	return nil
 }
 
-func (sv *server) Work(nativeRequest *gofarm.Request) (dummyResponsePtr *gofarm.Response) {
-	log.Debugf(runningRequestLogMsg)
-	dummyResponsePtr = nil
-
-	wrappedRequest := (*nativeRequest).(*executorRequest)
+/*
+	Result of running a request
+	(failures are retryable if the subsystems could not be reached or closed the response channel,
+	and permanent if the request itself was refused)
+*/
+type executionResult struct {
+	status     status.StatusCode
+	failReason status.FailReasonCode
+	result     []byte
+	errs       []error
+	retryable  bool
+}
 
-	switch wrappedRequest.requestType {
-	case core.UsersRequestType:
-		sv.responseReporter(wrappedRequest.ticket, status.RunningStatus, status.NoReason, nil, nil)
+/*
+	Re-enqueues request after its backoff if it has attempts left
+	(returns false if the failure should be reported instead)
+*/
+func (sv *server) retry(wrappedRequest *executorRequest, errs []error) bool {
+	if !sv.retryPolicy.canRetry(wrappedRequest.attempts) || sv.isDraining() {
+		return false
+	}
+	wrappedRequest.attempts++
+	wrappedRequest.logger().Debugf(retryingRequestLogMsg, wrappedRequest.attempts)
+	if sv.retryReporter != nil {
+		sv.retryReporter(wrappedRequest.ticket, wrappedRequest.attempts, errs)
+	}
 
-		// Determine lambda to use based on whether the request is verified or not
-		var usersRequester users.Requester
-		if wrappedRequest.isVerified {
-			usersRequester = sv.usersRequester
-		} else {
-			usersRequester = sv.usersRequesterUnverified
+	time.AfterFunc(sv.retryPolicy.backoff(wrappedRequest.attempts), func() {
+		if sv.isDraining() {
+			sv.reportRejection(wrappedRequest, status.RejectedReason, []error{serverShuttingDownError})
+			return
 		}
+		if err := enqueueRequest(wrappedRequest, true); err != nil {
+			sv.reportRejection(wrappedRequest, status.RejectedReason, []error{err})
+		}
+	})
+	return true
+}
 
-		// Make the request to users subsystem
-		channel, errs := usersRequester(wrappedRequest.signers, wrappedRequest.request)
-		if errs != nil {
-			sv.reportRejection(wrappedRequest.ticket, status.RejectedReason, errs)
-			return
+/*
+	Returns context bounded by the operation timeout if any
+	(also cancelled if operations are abandoned during shutdown)
+*/
+func (sv *server) operationContext() (context.Context, context.CancelFunc) {
+	if sv.operationTimeout > 0 {
+		return context.WithTimeout(sv.abandonContext, sv.operationTimeout)
+	}
+	return context.WithCancel(sv.abandonContext)
+}
+
+/*
+	Runs execution and reports its result
+	(execution is abandoned and the ticket fails if the operation times out before it starts committing,
+	otherwise the commit result is waited for and reported)
+*/
+func (sv *server) runWithTimeout(wrappedRequest *executorRequest, execute func(context.Context) executionResult) {
+	ctx, cancel := sv.operationContext()
+	defer cancel()
+
+	guard := &commitGuard{}
+	wrappedRequest.commit = guard
+	resultChannel := make(chan executionResult, 1)
+	go (func() {
+		res := execute(ctx)
+		if guard.finish() && res.status == status.SuccessStatus {
+			sv.appendToReplayLog(wrappedRequest)
 		}
+		resultChannel <- res
+	})()
 
-		// Wait for response from users subsystem
-		userResponsePtr, ok := <-channel
-		if !ok {
-			sv.reportRejection(wrappedRequest.ticket, status.RejectedReason, []error{subsystemChannelClosed})
+	select {
+	case res := <-resultChannel:
+		sv.reportResult(wrappedRequest, res)
+		return
+	case <-ctx.Done():
+		if !guard.timeOut() {
+			sv.reportResult(wrappedRequest, <-resultChannel)
 			return
 		}
-
-		// Handle failure after running the request
-		userReponseEncoded, _ := userResponsePtr.Encode()
-		if userResponsePtr.Result != users.Success {
-			sv.responseReporter(wrappedRequest.ticket, status.FailedStatus, status.FailedReason, userReponseEncoded, nil)
+		if sv.abandonContext.Err() != nil {
+			wrappedRequest.logger().Debugf(abandonedRequestLogMsg)
+			atomic.AddInt32(&sv.abandonedOperations, 1)
+			sv.reportRejection(wrappedRequest, status.RejectedReason, []error{operationAbandonedError})
 		} else {
-			sv.responseReporter(wrappedRequest.ticket, status.SuccessStatus, status.NoReason, userReponseEncoded, nil)
+			wrappedRequest.logger().Debugf(timedOutRequestLogMsg)
+			sv.reportRejection(wrappedRequest, status.TimedOutReason, []error{operationTimedOutError})
 		}
 	}
+	sv.stats.finishOperation(true)
+}
+
+/*
+	Reports the result of an execution (retrying it instead if possible)
+*/
+func (sv *server) reportResult(wrappedRequest *executorRequest, res executionResult) {
+	if res.status == status.FailedStatus && res.retryable && sv.retry(wrappedRequest, res.errs) {
+		sv.stats.finishOperation(true)
+		return
+	}
+	sv.reportStatus(wrappedRequest, res.status, res.failReason, res.result, res.errs)
+	if res.status == status.FailedStatus {
+		sv.deadLetter(wrappedRequest, res.failReason)
+	}
+	sv.stats.finishOperation(res.status == status.FailedStatus)
+}
+
+/*
+	Users requester running a request without applying it (nil if dry runs aren't supported for the request)
+*/
+func (sv *server) dryRunUsersRequester(wrappedRequest *executorRequest) users.Requester {
+	if wrappedRequest.requestType != core.UsersRequestType {
+		return nil
+	}
+	if wrappedRequest.isVerified {
+		return sv.usersDryRunRequester
+	}
+	return sv.usersDryRunRequesterUnverified
+}
+
+func executeUsersRequest(ctx context.Context, usersRequester users.Requester, wrappedRequest *executorRequest) executionResult {
+	// Make the request to users subsystem and wait for response (unless the operation timed out first)
+	commitCtx, committing := wrappedRequest.beginCommit(ctx)
+	if !committing {
+		return executionResult{status: status.FailedStatus, failReason: status.TimedOutReason, errs: []error{operationTimedOutError}}
+	}
+	userResponsePtr, ok, errs := usersRequester.RequestWithContext(commitCtx, wrappedRequest.signers, wrappedRequest.request)
+	if commitCtx.Err() != nil {
+		return executionResult{status: status.FailedStatus, failReason: status.TimedOutReason, errs: []error{operationTimedOutError}}
+	}
+	if len(errs) != 0 {
+		return executionResult{status: status.FailedStatus, failReason: status.RejectedReason, errs: errs, retryable: users.IsUnavailable(errs)}
+	}
+	if !ok {
+		return executionResult{status: status.FailedStatus, failReason: status.RejectedReason, errs: []error{subsystemChannelClosed}, retryable: true}
+	}
+
+	// Reject requests from inactive issuers
+	userReponseEncoded, _ := userResponsePtr.Encode()
+	if userResponsePtr.Result == users.IssuerInactiveError {
+		return executionResult{status: status.FailedStatus, failReason: status.RejectedReason, errs: []error{issuerInactiveError}}
+	}
+
+	// Deny updates of fields the issuer isn't allowed to update
+	if userResponsePtr.Result == users.IssuerPermissionsError {
+		return executionResult{status: status.FailedStatus, failReason: status.PermissionDeniedReason, errs: []error{userUpdatePermissionError}}
+	}
+
+	// Handle failure after running the request
+	if userResponsePtr.Result != users.Success {
+		return executionResult{
+			status:     status.FailedStatus,
+			failReason: status.FailedReason,
+			result:     userReponseEncoded,
+			errs:       []error{&UsersResponseError{Result: userResponsePtr.Result}},
+		}
+	}
+	return executionResult{status: status.SuccessStatus, failReason: status.NoReason, result: userReponseEncoded}
+}
+
+func (sv *server) Work(nativeRequest *gofarm.Request) (dummyResponsePtr *gofarm.Response) {
+	dummyResponsePtr = nil
+
+	// Run highest priority request queued (not necessarily the one that triggered this call)
+	wrappedRequest := sv.requestQueue.pop()
+	if wrappedRequest == nil {
+		return
+	}
+	wrappedRequest.logger().Debugf(runningRequestLogMsg)
+	sv.stats.startOperation()
+
+	// Reject queued requests if shutting down
+	if sv.isDraining() {
+		sv.reportRejection(wrappedRequest, status.RejectedReason, []error{serverShuttingDownError})
+		sv.stats.finishOperation(true)
+		return
+	}
+
+	// Reject requests outside their validity window
+	if err := wrappedRequest.validity.Check(sv.now()); err != nil {
+		wrappedRequest.logger().Debugf(outsideValidityWindowLogMsg)
+		sv.reportRejection(wrappedRequest, status.OutsideValidityWindowReason, []error{err})
+		sv.stats.finishOperation(true)
+		return
+	}
+
+	// Reject requests whose signers don't satisfy the policy of their type
+	if err := sv.requestTypePolicies.checkSigners(wrappedRequest.requestType, wrappedRequest.signers); err != nil {
+		wrappedRequest.logger().Debugf(signersPolicyLogMsg)
+		sv.reportRejection(wrappedRequest, status.PermissionDeniedReason, []error{err})
+		sv.stats.finishOperation(true)
+		return
+	}
+
+	// Reject dry runs that can't be run without being applied
+	if wrappedRequest.dryRun && sv.dryRunUsersRequester(wrappedRequest) == nil {
+		wrappedRequest.logger().Debugf(dryRunUnsupportedLogMsg)
+		sv.reportRejection(wrappedRequest, status.RejectedReason, []error{dryRunUnsupportedError})
+		sv.stats.finishOperation(true)
+		return
+	}
+
+	// Reject requests whose type has no handler
+	handler, ok := lookupRequestHandler(wrappedRequest.requestType)
+	if !ok {
+		wrappedRequest.logger().Debugf(unknownRequestTypeLogMsg)
+		sv.reportRejection(wrappedRequest, status.UnknownRequestTypeReason, []error{invalidRequestTypeError})
+		sv.stats.finishOperation(true)
+		return
+	}
+
+	if !sv.reportRunning(wrappedRequest) {
+		return
+	}
+	sv.runWithTimeout(wrappedRequest, func(ctx context.Context) executionResult {
+		return handler(ctx, sv, wrappedRequest)
+	})
 
 	return
 }
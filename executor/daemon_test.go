@@ -1,13 +1,18 @@
 package executor
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/mngharbi/DMPC/channels"
 	"github.com/mngharbi/DMPC/core"
 	"github.com/mngharbi/DMPC/status"
 	"github.com/mngharbi/DMPC/users"
 	"math/rand"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -124,18 +129,116 @@ func TestStartShutdownServer(t *testing.T) {
 func TestInvalidRequestType(t *testing.T) {
 	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
 	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
-	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
 	ticketGenerator := createDummyTicketGeneratorFunctor()
 	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
 		return
 	}
 
-	_, err := MakeRequest(false, UsersRequest-1, generateGenericSigners(), []byte{}, nil)
-	if err != invalidRequestTypeError {
-		t.Error("Request with invalid type should be rejected.")
+	for _, requestType := range []core.RequestType{UsersRequest - 1, core.RequestType(100)} {
+		ticketId, err := MakeRequest(false, requestType, generateGenericSigners(), []byte{}, nil, RequestOptions{})
+		if err != invalidRequestTypeError {
+			t.Errorf("Request with unregistered type should be rejected. requestType=%v, err=%v", requestType, err)
+		}
+		reg.lock.Lock()
+		logs := reg.ticketLogs[ticketId]
+		reg.lock.Unlock()
+		if len(logs) != 2 || logs[1].status != status.FailedStatus || logs[1].failureReason != status.UnknownRequestTypeReason {
+			t.Errorf("Request with unregistered type should fail with unknown type reason. logs=%v", logs)
+		}
+	}
+
+	ShutdownServer()
+}
+
+func TestRegisterRequestHandler(t *testing.T) {
+	const customRequestType core.RequestType = 100
+	defer (func() {
+		requestHandlersLock.Lock()
+		delete(requestHandlers, customRequestType)
+		requestHandlersLock.Unlock()
+	})()
+
+	// Built in types and nil handlers can't be registered
+	handler := func(ctx context.Context, signers *core.VerifiedSigners, request []byte) ([]byte, error) {
+		return append([]byte(signers.IssuerId+":"), request...), nil
+	}
+	if err := RegisterRequestHandler(UsersRequest, handler); err != requestTypeAlreadyRegisteredError {
+		t.Errorf("Built in request type should not be replaced. err=%v", err)
+	}
+	if err := RegisterRequestHandler(customRequestType, nil); err != requestHandlerMissingError {
+		t.Errorf("Nil handler should not be registered. err=%v", err)
+	}
+	if err := RegisterRequestHandler(customRequestType, handler); err != nil {
+		t.Fatalf("Custom request type should be registered. err=%v", err)
 	}
 
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 1}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+	ticketId, err := MakeRequest(true, customRequestType, generateGenericSigners(), []byte("PAYLOAD"), nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request with registered type should be queued. err=%v", err)
+	}
 	ShutdownServer()
+
+	logs := reg.ticketLogs[ticketId]
+	if len(logs) != 3 || logs[2].status != status.SuccessStatus || string(logs[2].result) != genericIssuerId+":PAYLOAD" {
+		t.Errorf("Registered handler should run request. logs=%v", logs)
+	}
+}
+
+func TestNoopUsersRequester(t *testing.T) {
+	const customRequestType core.RequestType = 101
+	defer (func() {
+		requestHandlersLock.Lock()
+		delete(requestHandlers, customRequestType)
+		requestHandlersLock.Unlock()
+	})()
+	RegisterRequestHandler(customRequestType, func(context.Context, *core.VerifiedSigners, []byte) ([]byte, error) {
+		return []byte("RESULT"), nil
+	})
+
+	for _, usersRequester := range []users.Requester{users.NoopRequester, nil} {
+		responseReporter, reg := createDummyResposeReporterFunctor(true)
+		if !resetAndStartServer(t, Config{NumWorkers: 1}, usersRequester, usersRequester, responseReporter, createDummyTicketGeneratorFunctor()) {
+			return
+		}
+
+		// Request types resolving users are rejected
+		rejected := []core.RequestType{UsersRequest, core.ChannelsRequestType}
+		rejectedTickets := []status.Ticket{}
+		for _, requestType := range rejected {
+			ticketId, err := MakeRequest(true, requestType, generateGenericSigners(), []byte("PAYLOAD"), nil, RequestOptions{})
+			if err != usersUnavailableError {
+				t.Errorf("Request needing users should be rejected. requestType=%v, err=%v", requestType, err)
+			}
+			rejectedTickets = append(rejectedTickets, ticketId)
+		}
+
+		// Other request types still run
+		ticketId, err := MakeRequest(true, customRequestType, generateGenericSigners(), []byte("PAYLOAD"), nil, RequestOptions{})
+		if err != nil {
+			t.Errorf("Request not needing users should be queued. err=%v", err)
+		}
+		ShutdownServer()
+
+		var expectedReason status.FailReasonCode = status.UsersUnavailableReason
+		for _, rejectedTicket := range rejectedTickets {
+			logs := reg.ticketLogs[rejectedTicket]
+			if len(logs) != 2 || logs[1].status != status.FailedStatus || logs[1].failureReason != expectedReason {
+				t.Errorf("Rejection should report users are unavailable. logs=%v", logs)
+			}
+		}
+		logs := reg.ticketLogs[ticketId]
+		if len(logs) != 3 || logs[2].status != status.SuccessStatus || string(logs[2].result) != "RESULT" {
+			t.Errorf("Request not needing users should succeed. logs=%v", logs)
+		}
+	}
 }
 
 func TestReponseReporterQueueError(t *testing.T) {
@@ -143,11 +246,13 @@ func TestReponseReporterQueueError(t *testing.T) {
 	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
 	responseReporter, reg := createDummyResposeReporterFunctor(false)
 	ticketGenerator := createDummyTicketGeneratorFunctor()
-	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+	conf := multipleWorkersConfig()
+	conf.StrictStatusReporting = true
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
 		return
 	}
 
-	ticketId, err := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte{}, nil)
+	ticketId, err := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte{}, nil, RequestOptions{})
 	if err != responseReporterError {
 		t.Error("Request should fail with response reporter error while queueing.")
 	}
@@ -159,6 +264,70 @@ func TestReponseReporterQueueError(t *testing.T) {
 	ShutdownServer()
 }
 
+/*
+	Reporter failing for one status only
+*/
+func createFailingStatusResposeReporterFunctor(failingStatus status.StatusCode) (status.Reporter, *dummyStatusRegistry) {
+	reporter, reg := createDummyResposeReporterFunctor(true)
+	return func(ticketId status.Ticket, statusCode status.StatusCode, failureReason status.FailReasonCode, result []byte, errs []error) error {
+		if statusCode == failingStatus {
+			return responseReporterError
+		}
+		return reporter(ticketId, statusCode, failureReason, result, errs)
+	}, reg
+}
+
+func TestResponseReporterBestEffort(t *testing.T) {
+	for _, failingStatus := range []status.StatusCode{status.QueuedStatus, status.RunningStatus} {
+		for _, strict := range []bool{false, true} {
+			usersRequester, calls := createDummyUsersRequesterFunctor(users.Success, nil, false)
+			usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+			responseReporter, reg := createFailingStatusResposeReporterFunctor(failingStatus)
+			ticketGenerator := createDummyTicketGeneratorFunctor()
+			if !resetAndStartServer(t, Config{NumWorkers: 1, StrictStatusReporting: strict}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+				return
+			}
+
+			ticketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("PAYLOAD"), nil, RequestOptions{})
+			if strict && failingStatus == status.QueuedStatus {
+				if err != responseReporterError {
+					t.Errorf("Strict mode should fail requests that can't be queued. err=%v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Request should be queued. failingStatus=%v, strict=%v, err=%v", failingStatus, strict, err)
+			}
+
+			// Operation only runs in lenient mode
+			executed := false
+			select {
+			case <-calls:
+				executed = true
+			case <-time.After(100 * time.Millisecond):
+			}
+			ShutdownServer()
+			if executed == strict {
+				t.Errorf("Operation should run unless strict. failingStatus=%v, strict=%v, executed=%v", failingStatus, strict, executed)
+			}
+
+			// Final status is reported when the operation runs or fails while running
+			logs := reg.ticketLogs[ticketId]
+			if strict && failingStatus == status.QueuedStatus {
+				if len(logs) != 0 {
+					t.Errorf("Ticket should not have statuses. logs=%v", logs)
+				}
+				continue
+			}
+			var expectedStatus status.StatusCode = status.SuccessStatus
+			if strict {
+				expectedStatus = status.FailedStatus
+			}
+			if len(logs) == 0 || logs[len(logs)-1].status != expectedStatus {
+				t.Errorf("Final status mismatch. failingStatus=%v, strict=%v, logs=%v", failingStatus, strict, logs)
+			}
+		}
+	}
+}
+
 func TestRequestWhileNotRunning(t *testing.T) {
 	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
 	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
@@ -170,7 +339,7 @@ func TestRequestWhileNotRunning(t *testing.T) {
 
 	ShutdownServer()
 
-	ticketId, err := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte{}, nil)
+	ticketId, err := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte{}, nil, RequestOptions{})
 	if err == nil {
 		t.Error("Request should fail if made while server is down.")
 	}
@@ -202,7 +371,7 @@ func doUserRequestTesting(t *testing.T, isVerified bool) {
 		return
 	}
 
-	ticketId, err := MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil)
+	ticketId, err := MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil, RequestOptions{})
 	if err != nil {
 		t.Error("Request should not fail.")
 		return
@@ -229,7 +398,7 @@ func doUserRequestTesting(t *testing.T, isVerified bool) {
 	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterVerified, responseReporter, ticketGenerator) {
 		return
 	}
-	ticketId, err = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil)
+	ticketId, err = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil, RequestOptions{})
 	if err != nil {
 		t.Error("Request should not fail.")
 		return
@@ -256,7 +425,7 @@ func doUserRequestTesting(t *testing.T, isVerified bool) {
 	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterVerified, responseReporter, ticketGenerator) {
 		return
 	}
-	ticketId, err = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil)
+	ticketId, err = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil, RequestOptions{})
 	if err != nil {
 		t.Error("Request should not fail.")
 		return
@@ -269,7 +438,7 @@ func doUserRequestTesting(t *testing.T, isVerified bool) {
 		reg.ticketLogs[ticketId][1].status != status.RunningStatus ||
 		reg.ticketLogs[ticketId][2].status != status.FailedStatus ||
 		reg.ticketLogs[ticketId][2].failureReason != status.FailedReason ||
-		reg.ticketLogs[ticketId][2].errors != nil {
+		!reflect.DeepEqual(reg.ticketLogs[ticketId][2].errors, []error{&UsersResponseError{Result: 1 + users.Success}}) {
 		t.Error("Request should run but fail, and statuses should be reported correctly when the request failed.")
 	}
 
@@ -283,7 +452,7 @@ func doUserRequestTesting(t *testing.T, isVerified bool) {
 	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterVerified, responseReporter, ticketGenerator) {
 		return
 	}
-	ticketId, err = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil)
+	ticketId, err = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), []byte{}, nil, RequestOptions{})
 	if err != nil {
 		t.Error("Request should not fail.")
 		return
@@ -318,7 +487,7 @@ func doUserRequestTesting(t *testing.T, isVerified bool) {
 		go (func() {
 			waitForRandomDuration()
 			payload := []byte(strconv.Itoa(copyI))
-			_, _ = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), payload, nil)
+			_, _ = MakeRequest(isVerified, UsersRequest, generateGenericSigners(), payload, nil, RequestOptions{})
 			wg.Done()
 		})()
 	}
@@ -350,3 +519,1335 @@ func TestUnverifiedUserRequest(t *testing.T) {
 func TestVerifiedUserRequest(t *testing.T) {
 	doUserRequestTesting(t, true)
 }
+
+/*
+	Idempotency tests
+*/
+
+func TestDuplicateIdempotencyKey(t *testing.T) {
+	usersRequester, callsChannel := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	firstTicketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("1"), nil, RequestOptions{IdempotencyKey: "KEY"})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return
+	}
+	secondTicketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("2"), nil, RequestOptions{IdempotencyKey: "KEY"})
+	if err != nil || secondTicketId != firstTicketId {
+		t.Errorf("Duplicate request should return original ticket. ticket=%v, expected=%v, err=%v", secondTicketId, firstTicketId, err)
+	}
+
+	ShutdownServer()
+
+	if callLog := <-callsChannel; string(callLog.request) != "1" {
+		t.Errorf("Original request should be executed. request=%v", string(callLog.request))
+	}
+	select {
+	case callLog := <-callsChannel:
+		t.Errorf("Duplicate request should not be executed. request=%v", string(callLog.request))
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(reg.ticketLogs) != 1 || len(reg.ticketLogs[firstTicketId]) != 3 {
+		t.Errorf("Only one ticket should be reported. tickets=%v", reg.ticketLogs)
+	}
+}
+
+func TestUnverifiedIdempotencyKey(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Unverified requests claiming an issuer don't take its idempotency keys
+	unverifiedTicketId, _ := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("1"), nil, RequestOptions{IdempotencyKey: "KEY"})
+	repeatedTicketId, _ := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("2"), nil, RequestOptions{IdempotencyKey: "KEY"})
+	verifiedTicketId, _ := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("3"), nil, RequestOptions{IdempotencyKey: "KEY"})
+
+	ShutdownServer()
+
+	if unverifiedTicketId == repeatedTicketId || unverifiedTicketId == verifiedTicketId || repeatedTicketId == verifiedTicketId {
+		t.Errorf("Unverified requests should not be deduplicated. tickets=%v, %v, %v", unverifiedTicketId, repeatedTicketId, verifiedTicketId)
+	}
+}
+
+func TestDistinctIdempotencyKeys(t *testing.T) {
+	usersRequester, callsChannel := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Different keys from same issuer, and same key from different issuers
+	requests := []struct {
+		signers        *core.VerifiedSigners
+		idempotencyKey string
+	}{
+		{generateGenericSigners(), "KEY_1"},
+		{generateGenericSigners(), "KEY_2"},
+		{generateSigners("OTHER_ISSUER_ID", genericCertifierId), "KEY_1"},
+	}
+	tickets := map[status.Ticket]bool{}
+	for i, request := range requests {
+		ticketId, err := MakeRequest(true, UsersRequest, request.signers, []byte(strconv.Itoa(i)), nil, RequestOptions{IdempotencyKey: request.idempotencyKey})
+		if err != nil {
+			t.Errorf("Request should not fail. err=%v", err)
+			return
+		}
+		tickets[ticketId] = true
+	}
+
+	ShutdownServer()
+
+	if len(tickets) != len(requests) {
+		t.Errorf("Requests with distinct keys should get distinct tickets. tickets=%v", tickets)
+	}
+	for range requests {
+		<-callsChannel
+	}
+	for ticketId := range tickets {
+		if len(reg.ticketLogs[ticketId]) != 3 ||
+			reg.ticketLogs[ticketId][2].status != status.SuccessStatus {
+			t.Errorf("Requests with distinct keys should execute independently. ticket=%v", ticketId)
+		}
+	}
+}
+
+/*
+	Worker pool tests
+*/
+
+func TestResizeWorkersInvalid(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	if err := ResizeWorkers(0); err != invalidNumWorkersError {
+		t.Errorf("Resizing to no workers should fail with invalidNumWorkersError. err=%v", err)
+	}
+
+	ShutdownServer()
+
+	if err := ResizeWorkers(2); err != serverNotRunningError {
+		t.Errorf("Resizing while server is down should fail with serverNotRunningError. err=%v", err)
+	}
+}
+
+func TestResizeWorkersUnderLoad(t *testing.T) {
+	usersRequester, callsChannel := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 2}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Make requests while resizing to 8 workers, then back to 1
+	requestsNumber := 60
+	tickets := make([]status.Ticket, requestsNumber)
+	var wg sync.WaitGroup
+	wg.Add(requestsNumber)
+	for i := 0; i < requestsNumber; i++ {
+		copyI := i
+		go (func() {
+			waitForRandomDuration()
+			tickets[copyI], _ = MakeRequest(true, UsersRequest, generateGenericSigners(), []byte(strconv.Itoa(copyI)), nil, RequestOptions{})
+			wg.Done()
+		})()
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := ResizeWorkers(8); err != nil {
+		t.Errorf("Resizing to 8 workers should not fail. err=%v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := ResizeWorkers(1); err != nil {
+		t.Errorf("Resizing to 1 worker should not fail. err=%v", err)
+	}
+	wg.Wait()
+
+	ShutdownServer()
+
+	// All requests should have been executed exactly once
+	executed := map[string]bool{}
+	for i := 0; i < requestsNumber; i++ {
+		callLog := <-callsChannel
+		executed[string(callLog.request)] = true
+	}
+	if len(executed) != requestsNumber {
+		t.Errorf("Operations were lost during resizing. executed=%v, expected=%v", len(executed), requestsNumber)
+	}
+	for _, ticketId := range tickets {
+		if len(reg.ticketLogs[ticketId]) != 3 ||
+			reg.ticketLogs[ticketId][2].status != status.SuccessStatus {
+			t.Errorf("Request should succeed despite resizing. ticket=%v, logs=%v", ticketId, reg.ticketLogs[ticketId])
+		}
+	}
+}
+
+/*
+	Timeout tests
+*/
+
+func createSlowUsersRequesterFunctor(slowPayload string, delay time.Duration) users.Requester {
+	return func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		responseChannel := make(chan *users.UserResponse, 1)
+		go (func() {
+			if string(request) == slowPayload {
+				time.Sleep(delay)
+			}
+			responseChannel <- &users.UserResponse{
+				Result: users.Success,
+			}
+		})()
+		return responseChannel, nil
+	}
+}
+
+/*
+	Registers handler sleeping before succeeding (never commits through a subsystem)
+	(returns function unregistering it)
+*/
+func registerSlowRequestHandler(requestType core.RequestType, delay time.Duration) func() {
+	RegisterRequestHandler(requestType, func(context.Context, *core.VerifiedSigners, []byte) ([]byte, error) {
+		time.Sleep(delay)
+		return []byte("RESULT"), nil
+	})
+	return func() {
+		requestHandlersLock.Lock()
+		delete(requestHandlers, requestType)
+		requestHandlersLock.Unlock()
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	const slowRequestType core.RequestType = 102
+	defer registerSlowRequestHandler(slowRequestType, time.Second)()
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	conf := Config{
+		NumWorkers:       1,
+		OperationTimeout: 50 * time.Millisecond,
+	}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Slow operation followed by a fast one on the same worker
+	slowTicketId, err := MakeRequest(true, slowRequestType, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return
+	}
+	fastTicketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("FAST"), nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return
+	}
+
+	ShutdownServer()
+
+	if len(reg.ticketLogs[slowTicketId]) != 3 ||
+		reg.ticketLogs[slowTicketId][2].status != status.FailedStatus ||
+		reg.ticketLogs[slowTicketId][2].failureReason != status.TimedOutReason ||
+		!reflect.DeepEqual(reg.ticketLogs[slowTicketId][2].errors, []error{operationTimedOutError}) {
+		t.Errorf("Slow request should time out. logs=%v", reg.ticketLogs[slowTicketId])
+	}
+	if len(reg.ticketLogs[fastTicketId]) != 3 ||
+		reg.ticketLogs[fastTicketId][2].status != status.SuccessStatus {
+		t.Errorf("Worker should recover after timeout. logs=%v", reg.ticketLogs[fastTicketId])
+	}
+}
+
+func TestTimeoutExcludesCommit(t *testing.T) {
+	const slowRequestType core.RequestType = 103
+	defer registerSlowRequestHandler(slowRequestType, 100*time.Millisecond)()
+	replayLog := NewMemoryReplayLog()
+	usersRequester := createSlowUsersRequesterFunctor("SLOW", 100*time.Millisecond)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	conf := Config{
+		NumWorkers:       1,
+		OperationTimeout: 50 * time.Millisecond,
+		ReplayLog:        replayLog,
+		ReplayKey:        testReplayKey,
+	}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Commit started before the timeout is waited for, operation timing out before committing is never logged
+	committedTicketId, _ := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+	timedOutTicketId, _ := MakeRequest(true, slowRequestType, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+	ShutdownServer()
+	time.Sleep(100 * time.Millisecond)
+
+	committedLogs := reg.ticketLogs[committedTicketId]
+	if len(committedLogs) != 3 || committedLogs[2].status != status.SuccessStatus {
+		t.Errorf("Operation committing past its timeout should report its result. logs=%v", committedLogs)
+	}
+	timedOutLogs := reg.ticketLogs[timedOutTicketId]
+	if len(timedOutLogs) != 3 || timedOutLogs[2].failureReason != status.TimedOutReason {
+		t.Errorf("Operation not committing before its timeout should time out. logs=%v", timedOutLogs)
+	}
+	logged := []string{}
+	replayLog.Iterate(func(entry *ReplayEntry) error {
+		logged = append(logged, fmt.Sprint(entry.Operation.Meta.RequestType))
+		return nil
+	})
+	if !reflect.DeepEqual(logged, []string{fmt.Sprint(UsersRequest)}) {
+		t.Errorf("Only the committed operation should be logged. logged=%v", logged)
+	}
+}
+
+/*
+	Graceful shutdown tests
+*/
+
+func TestStopServerGracefully(t *testing.T) {
+	usersRequester := createSlowUsersRequesterFunctor("SLOW", 100*time.Millisecond)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 2}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Submit batch and shutdown while the first operations are running
+	tickets := []status.Ticket{}
+	for i := 0; i < 6; i++ {
+		ticketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+		if err != nil {
+			t.Errorf("Request should not fail. err=%v", err)
+			return
+		}
+		tickets = append(tickets, ticketId)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := StopServerGracefully(time.Second); err != nil {
+		t.Errorf("Graceful shutdown should not time out. err=%v", err)
+	}
+
+	// Started operations should finish and queued ones should be rejected
+	started, rejected := 0, 0
+	for _, ticketId := range tickets {
+		logs := reg.ticketLogs[ticketId]
+		if len(logs) == 3 && logs[1].status == status.RunningStatus && logs[2].status == status.SuccessStatus {
+			started++
+		} else if len(logs) == 2 &&
+			logs[1].status == status.FailedStatus &&
+			logs[1].failureReason == status.RejectedReason &&
+			reflect.DeepEqual(logs[1].errors, []error{serverShuttingDownError}) {
+			rejected++
+		} else {
+			t.Errorf("Operation should either finish or be rejected. logs=%v", logs)
+		}
+	}
+	if started != 2 || rejected != 4 {
+		t.Errorf("Running operations should finish and queued ones be rejected. started=%v, rejected=%v", started, rejected)
+	}
+
+	// New operations should be rejected
+	if _, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{}); err == nil {
+		t.Error("Request made after graceful shutdown should fail.")
+	}
+	if err := StopServerGracefully(time.Second); err != serverNotRunningError {
+		t.Errorf("Graceful shutdown of stopped server should fail with serverNotRunningError. err=%v", err)
+	}
+}
+
+func TestStopServerGracefullyTimeout(t *testing.T) {
+	const slowRequestType core.RequestType = 104
+	defer registerSlowRequestHandler(slowRequestType, time.Second)()
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 2}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	tickets := []status.Ticket{}
+	for i := 0; i < 2; i++ {
+		ticketId, _ := MakeRequest(true, slowRequestType, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+		tickets = append(tickets, ticketId)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// Running operations should be abandoned after timeout
+	err := StopServerGracefully(50 * time.Millisecond)
+	if abandonedErr, ok := err.(*AbandonedOperationsError); !ok || abandonedErr.Abandoned != 2 {
+		t.Errorf("Graceful shutdown should abandon running operations. err=%v", err)
+	}
+	for _, ticketId := range tickets {
+		logs := reg.ticketLogs[ticketId]
+		if len(logs) != 3 ||
+			logs[2].status != status.FailedStatus ||
+			!reflect.DeepEqual(logs[2].errors, []error{operationAbandonedError}) {
+			t.Errorf("Abandoned operation should fail. logs=%v", logs)
+		}
+	}
+}
+
+/*
+	Priority tests
+*/
+
+func createOrderRecordingUsersRequesterFunctor(slowPayload string, delay time.Duration) (users.Requester, *[]string, *sync.Mutex) {
+	order := []string{}
+	lock := &sync.Mutex{}
+	requester := func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		lock.Lock()
+		order = append(order, string(request))
+		lock.Unlock()
+		if string(request) == slowPayload {
+			time.Sleep(delay)
+		}
+		responseChannel := make(chan *users.UserResponse, 1)
+		responseChannel <- &users.UserResponse{
+			Result: users.Success,
+		}
+		return responseChannel, nil
+	}
+	return requester, &order, lock
+}
+
+func TestRequestPriority(t *testing.T) {
+	usersRequester, order, lock := createOrderRecordingUsersRequesterFunctor("SLOW", 50*time.Millisecond)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 1}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Occupy worker, then queue low priority backlog followed by a high priority request
+	MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("LOW_"+strconv.Itoa(i)), nil, RequestOptions{})
+	}
+	MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("HIGH"), nil, RequestOptions{Priority: 1})
+
+	ShutdownServer()
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []string{"SLOW", "HIGH", "LOW_0", "LOW_1", "LOW_2", "LOW_3", "LOW_4"}
+	if !reflect.DeepEqual(*order, expected) {
+		t.Errorf("Requests should run by priority then submission order. found=%v, expected=%v", *order, expected)
+	}
+}
+
+/*
+	Backpressure tests
+*/
+
+func createStalledUsersRequesterFunctor(release chan bool) users.Requester {
+	return func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		responseChannel := make(chan *users.UserResponse, 1)
+		go (func() {
+			<-release
+			responseChannel <- &users.UserResponse{
+				Result: users.Success,
+			}
+		})()
+		return responseChannel, nil
+	}
+}
+
+func TestTrySubmitQueueFull(t *testing.T) {
+	release := make(chan bool)
+	usersRequester := createStalledUsersRequesterFunctor(release)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 1, QueueCapacity: 2}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Stall worker, then fill the queue
+	MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("STALLED"), nil, RequestOptions{})
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := TrySubmit(true, UsersRequest, generateGenericSigners(), []byte("QUEUED"), nil, RequestOptions{}); err != nil {
+			t.Errorf("Request should be queued while the queue has room. err=%v", err)
+		}
+	}
+
+	// Full queue is signaled immediately
+	rejectedTicket, err := TrySubmit(true, UsersRequest, generateGenericSigners(), []byte("REJECTED"), nil, RequestOptions{})
+	if queueFullErr, ok := err.(*QueueFullError); !ok || queueFullErr.Capacity != 2 {
+		t.Errorf("Request should be rejected when the queue is full. err=%v", err)
+	}
+	if depth := Stats().QueueDepth; depth != 2 {
+		t.Errorf("Rejected request should not be queued. depth=%v", depth)
+	}
+
+	// Blocking submission waits for room
+	blockingDone := make(chan error, 1)
+	go (func() {
+		_, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("BLOCKING"), nil, RequestOptions{})
+		blockingDone <- err
+	})()
+	select {
+	case <-blockingDone:
+		t.Errorf("Blocking request should wait while the queue is full.")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-blockingDone:
+		if err != nil {
+			t.Errorf("Blocking request should be queued once there is room. err=%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Blocking request should be queued once there is room.")
+	}
+
+	ShutdownServer()
+
+	if logs := reg.ticketLogs[rejectedTicket]; len(logs) != 2 ||
+		logs[1].status != status.FailedStatus ||
+		logs[1].failureReason != status.RejectedReason {
+		t.Errorf("Request rejected because of a full queue should fail. logs=%v", logs)
+	}
+	if after := Stats(); after.Processed != 4 || after.Failures != 0 {
+		t.Errorf("Queued requests should all run. stats=%+v", after)
+	}
+}
+
+/*
+	Stats tests
+*/
+
+func TestStats(t *testing.T) {
+	usersRequester := createSlowUsersRequesterFunctor("SLOW", 100*time.Millisecond)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(1+users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 2}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Before
+	before := Stats()
+	if before != (ServerStats{IdleWorkers: 2}) {
+		t.Errorf("Stats should be empty before processing. stats=%+v", before)
+	}
+
+	// During (2 running, 2 queued)
+	for i := 0; i < 3; i++ {
+		MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+	}
+	MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("SLOW"), nil, RequestOptions{})
+	time.Sleep(30 * time.Millisecond)
+	during := Stats()
+	if during.QueueDepth != 2 || during.BusyWorkers != 2 || during.IdleWorkers != 0 || during.Processed != 0 {
+		t.Errorf("Stats should show busy workers and queued operations. stats=%+v", during)
+	}
+
+	// After (unverified request fails)
+	ShutdownServer()
+	after := Stats()
+	if after.QueueDepth != 0 || after.BusyWorkers != 0 || after.Processed != 4 || after.Failures != 1 {
+		t.Errorf("Stats should count processed operations and failures. stats=%+v", after)
+	}
+}
+
+/*
+	Validity window tests
+*/
+
+func TestValidityWindow(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	conf := Config{NumWorkers: 1, Clock: core.NewManualClock(now)}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	windows := map[string]core.ValidityWindow{
+		"EXPIRED":       {NotBefore: now.Add(-2 * time.Hour), NotAfter: now.Add(-time.Hour)},
+		"NOT_YET_VALID": {NotBefore: now.Add(time.Hour)},
+		"VALID":         {NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+	}
+	tickets := map[string]status.Ticket{}
+	for name, window := range windows {
+		ticketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte(name), nil, RequestOptions{Validity: window})
+		if err != nil {
+			t.Errorf("Request should not fail. err=%v", err)
+			return
+		}
+		tickets[name] = ticketId
+	}
+
+	ShutdownServer()
+
+	for _, name := range []string{"EXPIRED", "NOT_YET_VALID"} {
+		logs := reg.ticketLogs[tickets[name]]
+		if len(logs) != 2 ||
+			logs[1].status != status.FailedStatus ||
+			logs[1].failureReason != status.OutsideValidityWindowReason ||
+			len(logs[1].errors) != 1 {
+			t.Errorf("Request outside validity window should be rejected. name=%v, logs=%v", name, logs)
+		}
+	}
+	if logs := reg.ticketLogs[tickets["VALID"]]; len(logs) != 3 || logs[2].status != status.SuccessStatus {
+		t.Errorf("Request inside validity window should run. logs=%v", logs)
+	}
+}
+
+/*
+	Request type policy tests
+*/
+
+func TestRequireDistinctCertifier(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	conf := Config{
+		NumWorkers: 1,
+		RequestTypePolicies: RequestTypePolicies{
+			UsersRequest: {RequireDistinctCertifier: true},
+		},
+	}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	signers := map[string]*core.VerifiedSigners{
+		"IDENTICAL": generateSigners(genericIssuerId, genericIssuerId),
+		"DISTINCT":  generateGenericSigners(),
+	}
+	tickets := map[string]status.Ticket{}
+	for name, requestSigners := range signers {
+		ticketId, err := MakeRequest(true, UsersRequest, requestSigners, []byte(name), nil, RequestOptions{})
+		if err != nil {
+			t.Errorf("Request should not fail. err=%v", err)
+			return
+		}
+		tickets[name] = ticketId
+	}
+
+	ShutdownServer()
+
+	if logs := reg.ticketLogs[tickets["IDENTICAL"]]; len(logs) != 2 ||
+		logs[1].status != status.FailedStatus ||
+		logs[1].failureReason != status.PermissionDeniedReason ||
+		len(logs[1].errors) != 1 || logs[1].errors[0] != certifierNotDistinctError {
+		t.Errorf("Request certified by its issuer should be rejected. logs=%v", logs)
+	}
+	if logs := reg.ticketLogs[tickets["DISTINCT"]]; len(logs) != 3 || logs[2].status != status.SuccessStatus {
+		t.Errorf("Request with distinct certifier should run. logs=%v", logs)
+	}
+}
+
+func TestRequestTypePoliciesCheckSigners(t *testing.T) {
+	policies := RequestTypePolicies{
+		UsersRequest: {RequireDistinctCertifier: true},
+	}
+	identical := generateSigners(genericIssuerId, genericIssuerId)
+	if err := policies.checkSigners(UsersRequest, identical); err != certifierNotDistinctError {
+		t.Errorf("Identical signers should be rejected for dual control request type. err=%v", err)
+	}
+	if err := policies.checkSigners(ChannelsRequest, identical); err != nil {
+		t.Errorf("Identical signers should be allowed for other request types. err=%v", err)
+	}
+	if err := policies.checkSigners(UsersRequest, nil); err != nil {
+		t.Errorf("Requests without signers should not be checked. err=%v", err)
+	}
+	if err := RequestTypePolicies(nil).checkSigners(UsersRequest, identical); err != nil {
+		t.Errorf("Missing policies should not reject requests. err=%v", err)
+	}
+}
+
+/*
+	Dry run tests
+*/
+
+func TestDryRun(t *testing.T) {
+	usersRequester, calls := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersDryRunRequester, dryRunCalls := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	conf := Config{
+		NumWorkers:           1,
+		IdempotencyCacheSize: 10,
+		UsersDryRunRequester: usersDryRunRequester,
+	}
+	if !resetAndStartServerWithChannels(t, conf, usersRequester, usersRequesterUnverified, createDummyChannelsRequesterFunctor(), responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Dry run goes to the dry run requester, and doesn't consume the idempotency key
+	dryRunTicket, _ := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("DRY_RUN"), nil, RequestOptions{IdempotencyKey: "KEY", DryRun: true})
+	select {
+	case call := <-dryRunCalls:
+		if string(call.request) != "DRY_RUN" {
+			t.Errorf("Dry run should be made to users dry run requester. request=%s", call.request)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Dry run should be made to users dry run requester.")
+	}
+	ticketId, _ := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("REQUEST"), nil, RequestOptions{IdempotencyKey: "KEY"})
+	if ticketId == dryRunTicket {
+		t.Errorf("Request should not be deduplicated with a dry run.")
+	}
+	select {
+	case call := <-calls:
+		if string(call.request) != "REQUEST" {
+			t.Errorf("Request should be made to users requester. request=%s", call.request)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Request should be made to users requester.")
+	}
+
+	// Dry runs are rejected if they can't be run without being applied
+	channelsDryRunTicket, _ := MakeRequest(true, ChannelsRequest, generateGenericSigners(), []byte("{}"), nil, RequestOptions{DryRun: true})
+	unverifiedDryRunTicket, _ := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("DRY_RUN"), nil, RequestOptions{DryRun: true})
+
+	ShutdownServer()
+
+	for _, ticket := range []status.Ticket{dryRunTicket, ticketId} {
+		if logs := reg.ticketLogs[ticket]; len(logs) != 3 || logs[2].status != status.SuccessStatus {
+			t.Errorf("Request should succeed. logs=%v", logs)
+		}
+	}
+	for _, ticket := range []status.Ticket{channelsDryRunTicket, unverifiedDryRunTicket} {
+		if logs := reg.ticketLogs[ticket]; len(logs) != 2 ||
+			logs[1].status != status.FailedStatus ||
+			len(logs[1].errors) != 1 || logs[1].errors[0] != dryRunUnsupportedError {
+			t.Errorf("Unsupported dry run should be rejected. logs=%v", logs)
+		}
+	}
+}
+
+/*
+	Logging tests
+*/
+
+type capturingWriter struct {
+	lines []string
+	lock  *sync.Mutex
+}
+
+func (writer *capturingWriter) Write(line []byte) (int, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	writer.lines = append(writer.lines, string(line))
+	return len(line), nil
+}
+
+func TestRequestLogsCarryTicket(t *testing.T) {
+	writer := &capturingWriter{lock: &sync.Mutex{}}
+	defer (func(original *core.LoggingHandler) {
+		log = original
+	})(log)
+	log = core.NewLoggingHandler(writer, writer)
+	log.SetLogLevel(core.DEBUG)
+
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 1}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Operation that runs, and one rejected by its validity window
+	tickets := []status.Ticket{}
+	for _, window := range []core.ValidityWindow{{}, {NotAfter: time.Now().Add(-time.Hour)}} {
+		ticketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("PAYLOAD"), nil, RequestOptions{Validity: window})
+		if err != nil {
+			t.Errorf("Request should not fail. err=%v", err)
+			return
+		}
+		tickets = append(tickets, ticketId)
+	}
+
+	ShutdownServer()
+
+	// Every operation log line carries exactly one ticket
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	linesPerTicket := map[status.Ticket]int{}
+	for _, line := range writer.lines {
+		if strings.Contains(line, daemonStartLogMsg) || strings.Contains(line, daemonShutdownLogMsg) {
+			continue
+		}
+		ticketsFound := 0
+		for _, ticketId := range tickets {
+			if strings.Contains(line, ticketLogField+"="+string(ticketId)+" ") {
+				linesPerTicket[ticketId]++
+				ticketsFound++
+			}
+		}
+		if ticketsFound != 1 {
+			t.Errorf("Operation log line should carry its ticket. line=%v", line)
+		}
+	}
+	for _, ticketId := range tickets {
+		if linesPerTicket[ticketId] < 2 {
+			t.Errorf("Operation lifecycle should be logged with its ticket. ticket=%v, lines=%v", ticketId, writer.lines)
+		}
+	}
+}
+
+/*
+	Payload size tests
+*/
+
+func TestPayloadTooLarge(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 1, MaxPayloadBytes: 16}, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Encrypted operation over the limit is rejected without being decoded
+	largeOperation := core.GenerateOperation(true, "KEY_ID", []byte{}, false, "", []byte{}, false, "", []byte{}, false, core.UsersRequestType, make([]byte, 17), false)
+	if largeOperation.PayloadSizeBytes() != 17 || !PayloadTooLarge(largeOperation) {
+		t.Errorf("Operation over the limit should be too large. size=%v", largeOperation.PayloadSizeBytes())
+	}
+	largeOperationTicket, err := MakeRequest(false, UsersRequest, nil, nil, largeOperation, RequestOptions{})
+	if err != payloadTooLargeError {
+		t.Errorf("Operation over the limit should be rejected. err=%v", err)
+	}
+
+	// Plaintext request over the limit is rejected
+	largeRequestTicket, err := MakeRequest(true, UsersRequest, generateGenericSigners(), make([]byte, 17), nil, RequestOptions{})
+	if err != payloadTooLargeError {
+		t.Errorf("Request over the limit should be rejected. err=%v", err)
+	}
+
+	// Request at the limit runs
+	smallOperation := core.GenerateOperation(true, "KEY_ID", []byte{}, false, "", []byte{}, false, "", []byte{}, false, core.UsersRequestType, make([]byte, 16), false)
+	if PayloadTooLarge(smallOperation) {
+		t.Errorf("Operation at the limit should not be too large.")
+	}
+	smallRequestTicket, err := MakeRequest(true, UsersRequest, generateGenericSigners(), make([]byte, 16), nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request at the limit should not fail. err=%v", err)
+	}
+
+	ShutdownServer()
+
+	for _, ticketId := range []status.Ticket{largeOperationTicket, largeRequestTicket} {
+		logs := reg.ticketLogs[ticketId]
+		if len(logs) != 2 ||
+			logs[1].status != status.FailedStatus ||
+			logs[1].failureReason != status.PayloadTooLargeReason ||
+			len(logs[1].errors) != 1 {
+			t.Errorf("Request over the limit should be rejected with payload too large reason. logs=%v", logs)
+		}
+	}
+	if logs := reg.ticketLogs[smallRequestTicket]; len(logs) != 3 || logs[2].status != status.SuccessStatus {
+		t.Errorf("Request at the limit should run. logs=%v", logs)
+	}
+}
+
+/*
+	Channels tests
+*/
+
+func createDummyChannelsRequesterFunctor() channels.Requester {
+	return func(request []byte) (chan *channels.ChannelResponse, []error) {
+		var rq channels.ChannelRequest
+		rq.Decode(request)
+		responseChannel := make(chan *channels.ChannelResponse, 1)
+		responseChannel <- &channels.ChannelResponse{
+			Result: channels.Success,
+			Data:   []channels.ChannelObject{rq.Data},
+		}
+		return responseChannel, nil
+	}
+}
+
+func TestChannelRequestPermissions(t *testing.T) {
+	permittedIssuer := &users.UserObject{Id: "PERMITTED", Active: true}
+	permittedIssuer.Permissions.Channel.Add = true
+	deniedIssuer := &users.UserObject{Id: "DENIED", Active: true}
+	lateIssuer := &users.UserObject{Id: "LATE", Active: true}
+	lateIssuer.Permissions.Channel.Add = true
+	lateIssuer.Permissions.Channel.AddUpdatedAt = time.Now().Add(time.Hour)
+	usersReader := createDummyUsersReaderFunctor(map[string]*users.UserObject{
+		permittedIssuer.Id: permittedIssuer,
+		deniedIssuer.Id:    deniedIssuer,
+		lateIssuer.Id:      lateIssuer,
+	})
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServerWithChannels(t, multipleWorkersConfig(), usersReader, usersReader, createDummyChannelsRequesterFunctor(), responseReporter, ticketGenerator) {
+		return
+	}
+
+	request := &channels.ChannelRequest{
+		Type: channels.CreateRequest,
+		Data: channels.ChannelObject{
+			Id:    "CHANNEL",
+			KeyId: "KEY",
+		},
+		Timestamp: time.Now(),
+	}
+	requestEncoded, _ := request.Encode()
+	permittedTicketId, err := MakeRequest(true, ChannelsRequest, generateSigners(permittedIssuer.Id, genericCertifierId), requestEncoded, nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return
+	}
+	deniedTicketId, err := MakeRequest(true, ChannelsRequest, generateSigners(deniedIssuer.Id, genericCertifierId), requestEncoded, nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return
+	}
+	lateTicketId, err := MakeRequest(true, ChannelsRequest, generateSigners(lateIssuer.Id, genericCertifierId), requestEncoded, nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return
+	}
+
+	ShutdownServer()
+
+	permittedLogs := reg.ticketLogs[permittedTicketId]
+	if len(permittedLogs) != 3 || permittedLogs[2].status != status.SuccessStatus {
+		t.Errorf("Channel creation by permitted issuer should succeed. logs=%v", permittedLogs)
+	} else {
+		response := &channels.ChannelResponse{}
+		if response.Decode(permittedLogs[2].result); len(response.Data) != 1 || response.Data[0].Id != "CHANNEL" {
+			t.Errorf("Channel creation result should contain the channel. result=%s", permittedLogs[2].result)
+		}
+	}
+	deniedLogs := reg.ticketLogs[deniedTicketId]
+	if len(deniedLogs) != 3 ||
+		deniedLogs[2].status != status.FailedStatus ||
+		deniedLogs[2].failureReason != status.PermissionDeniedReason ||
+		!reflect.DeepEqual(deniedLogs[2].errors, []error{channelPermissionError}) {
+		t.Errorf("Channel creation by issuer lacking permission should be denied. logs=%v", deniedLogs)
+	}
+	lateLogs := reg.ticketLogs[lateTicketId]
+	if len(lateLogs) != 3 ||
+		lateLogs[2].status != status.FailedStatus ||
+		lateLogs[2].failureReason != status.PermissionDeniedReason {
+		t.Errorf("Channel creation before issuer was granted permission should be denied. logs=%v", lateLogs)
+	}
+}
+
+/*
+	User update permission tests
+*/
+
+func TestUserUpdateKeyPermissions(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.IssuerPermissionsError, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, multipleWorkersConfig(), usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Issuer lacking permission for an updated field is denied
+	ticketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("REQUEST_PAYLOAD"), nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+	}
+
+	ShutdownServer()
+
+	logs := reg.ticketLogs[ticketId]
+	if len(logs) != 3 ||
+		logs[2].status != status.FailedStatus ||
+		logs[2].failureReason != status.PermissionDeniedReason ||
+		!reflect.DeepEqual(logs[2].errors, []error{userUpdatePermissionError}) {
+		t.Errorf("Update by issuer lacking permission should be denied. logs=%v", logs)
+	}
+}
+
+/*
+	Dead letter tests
+*/
+
+type deadLetter struct {
+	operation *core.Operation
+	reason    status.FailReasonCode
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(1+users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	deadLetters := make(chan deadLetter, 2)
+	conf := Config{
+		NumWorkers: 1,
+		DeadLetterHandler: func(operation *core.Operation, reason status.FailReasonCode) {
+			deadLetters <- deadLetter{operation, reason}
+		},
+	}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Successful verified request, and failed unverified one
+	MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("SUCCEEDS"), nil, RequestOptions{})
+	MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("FAILS"), nil, RequestOptions{})
+	ShutdownServer()
+
+	select {
+	case letter := <-deadLetters:
+		payload, _ := core.Base64DecodeString(letter.operation.Payload)
+		if string(payload) != "FAILS" ||
+			letter.operation.Issue.Id != genericIssuerId ||
+			letter.operation.Meta.RequestType != UsersRequest ||
+			letter.reason != status.FailedReason {
+			t.Errorf("Dead letter handler should receive failed operation. operation=%+v, reason=%v", letter.operation, letter.reason)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Dead letter handler should be called for failed operation.")
+	}
+
+	select {
+	case letter := <-deadLetters:
+		t.Errorf("Dead letter handler should be called exactly once. operation=%+v", letter.operation)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+/*
+	Retry tests
+*/
+
+var transientUsersError error = &users.UnavailableError{Err: errors.New("Transient users error")}
+
+func createFlakyUsersRequesterFunctor(failures int) users.Requester {
+	lock := &sync.Mutex{}
+	calls := 0
+	return func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		lock.Lock()
+		calls++
+		failing := calls <= failures
+		lock.Unlock()
+		if failing {
+			return nil, []error{transientUsersError}
+		}
+		responseChannel := make(chan *users.UserResponse, 1)
+		responseChannel <- &users.UserResponse{Result: users.Success}
+		return responseChannel, nil
+	}
+}
+
+func waitForFinalStatus(reg *dummyStatusRegistry, ticketId status.Ticket) []dummyStatusEntry {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		reg.lock.Lock()
+		logs := append([]dummyStatusEntry{}, reg.ticketLogs[ticketId]...)
+		reg.lock.Unlock()
+		if len(logs) != 0 && logs[len(logs)-1].status >= status.SuccessStatus {
+			return logs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestRetryTransientFailures(t *testing.T) {
+	usersRequester := createFlakyUsersRequesterFunctor(2)
+	usersRequesterUnverified, unverifiedCalls := createDummyUsersRequesterFunctor(1+users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	retriesLock := &sync.Mutex{}
+	retries := map[status.Ticket][]int{}
+	retryReporter := func(ticketId status.Ticket, attempts int, errs []error) error {
+		retriesLock.Lock()
+		retries[ticketId] = append(retries[ticketId], attempts)
+		retriesLock.Unlock()
+		return nil
+	}
+	conf := Config{
+		NumWorkers: 1,
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+		},
+	}
+	if !resetAndStartServerWithRetries(t, conf, usersRequester, usersRequesterUnverified, nil, responseReporter, retryReporter, ticketGenerator) {
+		return
+	}
+
+	// Request failing twice before succeeding
+	flakyTicketId, _ := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("FLAKY"), nil, RequestOptions{})
+	logs := waitForFinalStatus(reg, flakyTicketId)
+	if len(logs) == 0 || logs[len(logs)-1].status != status.SuccessStatus {
+		t.Errorf("Request should succeed after retries. logs=%v", logs)
+	}
+	retriesLock.Lock()
+	if !reflect.DeepEqual(retries[flakyTicketId], []int{1, 2}) {
+		t.Errorf("Retries should be reported with attempts. found=%v", retries[flakyTicketId])
+	}
+	retriesLock.Unlock()
+
+	// Permanent failure should not be retried
+	failedTicketId, _ := MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("FAILS"), nil, RequestOptions{})
+	logs = waitForFinalStatus(reg, failedTicketId)
+	if len(logs) == 0 || logs[len(logs)-1].failureReason != status.FailedReason {
+		t.Errorf("Permanent failure should be reported. logs=%v", logs)
+	}
+	<-unverifiedCalls
+	select {
+	case <-unverifiedCalls:
+		t.Errorf("Permanent failure should not be retried.")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ShutdownServer()
+}
+
+func TestNoRetryMalformedRequest(t *testing.T) {
+	// Users requester decoding requests like the users subsystem
+	callsLock := &sync.Mutex{}
+	calls := 0
+	usersRequester := func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		callsLock.Lock()
+		calls++
+		callsLock.Unlock()
+		if err := (&users.UserRequest{}).Decode(request); err != nil {
+			return nil, []error{err}
+		}
+		return nil, []error{&users.UnavailableError{Err: transientUsersError}}
+	}
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	retryReporter := func(ticketId status.Ticket, attempts int, errs []error) error {
+		t.Errorf("Malformed request should not be retried. attempts=%v", attempts)
+		return nil
+	}
+	conf := Config{
+		NumWorkers: 1,
+		RetryPolicy: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: 10 * time.Millisecond,
+		},
+	}
+	if !resetAndStartServerWithRetries(t, conf, usersRequester, usersRequester, nil, responseReporter, retryReporter, createDummyTicketGeneratorFunctor()) {
+		return
+	}
+
+	ticketId, _ := MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("MALFORMED"), nil, RequestOptions{})
+	logs := waitForFinalStatus(reg, ticketId)
+	if len(logs) == 0 || logs[len(logs)-1].status != status.FailedStatus {
+		t.Errorf("Malformed request should fail. logs=%v", logs)
+	}
+	time.Sleep(50 * time.Millisecond)
+	callsLock.Lock()
+	if calls != 1 {
+		t.Errorf("Malformed request should be attempted exactly once. calls=%v", calls)
+	}
+	callsLock.Unlock()
+
+	ShutdownServer()
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     30 * time.Millisecond,
+	}
+	expectedBackoffs := map[int]time.Duration{
+		1: 10 * time.Millisecond,
+		2: 20 * time.Millisecond,
+		3: 30 * time.Millisecond,
+		4: 30 * time.Millisecond,
+	}
+	for attempts, expectedBackoff := range expectedBackoffs {
+		if backoff := policy.backoff(attempts); backoff != expectedBackoff {
+			t.Errorf("Unexpected backoff. attempts=%v, found=%v, expected=%v", attempts, backoff, expectedBackoff)
+		}
+	}
+	if !policy.canRetry(3) || policy.canRetry(4) {
+		t.Errorf("Retries should be bounded by max attempts.")
+	}
+	if (RetryPolicy{}).canRetry(0) {
+		t.Errorf("Zero policy should never retry.")
+	}
+}
+
+/*
+	Health check tests
+*/
+
+var probeError error = errors.New("Probe error")
+
+func TestIsHealthy(t *testing.T) {
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	var usersProbeErr error
+	conf := Config{
+		NumWorkers: 1,
+		UsersProbe: func() error {
+			return usersProbeErr
+		},
+		StatusProbe: func() error {
+			return nil
+		},
+	}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	if healthy, reason := IsHealthy(); !healthy || reason != "" {
+		t.Errorf("Running server should be healthy. reason=%v", reason)
+	}
+
+	// Unreachable dependency
+	usersProbeErr = probeError
+	if healthy, reason := IsHealthy(); healthy || reason != fmt.Sprintf(usersUnreachableReason, probeError) {
+		t.Errorf("Server with unreachable users subsystem should be unhealthy. reason=%v", reason)
+	}
+	usersProbeErr = nil
+
+	ShutdownServer()
+
+	if healthy, reason := IsHealthy(); healthy || reason != workersNotRunningReason {
+		t.Errorf("Stopped server should be unhealthy. reason=%v", reason)
+	}
+}
+
+/*
+	Issuer activity tests
+*/
+
+func makeUsersRequest(t *testing.T, signers *core.VerifiedSigners, request *users.UserRequest, reg *dummyStatusRegistry) dummyStatusEntry {
+	requestEncoded, _ := request.Encode()
+	ticketId, err := MakeRequest(true, UsersRequest, signers, requestEncoded, nil, RequestOptions{})
+	if err != nil {
+		t.Errorf("Request should not fail. err=%v", err)
+		return dummyStatusEntry{}
+	}
+	logs := waitForFinalStatus(reg, ticketId)
+	if len(logs) == 0 {
+		t.Errorf("Request should complete.")
+		return dummyStatusEntry{}
+	}
+	return logs[len(logs)-1]
+}
+
+func TestInactiveIssuer(t *testing.T) {
+	// Start users subsystem with issuer and certifier allowed to (de)activate users
+	if err := users.StartServer(users.Config{NumWorkers: 1}, log, shutdownProgram); err != nil {
+		t.Errorf("Users server should start. err=%v", err)
+		return
+	}
+	defer users.ShutdownServer()
+	baseTime := time.Now()
+	for _, userId := range []string{genericIssuerId, genericCertifierId} {
+		encKey, _ := core.PublicAsymKeyToString(core.GeneratePublicKey())
+		signKey, _ := core.PublicAsymKeyToString(core.GeneratePublicKey())
+		request := &users.UserRequest{
+			Type:      users.CreateRequest,
+			Timestamp: baseTime,
+			Data: users.UserObject{
+				Id:      userId,
+				EncKey:  encKey,
+				SignKey: signKey,
+				Active:  true,
+			},
+		}
+		request.Data.Permissions.User.Add = true
+		request.Data.Permissions.User.Remove = true
+		requestEncoded, _ := request.Encode()
+		channel, errs := users.MakeUnverifiedRequest(nil, requestEncoded)
+		if len(errs) != 0 {
+			t.Errorf("User creation should not fail. errs=%v", errs)
+			return
+		}
+		<-channel
+	}
+
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	if !resetAndStartServer(t, Config{NumWorkers: 1}, users.MakeRequest, users.MakeUnverifiedRequest, responseReporter, ticketGenerator) {
+		return
+	}
+	defer ShutdownServer()
+	readIssuer := &users.UserRequest{
+		Type:   users.ReadRequest,
+		Fields: []string{genericIssuerId},
+	}
+	activityChange := func(field string, timestamp time.Time) *users.UserRequest {
+		return &users.UserRequest{
+			Type:      users.UpdateRequest,
+			Fields:    []string{field},
+			Timestamp: timestamp,
+			Data:      users.UserObject{Id: genericIssuerId},
+		}
+	}
+
+	// Issuer deactivated by certifier
+	result := makeUsersRequest(t, generateSigners(genericCertifierId, genericCertifierId), activityChange("deactivate", baseTime.Add(time.Hour)), reg)
+	if result.status != status.SuccessStatus {
+		t.Errorf("Deactivation should succeed. result=%v", result)
+		return
+	}
+
+	// Operations from deactivated issuer are refused
+	result = makeUsersRequest(t, generateGenericSigners(), readIssuer, reg)
+	if result.status != status.FailedStatus ||
+		result.failureReason != status.RejectedReason ||
+		!reflect.DeepEqual(result.errors, []error{issuerInactiveError}) {
+		t.Errorf("Operation from inactive issuer should be rejected. result=%v", result)
+	}
+
+	// Generic activity update can't reactivate issuer
+	reactivation := activityChange("active", baseTime.Add(2*time.Hour))
+	reactivation.Data.Active = true
+	makeUsersRequest(t, generateSigners(genericCertifierId, genericCertifierId), reactivation, reg)
+	result = makeUsersRequest(t, generateGenericSigners(), readIssuer, reg)
+	if result.status != status.FailedStatus {
+		t.Errorf("Deactivated issuer should only be reactivated explicitly. result=%v", result)
+	}
+
+	// Reactivation restores operations
+	result = makeUsersRequest(t, generateSigners(genericCertifierId, genericCertifierId), activityChange("reactivate", baseTime.Add(3*time.Hour)), reg)
+	if result.status != status.SuccessStatus {
+		t.Errorf("Reactivation should succeed. result=%v", result)
+		return
+	}
+	result = makeUsersRequest(t, generateGenericSigners(), readIssuer, reg)
+	if result.status != status.SuccessStatus {
+		t.Errorf("Operation from reactivated issuer should succeed. result=%v", result)
+		return
+	}
+	response := &users.UserResponse{}
+	json.Unmarshal(result.result, response)
+	if len(response.Data) != 1 ||
+		response.Data[0].DeactivatedBy != genericCertifierId ||
+		response.Data[0].ReactivatedBy != genericCertifierId ||
+		!response.Data[0].ReactivatedAt.Equal(baseTime.Add(3*time.Hour)) {
+		t.Errorf("Activity changes should be recorded. response=%+v", response)
+	}
+}
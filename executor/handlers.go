@@ -0,0 +1,109 @@
+/*
+	Registry of handlers running requests by type
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/status"
+	"github.com/mngharbi/DMPC/users"
+	"sync"
+)
+
+/*
+	Function running a request of a registered type
+	(signers are nil for unverified requests, and the result is reported with the ticket)
+*/
+type RequestHandler func(context.Context, *core.VerifiedSigners, []byte) ([]byte, error)
+
+/*
+	Errors
+*/
+var (
+	requestTypeAlreadyRegisteredError error = errors.New("Request type already has a handler.")
+	requestHandlerMissingError        error = errors.New("Request handler should not be nil.")
+)
+
+type requestHandler func(context.Context, *server, *executorRequest) executionResult
+
+var (
+	requestHandlers map[core.RequestType]requestHandler = map[core.RequestType]requestHandler{
+		core.UsersRequestType:    runUsersRequest,
+		core.ChannelsRequestType: runChannelsRequest,
+	}
+	requestHandlersLock *sync.RWMutex = &sync.RWMutex{}
+)
+
+/*
+	Registers handler for a new request type (built in and registered types can't be replaced)
+*/
+func RegisterRequestHandler(requestType core.RequestType, handler RequestHandler) error {
+	if handler == nil {
+		return requestHandlerMissingError
+	}
+	requestHandlersLock.Lock()
+	defer requestHandlersLock.Unlock()
+	if _, ok := requestHandlers[requestType]; ok {
+		return requestTypeAlreadyRegisteredError
+	}
+	requestHandlers[requestType] = func(ctx context.Context, _ *server, wrappedRequest *executorRequest) executionResult {
+		result, err := handler(ctx, wrappedRequest.signers, wrappedRequest.request)
+		if ctx.Err() != nil {
+			return executionResult{status: status.FailedStatus, failReason: status.TimedOutReason, errs: []error{operationTimedOutError}}
+		}
+		if err != nil {
+			return executionResult{status: status.FailedStatus, failReason: status.FailedReason, result: result, errs: []error{err}}
+		}
+		return executionResult{status: status.SuccessStatus, failReason: status.NoReason, result: result}
+	}
+	return nil
+}
+
+/*
+	Request types resolving users (rejected when running without the users subsystem)
+*/
+var usersDependentRequestTypes map[core.RequestType]bool = map[core.RequestType]bool{
+	core.UsersRequestType:    true,
+	core.ChannelsRequestType: true,
+}
+
+func (sv *server) isUsersUnavailable(requestType core.RequestType) bool {
+	return usersDependentRequestTypes[requestType] && (sv.usersRequester.IsNoop() || sv.usersRequesterUnverified.IsNoop())
+}
+
+func lookupRequestHandler(requestType core.RequestType) (requestHandler, bool) {
+	requestHandlersLock.RLock()
+	defer requestHandlersLock.RUnlock()
+	handler, ok := requestHandlers[requestType]
+	return handler, ok
+}
+
+func isValidRequestType(requestType core.RequestType) bool {
+	_, ok := lookupRequestHandler(requestType)
+	return ok
+}
+
+/*
+	Built in handlers
+*/
+
+func runUsersRequest(ctx context.Context, sv *server, wrappedRequest *executorRequest) executionResult {
+	// Determine lambda to use based on whether the request is verified or dry run
+	var usersRequester users.Requester
+	if wrappedRequest.dryRun {
+		usersRequester = sv.dryRunUsersRequester(wrappedRequest)
+	} else if wrappedRequest.isVerified {
+		usersRequester = sv.usersRequester
+	} else {
+		usersRequester = sv.usersRequesterUnverified
+	}
+	return executeUsersRequest(ctx, usersRequester, wrappedRequest)
+}
+
+func runChannelsRequest(ctx context.Context, sv *server, wrappedRequest *executorRequest) executionResult {
+	// Issuer permissions are read without verification
+	return executeChannelsRequest(ctx, sv.usersRequesterUnverified, sv.channelsRequester, wrappedRequest)
+}
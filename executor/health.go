@@ -0,0 +1,56 @@
+/*
+	Health checks for load balancers
+*/
+
+package executor
+
+import (
+	"fmt"
+)
+
+/*
+	Function checking that a dependency is reachable (without making a request)
+*/
+type HealthProbe func() error
+
+/*
+	Reasons reported when unhealthy
+*/
+const (
+	workersNotRunningReason string = "Executor workers are not running"
+	drainingReason          string = "Executor is shutting down"
+	usersUnreachableReason  string = "Users subsystem is unreachable: %v"
+	statusUnreachableReason string = "Status subsystem is unreachable: %v"
+)
+
+/*
+	Reports whether the executor can run operations, and why not if unhealthy
+	(checks the worker pool and probes dependencies, but never runs an operation)
+*/
+func IsHealthy() (bool, string) {
+	// Checked first since the lifecycle lock is held during graceful shutdown
+	if serverSingleton.isDraining() {
+		return false, drainingReason
+	}
+
+	serverLifecycleLock.RLock()
+	running := serverRunning
+	usersProbe := serverSingleton.usersProbe
+	statusProbe := serverSingleton.statusProbe
+	serverLifecycleLock.RUnlock()
+	if !running {
+		return false, workersNotRunningReason
+	}
+
+	if usersProbe != nil {
+		if err := usersProbe(); err != nil {
+			return false, fmt.Sprintf(usersUnreachableReason, err)
+		}
+	}
+	if statusProbe != nil {
+		if err := statusProbe(); err != nil {
+			return false, fmt.Sprintf(statusUnreachableReason, err)
+		}
+	}
+	return true, ""
+}
@@ -5,6 +5,7 @@
 package executor
 
 import (
+	"github.com/mngharbi/DMPC/channels"
 	"github.com/mngharbi/DMPC/core"
 	"github.com/mngharbi/DMPC/status"
 	"github.com/mngharbi/DMPC/users"
@@ -42,9 +43,34 @@ func resetAndStartServer(
 	usersRequesterUnverified users.Requester,
 	responseReporter status.Reporter,
 	ticketGenerator status.TicketGenerator,
+) bool {
+	return resetAndStartServerWithChannels(t, conf, usersRequester, usersRequesterUnverified, nil, responseReporter, ticketGenerator)
+}
+
+func resetAndStartServerWithChannels(
+	t *testing.T,
+	conf Config,
+	usersRequester users.Requester,
+	usersRequesterUnverified users.Requester,
+	channelsRequester channels.Requester,
+	responseReporter status.Reporter,
+	ticketGenerator status.TicketGenerator,
+) bool {
+	return resetAndStartServerWithRetries(t, conf, usersRequester, usersRequesterUnverified, channelsRequester, responseReporter, nil, ticketGenerator)
+}
+
+func resetAndStartServerWithRetries(
+	t *testing.T,
+	conf Config,
+	usersRequester users.Requester,
+	usersRequesterUnverified users.Requester,
+	channelsRequester channels.Requester,
+	responseReporter status.Reporter,
+	retryReporter status.RetryReporter,
+	ticketGenerator status.TicketGenerator,
 ) bool {
 	serverSingleton = server{}
-	InitializeServer(usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator, log, shutdownProgram)
+	InitializeServer(usersRequester, usersRequesterUnverified, channelsRequester, responseReporter, retryReporter, ticketGenerator, log, shutdownProgram)
 	err := StartServer(conf)
 	if err != nil {
 		t.Errorf(err.Error())
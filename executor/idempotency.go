@@ -0,0 +1,96 @@
+package executor
+
+import (
+	"container/list"
+	"github.com/mngharbi/DMPC/status"
+	"sync"
+)
+
+/*
+	Default number of idempotency keys remembered
+*/
+const defaultIdempotencyCacheSize int = 1024
+
+/*
+	Bounded LRU mapping (issuer id, idempotency key) to the ticket originally generated
+*/
+type idempotencyCacheKey struct {
+	issuerId       string
+	idempotencyKey string
+}
+
+type idempotencyCacheEntry struct {
+	key    idempotencyCacheKey
+	ticket status.Ticket
+	failed bool
+	queued chan bool // Closed once the original request was queued (or failed to be)
+}
+
+type idempotencyCache struct {
+	capacity int
+	entries  map[idempotencyCacheKey]*list.Element
+	order    *list.List
+	lock     *sync.Mutex
+}
+
+func newIdempotencyCache(capacity int) *idempotencyCache {
+	if capacity <= 0 {
+		capacity = defaultIdempotencyCacheSize
+	}
+	return &idempotencyCache{
+		capacity: capacity,
+		entries:  map[idempotencyCacheKey]*list.Element{},
+		order:    list.New(),
+		lock:     &sync.Mutex{},
+	}
+}
+
+/*
+	Returns entry mapped to key if any (marks it as most recently used)
+	Cache lock should be held
+*/
+func (cache *idempotencyCache) get(key idempotencyCacheKey) (*idempotencyCacheEntry, bool) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*idempotencyCacheEntry), true
+}
+
+/*
+	Maps key to a pending entry for a request about to be queued,
+	and evicts least recently used entry if over capacity
+	Cache lock should be held
+*/
+func (cache *idempotencyCache) reserve(key idempotencyCacheKey) *idempotencyCacheEntry {
+	entry := &idempotencyCacheEntry{
+		key:    key,
+		queued: make(chan bool),
+	}
+	cache.entries[key] = cache.order.PushFront(entry)
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*idempotencyCacheEntry).key)
+	}
+	return entry
+}
+
+/*
+	Completes pending entry with the ticket of the queued request,
+	or removes it if the request failed to be queued (only requests queued successfully are remembered)
+	Cache lock should be held
+*/
+func (cache *idempotencyCache) complete(entry *idempotencyCacheEntry, ticket status.Ticket, err error) {
+	if err != nil {
+		entry.failed = true
+		if element, ok := cache.entries[entry.key]; ok && element.Value == entry {
+			cache.order.Remove(element)
+			delete(cache.entries, entry.key)
+		}
+	} else {
+		entry.ticket = ticket
+	}
+	close(entry.queued)
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"errors"
+	"github.com/mngharbi/DMPC/status"
+	"testing"
+)
+
+func TestIdempotencyCacheEviction(t *testing.T) {
+	cache := newIdempotencyCache(2)
+	keyA := idempotencyCacheKey{issuerId: genericIssuerId, idempotencyKey: "A"}
+	keyB := idempotencyCacheKey{issuerId: genericIssuerId, idempotencyKey: "B"}
+	keyC := idempotencyCacheKey{issuerId: genericIssuerId, idempotencyKey: "C"}
+
+	cache.complete(cache.reserve(keyA), status.Ticket("TICKET_A"), nil)
+	cache.complete(cache.reserve(keyB), status.Ticket("TICKET_B"), nil)
+
+	// Using A makes B the least recently used
+	if entry, ok := cache.get(keyA); !ok || entry.ticket != "TICKET_A" {
+		t.Errorf("Cached ticket should be returned. entry=%+v", entry)
+	}
+	cache.complete(cache.reserve(keyC), status.Ticket("TICKET_C"), nil)
+
+	if _, ok := cache.get(keyB); ok {
+		t.Error("Least recently used key should be evicted.")
+	}
+	for _, key := range []idempotencyCacheKey{keyA, keyC} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("Recently used key should not be evicted. key=%v", key)
+		}
+	}
+}
+
+func TestIdempotencyCachePendingEntries(t *testing.T) {
+	cache := newIdempotencyCache(2)
+	key := idempotencyCacheKey{issuerId: genericIssuerId, idempotencyKey: "A"}
+
+	// Pending entry is visible before its request is queued
+	pending := cache.reserve(key)
+	if entry, ok := cache.get(key); !ok || entry != pending {
+		t.Errorf("Pending entry should be returned. entry=%+v", entry)
+	}
+	select {
+	case <-pending.queued:
+		t.Error("Pending entry should not be marked queued.")
+	default:
+	}
+
+	// Entry of request that failed to be queued is removed
+	cache.complete(pending, "", errors.New("QUEUE_FULL"))
+	<-pending.queued
+	if _, ok := cache.get(key); ok || !pending.failed {
+		t.Errorf("Entry of failed request should be removed. entry=%+v", pending)
+	}
+
+	// Entry of queued request keeps its ticket
+	queued := cache.reserve(key)
+	cache.complete(queued, status.Ticket("TICKET_A"), nil)
+	<-queued.queued
+	if entry, ok := cache.get(key); !ok || entry.failed || entry.ticket != "TICKET_A" {
+		t.Errorf("Entry of queued request should be kept. entry=%+v", entry)
+	}
+}
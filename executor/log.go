@@ -1,11 +1,35 @@
 package executor
 
+/*
+	Structured logging fields
+*/
+const ticketLogField string = "ticket"
+
 /*
 	Logging messages
 */
 const (
-	daemonStartLogMsg     string = "Executor daemon started"
-	daemonShutdownLogMsg  string = "Executor daemon shutdown"
-	receivedRequestLogMsg string = "Executor received request"
-	runningRequestLogMsg  string = "Executor running request"
+	daemonStartLogMsg            string = "Executor daemon started"
+	daemonShutdownLogMsg         string = "Executor daemon shutdown"
+	receivedRequestLogMsg        string = "Executor received request"
+	runningRequestLogMsg         string = "Executor running request"
+	duplicateRequestLogMsg       string = "Executor received duplicate request"
+	resizingWorkersLogMsg        string = "Executor resizing worker pool to %v workers"
+	timedOutRequestLogMsg        string = "Executor request timed out"
+	abandonedRequestLogMsg       string = "Executor request abandoned during shutdown"
+	outsideValidityWindowLogMsg  string = "Executor request outside its validity window"
+	retryingRequestLogMsg        string = "Executor retrying request after %v failed attempts"
+	payloadTooLargeLogMsg        string = "Executor request payload too large"
+	queueFullLogMsg              string = "Executor queue full, request rejected"
+	signersPolicyLogMsg          string = "Executor request signers do not satisfy request type policy"
+	uploadChunkReceivedLogMsg    string = "Executor received chunk %v of upload %v"
+	uploadCompleteLogMsg         string = "Executor dispatching reassembled upload %v"
+	uploadDiscardedLogMsg        string = "Executor discarded incomplete upload %v"
+	dryRunUnsupportedLogMsg      string = "Executor dry run not supported for request"
+	replayLogAppendFailedLogMsg  string = "Executor failed to append operation to replay log: %v"
+	replayedOperationLogMsg      string = "Executor replayed logged operation %v"
+	statusReportFailedLogMsg     string = "Executor failed to report status %v: %v"
+	unknownRequestTypeLogMsg     string = "Executor request type has no registered handler"
+	usersUnavailableLogMsg       string = "Executor request requires unavailable users subsystem"
+	batchOperationRejectedLogMsg string = "Executor rejected batch operation: %v"
 )
@@ -9,7 +9,8 @@ import (
 	Request types
 */
 const (
-	UsersRequest = iota
+	UsersRequest    = core.UsersRequestType
+	ChannelsRequest = core.ChannelsRequestType
 )
 
 /*
@@ -22,11 +23,46 @@ type executorRequest struct {
 	ticket          status.Ticket
 	request         []byte
 	failedOperation *core.Operation
+	priority        int
+	validity        core.ValidityWindow
+	dryRun          bool
+	attempts        int          // Failed attempts retried so far
+	commit          *commitGuard // Guard of the current attempt (set when it starts running)
+
+	// Logs with the ticket of the request (created at ingest)
+	log *core.LoggingHandler
+}
+
+/*
+	Logging handler for the request (falls back to the executor one if none was created)
+*/
+func (rq *executorRequest) logger() *core.LoggingHandler {
+	if rq.log == nil {
+		return log
+	}
+	return rq.log
 }
 
 /*
-	Utilities
+	Operation requested (failed operations are kept as is,
+	and decrypted ones are rebuilt with their plaintext payload)
 */
-func isValidRequestType(requestType core.RequestType) bool {
-	return core.UsersRequestType <= requestType && requestType <= core.AddMessageType
+func (rq *executorRequest) operation() *core.Operation {
+	if rq.failedOperation != nil {
+		return rq.failedOperation
+	}
+	op := &core.Operation{
+		Meta: core.OperationMetaFields{
+			RequestType:    rq.requestType,
+			Priority:       rq.priority,
+			ValidityWindow: rq.validity,
+			DryRun:         rq.dryRun,
+		},
+		Payload: core.Base64EncodeToString(rq.request),
+	}
+	if rq.signers != nil {
+		op.Issue.Id = rq.signers.IssuerId
+		op.Certification.Id = rq.signers.CertifierId
+	}
+	return op
 }
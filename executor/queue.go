@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"container/heap"
+	"sync"
+)
+
+/*
+	Queue of requests waiting for a worker
+	(higher priorities are dispatched first, and equal priorities in submission order)
+*/
+type requestQueueItem struct {
+	request  *executorRequest
+	sequence uint64
+	index    int
+}
+
+type requestHeap []*requestQueueItem
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].request.priority != h[j].request.priority {
+		return h[i].request.priority > h[j].request.priority
+	}
+	return h[i].sequence < h[j].sequence
+}
+
+func (h requestHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *requestHeap) Push(x interface{}) {
+	item := x.(*requestQueueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+/*
+	Bounded queue of requests (unbounded if capacity is zero)
+*/
+type requestQueue struct {
+	items        requestHeap
+	nextSequence uint64
+	capacity     int
+	lock         *sync.Mutex
+	notFull      *sync.Cond
+}
+
+func newRequestQueue(capacity int) *requestQueue {
+	lock := &sync.Mutex{}
+	return &requestQueue{
+		items:    requestHeap{},
+		capacity: capacity,
+		lock:     lock,
+		notFull:  sync.NewCond(lock),
+	}
+}
+
+func (queue *requestQueue) isFull() bool {
+	return queue.capacity > 0 && len(queue.items) >= queue.capacity
+}
+
+/*
+	Queues request (waits for room if the queue is full)
+*/
+func (queue *requestQueue) push(request *executorRequest) *requestQueueItem {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	for queue.isFull() {
+		queue.notFull.Wait()
+	}
+	return queue.pushLocked(request)
+}
+
+/*
+	Queues request only if the queue has room (returns false otherwise)
+*/
+func (queue *requestQueue) tryPush(request *executorRequest) (*requestQueueItem, bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if queue.isFull() {
+		return nil, false
+	}
+	return queue.pushLocked(request), true
+}
+
+func (queue *requestQueue) pushLocked(request *executorRequest) *requestQueueItem {
+	item := &requestQueueItem{
+		request:  request,
+		sequence: queue.nextSequence,
+	}
+	queue.nextSequence++
+	heap.Push(&queue.items, item)
+	return item
+}
+
+/*
+	Removes item if still queued (used when the request couldn't be dispatched)
+*/
+func (queue *requestQueue) remove(item *requestQueueItem) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if item.index >= 0 {
+		heap.Remove(&queue.items, item.index)
+		queue.notFull.Signal()
+	}
+}
+
+func (queue *requestQueue) pop() *executorRequest {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.items) == 0 {
+		return nil
+	}
+	queue.notFull.Signal()
+	return heap.Pop(&queue.items).(*requestQueueItem).request
+}
+
+func (queue *requestQueue) len() int {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	return len(queue.items)
+}
@@ -0,0 +1,28 @@
+package executor
+
+import (
+	"testing"
+)
+
+func TestRequestQueueRemove(t *testing.T) {
+	queue := newRequestQueue(0)
+	first := &executorRequest{priority: 0}
+	second := &executorRequest{priority: 2}
+	third := &executorRequest{priority: 1}
+	queue.push(first)
+	secondItem := queue.push(second)
+	queue.push(third)
+
+	queue.remove(secondItem)
+	queue.remove(secondItem)
+
+	if popped := queue.pop(); popped != third {
+		t.Errorf("Highest priority remaining request should be popped. found=%+v", popped)
+	}
+	if popped := queue.pop(); popped != first {
+		t.Errorf("Lowest priority request should be popped last. found=%+v", popped)
+	}
+	if popped := queue.pop(); popped != nil {
+		t.Errorf("Empty queue should return nil. found=%+v", popped)
+	}
+}
@@ -0,0 +1,184 @@
+/*
+	Append-only log of executed operations for audit and recovery
+*/
+
+package executor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/status"
+	"sync"
+)
+
+/*
+	Log of operations in the order they were executed
+	(iteration stops at the first error returned by the callback)
+*/
+type ReplayLog interface {
+	Append(*ReplayEntry) error
+	Iterate(func(*ReplayEntry) error) error
+}
+
+/*
+	Operation executed, with the signers it ran as and whether they were verified
+	(authenticated by a MAC under the replay key since nothing else in the entry is trusted)
+*/
+type ReplayEntry struct {
+	Operation *core.Operation       `json:"operation"`
+	Verified  bool                  `json:"verified"`
+	Signers   *core.VerifiedSigners `json:"signers"`
+	Mac       []byte                `json:"mac"`
+}
+
+/*
+	Errors
+*/
+var (
+	replayKeyMissingError     error = errors.New("Replay log requires a replay key.")
+	replayEntryIntegrityError error = errors.New("Replay log entry failed integrity check.")
+)
+
+/*
+	MAC of the entry fields (HMAC-SHA256 of their canonical JSON encoding)
+*/
+func (entry *ReplayEntry) computeMac(key []byte) ([]byte, error) {
+	encoded, err := core.CanonicalJSON(ReplayEntry{
+		Operation: entry.Operation,
+		Verified:  entry.Verified,
+		Signers:   entry.Signers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encoded)
+	return mac.Sum(nil), nil
+}
+
+func (entry *ReplayEntry) sign(key []byte) error {
+	mac, err := entry.computeMac(key)
+	if err != nil {
+		return err
+	}
+	entry.Mac = mac
+	return nil
+}
+
+func (entry *ReplayEntry) checkMac(key []byte) bool {
+	mac, err := entry.computeMac(key)
+	return err == nil && hmac.Equal(mac, entry.Mac)
+}
+
+/*
+	Error returned when replaying the log fails
+*/
+type ReplayError struct {
+	Index int
+	Errs  []error
+}
+
+func (err *ReplayError) Error() string {
+	return fmt.Sprintf("Replay of logged operation %v failed: %v", err.Index, err.Errs)
+}
+
+/*
+	Replay log kept in memory
+*/
+type MemoryReplayLog struct {
+	entries []*ReplayEntry
+	lock    *sync.Mutex
+}
+
+func NewMemoryReplayLog() *MemoryReplayLog {
+	return &MemoryReplayLog{
+		entries: []*ReplayEntry{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+func (replayLog *MemoryReplayLog) Append(entry *ReplayEntry) error {
+	replayLog.lock.Lock()
+	defer replayLog.lock.Unlock()
+	replayLog.entries = append(replayLog.entries, entry)
+	return nil
+}
+
+func (replayLog *MemoryReplayLog) Iterate(callback func(*ReplayEntry) error) error {
+	replayLog.lock.Lock()
+	entries := append([]*ReplayEntry{}, replayLog.entries...)
+	replayLog.lock.Unlock()
+	for _, entry := range entries {
+		if err := callback(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Appends operation that was executed successfully (dry runs are never logged)
+	(appends are serialized so the log follows the order in which executions completed)
+*/
+func (sv *server) appendToReplayLog(wrappedRequest *executorRequest) {
+	if sv.replayLog == nil || wrappedRequest.dryRun {
+		return
+	}
+	entry := &ReplayEntry{
+		Operation: wrappedRequest.operation(),
+		Verified:  wrappedRequest.isVerified,
+		Signers:   wrappedRequest.signers,
+	}
+	if err := entry.sign(sv.replayKey); err != nil {
+		wrappedRequest.logger().Errorf(replayLogAppendFailedLogMsg, err)
+		return
+	}
+	sv.replayLock.Lock()
+	defer sv.replayLock.Unlock()
+	if err := sv.replayLog.Append(entry); err != nil {
+		wrappedRequest.logger().Errorf(replayLogAppendFailedLogMsg, err)
+	}
+}
+
+/*
+	Runs every logged operation in order, as it was originally run and without reporting tickets
+	(stops at the first entry failing its integrity check or operation that fails)
+*/
+func (sv *server) replay() error {
+	index := 0
+	return sv.replayLog.Iterate(func(entry *ReplayEntry) error {
+		defer (func() { index++ })()
+
+		if entry.Operation == nil || !entry.checkMac(sv.replayKey) {
+			return &ReplayError{Index: index, Errs: []error{replayEntryIntegrityError}}
+		}
+		operation := entry.Operation
+		request, err := core.Base64DecodeString(operation.Payload)
+		if err != nil {
+			return &ReplayError{Index: index, Errs: []error{err}}
+		}
+		wrappedRequest := &executorRequest{
+			isVerified:  entry.Verified,
+			requestType: operation.Meta.RequestType,
+			signers:     entry.Signers,
+			request:     request,
+		}
+
+		handler, ok := lookupRequestHandler(wrappedRequest.requestType)
+		if !ok {
+			return &ReplayError{Index: index, Errs: []error{invalidRequestTypeError}}
+		}
+		if sv.isUsersUnavailable(wrappedRequest.requestType) {
+			return &ReplayError{Index: index, Errs: []error{usersUnavailableError}}
+		}
+		if res := handler(context.Background(), sv, wrappedRequest); res.status != status.SuccessStatus {
+			return &ReplayError{Index: index, Errs: res.errs}
+		}
+		log.Debugf(replayedOperationLogMsg, index)
+		return nil
+	})
+}
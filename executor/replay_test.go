@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/users"
+	"reflect"
+	"testing"
+)
+
+var testReplayKey []byte = []byte("REPLAY_KEY")
+
+func TestReplayLog(t *testing.T) {
+	replayLog := NewMemoryReplayLog()
+	usersRequester, executed, lock := createOrderRecordingUsersRequesterFunctor("", 0)
+	usersRequesterUnverified, executedUnverified, unverifiedLock := createOrderRecordingUsersRequesterFunctor("", 0)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	conf := Config{NumWorkers: 1, ReplayLog: replayLog, ReplayKey: testReplayKey}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	// Execute sequence (dry run is rejected and not logged)
+	for _, payload := range []string{"FIRST", "SECOND", "THIRD"} {
+		MakeRequest(true, UsersRequest, generateGenericSigners(), []byte(payload), nil, RequestOptions{})
+	}
+	MakeRequest(false, UsersRequest, generateGenericSigners(), []byte("UNVERIFIED"), nil, RequestOptions{})
+	MakeRequest(true, UsersRequest, generateGenericSigners(), []byte("DRY_RUN"), nil, RequestOptions{DryRun: true})
+	ShutdownServer()
+
+	logged := []*ReplayEntry{}
+	replayLog.Iterate(func(entry *ReplayEntry) error {
+		logged = append(logged, entry)
+		return nil
+	})
+	if len(logged) != 4 ||
+		!logged[0].Verified ||
+		!reflect.DeepEqual(logged[0].Signers, generateGenericSigners()) ||
+		logged[0].Operation.Issue.Id != genericIssuerId ||
+		logged[3].Verified {
+		t.Fatalf("Executed operations should be logged with their signers and verification. logged=%+v", logged)
+	}
+
+	// Replay into fresh state (operations run through the same path as originally)
+	replayRequester, replayed, replayLock := createOrderRecordingUsersRequesterFunctor("", 0)
+	replayRequesterUnverified, replayedUnverified, replayUnverifiedLock := createOrderRecordingUsersRequesterFunctor("", 0)
+	conf.ReplayOnStart = true
+	if !resetAndStartServer(t, conf, replayRequester, replayRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+	ShutdownServer()
+
+	lock.Lock()
+	replayLock.Lock()
+	if !reflect.DeepEqual(*replayed, *executed) {
+		t.Errorf("Replaying should rebuild the same state. found=%v, expected=%v", *replayed, *executed)
+	}
+	replayLock.Unlock()
+	lock.Unlock()
+	unverifiedLock.Lock()
+	replayUnverifiedLock.Lock()
+	if !reflect.DeepEqual(*replayedUnverified, *executedUnverified) {
+		t.Errorf("Unverified operations should be replayed unverified. found=%v, expected=%v", *replayedUnverified, *executedUnverified)
+	}
+	replayUnverifiedLock.Unlock()
+	unverifiedLock.Unlock()
+
+	// Replayed operations are not logged again
+	count := 0
+	replayLog.Iterate(func(*ReplayEntry) error {
+		count++
+		return nil
+	})
+	if count != 4 {
+		t.Errorf("Replaying should not append to the log. count=%v", count)
+	}
+}
+
+func TestReplayLogFailure(t *testing.T) {
+	makeEntry := func(payload string) *ReplayEntry {
+		entry := &ReplayEntry{
+			Operation: &core.Operation{
+				Meta:    core.OperationMetaFields{RequestType: core.UsersRequestType},
+				Payload: payload,
+			},
+			Signers: generateGenericSigners(),
+		}
+		entry.sign(testReplayKey)
+		return entry
+	}
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, _ := createDummyResposeReporterFunctor(true)
+	startReplaying := func(replayLog ReplayLog, replayKey []byte) error {
+		serverSingleton = server{}
+		InitializeServer(usersRequester, usersRequester, nil, responseReporter, nil, createDummyTicketGeneratorFunctor(), log, shutdownProgram)
+		return StartServer(Config{NumWorkers: 1, ReplayLog: replayLog, ReplayKey: replayKey, ReplayOnStart: true})
+	}
+
+	// Operation that can't be decoded
+	replayLog := NewMemoryReplayLog()
+	replayLog.Append(makeEntry(core.Base64EncodeToString([]byte("VALID"))))
+	replayLog.Append(makeEntry("%INVALID_BASE64%"))
+	err := startReplaying(replayLog, testReplayKey)
+	if replayErr, ok := err.(*ReplayError); !ok || replayErr.Index != 1 {
+		t.Errorf("Replay should fail at the invalid operation. err=%v", err)
+	}
+
+	// Entries altered after being logged, or logged under another key
+	replayLog = NewMemoryReplayLog()
+	replayLog.Append(makeEntry(core.Base64EncodeToString([]byte("VALID"))))
+	elevated := makeEntry(core.Base64EncodeToString([]byte("VALID")))
+	elevated.Verified = true
+	replayLog.Append(elevated)
+	err = startReplaying(replayLog, testReplayKey)
+	if replayErr, ok := err.(*ReplayError); !ok || replayErr.Index != 1 || !reflect.DeepEqual(replayErr.Errs, []error{replayEntryIntegrityError}) {
+		t.Errorf("Replay should fail at the altered entry. err=%v", err)
+	}
+	err = startReplaying(replayLog, []byte("OTHER_KEY"))
+	if replayErr, ok := err.(*ReplayError); !ok || replayErr.Index != 0 || !reflect.DeepEqual(replayErr.Errs, []error{replayEntryIntegrityError}) {
+		t.Errorf("Replay should fail with another key. err=%v", err)
+	}
+
+	// Replay log without key
+	if err = startReplaying(replayLog, nil); err != replayKeyMissingError {
+		t.Errorf("Replay log should require a key. err=%v", err)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+	Verification of operation signers against user records
+*/
+
+package executor
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/users"
+)
+
+/*
+	Errors
+*/
+var (
+	signersRequestError       error = errors.New("Unable to request signer records.")
+	issuerUnknownError        error = errors.New("Issuer unknown.")
+	certifierUnknownError     error = errors.New("Certifier unknown.")
+	issuerInactiveError       error = errors.New("Issuer is inactive.")
+	certifierInactiveError    error = errors.New("Certifier is inactive.")
+	issuerKeyRevokedError     error = errors.New("Issuer signing key is revoked.")
+	certifierKeyRevokedError  error = errors.New("Certifier signing key is revoked.")
+	issuerKeyExpiredError     error = errors.New("Issuer signing key is expired.")
+	certifierKeyExpiredError  error = errors.New("Certifier signing key is expired.")
+	certifierMissingError     error = errors.New("Certifier signature is required for this request type.")
+	issuerSignatureError      error = errors.New("Issuer signature verification failed.")
+	certifierSignatureError   error = errors.New("Certifier signature verification failed.")
+	certifierNotDistinctError error = errors.New("Certifier should be distinct from the issuer for this request type.")
+)
+
+/*
+	Request types that can be certified by the issuer alone
+	(request types not in the policy require a certifier)
+*/
+type CertifierPolicy map[core.RequestType]bool
+
+var DefaultCertifierPolicy CertifierPolicy = CertifierPolicy{}
+
+func (policy CertifierPolicy) requiresCertifier(requestType core.RequestType) bool {
+	return !policy[requestType]
+}
+
+/*
+	Signer requirements of a request type checked before running operations
+	(dual control operations require a certifier distinct from the issuer)
+*/
+type RequestTypePolicy struct {
+	RequireDistinctCertifier bool
+}
+
+type RequestTypePolicies map[core.RequestType]RequestTypePolicy
+
+/*
+	Checks signers satisfy the policy of the request type (requests without signers are not checked)
+*/
+func (policies RequestTypePolicies) checkSigners(requestType core.RequestType, signers *core.VerifiedSigners) error {
+	if signers == nil {
+		return nil
+	}
+	if policies[requestType].RequireDistinctCertifier && signers.IssuerId == signers.CertifierId {
+		return certifierNotDistinctError
+	}
+	return nil
+}
+
+/*
+	Resolves issuer and certifier records, and verifies their signatures of the plaintext payload
+	(fails with the context error if the context is done before the records are resolved)
+*/
+func VerifySigners(
+	ctx context.Context,
+	operation *core.Operation,
+	payload []byte,
+	usersRequester users.Requester,
+) (*core.VerifiedSigners, error) {
+	return VerifySignersWithPolicy(ctx, operation, payload, usersRequester, DefaultCertifierPolicy)
+}
+
+/*
+	Same as VerifySigners, operations without a certifier signature are certified by their issuer
+	if the policy allows it for their request type
+*/
+func VerifySignersWithPolicy(
+	ctx context.Context,
+	operation *core.Operation,
+	payload []byte,
+	usersRequester users.Requester,
+	policy CertifierPolicy,
+) (*core.VerifiedSigners, error) {
+	// Check certifier is present if required
+	if !operation.HasCertifier() && policy.requiresCertifier(operation.Meta.RequestType) {
+		return nil, certifierMissingError
+	}
+
+	// Get issuer signing keys and verify signature
+	issuerKeys, err := getActiveSigningKeys(ctx, operation.Issue.Id, usersRequester, issuerUnknownError, issuerInactiveError, issuerKeyRevokedError, issuerKeyExpiredError)
+	if err != nil {
+		return nil, err
+	}
+	if !verifiesWithAnyKey(issuerKeys, operation.VerifyIssuer, payload) {
+		return nil, issuerSignatureError
+	}
+
+	// Issuer certifies the operation if there is no certifier
+	if !operation.HasCertifier() {
+		return &core.VerifiedSigners{
+			IssuerId:    operation.Issue.Id,
+			CertifierId: operation.Issue.Id,
+		}, nil
+	}
+
+	// Get certifier signing keys and verify signature
+	certifierKeys, err := getActiveSigningKeys(ctx, operation.Certification.Id, usersRequester, certifierUnknownError, certifierInactiveError, certifierKeyRevokedError, certifierKeyExpiredError)
+	if err != nil {
+		return nil, err
+	}
+	if !verifiesWithAnyKey(certifierKeys, operation.VerifyCertifier, payload) {
+		return nil, certifierSignatureError
+	}
+
+	return &core.VerifiedSigners{
+		IssuerId:    operation.Issue.Id,
+		CertifierId: operation.Certification.Id,
+	}, nil
+}
+
+/*
+	Determines if the signature verifies with any of the keys
+*/
+func verifiesWithAnyKey(keys []crypto.PublicKey, verify func(crypto.PublicKey, []byte) error, payload []byte) bool {
+	for _, key := range keys {
+		if verify(key, payload) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	Signing keys of an active signer (including the key replaced by the last rotation while retained)
+*/
+func getActiveSigningKeys(
+	ctx context.Context,
+	userId string,
+	usersRequester users.Requester,
+	unknownError error,
+	inactiveError error,
+	revokedError error,
+	expiredError error,
+) ([]crypto.PublicKey, error) {
+	// Make read request for user
+	request := &users.UserRequest{
+		Type:   users.ReadRequest,
+		Fields: []string{userId},
+	}
+	requestEncoded, _ := request.Encode()
+	userResponsePtr, ok, errs := usersRequester.RequestWithContext(ctx, nil, requestEncoded)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) != 0 || !ok || userResponsePtr == nil {
+		return nil, signersRequestError
+	}
+	if userResponsePtr.Result != users.Success || len(userResponsePtr.Data) != 1 {
+		return nil, unknownError
+	}
+
+	// Check user is active, signing key is not revoked or expired, and parse signing keys
+	userObject := userResponsePtr.Data[0]
+	if !userObject.Active {
+		return nil, inactiveError
+	}
+	if userObject.SignKeyRevoked {
+		return nil, revokedError
+	}
+	now := serverSingleton.now()
+	if userObject.IsSignKeyExpired(now) {
+		return nil, expiredError
+	}
+	signKeys, err := userObject.VerifyingSignKeys(now)
+	if err != nil {
+		return nil, unknownError
+	}
+	return signKeys, nil
+}
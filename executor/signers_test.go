@@ -0,0 +1,264 @@
+package executor
+
+import (
+	"context"
+	"crypto/rsa"
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/users"
+	"testing"
+	"time"
+)
+
+/*
+	Test helpers
+*/
+
+func createUserObject(id string, signKey *rsa.PublicKey, active bool) *users.UserObject {
+	signKeyEncoded, _ := core.PublicAsymKeyToString(signKey)
+	return &users.UserObject{
+		Id:      id,
+		SignKey: signKeyEncoded,
+		Active:  active,
+	}
+}
+
+func createDummyUsersReaderFunctor(userObjects map[string]*users.UserObject) users.Requester {
+	return func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		var rq users.UserRequest
+		rq.Decode(request)
+		response := &users.UserResponse{
+			Result: users.Success,
+		}
+		for _, userId := range rq.Fields {
+			userObject, ok := userObjects[userId]
+			if !ok {
+				response = &users.UserResponse{
+					Result: users.SubjectUnknownError,
+				}
+				break
+			}
+			response.Data = append(response.Data, *userObject)
+		}
+		responseChannel := make(chan *users.UserResponse, 1)
+		responseChannel <- response
+		return responseChannel, nil
+	}
+}
+
+func createSignedOperation(payload []byte, issuerKey *rsa.PrivateKey, certifierKey *rsa.PrivateKey) *core.Operation {
+	issuerSignature, _ := core.Sign(issuerKey, core.Hash(payload))
+	certifierSignature, _ := core.Sign(certifierKey, core.Hash(payload))
+	return core.GenerateOperation(
+		false,
+		"",
+		[]byte{},
+		false,
+		genericIssuerId,
+		issuerSignature,
+		false,
+		genericCertifierId,
+		certifierSignature,
+		false,
+		core.UsersRequestType,
+		payload,
+		false,
+	)
+}
+
+/*
+	Tests
+*/
+
+func TestVerifySigners(t *testing.T) {
+	payload := []byte("PAYLOAD")
+	issuerKey := core.GeneratePrivateKey()
+	certifierKey := core.GeneratePrivateKey()
+	operation := createSignedOperation(payload, issuerKey, certifierKey)
+
+	// Valid signers
+	userObjects := map[string]*users.UserObject{
+		genericIssuerId:    createUserObject(genericIssuerId, &issuerKey.PublicKey, true),
+		genericCertifierId: createUserObject(genericCertifierId, &certifierKey.PublicKey, true),
+	}
+	signers, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects))
+	if err != nil || *signers != *generateGenericSigners() {
+		t.Errorf("Signers verification should succeed with valid signatures. signers=%v, err=%v", signers, err)
+	}
+
+	// Unknown issuer
+	delete(userObjects, genericIssuerId)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != issuerUnknownError {
+		t.Errorf("Signers verification should fail with unknown issuer. err=%v", err)
+	}
+	userObjects[genericIssuerId] = createUserObject(genericIssuerId, &issuerKey.PublicKey, true)
+
+	// Revoked (inactive) certifier
+	userObjects[genericCertifierId] = createUserObject(genericCertifierId, &certifierKey.PublicKey, false)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != certifierInactiveError {
+		t.Errorf("Signers verification should fail with inactive certifier. err=%v", err)
+	}
+	userObjects[genericCertifierId] = createUserObject(genericCertifierId, &certifierKey.PublicKey, true)
+
+	// Revoked certifier signing key
+	userObjects[genericCertifierId].SignKeyRevoked = true
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != certifierKeyRevokedError {
+		t.Errorf("Signers verification should fail with revoked certifier key. err=%v", err)
+	}
+	userObjects[genericCertifierId].SignKeyRevoked = false
+
+	// Certifier signing key not expired yet
+	userObjects[genericCertifierId].SignKeyExpiresAt = time.Now().Add(time.Hour)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != nil {
+		t.Errorf("Signers verification should succeed with non expired certifier key. err=%v", err)
+	}
+
+	// Expired certifier signing key
+	userObjects[genericCertifierId].SignKeyExpiresAt = time.Now().Add(-time.Hour)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != certifierKeyExpiredError {
+		t.Errorf("Signers verification should fail with expired certifier key. err=%v", err)
+	}
+
+	// Expired issuer signing key
+	userObjects[genericCertifierId].SignKeyExpiresAt = time.Time{}
+	userObjects[genericIssuerId].SignKeyExpiresAt = time.Now().Add(-time.Hour)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != issuerKeyExpiredError {
+		t.Errorf("Signers verification should fail with expired issuer key. err=%v", err)
+	}
+	userObjects[genericIssuerId].SignKeyExpiresAt = time.Time{}
+
+	// Valid signature with an out of date issuer key
+	userObjects[genericIssuerId] = createUserObject(genericIssuerId, core.GeneratePublicKey(), true)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != issuerSignatureError {
+		t.Errorf("Signers verification should fail with out of date issuer key. err=%v", err)
+	}
+	userObjects[genericIssuerId] = createUserObject(genericIssuerId, &issuerKey.PublicKey, true)
+
+	// Valid signature with an out of date certifier key
+	userObjects[genericCertifierId] = createUserObject(genericCertifierId, core.GeneratePublicKey(), true)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != certifierSignatureError {
+		t.Errorf("Signers verification should fail with out of date certifier key. err=%v", err)
+	}
+}
+
+func TestVerifySignersCertifierPolicy(t *testing.T) {
+	payload := []byte("PAYLOAD")
+	issuerKey := core.GeneratePrivateKey()
+	operation := createSignedOperation(payload, issuerKey, core.GeneratePrivateKey())
+	operation.Certification.Signature = ""
+	userObjects := map[string]*users.UserObject{
+		genericIssuerId: createUserObject(genericIssuerId, &issuerKey.PublicKey, true),
+	}
+
+	// Certifier required and missing
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != certifierMissingError {
+		t.Errorf("Signers verification should fail without required certifier. err=%v", err)
+	}
+
+	// Self-certified request type
+	policy := CertifierPolicy{core.UsersRequestType: true}
+	signers, err := VerifySignersWithPolicy(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects), policy)
+	if err != nil || signers.IssuerId != genericIssuerId || signers.CertifierId != genericIssuerId {
+		t.Errorf("Signers verification should succeed for self-certified request type. signers=%v, err=%v", signers, err)
+	}
+
+	// Issuer signature is still verified
+	userObjects[genericIssuerId] = createUserObject(genericIssuerId, core.GeneratePublicKey(), true)
+	if _, err := VerifySignersWithPolicy(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects), policy); err != issuerSignatureError {
+		t.Errorf("Signers verification should fail with invalid issuer signature for self-certified request type. err=%v", err)
+	}
+}
+
+func TestVerifySignersRotatedKey(t *testing.T) {
+	payload := []byte("PAYLOAD")
+	oldIssuerKey := core.GeneratePrivateKey()
+	certifierKey := core.GeneratePrivateKey()
+
+	// Operation signed right before the issuer rotated keys
+	operation := createSignedOperation(payload, oldIssuerKey, certifierKey)
+	issuerObject := createUserObject(genericIssuerId, core.GeneratePublicKey(), true)
+	issuerObject.PreviousSignKey, _ = core.PublicAsymKeyToString(&oldIssuerKey.PublicKey)
+	issuerObject.PreviousSignKeyRevokedAt = time.Now()
+	userObjects := map[string]*users.UserObject{
+		genericIssuerId:    issuerObject,
+		genericCertifierId: createUserObject(genericCertifierId, &certifierKey.PublicKey, true),
+	}
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != nil {
+		t.Errorf("Signers verification should succeed with the retained previous key. err=%v", err)
+	}
+
+	// Previous key no longer retained
+	issuerObject.PreviousSignKeyRevokedAt = time.Now().Add(-time.Hour)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != issuerSignatureError {
+		t.Errorf("Signers verification should fail once the previous key is no longer retained. err=%v", err)
+	}
+}
+
+func TestVerifySignersRequestFailure(t *testing.T) {
+	payload := []byte("PAYLOAD")
+	operation := createSignedOperation(payload, core.GeneratePrivateKey(), core.GeneratePrivateKey())
+
+	usersRequester, _ := createDummyUsersRequesterFunctor(users.Success, nil, true)
+	if _, err := VerifySigners(context.Background(), operation, payload, usersRequester); err != signersRequestError {
+		t.Errorf("Signers verification should fail if users channel is closed. err=%v", err)
+	}
+}
+
+func TestVerifySignersCancelled(t *testing.T) {
+	payload := []byte("PAYLOAD")
+	operation := createSignedOperation(payload, core.GeneratePrivateKey(), core.GeneratePrivateKey())
+
+	// Users requester never responding
+	requested := make(chan bool, 1)
+	usersRequester := func(signers *core.VerifiedSigners, request []byte) (chan *users.UserResponse, []error) {
+		requested <- true
+		return make(chan *users.UserResponse), nil
+	}
+
+	// Cancel context mid lookup
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requested
+		cancel()
+	}()
+	result := make(chan error, 1)
+	go func() {
+		_, err := VerifySigners(ctx, operation, payload, usersRequester)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != context.Canceled {
+			t.Errorf("Signers verification should fail with cancellation error. err=%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Signers verification should return promptly once cancelled.")
+	}
+}
+
+func TestVerifySignersClock(t *testing.T) {
+	defer (func(original core.Clock) {
+		serverSingleton.clock = original
+	})(serverSingleton.clock)
+
+	payload := []byte("PAYLOAD")
+	issuerKey := core.GeneratePrivateKey()
+	certifierKey := core.GeneratePrivateKey()
+	operation := createSignedOperation(payload, issuerKey, certifierKey)
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	userObjects := map[string]*users.UserObject{
+		genericIssuerId:    createUserObject(genericIssuerId, &issuerKey.PublicKey, true),
+		genericCertifierId: createUserObject(genericCertifierId, &certifierKey.PublicKey, true),
+	}
+	userObjects[genericIssuerId].SignKeyExpiresAt = start.Add(time.Hour)
+
+	// Key expiry follows the server clock
+	clock := core.NewManualClock(start)
+	serverSingleton.clock = clock
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != nil {
+		t.Errorf("Signers verification should succeed before key expiry. err=%v", err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := VerifySigners(context.Background(), operation, payload, createDummyUsersReaderFunctor(userObjects)); err != issuerKeyExpiredError {
+		t.Errorf("Signers verification should fail once the clock passes key expiry. err=%v", err)
+	}
+}
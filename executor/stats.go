@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"sync/atomic"
+)
+
+/*
+	Snapshot of executor metrics
+*/
+type ServerStats struct {
+	QueueDepth  int
+	BusyWorkers int
+	IdleWorkers int
+	Processed   uint64
+	Failures    uint64
+}
+
+/*
+	Counters updated atomically by workers
+*/
+type serverCounters struct {
+	processed   uint64
+	failures    uint64
+	numWorkers  int32
+	busyWorkers int32
+}
+
+func (counters *serverCounters) startOperation() {
+	atomic.AddInt32(&counters.busyWorkers, 1)
+}
+
+func (counters *serverCounters) finishOperation(failed bool) {
+	atomic.AddUint64(&counters.processed, 1)
+	if failed {
+		atomic.AddUint64(&counters.failures, 1)
+	}
+	atomic.AddInt32(&counters.busyWorkers, -1)
+}
+
+func (sv *server) setNumWorkers(numWorkers int) {
+	atomic.StoreInt32(&sv.stats.numWorkers, int32(numWorkers))
+}
+
+func (sv *server) resetStats() {
+	atomic.StoreInt32(&sv.stats.busyWorkers, 0)
+	atomic.StoreUint64(&sv.stats.processed, 0)
+	atomic.StoreUint64(&sv.stats.failures, 0)
+}
+
+/*
+	Returns current queue depth, worker usage, and totals since the server started
+*/
+func Stats() ServerStats {
+	stats := ServerStats{
+		BusyWorkers: int(atomic.LoadInt32(&serverSingleton.stats.busyWorkers)),
+		Processed:   atomic.LoadUint64(&serverSingleton.stats.processed),
+		Failures:    atomic.LoadUint64(&serverSingleton.stats.failures),
+	}
+	if queue := serverSingleton.requestQueue; queue != nil {
+		stats.QueueDepth = queue.len()
+	}
+	stats.IdleWorkers = int(atomic.LoadInt32(&serverSingleton.stats.numWorkers)) - stats.BusyWorkers
+	if stats.IdleWorkers < 0 {
+		stats.IdleWorkers = 0
+	}
+	return stats
+}
@@ -0,0 +1,187 @@
+/*
+	Chunked uploads of large transactions
+*/
+
+package executor
+
+import (
+	"errors"
+	"github.com/mngharbi/gofarm"
+	"sync"
+	"time"
+)
+
+/*
+	Function dispatching a reassembled encoded transaction
+*/
+type UploadDispatcher func([]byte) (chan *gofarm.Response, []error)
+
+/*
+	Errors
+*/
+var (
+	uploadDispatcherMissingError error = errors.New("Chunked uploads are not enabled.")
+	invalidChunkIndexError       error = errors.New("Chunk index should not be negative.")
+	chunkAfterFinalError         error = errors.New("Chunk index is past the final chunk of the upload.")
+	conflictingFinalChunkError   error = errors.New("Upload already has a different final chunk.")
+)
+
+// Time after which incomplete uploads are discarded (used if not configured)
+const defaultUploadTimeout time.Duration = time.Minute
+
+/*
+	Chunks received for an upload
+	(kept after dispatch until timeout so that late duplicates are ignored)
+*/
+type pendingUpload struct {
+	chunks     map[int][]byte
+	finalIndex int // Negative until the final chunk is received
+	dispatched bool
+	lastChunk  time.Time
+	timer      *time.Timer
+}
+
+func (upload *pendingUpload) isComplete() bool {
+	return upload.finalIndex >= 0 && len(upload.chunks) == upload.finalIndex+1
+}
+
+func (upload *pendingUpload) assemble() []byte {
+	assembled := []byte{}
+	for index := 0; index <= upload.finalIndex; index++ {
+		assembled = append(assembled, upload.chunks[index]...)
+	}
+	return assembled
+}
+
+/*
+	Buffer reassembling uploads keyed by upload id
+	(chunks can arrive in any order, and duplicate chunks are ignored)
+*/
+type uploadAssembler struct {
+	uploads    map[string]*pendingUpload
+	timeout    time.Duration
+	dispatcher UploadDispatcher
+	lock       *sync.Mutex
+}
+
+func newUploadAssembler(timeout time.Duration, dispatcher UploadDispatcher) *uploadAssembler {
+	if timeout <= 0 {
+		timeout = defaultUploadTimeout
+	}
+	return &uploadAssembler{
+		uploads:    map[string]*pendingUpload{},
+		timeout:    timeout,
+		dispatcher: dispatcher,
+		lock:       &sync.Mutex{},
+	}
+}
+
+/*
+	Adds chunk to its upload and dispatches the reassembled transaction once all chunks arrived
+	(returns a nil channel and no errors while the upload is incomplete or for duplicate chunks)
+*/
+func (assembler *uploadAssembler) addChunk(uploadId string, index int, final bool, data []byte) (chan *gofarm.Response, []error) {
+	if assembler.dispatcher == nil {
+		return nil, []error{uploadDispatcherMissingError}
+	}
+	if index < 0 {
+		return nil, []error{invalidChunkIndexError}
+	}
+
+	assembler.lock.Lock()
+	upload, ok := assembler.uploads[uploadId]
+	if !ok {
+		upload = &pendingUpload{
+			chunks:     map[int][]byte{},
+			finalIndex: -1,
+		}
+		upload.timer = time.AfterFunc(assembler.timeout, func() {
+			assembler.discard(uploadId, upload)
+		})
+		assembler.uploads[uploadId] = upload
+	}
+
+	// Ignore duplicates and chunks of uploads already dispatched
+	if _, duplicate := upload.chunks[index]; duplicate || upload.dispatched {
+		assembler.lock.Unlock()
+		return nil, nil
+	}
+
+	// Check chunk is consistent with the final chunk
+	if final && upload.finalIndex >= 0 && upload.finalIndex != index {
+		assembler.lock.Unlock()
+		return nil, []error{conflictingFinalChunkError}
+	}
+	if upload.finalIndex >= 0 && index > upload.finalIndex {
+		assembler.lock.Unlock()
+		return nil, []error{chunkAfterFinalError}
+	}
+	if final {
+		for receivedIndex := range upload.chunks {
+			if receivedIndex > index {
+				assembler.lock.Unlock()
+				return nil, []error{chunkAfterFinalError}
+			}
+		}
+		upload.finalIndex = index
+	}
+
+	// Keep chunk and refresh timeout
+	upload.chunks[index] = append([]byte{}, data...)
+	upload.lastChunk = time.Now()
+	upload.timer.Reset(assembler.timeout)
+	if !upload.isComplete() {
+		log.Debugf(uploadChunkReceivedLogMsg, index, uploadId)
+		assembler.lock.Unlock()
+		return nil, nil
+	}
+
+	// Free chunks before dispatching
+	upload.dispatched = true
+	assembled := upload.assemble()
+	upload.chunks = nil
+	assembler.lock.Unlock()
+
+	log.Debugf(uploadCompleteLogMsg, uploadId)
+	return assembler.dispatcher(assembled)
+}
+
+/*
+	Removes upload after its timeout (unless a new upload replaced it)
+*/
+func (assembler *uploadAssembler) discard(uploadId string, upload *pendingUpload) {
+	assembler.lock.Lock()
+	defer assembler.lock.Unlock()
+	if assembler.uploads[uploadId] != upload || time.Since(upload.lastChunk) < assembler.timeout {
+		return
+	}
+	if !upload.dispatched {
+		log.Debugf(uploadDiscardedLogMsg, uploadId)
+	}
+	delete(assembler.uploads, uploadId)
+}
+
+func (assembler *uploadAssembler) pending() int {
+	assembler.lock.Lock()
+	defer assembler.lock.Unlock()
+	count := 0
+	for _, upload := range assembler.uploads {
+		if !upload.dispatched {
+			count++
+		}
+	}
+	return count
+}
+
+/*
+	Submits a chunk of an encoded transaction
+	(the transaction is dispatched once its final chunk and all preceding ones were received,
+	and incomplete uploads are discarded if no chunk is received before the upload timeout)
+*/
+func SubmitChunk(uploadId string, index int, final bool, data []byte) (chan *gofarm.Response, []error) {
+	assembler := serverSingleton.uploads
+	if assembler == nil {
+		return nil, []error{serverNotRunningError}
+	}
+	return assembler.addChunk(uploadId, index, final, data)
+}
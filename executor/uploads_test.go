@@ -0,0 +1,133 @@
+package executor
+
+import (
+	"github.com/mngharbi/DMPC/core"
+	"github.com/mngharbi/DMPC/status"
+	"github.com/mngharbi/DMPC/users"
+	"github.com/mngharbi/gofarm"
+	"testing"
+	"time"
+)
+
+/*
+	Dispatcher running the payload of reassembled transactions
+*/
+func createRunningUploadDispatcherFunctor(dispatched chan []byte) UploadDispatcher {
+	return func(encoded []byte) (chan *gofarm.Response, []error) {
+		dispatched <- encoded
+		var transaction core.Transaction
+		if err := transaction.Decode(encoded); err != nil {
+			return nil, []error{err}
+		}
+		payload, _ := core.Base64DecodeString(transaction.Payload)
+		ticketId, err := MakeRequest(true, UsersRequest, generateGenericSigners(), payload, nil, RequestOptions{})
+		if err != nil {
+			return nil, []error{err}
+		}
+		responseChannel := make(chan *gofarm.Response, 1)
+		var nativeResponse gofarm.Response = ticketId
+		responseChannel <- &nativeResponse
+		return responseChannel, nil
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	dispatched := make(chan []byte, 2)
+	usersRequester, calls := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	usersRequesterUnverified, _ := createDummyUsersRequesterFunctor(users.Success, nil, false)
+	responseReporter, reg := createDummyResposeReporterFunctor(true)
+	ticketGenerator := createDummyTicketGeneratorFunctor()
+	conf := Config{
+		NumWorkers:       1,
+		UploadDispatcher: createRunningUploadDispatcherFunctor(dispatched),
+	}
+	if !resetAndStartServer(t, conf, usersRequester, usersRequesterUnverified, responseReporter, ticketGenerator) {
+		return
+	}
+
+	transaction := core.GenerateTransaction(true, map[string]string{"CIPHER": "CHALLENGE_CIPHER"}, []byte("NONCE"), false, []byte("UPLOADED_PAYLOAD"), false)
+	encoded, _ := transaction.Encode()
+	third := len(encoded) / 3
+	chunks := [][]byte{encoded[:third], encoded[third : 2*third], encoded[2*third:]}
+
+	// Out of order chunks with a duplicate don't dispatch until all chunks are received
+	for _, index := range []int{2, 0, 0} {
+		if channel, errs := SubmitChunk("UPLOAD", index, index == 2, chunks[index]); channel != nil || len(errs) != 0 {
+			t.Errorf("Incomplete upload should not be dispatched. index=%v, errs=%v", index, errs)
+		}
+	}
+	channel, errs := SubmitChunk("UPLOAD", 1, false, chunks[1])
+	if channel == nil || len(errs) != 0 {
+		t.Fatalf("Complete upload should be dispatched. errs=%v", errs)
+	}
+	if reassembled := <-dispatched; string(reassembled) != string(encoded) {
+		t.Errorf("Upload should be reassembled in order. found=%s, expected=%s", reassembled, encoded)
+	}
+
+	// Late duplicate is ignored
+	if channel, errs := SubmitChunk("UPLOAD", 1, false, chunks[1]); channel != nil || len(errs) != 0 {
+		t.Errorf("Duplicate chunk of a dispatched upload should be ignored. errs=%v", errs)
+	}
+
+	// Reassembled operation executes
+	call := <-calls
+	if string(call.request) != "UPLOADED_PAYLOAD" {
+		t.Errorf("Reassembled operation should run. request=%s", call.request)
+	}
+	ticketId := (*<-channel).(status.Ticket)
+	ShutdownServer()
+	if logs := reg.ticketLogs[ticketId]; len(logs) != 3 || logs[2].status != status.SuccessStatus {
+		t.Errorf("Reassembled operation should succeed. logs=%v", logs)
+	}
+	select {
+	case <-dispatched:
+		t.Errorf("Upload should only be dispatched once.")
+	default:
+	}
+}
+
+func TestChunkedUploadErrors(t *testing.T) {
+	assembler := newUploadAssembler(time.Hour, func([]byte) (chan *gofarm.Response, []error) {
+		return nil, nil
+	})
+	if _, errs := assembler.addChunk("UPLOAD", -1, false, nil); len(errs) != 1 || errs[0] != invalidChunkIndexError {
+		t.Errorf("Negative chunk index should be rejected. errs=%v", errs)
+	}
+	assembler.addChunk("UPLOAD", 1, true, []byte("CHUNK"))
+	if _, errs := assembler.addChunk("UPLOAD", 2, false, nil); len(errs) != 1 || errs[0] != chunkAfterFinalError {
+		t.Errorf("Chunk past the final one should be rejected. errs=%v", errs)
+	}
+	if _, errs := assembler.addChunk("UPLOAD", 0, true, nil); len(errs) != 1 || errs[0] != conflictingFinalChunkError {
+		t.Errorf("Second final chunk should be rejected. errs=%v", errs)
+	}
+
+	disabledAssembler := newUploadAssembler(0, nil)
+	if _, errs := disabledAssembler.addChunk("UPLOAD", 0, true, nil); len(errs) != 1 || errs[0] != uploadDispatcherMissingError {
+		t.Errorf("Chunks should be rejected without a dispatcher. errs=%v", errs)
+	}
+}
+
+func TestChunkedUploadTimeout(t *testing.T) {
+	assembler := newUploadAssembler(20*time.Millisecond, func([]byte) (chan *gofarm.Response, []error) {
+		t.Errorf("Incomplete upload should not be dispatched.")
+		return nil, nil
+	})
+	assembler.addChunk("UPLOAD", 0, false, []byte("CHUNK"))
+	if pending := assembler.pending(); pending != 1 {
+		t.Errorf("Incomplete upload should be pending. pending=%v", pending)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if pending := assembler.pending(); pending != 0 {
+		t.Errorf("Incomplete upload should be discarded after timeout. pending=%v", pending)
+	}
+
+	// Upload restarts from scratch after being discarded
+	if channel, errs := assembler.addChunk("UPLOAD", 1, true, []byte("CHUNK")); channel != nil || len(errs) != 0 || assembler.pending() != 1 {
+		t.Errorf("Discarded upload should start over. errs=%v", errs)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if pending := assembler.pending(); pending != 0 {
+		t.Errorf("Restarted upload should be discarded after timeout. pending=%v", pending)
+	}
+}
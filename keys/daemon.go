@@ -111,12 +111,14 @@ func AddKey(keyId string, key []byte) error {
 	return addingKeyFailedError
 }
 
-func Decrypt(keyId string, nonce []byte, ciphertext []byte) ([]byte, error) {
+func Decrypt(keyId string, algorithm core.AeadAlgorithm, nonce []byte, ciphertext []byte, associatedData []byte) ([]byte, error) {
 	nativeResponseChannel, err := makeGenericRequest(&keyRequest{
-		Type:    DecryptRequest,
-		KeyId:   keyId,
-		Payload: ciphertext,
-		Nonce:   nonce,
+		Type:           DecryptRequest,
+		KeyId:          keyId,
+		Algorithm:      algorithm,
+		Payload:        ciphertext,
+		Nonce:          nonce,
+		AssociatedData: associatedData,
 	})
 	if err != nil {
 		return nil, err
@@ -172,12 +174,16 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 		}
 
 		// Decrypt
-		aead, _ := core.NewAead(storedRecord.(*keyRecord).Key)
-		decrypted, err := core.SymmetricDecrypt(
+		aead, err := core.NewAeadWithAlgorithm(storedRecord.(*keyRecord).Key, rqPtr.Algorithm)
+		if err != nil {
+			return failRequest(DecryptionFailure)
+		}
+		decrypted, err := core.SymmetricDecryptWithAssociatedData(
 			aead,
 			rqPtr.Payload[:0],
 			rqPtr.Nonce,
 			rqPtr.Payload,
+			rqPtr.AssociatedData,
 		)
 		if err != nil {
 			return failRequest(DecryptionFailure)
@@ -1,6 +1,7 @@
 package keys
 
 import (
+	"github.com/mngharbi/DMPC/core"
 	"reflect"
 	"testing"
 )
@@ -61,7 +62,7 @@ func TestAddKeyDuplicate(t *testing.T) {
 func TestDecryptServerDown(t *testing.T) {
 	key := getKeysCollection()[keyId1]
 	_, _, cipher := getPlainNonceCipher(key)
-	if _, err := Decrypt(keyId1, validNonce(), cipher); err == nil {
+	if _, err := Decrypt(keyId1, core.ChaCha20Poly1305AeadAlgorithm, validNonce(), cipher, nil); err == nil {
 		t.Error("Decrypting while server is down should fail")
 	}
 }
@@ -73,7 +74,7 @@ func TestDecryptInvalidKeyId(t *testing.T) {
 
 	key := getKeysCollection()[keyId1]
 	_, _, cipher := getPlainNonceCipher(key)
-	if _, err := Decrypt(invalidKeyId, validNonce(), cipher); err != invalidRequestFormatError {
+	if _, err := Decrypt(invalidKeyId, core.ChaCha20Poly1305AeadAlgorithm, validNonce(), cipher, nil); err != invalidRequestFormatError {
 		t.Error("Decrypting with invalid key id should fail")
 	}
 
@@ -87,7 +88,7 @@ func TestDecryptInvalidNonce(t *testing.T) {
 
 	key := getKeysCollection()[keyId1]
 	_, _, cipher := getPlainNonceCipher(key)
-	if _, err := Decrypt(keyId1, invalidNonce(), cipher); err != invalidRequestFormatError {
+	if _, err := Decrypt(keyId1, core.ChaCha20Poly1305AeadAlgorithm, invalidNonce(), cipher, nil); err != invalidRequestFormatError {
 		t.Error("Decrypting with invalid nonce should fail")
 	}
 
@@ -101,7 +102,7 @@ func TestDecryptInexistentKey(t *testing.T) {
 
 	key := getKeysCollection()[keyId1]
 	_, _, cipher := getPlainNonceCipher(key)
-	if _, err := Decrypt(keyId1, validNonce(), cipher); err != decryptionFailedError {
+	if _, err := Decrypt(keyId1, core.ChaCha20Poly1305AeadAlgorithm, validNonce(), cipher, nil); err != decryptionFailedError {
 		t.Error("Decrypting with inexistent key id should fail")
 	}
 
@@ -119,10 +120,46 @@ func TestValidDecrypt(t *testing.T) {
 	}
 
 	expectedPlain, nonce, cipher := getPlainNonceCipher(key)
-	plain, err := Decrypt(keyId1, nonce, cipher)
+	plain, err := Decrypt(keyId1, core.ChaCha20Poly1305AeadAlgorithm, nonce, cipher, nil)
 	if err != nil || !reflect.DeepEqual(plain, expectedPlain) {
 		t.Error("Decrypting with existent key id should not fail")
 	}
 
 	ShutdownServer()
 }
+
+func TestDecryptAlgorithmMismatch(t *testing.T) {
+	if !resetAndStartServer(t) {
+		return
+	}
+
+	key := getKeysCollection()[keyId1]
+	if AddKey(keyId1, key) != nil {
+		t.Error("Adding valid key should not fail")
+	}
+
+	_, nonce, cipher := getPlainNonceCipher(key)
+	if _, err := Decrypt(keyId1, core.Aes256GcmAeadAlgorithm, nonce, cipher, nil); err != decryptionFailedError {
+		t.Error("Decrypting with a different symmetric algorithm should fail")
+	}
+
+	ShutdownServer()
+}
+
+func TestDecryptAssociatedDataMismatch(t *testing.T) {
+	if !resetAndStartServer(t) {
+		return
+	}
+
+	key := getKeysCollection()[keyId1]
+	if AddKey(keyId1, key) != nil {
+		t.Error("Adding valid key should not fail")
+	}
+
+	_, nonce, cipher := getPlainNonceCipher(key)
+	if _, err := Decrypt(keyId1, core.ChaCha20Poly1305AeadAlgorithm, nonce, cipher, []byte("ASSOCIATED_DATA")); err != decryptionFailedError {
+		t.Error("Decrypting with different associated data should fail")
+	}
+
+	ShutdownServer()
+}
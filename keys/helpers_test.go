@@ -68,7 +68,7 @@ func getKeyRecordById(id string) *keyRecord {
 
 func encrypt(key []byte, payload []byte, nonce []byte) []byte {
 	aead, _ := core.NewAead(key)
-	encrypted := core.SymmetricEncrypt(
+	encrypted, _ := core.SymmetricEncrypt(
 		aead,
 		payload[:0],
 		nonce,
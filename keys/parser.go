@@ -15,10 +15,12 @@ const (
 )
 
 type keyRequest struct {
-	Type    keyRequestType
-	KeyId   string
-	Payload []byte
-	Nonce   []byte
+	Type           keyRequestType
+	KeyId          string
+	Algorithm      core.AeadAlgorithm
+	Payload        []byte
+	Nonce          []byte
+	AssociatedData []byte
 }
 
 /*
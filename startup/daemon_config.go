@@ -15,6 +15,7 @@ import (
 	"github.com/mngharbi/DMPC/status"
 	"github.com/mngharbi/DMPC/users"
 	"log"
+	"time"
 )
 
 /*
@@ -116,25 +117,29 @@ type ChannelsSubsystemConfig struct {
 
 func (conf *Config) GetChannelsSubsystemConfig() (channels.ChannelsServerConfig, channels.MessagesServerConfig, channels.ListenersServerConfig) {
 	return channels.ChannelsServerConfig{
-			NumWorkers: conf.Channels.Channels.NumWorkers,
-		}, channels.MessagesServerConfig{
-			NumWorkers: conf.Channels.Messages.NumWorkers,
-		}, channels.ListenersServerConfig{
-			NumWorkers: conf.Channels.Listeners.NumWorkers,
-		}
+		NumWorkers: conf.Channels.Channels.NumWorkers,
+	}, channels.MessagesServerConfig{
+		NumWorkers: conf.Channels.Messages.NumWorkers,
+	}, channels.ListenersServerConfig{
+		NumWorkers: conf.Channels.Listeners.NumWorkers,
+	}
 }
 
 type StatusSubsystemConfig struct {
 	Update    NumWorkersOnlyConfig `json:"update"`
 	Listeners NumWorkersOnlyConfig `json:"listeners"`
+
+	// Seconds after which completed tickets are purged (never if zero)
+	TicketTTLSeconds int `json:"ticketTtlSeconds"`
 }
 
 func (conf *Config) GetStatusSubsystemConfig() (status.StatusServerConfig, status.ListenersServerConfig) {
 	return status.StatusServerConfig{
-			NumWorkers: conf.Status.Update.NumWorkers,
-		}, status.ListenersServerConfig{
-			NumWorkers: conf.Status.Listeners.NumWorkers,
-		}
+		NumWorkers: conf.Status.Update.NumWorkers,
+		TicketTTL:  time.Duration(conf.Status.TicketTTLSeconds) * time.Second,
+	}, status.ListenersServerConfig{
+		NumWorkers: conf.Status.Listeners.NumWorkers,
+	}
 }
 
 func (conf *Config) GetKeysSubsystemConfig() keys.Config {
@@ -99,7 +99,11 @@ func generateAndSaveKeys(isEncryption bool) (string, string) {
 
 	// Save public key to file
 	public := &priv.PublicKey
-	publicString := core.PublicAsymKeyToString(public)
+	publicString, err := core.PublicAsymKeyToString(public)
+	if err != nil {
+		MakeBadStateFile()
+		log.Fatalf("Failed to encode public key. err=%v", err)
+	}
 	publicFilename := baseFilename + PublicKeySuffix
 	if err := WriteFile([]byte(publicString), KeysDir, publicFilename); err != nil {
 		MakeBadStateFile()
@@ -26,7 +26,7 @@ func GetPublicKey(filePath string) (*rsa.PublicKey, error) {
 	if err != nil {
 		return nil, err
 	}
-	return core.PublicStringToAsymKey(encodedKey)
+	return core.PublicStringToRsaKey(encodedKey)
 }
 
 func GetEncodedPublicKey(filePath string) (string, error) {
@@ -0,0 +1,108 @@
+/*
+	Coalescing of rapid non-terminal status updates
+*/
+
+package status
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+	Tickets with non-terminal updates waiting to be published
+*/
+type pendingUpdates struct {
+	timers map[Ticket]*time.Timer
+	lock   *sync.Mutex
+}
+
+func makePendingUpdates() *pendingUpdates {
+	return &pendingUpdates{
+		timers: map[Ticket]*time.Timer{},
+		lock:   &sync.Mutex{},
+	}
+}
+
+/*
+	Determines if publishing a changed record should be delayed
+	(non-terminal updates are published once per window with the latest status,
+	and terminal updates are published right away, replacing any pending update)
+	Status record lock should be held
+*/
+func (sv *statusServer) coalesceUpdate(record *StatusRecord) bool {
+	if sv.coalesceWindow <= 0 || sv.pendingUpdates == nil {
+		return false
+	}
+	pending := sv.pendingUpdates
+	pending.lock.Lock()
+	defer pending.lock.Unlock()
+
+	if record.isDone() {
+		if timer, ok := pending.timers[record.Id]; ok {
+			timer.Stop()
+			delete(pending.timers, record.Id)
+		}
+		return false
+	}
+	if _, ok := pending.timers[record.Id]; !ok {
+		ticket := record.Id
+		pending.timers[ticket] = time.AfterFunc(sv.coalesceWindow, func() {
+			sv.flushUpdate(ticket)
+		})
+	}
+	return true
+}
+
+/*
+	Publishes the latest status of a ticket if its update is still pending
+*/
+func (sv *statusServer) flushUpdate(ticket Ticket) {
+	pending := sv.pendingUpdates
+	pending.lock.Lock()
+	_, ok := pending.timers[ticket]
+	delete(pending.timers, ticket)
+	pending.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	statusStoreLock.RLock()
+	defer statusStoreLock.RUnlock()
+	if statusStore == nil {
+		return
+	}
+	recordItem := statusStore.Get(makeStatusEmptyRecord(ticket), statusMemstoreId)
+	if recordItem == nil {
+		return
+	}
+	record := recordItem.(*StatusRecord)
+	record.Lock()
+	defer record.Unlock()
+
+	// Terminal updates are published when applied
+	if !record.isDone() {
+		publishStatusUpdate(record)
+	}
+}
+
+/*
+	Publishes all pending updates right away
+*/
+func (sv *statusServer) flushPendingUpdates() {
+	pending := sv.pendingUpdates
+	if pending == nil {
+		return
+	}
+	pending.lock.Lock()
+	tickets := []Ticket{}
+	for ticket, timer := range pending.timers {
+		timer.Stop()
+		tickets = append(tickets, ticket)
+	}
+	pending.lock.Unlock()
+
+	for _, ticket := range tickets {
+		sv.flushUpdate(ticket)
+	}
+}
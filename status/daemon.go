@@ -5,12 +5,24 @@
 package status
 
 import (
+	"errors"
 	"github.com/mngharbi/DMPC/core"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	serversStartWaitGroup sync.WaitGroup
+
+	// Set while both servers are started (read by probes)
+	serversRunning int32
+)
+
+/*
+	Errors
+*/
+var (
+	serversNotRunningError error = errors.New("Status servers are not running.")
 )
 
 /*
@@ -42,10 +54,22 @@ func StartServers(
 		return err
 	}
 	serversStartWaitGroup.Wait()
+	atomic.StoreInt32(&serversRunning, 1)
 	return nil
 }
 
 func ShutdownServers() {
+	atomic.StoreInt32(&serversRunning, 0)
 	shutdownStatusServer()
 	shutdownListenersServer()
 }
+
+/*
+	Checks that the servers are accepting updates (without making one)
+*/
+func Probe() error {
+	if atomic.LoadInt32(&serversRunning) == 0 {
+		return serversNotRunningError
+	}
+	return nil
+}
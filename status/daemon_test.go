@@ -9,7 +9,13 @@ func TestStartShutdown(t *testing.T) {
 	if !resetAndStartBothServers(t, multipleWorkersStatusConfig(), multipleWorkersListenersConfig(), false) {
 		return
 	}
+	if err := Probe(); err != nil {
+		t.Errorf("Probe should succeed while servers are running. err=%v", err)
+	}
 	ShutdownServers()
+	if err := Probe(); err != serversNotRunningError {
+		t.Errorf("Probe should fail after servers are shutdown. err=%v", err)
+	}
 }
 
 func TestStartServersFailure(t *testing.T) {
@@ -0,0 +1,67 @@
+package status
+
+import (
+	"time"
+)
+
+/*
+	Tickets purged after reaching a terminal status
+	(remembered for another TTL so that queries can tell them apart from unknown tickets)
+*/
+var expiredTickets *ticketSet = makeTicketSet()
+
+func addExpiredTicket(registry *ticketSet, ticket Ticket, ttl time.Duration) {
+	registry.add(ticket)
+	time.AfterFunc(ttl, func() {
+		registry.remove(ticket)
+	})
+}
+
+/*
+	Sets expiry time of a record that reached a terminal status and schedules its purge
+	(queries follow the server clock, so tickets expire for them even before they're purged)
+	Record lock should be held
+*/
+func (sv *statusServer) scheduleRecordExpiry(record *StatusRecord) {
+	if sv.ticketTTL <= 0 {
+		return
+	}
+	record.expiresAt = sv.now().Add(sv.ticketTTL)
+	scheduleTicketExpiry(record.Id, sv.ticketTTL)
+}
+
+func (rec *StatusRecord) isExpired(now time.Time) bool {
+	return !rec.expiresAt.IsZero() && !now.Before(rec.expiresAt)
+}
+
+/*
+	Schedules purge of a ticket that reached a terminal status (no expiry if TTL is zero)
+*/
+func scheduleTicketExpiry(ticket Ticket, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	store := statusStore
+	persistentStore := statusServerSingleton.persistentStore
+	registry := expiredTickets
+	time.AfterFunc(ttl, func() {
+		statusStoreLock.RLock()
+		defer statusStoreLock.RUnlock()
+		recordItem := store.Get(makeStatusEmptyRecord(ticket), statusMemstoreId)
+		if recordItem == nil {
+			return
+		}
+		record := recordItem.(*StatusRecord)
+		record.Lock()
+		addExpiredTicket(registry, ticket, ttl)
+		issuedTickets.remove(ticket)
+		metrics.ticketExpired(ticket, record.Status)
+		store.Delete(record, statusMemstoreId)
+		if persistentStore != nil {
+			if err := persistentStore.Delete(ticket); err != nil {
+				log.Warnf(persistenceFailedLogMsg, ticket, err)
+			}
+		}
+		record.Unlock()
+	})
+}
@@ -77,6 +77,7 @@ func (sv *listenersServer) Start(_ gofarm.Config, isFirstStart bool) error {
 	// Initialize store (only if starting for the first time)
 	if isFirstStart {
 		listenersStore = memstore.New(getListenersIndexes())
+		subscriptions = makeSubscriptionRegistry()
 	}
 	log.Debugf(listenersDaemonStartLogMsg)
 	return nil
@@ -110,21 +111,39 @@ func doListenerServerWork(statusRecord *StatusRecord, channel UpdateChannel) {
 	listenersRecordObj.Unlock()
 }
 
+func doSubscriptionServerWork(statusRecord *StatusRecord, request *subscriptionRequest) {
+	subscriptions.register(request.subscription, statusRecord)
+	close(request.registered)
+}
+
 func (sv *listenersServer) Work(rq *gofarm.Request) (dummyReturnVal *gofarm.Response) {
 	log.Debugf(listenersRunningRequestLogMsg)
 
 	dummyReturnVal = nil
-	listeningRequest := (*rq).(*listeningRequest)
+
+	// Determine ticket
+	var ticket Ticket
+	switch request := (*rq).(type) {
+	case *listeningRequest:
+		ticket = request.ticket
+	case *subscriptionRequest:
+		ticket = request.subscription.ticket
+	}
 
 	// Read/Create and read lock status record
-	newStatusRecord := makeStatusEmptyRecord(listeningRequest.ticket)
+	newStatusRecord := makeStatusEmptyRecord(ticket)
 	currentStatusRecord := newStatusRecord.createOrGet(statusStore)
 	currentStatusRecord.RLock()
 
 	// Read record again (avoids race conditions)
 	currentStatusRecord = statusStore.Get(currentStatusRecord, statusMemstoreId).(*StatusRecord)
 
-	doListenerServerWork(currentStatusRecord, listeningRequest.channel)
+	switch request := (*rq).(type) {
+	case *listeningRequest:
+		doListenerServerWork(currentStatusRecord, request.channel)
+	case *subscriptionRequest:
+		doSubscriptionServerWork(currentStatusRecord, request)
+	}
 
 	currentStatusRecord.RUnlock()
 
@@ -8,14 +8,17 @@ const (
 	updateDaemonShutdownLogMsg  string = "Status update daemon shutdown"
 	updateReceivedRequestLogMsg string = "Status update received request"
 	updateRunningRequestLogMsg  string = "Status update running request"
+	queryReceivedRequestLogMsg  string = "Status query received request"
+	persistenceFailedLogMsg     string = "Status persistence failed for ticket %v. Error: %v"
 )
 
 /*
 	Listeners logging messages
 */
 const (
-	listenersDaemonStartLogMsg     string = "Status listeners daemon started"
-	listenersDaemonShutdownLogMsg  string = "Status listeners daemon shutdown"
-	listenersReceivedRequestLogMsg string = "Status listeners received request"
-	listenersRunningRequestLogMsg  string = "Status listeners running request"
+	listenersDaemonStartLogMsg        string = "Status listeners daemon started"
+	listenersDaemonShutdownLogMsg     string = "Status listeners daemon shutdown"
+	listenersReceivedRequestLogMsg    string = "Status listeners received request"
+	listenersRunningRequestLogMsg     string = "Status listeners running request"
+	subscriptionReceivedRequestLogMsg string = "Status listeners received subscription request"
 )
@@ -0,0 +1,117 @@
+package status
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+	Aggregates over ticket lifecycles
+*/
+type StatusMetrics struct {
+	// Tickets currently in each status
+	Queued  int
+	Running int
+	Success int
+	Failed  int
+
+	// Latency from ticket creation to terminal status (only tickets created by this daemon)
+	Completed    int
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+func (m StatusMetrics) AverageLatency() time.Duration {
+	if m.Completed == 0 {
+		return 0
+	}
+	return m.TotalLatency / time.Duration(m.Completed)
+}
+
+type metricsTracker struct {
+	counts       map[StatusCode]int
+	createdAt    map[Ticket]time.Time
+	completed    int
+	totalLatency time.Duration
+	maxLatency   time.Duration
+	lock         *sync.Mutex
+}
+
+func makeMetricsTracker() *metricsTracker {
+	return &metricsTracker{
+		counts:    map[StatusCode]int{},
+		createdAt: map[Ticket]time.Time{},
+		lock:      &sync.Mutex{},
+	}
+}
+
+var metrics *metricsTracker = makeMetricsTracker()
+
+func (tracker *metricsTracker) ticketCreated(ticket Ticket, at time.Time) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.createdAt[ticket] = at
+}
+
+/*
+	Moves a ticket between status counts (and measures latency when it first reaches a terminal status)
+*/
+func (tracker *metricsTracker) statusChanged(ticket Ticket, from StatusCode, to StatusCode, at time.Time) {
+	if from == to {
+		return
+	}
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if from != NoStatus {
+		tracker.counts[from]--
+	}
+	tracker.counts[to]++
+
+	if to < SuccessStatus || from >= SuccessStatus {
+		return
+	}
+	createdAt, ok := tracker.createdAt[ticket]
+	if !ok {
+		return
+	}
+	delete(tracker.createdAt, ticket)
+	latency := at.Sub(createdAt)
+	tracker.completed++
+	tracker.totalLatency += latency
+	if latency > tracker.maxLatency {
+		tracker.maxLatency = latency
+	}
+}
+
+func (tracker *metricsTracker) ticketExpired(ticket Ticket, status StatusCode) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if status != NoStatus {
+		tracker.counts[status]--
+	}
+	delete(tracker.createdAt, ticket)
+}
+
+// Status counts follow the store (latencies and creation times are kept)
+func (tracker *metricsTracker) resetCounts() {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.counts = map[StatusCode]int{}
+}
+
+/*
+	Returns current aggregates
+*/
+func Metrics() StatusMetrics {
+	metrics.lock.Lock()
+	defer metrics.lock.Unlock()
+	return StatusMetrics{
+		Queued:       metrics.counts[QueuedStatus],
+		Running:      metrics.counts[RunningStatus],
+		Success:      metrics.counts[SuccessStatus],
+		Failed:       metrics.counts[FailedStatus],
+		Completed:    metrics.completed,
+		TotalLatency: metrics.totalLatency,
+		MaxLatency:   metrics.maxLatency,
+	}
+}
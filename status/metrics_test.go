@@ -0,0 +1,47 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetricsLifecycle(t *testing.T) {
+	if !resetAndStartStatusServer(t, StatusServerConfig{NumWorkers: 1}) {
+		return
+	}
+	before := Metrics()
+
+	// Drive one ticket to success and another one to failure
+	successTicket := RequestNewTicket()
+	failedTicket := RequestNewTicket()
+	waitDuration := 20 * time.Millisecond
+	time.Sleep(waitDuration)
+	UpdateStatus(successTicket, QueuedStatus, NoReason, nil, nil)
+	UpdateStatus(successTicket, RunningStatus, NoReason, nil, nil)
+	UpdateStatus(failedTicket, RunningStatus, NoReason, nil, nil)
+	shutdownStatusServer()
+	running := Metrics()
+	if !startStatusServerAndTest(t, StatusServerConfig{NumWorkers: 1}) {
+		return
+	}
+	if running.Running-before.Running != 2 || running.Queued != before.Queued || running.Completed != before.Completed {
+		t.Errorf("Running tickets should be counted.\n before=%+v\n after=%+v", before, running)
+	}
+
+	UpdateStatus(successTicket, SuccessStatus, NoReason, nil, nil)
+	UpdateStatus(failedTicket, FailedStatus, FailedReason, nil, nil)
+	// Stale update should not be counted
+	UpdateStatus(failedTicket, RunningStatus, NoReason, nil, nil)
+	shutdownStatusServer()
+
+	after := Metrics()
+	if after.Running != before.Running || after.Success-before.Success != 1 || after.Failed-before.Failed != 1 {
+		t.Errorf("Terminal tickets should be counted.\n before=%+v\n after=%+v", before, after)
+	}
+	if after.Completed-before.Completed != 2 {
+		t.Errorf("Latency should be measured for both tickets.\n before=%+v\n after=%+v", before, after)
+	}
+	if after.TotalLatency-before.TotalLatency < 2*waitDuration || after.MaxLatency < waitDuration || after.AverageLatency() <= 0 {
+		t.Errorf("Latency should be measured from ticket creation.\n before=%+v\n after=%+v", before, after)
+	}
+}
@@ -0,0 +1,86 @@
+package status
+
+import (
+	"sync"
+)
+
+/*
+	Registry of fail reasons
+	(built in reasons come first, subsystems can register their own at runtime)
+*/
+type failureReasonRegistry struct {
+	names []string
+	codes map[string]int
+	lock  *sync.RWMutex
+}
+
+func makeFailureReasonRegistry(builtinNames []string) *failureReasonRegistry {
+	registry := &failureReasonRegistry{
+		names: []string{},
+		codes: map[string]int{},
+		lock:  &sync.RWMutex{},
+	}
+	for _, name := range builtinNames {
+		registry.register(name)
+	}
+	return registry
+}
+
+// Registering a known name returns its existing code
+func (registry *failureReasonRegistry) register(name string) int {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if code, ok := registry.codes[name]; ok {
+		return code
+	}
+	code := len(registry.names)
+	registry.names = append(registry.names, name)
+	registry.codes[name] = code
+	return code
+}
+
+func (registry *failureReasonRegistry) name(code int) string {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	if !(0 <= code && code < len(registry.names)) {
+		return ""
+	}
+	return registry.names[code]
+}
+
+func (registry *failureReasonRegistry) count() int {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	return len(registry.names)
+}
+
+// Names of built in reasons (same order as the constants)
+var failureReasons *failureReasonRegistry = makeFailureReasonRegistry([]string{
+	"NoReason",
+	"RejectedReason",
+	"FailedReason",
+	"TimedOutReason",
+	"OutsideValidityWindowReason",
+	"PayloadTooLargeReason",
+	"PermissionDeniedReason",
+	"UnknownRequestTypeReason",
+	"UsersUnavailableReason",
+})
+
+/*
+	Allocates a fail reason code usable in status updates
+*/
+func RegisterFailureReason(name string) int {
+	return failureReasons.register(name)
+}
+
+/*
+	Name of a fail reason (empty if unknown)
+*/
+func ReasonName(code int) string {
+	return failureReasons.name(code)
+}
+
+func isRegisteredFailureReason(code FailReasonCode) bool {
+	return NoReason <= code && int(code) < failureReasons.count()
+}
@@ -0,0 +1,52 @@
+package status
+
+import (
+	"testing"
+)
+
+func TestBuiltinReasonNames(t *testing.T) {
+	expectedNames := map[int]string{
+		NoReason:                 "NoReason",
+		FailedReason:             "FailedReason",
+		PermissionDeniedReason:   "PermissionDeniedReason",
+		UnknownRequestTypeReason: "UnknownRequestTypeReason",
+		-1:                       "",
+	}
+	for code, expectedName := range expectedNames {
+		if name := ReasonName(code); name != expectedName {
+			t.Errorf("Unexpected reason name. code=%v, found=%v, expected=%v", code, name, expectedName)
+		}
+	}
+}
+
+func TestRegisterFailureReason(t *testing.T) {
+	if !resetAndStartStatusServer(t, StatusServerConfig{NumWorkers: 1}) {
+		return
+	}
+
+	// Unregistered codes are rejected
+	unregisteredCode := FailReasonCode(failureReasons.count())
+	if err := UpdateStatus(RequestNewTicket(), FailedStatus, unregisteredCode, nil, nil); err != failedRangeError {
+		t.Errorf("Update with unregistered reason should fail. err=%v", err)
+	}
+
+	// Registration allocates a new code (same code if registered again)
+	name := "CustomReason" + string(RequestNewTicket())
+	code := RegisterFailureReason(name)
+	if FailReasonCode(code) != unregisteredCode || ReasonName(code) != name {
+		t.Errorf("Registration should allocate next reason code. code=%v, name=%v", code, ReasonName(code))
+	}
+	if again := RegisterFailureReason(name); again != code {
+		t.Errorf("Registering a known reason should return its code. found=%v, expected=%v", again, code)
+	}
+
+	// Registered codes can be used in updates
+	ticket := RequestNewTicket()
+	if err := UpdateStatus(ticket, FailedStatus, FailReasonCode(code), nil, nil); err != nil {
+		t.Errorf("Update with registered reason should succeed. err=%v", err)
+	}
+	shutdownStatusServer()
+	if record, err := GetStatus(ticket); err != nil || record.FailReason != FailReasonCode(code) {
+		t.Errorf("Ticket should be updated with registered reason. record=%+v, err=%v", record, err)
+	}
+}
@@ -1,8 +1,12 @@
 package status
 
 import (
+	"github.com/mngharbi/DMPC/core"
 	"github.com/mngharbi/gofarm"
 	"github.com/mngharbi/memstore"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -10,12 +14,34 @@ import (
 */
 type Reporter func(Ticket, StatusCode, FailReasonCode, []byte, []error) error
 
+/*
+	Function to report that a running ticket is being retried
+*/
+type RetryReporter func(Ticket, int, []error) error
+
 /*
 	Server API
 */
 
 type StatusServerConfig struct {
+	// Number of workers (starting fails if less than one)
 	NumWorkers int
+
+	// Time after which tickets in a terminal status are purged (never if zero)
+	TicketTTL time.Duration
+
+	// Persistence of ticket statuses across restarts (memory only if nil)
+	Store StatusStore
+
+	// Source of ticket ids (unique ids from the core package if nil)
+	TicketIdGenerator TicketIdGenerator
+
+	// Window in which non-terminal updates of a ticket are published once with the latest status
+	// (no coalescing if zero, and terminal updates are always published right away)
+	CoalesceWindow time.Duration
+
+	// Source of current time for ticket expiry and metrics (system clock if nil)
+	Clock core.Clock
 }
 
 func provisionStatusServerOnce() {
@@ -25,17 +51,39 @@ func provisionStatusServerOnce() {
 }
 
 func startStatusServer(conf StatusServerConfig) (err error) {
+	// Without workers, updates would be queued forever
+	if conf.NumWorkers < 1 {
+		return invalidNumWorkersError
+	}
+
+	setTicketIdGenerator(conf.TicketIdGenerator)
+
 	provisionStatusServerOnce()
 	if !statusServerSingleton.isInitialized {
 		statusServerSingleton.isInitialized = true
 		statusServerHandler.ResetServer()
 		statusServerHandler.InitServer(&statusServerSingleton)
 	}
+	statusServerSingleton.ticketTTL = conf.TicketTTL
+	statusServerSingleton.persistentStore = conf.Store
+	statusServerSingleton.coalesceWindow = conf.CoalesceWindow
+	statusServerSingleton.clock = core.ClockOrSystem(conf.Clock)
+	statusServerSingleton.pendingUpdates = makePendingUpdates()
 	err = statusServerHandler.StartServer(gofarm.Config{NumWorkers: conf.NumWorkers})
+	if err == nil {
+		atomic.StoreInt32(&statusWorkers, int32(conf.NumWorkers))
+	}
 	serversStartWaitGroup.Done()
 	return
 }
 
+/*
+	Number of workers the status server was started with
+*/
+func StatusWorkers() int {
+	return int(atomic.LoadInt32(&statusWorkers))
+}
+
 func shutdownStatusServer() {
 	provisionStatusServerOnce()
 	statusServerHandler.ShutdownServer()
@@ -44,19 +92,28 @@ func shutdownStatusServer() {
 func UpdateStatus(ticket Ticket, status StatusCode, failReason FailReasonCode, payload []byte, errs []error) error {
 	log.Debugf(updateReceivedRequestLogMsg)
 
-	statusRecord := &StatusRecord{
+	return submitStatusRecord(&StatusRecord{
 		Id:         ticket,
 		Status:     status,
 		FailReason: failReason,
 		Payload:    payload,
+		Result:     makeResult(status, payload),
 		Errs:       errs,
-	}
+		Errors:     makeErrorDetails(errs),
+	})
+}
 
+func submitStatusRecord(statusRecord *StatusRecord) error {
 	// Check record
 	if err := statusRecord.check(); err != nil {
 		return err
 	}
 
+	// Check ticket was issued
+	if !issuedTickets.contains(statusRecord.Id) {
+		return unknownTicketError
+	}
+
 	// Make request to server
 	if _, err := statusServerHandler.MakeRequest(statusRecord); err != nil {
 		return err
@@ -65,40 +122,208 @@ func UpdateStatus(ticket Ticket, status StatusCode, failReason FailReasonCode, p
 	return nil
 }
 
+/*
+	Records a retry of a running ticket (attempts is the number of failed attempts so far)
+*/
+func ReportRetry(ticket Ticket, attempts int, errs []error) error {
+	log.Debugf(updateReceivedRequestLogMsg)
+
+	return submitStatusRecord(&StatusRecord{
+		Id:       ticket,
+		Status:   RunningStatus,
+		Errs:     errs,
+		Errors:   makeErrorDetails(errs),
+		Attempts: attempts,
+	})
+}
+
+/*
+	Returns current status of a ticket
+*/
+func GetStatus(ticket Ticket) (*StatusRecord, error) {
+	log.Debugf(queryReceivedRequestLogMsg)
+
+	var recordItem memstore.Item
+	if statusStore != nil {
+		recordItem = statusStore.Get(makeStatusEmptyRecord(ticket), statusMemstoreId)
+	}
+	if recordItem == nil {
+		if expiredTickets.contains(ticket) {
+			return nil, ticketExpiredError
+		}
+		return nil, ticketNotFoundError
+	}
+
+	record := recordItem.(*StatusRecord)
+	record.RLock()
+	defer record.RUnlock()
+	if record.isExpired(statusServerSingleton.now()) {
+		return nil, ticketExpiredError
+	}
+	return record.copy(), nil
+}
+
+/*
+	Returns result of a ticket once it succeeded
+*/
+func GetResult(ticket Ticket) ([]byte, error) {
+	record, err := GetStatus(ticket)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case record.Status == SuccessStatus:
+		return record.Result, nil
+	case record.isDone():
+		return nil, ticketFailedError
+	default:
+		return nil, resultNotReadyError
+	}
+}
+
+/*
+	Returns current status of many tickets (unknown tickets are omitted)
+*/
+func GetStatuses(tickets []Ticket) (map[Ticket]StatusRecord, error) {
+	log.Debugf(queryReceivedRequestLogMsg)
+
+	if statusStore == nil {
+		return nil, statusStoreUninitializedError
+	}
+
+	// Exclusive store lock prevents any record from changing during the query
+	statusStoreLock.Lock()
+	defer statusStoreLock.Unlock()
+
+	records := map[Ticket]StatusRecord{}
+	now := statusServerSingleton.now()
+	for _, ticket := range tickets {
+		recordItem := statusStore.Get(makeStatusEmptyRecord(ticket), statusMemstoreId)
+		if recordItem == nil || recordItem.(*StatusRecord).isExpired(now) {
+			continue
+		}
+		records[ticket] = *recordItem.(*StatusRecord).copy()
+	}
+	return records, nil
+}
+
 /*
 	Server implementation
 */
 
 type statusServer struct {
-	isInitialized bool
+	isInitialized   bool
+	ticketTTL       time.Duration
+	persistentStore StatusStore
+	coalesceWindow  time.Duration
+	pendingUpdates  *pendingUpdates
+	clock           core.Clock
+}
+
+func (sv *statusServer) now() time.Time {
+	return core.ClockOrSystem(sv.clock).Now()
 }
 
 var (
 	statusServerSingleton statusServer
 	statusServerHandler   *gofarm.ServerHandler
 	statusStore           *memstore.Memstore
+
+	// Set when the status server starts (read by StatusWorkers)
+	statusWorkers int32
+
+	// Held for reading while records are changed, and for writing by bulk queries
+	statusStoreLock *sync.RWMutex = &sync.RWMutex{}
 )
 
 func (sv *statusServer) Start(_ gofarm.Config, isFirstStart bool) error {
 	// Initialize store (only if starting for the first time)
 	if isFirstStart {
 		statusStore = memstore.New(getStatusIndexes())
+		expiredTickets = makeTicketSet()
+		metrics.resetCounts()
+		if err := sv.loadPersistedRecords(); err != nil {
+			return err
+		}
 	}
 	log.Debugf(updateDaemonStartLogMsg)
 	return nil
 }
 
+/*
+	Reloads records from persistent store if any
+*/
+func (sv *statusServer) loadPersistedRecords() error {
+	if sv.persistentStore == nil {
+		return nil
+	}
+	records, err := sv.persistentStore.Load()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		record.lock = &sync.RWMutex{}
+		statusStore.Add(record)
+		issuedTickets.add(record.Id)
+		metrics.statusChanged(record.Id, NoStatus, record.Status, sv.now())
+		if record.isDone() {
+			sv.scheduleRecordExpiry(record)
+		}
+	}
+	return nil
+}
+
+/*
+	Writes changed record through to persistent store and schedules its expiry if done
+	Record lock should be held
+*/
+func (sv *statusServer) recordChanged(record *StatusRecord) {
+	if sv.persistentStore != nil {
+		if err := sv.persistentStore.Save(record); err != nil {
+			log.Warnf(persistenceFailedLogMsg, record.Id, err)
+		}
+	}
+	if record.isDone() {
+		sv.scheduleRecordExpiry(record)
+	}
+}
+
 func (sv *statusServer) Shutdown() error {
+	sv.flushPendingUpdates()
 	log.Debugf(updateDaemonShutdownLogMsg)
 	return nil
 }
 
 func doStatusUpdate(currentRecord *StatusRecord, changedRecord *StatusRecord) {
+	// Record created from the update has no listeners or subscribers
+	if currentRecord == changedRecord {
+		metrics.statusChanged(currentRecord.Id, NoStatus, currentRecord.Status, statusServerSingleton.now())
+		statusServerSingleton.recordChanged(currentRecord)
+		return
+	}
+
 	// Update record
+	previousStatus := currentRecord.Status
 	recordChanged := currentRecord.update(changedRecord)
 	if !recordChanged {
 		return
 	}
+	metrics.statusChanged(currentRecord.Id, previousStatus, currentRecord.Status, statusServerSingleton.now())
+	if statusServerSingleton.coalesceUpdate(currentRecord) {
+		return
+	}
+	publishStatusUpdate(currentRecord)
+}
+
+/*
+	Persists changed record, and sends it to subscribers and listeners
+	Status record lock should be held
+*/
+func publishStatusUpdate(currentRecord *StatusRecord) {
+	statusServerSingleton.recordChanged(currentRecord)
+
+	// Send update to subscribers
+	subscriptions.fanOut(currentRecord)
 
 	/*
 		Get listeners record
@@ -136,6 +361,8 @@ func (sv *statusServer) Work(rq *gofarm.Request) (dummyReturnVal *gofarm.Respons
 	changedRecord := (*rq).(*StatusRecord)
 
 	// Read/Create and write lock status record
+	statusStoreLock.RLock()
+	defer statusStoreLock.RUnlock()
 	currentRecord := changedRecord.createOrGet(statusStore)
 	currentRecord.Lock()
 
@@ -1,7 +1,12 @@
 package status
 
 import (
+	"errors"
+	"github.com/mngharbi/DMPC/core"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStatusStartShutdown(t *testing.T) {
@@ -11,13 +16,39 @@ func TestStatusStartShutdown(t *testing.T) {
 	shutdownStatusServer()
 }
 
+func TestStatusStartZeroWorkers(t *testing.T) {
+	statusServerSingleton = statusServer{}
+	serversStartWaitGroup = sync.WaitGroup{}
+	startDone := make(chan error, 1)
+	go (func() {
+		startDone <- StartServers(StatusServerConfig{NumWorkers: 0}, multipleWorkersListenersConfig(), log, shutdownProgram)
+	})()
+	select {
+	case err := <-startDone:
+		if err != invalidNumWorkersError {
+			t.Errorf("Starting with zero workers should fail with config error. err=%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Starting with zero workers should fail fast.")
+	}
+
+	// Effective worker count is exposed once started
+	if !resetAndStartStatusServer(t, multipleWorkersStatusConfig()) {
+		return
+	}
+	if workers := StatusWorkers(); workers != multipleWorkersStatusConfig().NumWorkers {
+		t.Errorf("Effective worker count should be exposed. found=%v, expected=%v", workers, multipleWorkersStatusConfig().NumWorkers)
+	}
+	shutdownStatusServer()
+}
+
 func TestInvalidStatusUpdate(t *testing.T) {
 	err := UpdateStatus(RequestNewTicket(), FailedStatus+1, NoReason, nil, nil)
 	if err != statusRangeError {
 		t.Errorf("Request with invalid status code should fail. err=%v", err)
 	}
 
-	err = UpdateStatus(RequestNewTicket(), FailedStatus, FailedReason+1, nil, nil)
+	err = UpdateStatus(RequestNewTicket(), FailedStatus, FailReasonCode(failureReasons.count()), nil, nil)
 	if err != failedRangeError {
 		t.Errorf("Request with invalid failure code should fail. err=%v", err)
 	}
@@ -29,3 +60,230 @@ func TestStatusUpdateServerDown(t *testing.T) {
 		t.Errorf("Request while server is down fails.")
 	}
 }
+
+func TestTicketExpiry(t *testing.T) {
+	conf := StatusServerConfig{
+		NumWorkers: 1,
+		TicketTTL:  100 * time.Millisecond,
+	}
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+
+	// Complete one ticket, and leave another one running
+	completedTicket := RequestNewTicket()
+	freshTicket := RequestNewTicket()
+	UpdateStatus(completedTicket, SuccessStatus, NoReason, nil, nil)
+	UpdateStatus(freshTicket, RunningStatus, NoReason, nil, nil)
+	shutdownStatusServer()
+	if record, err := GetStatus(completedTicket); err != nil || record.Status != SuccessStatus {
+		t.Errorf("Completed ticket should be queryable before expiry. record=%+v, err=%v", record, err)
+	}
+
+	// Purged after TTL, and remembered as expired for another TTL
+	time.Sleep(150 * time.Millisecond)
+
+	if _, err := GetStatus(completedTicket); err != ticketExpiredError {
+		t.Errorf("Completed ticket should be expired after TTL. err=%v", err)
+	}
+	if record, err := GetStatus(freshTicket); err != nil || record.Status != RunningStatus {
+		t.Errorf("Running ticket should not expire. record=%+v, err=%v", record, err)
+	}
+	if _, err := GetStatus(RequestNewTicket()); err != ticketNotFoundError {
+		t.Errorf("Unknown ticket should not be found. err=%v", err)
+	}
+}
+
+func TestTicketExpiryWithClock(t *testing.T) {
+	clock := core.NewManualClock(time.Now())
+	conf := StatusServerConfig{
+		NumWorkers: 1,
+		TicketTTL:  time.Hour,
+		Clock:      clock,
+	}
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+
+	completedTicket := RequestNewTicket()
+	UpdateStatus(completedTicket, SuccessStatus, NoReason, nil, nil)
+	shutdownStatusServer()
+
+	clock.Advance(time.Hour - time.Second)
+	if record, err := GetStatus(completedTicket); err != nil || record.Status != SuccessStatus {
+		t.Errorf("Completed ticket should be queryable before expiry. record=%+v, err=%v", record, err)
+	}
+
+	// Expired for queries once the clock reaches TTL (without waiting for the purge)
+	clock.Advance(time.Second)
+	if _, err := GetStatus(completedTicket); err != ticketExpiredError {
+		t.Errorf("Completed ticket should be expired after TTL. err=%v", err)
+	}
+	if records, err := GetStatuses([]Ticket{completedTicket}); err != nil || len(records) != 0 {
+		t.Errorf("Expired ticket should be omitted from bulk queries. records=%+v, err=%v", records, err)
+	}
+}
+
+func TestGetStatuses(t *testing.T) {
+	if !resetAndStartStatusServer(t, multipleWorkersStatusConfig()) {
+		return
+	}
+
+	queuedTicket := RequestNewTicket()
+	doneTicket := RequestNewTicket()
+	unknownTicket := RequestNewTicket()
+	UpdateStatus(queuedTicket, QueuedStatus, NoReason, nil, nil)
+	UpdateStatus(doneTicket, SuccessStatus, NoReason, []byte("RESULT"), nil)
+	shutdownStatusServer()
+
+	records, err := GetStatuses([]Ticket{queuedTicket, unknownTicket, doneTicket})
+	if err != nil {
+		t.Errorf("Bulk status query should not fail. err=%v", err)
+		return
+	}
+	if len(records) != 2 {
+		t.Errorf("Only known tickets should be returned. records=%+v", records)
+	}
+	if record, ok := records[queuedTicket]; !ok || record.Status != QueuedStatus {
+		t.Errorf("Queued ticket should be returned. record=%+v", record)
+	}
+	if record, ok := records[doneTicket]; !ok || record.Status != SuccessStatus || string(record.Payload) != "RESULT" {
+		t.Errorf("Done ticket should be returned. record=%+v", record)
+	}
+	if _, ok := records[unknownTicket]; ok {
+		t.Errorf("Unknown ticket should be omitted.")
+	}
+}
+
+func TestStatusErrorDetails(t *testing.T) {
+	if !resetAndStartStatusServer(t, multipleWorkersStatusConfig()) {
+		return
+	}
+
+	ticket := RequestNewTicket()
+	UpdateStatus(ticket, QueuedStatus, NoReason, nil, nil)
+	UpdateStatus(ticket, FailedStatus, FailedReason, nil, []error{
+		errors.New("First failure."),
+		errors.New("Second failure."),
+	})
+	shutdownStatusServer()
+
+	record, err := GetStatus(ticket)
+	expected := []string{"First failure.", "Second failure."}
+	if err != nil || record.Status != FailedStatus || !reflect.DeepEqual(record.Errors, expected) {
+		t.Errorf("Error details should round trip through status query. record=%+v, err=%v", record, err)
+	}
+}
+
+func TestUpdateUnknownTicket(t *testing.T) {
+	if !resetAndStartStatusServer(t, multipleWorkersStatusConfig()) {
+		return
+	}
+
+	if err := UpdateStatus(Ticket("NEVER_ISSUED"), QueuedStatus, NoReason, nil, nil); err != unknownTicketError {
+		t.Errorf("Updating never issued ticket should fail with unknownTicketError. err=%v", err)
+	}
+	if err := UpdateStatus(RequestNewTicket(), QueuedStatus, NoReason, nil, nil); err != nil {
+		t.Errorf("Updating issued ticket should succeed. err=%v", err)
+	}
+
+	shutdownStatusServer()
+}
+
+func TestReportRetry(t *testing.T) {
+	conf := StatusServerConfig{
+		NumWorkers: 1,
+	}
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+
+	ticket := RequestNewTicket()
+	UpdateStatus(ticket, RunningStatus, NoReason, nil, nil)
+	ReportRetry(ticket, 1, []error{errors.New("FIRST")})
+	ReportRetry(ticket, 2, []error{errors.New("SECOND")})
+	ReportRetry(ticket, 1, nil)
+	shutdownStatusServer()
+
+	record, err := GetStatus(ticket)
+	if err != nil || record.Status != RunningStatus || record.Attempts != 2 ||
+		!reflect.DeepEqual(record.Errors, []string{"SECOND"}) {
+		t.Errorf("Retries should update attempts of running ticket. record=%+v, err=%v", record, err)
+	}
+
+	if err := ReportRetry(ticket, -1, nil); err != attemptsRangeError {
+		t.Errorf("Retry with invalid attempts should fail. err=%v", err)
+	}
+}
+
+func TestGetResult(t *testing.T) {
+	conf := StatusServerConfig{
+		NumWorkers: 1,
+	}
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+
+	ticket := RequestNewTicket()
+	failedTicket := RequestNewTicket()
+	UpdateStatus(ticket, RunningStatus, NoReason, []byte("PARTIAL"), nil)
+	UpdateStatus(failedTicket, FailedStatus, FailedReason, []byte("ERROR"), nil)
+	shutdownStatusServer()
+
+	// Not ready before completion
+	if result, err := GetResult(ticket); err != resultNotReadyError || result != nil {
+		t.Errorf("Result should not be ready before success. result=%s, err=%v", result, err)
+	}
+	if _, err := GetResult(failedTicket); err != ticketFailedError {
+		t.Errorf("Failed ticket should have no result. err=%v", err)
+	}
+	if _, err := GetResult(Ticket("UNKNOWN")); err != ticketNotFoundError {
+		t.Errorf("Unknown ticket should have no result. err=%v", err)
+	}
+
+	// Result set on success
+	if !startStatusServerAndTest(t, conf) {
+		return
+	}
+	UpdateStatus(ticket, SuccessStatus, NoReason, []byte("RESULT"), nil)
+	shutdownStatusServer()
+	if result, err := GetResult(ticket); err != nil || !reflect.DeepEqual(result, []byte("RESULT")) {
+		t.Errorf("Result should be readable after success. result=%s, err=%v", result, err)
+	}
+}
+
+func TestCustomTicketIdGenerator(t *testing.T) {
+	defer setTicketIdGenerator(nil)
+
+	// Sortable ids embedding a trace id (first one collides with a known ticket)
+	knownTicket := RequestNewTicket()
+	generatedIds := []string{string(knownTicket), "TRACE-0001", "TRACE-0002"}
+	generated := 0
+	conf := StatusServerConfig{
+		NumWorkers: 1,
+		TicketIdGenerator: func() string {
+			id := generatedIds[generated]
+			generated++
+			return id
+		},
+	}
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+
+	firstTicket := RequestNewTicket()
+	secondTicket := RequestNewTicket()
+	if firstTicket != Ticket("TRACE-0001") || secondTicket != Ticket("TRACE-0002") {
+		t.Errorf("Tickets should come from custom generator skipping known ones. first=%v, second=%v", firstTicket, secondTicket)
+	}
+	if err := UpdateStatus(firstTicket, SuccessStatus, NoReason, nil, nil); err != nil {
+		t.Errorf("Custom ticket should be tracked. err=%v", err)
+	}
+	UpdateStatus(secondTicket, RunningStatus, NoReason, nil, nil)
+	shutdownStatusServer()
+
+	records, err := GetStatuses([]Ticket{firstTicket, secondTicket})
+	if err != nil || records[firstTicket].Status != SuccessStatus || records[secondTicket].Status != RunningStatus {
+		t.Errorf("Custom tickets should be tracked by the daemon. records=%+v, err=%v", records, err)
+	}
+}
@@ -5,14 +5,24 @@ import (
 	"github.com/mngharbi/memstore"
 	"reflect"
 	"sync"
+	"time"
 )
 
 /*
 	Errors
 */
 var (
-	statusRangeError error = errors.New("Status code is out of bounds.")
-	failedRangeError error = errors.New("Failed status code is out of bounds.")
+	statusRangeError    error = errors.New("Status code is out of bounds.")
+	failedRangeError    error = errors.New("Failed status code is out of bounds.")
+	attemptsRangeError  error = errors.New("Number of attempts is out of bounds.")
+	ticketNotFoundError error = errors.New("Ticket not found.")
+	ticketExpiredError  error = errors.New("Ticket expired.")
+	unknownTicketError  error = errors.New("Ticket was not issued.")
+	resultNotReadyError error = errors.New("Ticket result is not ready.")
+	ticketFailedError   error = errors.New("Ticket failed without a result.")
+
+	statusStoreUninitializedError error = errors.New("Status store is not initialized.")
+	invalidNumWorkersError        error = errors.New("Status server needs at least one worker.")
 )
 
 /*
@@ -30,6 +40,7 @@ const (
 
 /*
 	FailReason codes
+	(more can be registered with RegisterFailureReason)
 */
 type FailReasonCode int
 
@@ -37,6 +48,12 @@ const (
 	NoReason = iota
 	RejectedReason
 	FailedReason
+	TimedOutReason
+	OutsideValidityWindowReason
+	PayloadTooLargeReason
+	PermissionDeniedReason
+	UnknownRequestTypeReason
+	UsersUnavailableReason
 )
 
 /*
@@ -47,7 +64,11 @@ type StatusRecord struct {
 	Status     StatusCode
 	FailReason FailReasonCode
 	Payload    []byte
+	Result     []byte // Payload of successful tickets
 	Errs       []error
+	Errors     []string  // Error details readable by clients (built from errors)
+	Attempts   int       // Number of failed attempts retried so far
+	expiresAt  time.Time // Time after which queries treat the ticket as expired (never if zero)
 	lock       *sync.RWMutex
 }
 
@@ -104,24 +125,35 @@ func (rec *StatusRecord) check() error {
 		return statusRangeError
 	}
 
-	// Check fail reasons bounds
-	if !(NoReason <= rec.FailReason && rec.FailReason <= FailedReason) {
+	// Check fail reasons bounds (including registered ones)
+	if !isRegisteredFailureReason(rec.FailReason) {
 		return failedRangeError
 	}
 
+	// Check attempts bounds
+	if rec.Attempts < 0 {
+		return attemptsRangeError
+	}
+
 	return nil
 }
 
 func (current *StatusRecord) update(updated *StatusRecord) bool {
-	// Don't apply any stale updates
-	if current.Status >= updated.Status {
+	// Don't apply any stale updates (same status only applies if attempts increased)
+	if current.Status > updated.Status ||
+		(current.Status == updated.Status && current.Attempts >= updated.Attempts) {
 		return false
 	}
 
 	current.Status = updated.Status
 	current.FailReason = updated.FailReason
 	current.Payload = updated.Payload
+	current.Result = updated.Result
 	current.Errs = updated.Errs
+	current.Errors = updated.Errors
+	if updated.Attempts > current.Attempts {
+		current.Attempts = updated.Attempts
+	}
 	return true
 }
 
@@ -129,6 +161,7 @@ func (a *StatusRecord) isSame(b *StatusRecord) bool {
 	return a.Id == b.Id &&
 		a.Status == b.Status &&
 		a.FailReason == b.FailReason &&
+		a.Attempts == b.Attempts &&
 		reflect.DeepEqual(a.Payload, b.Payload) &&
 		reflect.DeepEqual(a.Errs, b.Errs)
 }
@@ -142,6 +175,45 @@ func (rec *StatusRecord) isDone() bool {
 	return rec.Status >= SuccessStatus
 }
 
+func (rec *StatusRecord) copy() *StatusRecord {
+	return &StatusRecord{
+		Id:         rec.Id,
+		Status:     rec.Status,
+		FailReason: rec.FailReason,
+		Payload:    rec.Payload,
+		Result:     rec.Result,
+		Errs:       rec.Errs,
+		Errors:     rec.Errors,
+		Attempts:   rec.Attempts,
+	}
+}
+
+/*
+	Result of a ticket is its payload once successful
+*/
+func makeResult(status StatusCode, payload []byte) []byte {
+	if status != SuccessStatus {
+		return nil
+	}
+	return payload
+}
+
+/*
+	Builds error details readable by clients from errors
+*/
+func makeErrorDetails(errs []error) []string {
+	if len(errs) == 0 {
+		return nil
+	}
+	details := []string{}
+	for _, err := range errs {
+		if err != nil {
+			details = append(details, err.Error())
+		}
+	}
+	return details
+}
+
 func makeStatusEmptyRecord(id Ticket) *StatusRecord {
 	return &StatusRecord{
 		Id: id,
@@ -0,0 +1,151 @@
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+	Pluggable persistence of status records
+*/
+type StatusStore interface {
+	Save(record *StatusRecord) error
+	Load() ([]*StatusRecord, error)
+	Delete(ticket Ticket) error
+}
+
+/*
+	Errors
+*/
+var (
+	invalidStoredTicketError error = errors.New("Invalid stored ticket.")
+)
+
+/*
+	Encoded form of a status record (errors are stored as their details)
+*/
+type storedStatusRecord struct {
+	Id         Ticket         `json:"id"`
+	Status     StatusCode     `json:"status"`
+	FailReason FailReasonCode `json:"failReason"`
+	Payload    []byte         `json:"payload"`
+	Errs       []string       `json:"errors"`
+	Attempts   int            `json:"attempts"`
+}
+
+func encodeStatusRecord(rec *StatusRecord) ([]byte, error) {
+	stored := storedStatusRecord{
+		Id:         rec.Id,
+		Status:     rec.Status,
+		FailReason: rec.FailReason,
+		Payload:    rec.Payload,
+		Errs:       rec.Errors,
+		Attempts:   rec.Attempts,
+	}
+	if stored.Errs == nil {
+		stored.Errs = makeErrorDetails(rec.Errs)
+	}
+	return json.Marshal(stored)
+}
+
+func decodeStatusRecord(raw []byte) (*StatusRecord, error) {
+	stored := storedStatusRecord{}
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return nil, err
+	}
+	rec := &StatusRecord{
+		Id:         stored.Id,
+		Status:     stored.Status,
+		FailReason: stored.FailReason,
+		Payload:    stored.Payload,
+		Result:     makeResult(stored.Status, stored.Payload),
+		Errors:     stored.Errs,
+		Attempts:   stored.Attempts,
+	}
+	for _, errMsg := range stored.Errs {
+		rec.Errs = append(rec.Errs, errors.New(errMsg))
+	}
+	return rec, rec.check()
+}
+
+/*
+	File backed status store (one file per ticket in a directory)
+*/
+const statusFileExtension string = ".json"
+
+type FileStatusStore struct {
+	directory string
+}
+
+func NewFileStatusStore(directory string) (*FileStatusStore, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStatusStore{
+		directory: directory,
+	}, nil
+}
+
+func (store *FileStatusStore) ticketPath(ticket Ticket) (string, error) {
+	name := string(ticket)
+	if len(name) == 0 || strings.ContainsAny(name, `/\.`) {
+		return "", invalidStoredTicketError
+	}
+	return filepath.Join(store.directory, name+statusFileExtension), nil
+}
+
+func (store *FileStatusStore) Save(record *StatusRecord) error {
+	path, err := store.ticketPath(record.Id)
+	if err != nil {
+		return err
+	}
+	raw, err := encodeStatusRecord(record)
+	if err != nil {
+		return err
+	}
+
+	// Write to temporary file and rename so that records are never partially written
+	temporaryPath := path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, path)
+}
+
+func (store *FileStatusStore) Load() ([]*StatusRecord, error) {
+	files, err := ioutil.ReadDir(store.directory)
+	if err != nil {
+		return nil, err
+	}
+	records := []*StatusRecord{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != statusFileExtension {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(store.directory, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		record, err := decodeStatusRecord(raw)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (store *FileStatusStore) Delete(ticket Ticket) error {
+	path, err := store.ticketPath(ticket)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package status
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFileStatusStore(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "dmpc-status")
+	defer os.RemoveAll(directory)
+	store, err := NewFileStatusStore(directory)
+	if err != nil {
+		t.Errorf("Creating file status store should not fail. err=%v", err)
+		return
+	}
+
+	record := &StatusRecord{
+		Id:         RequestNewTicket(),
+		Status:     FailedStatus,
+		FailReason: FailedReason,
+		Payload:    []byte("PAYLOAD"),
+		Errs:       []error{errors.New("Failure.")},
+	}
+	if err := store.Save(record); err != nil {
+		t.Errorf("Saving status record should not fail. err=%v", err)
+	}
+	records, err := store.Load()
+	if err != nil || len(records) != 1 || !records[0].isSame(record) {
+		t.Errorf("Loaded status record should match saved one. records=%+v, err=%v", records, err)
+	}
+
+	if err := store.Delete(record.Id); err != nil {
+		t.Errorf("Deleting status record should not fail. err=%v", err)
+	}
+	if records, err := store.Load(); err != nil || len(records) != 0 {
+		t.Errorf("Deleted status record should not be loaded. records=%+v, err=%v", records, err)
+	}
+
+	if err := store.Save(&StatusRecord{Id: Ticket("../ESCAPE"), Status: QueuedStatus}); err != invalidStoredTicketError {
+		t.Errorf("Saving record with invalid ticket should fail. err=%v", err)
+	}
+}
+
+func TestStatusPersistenceAcrossRestart(t *testing.T) {
+	directory, _ := ioutil.TempDir("", "dmpc-status")
+	defer os.RemoveAll(directory)
+	store, _ := NewFileStatusStore(directory)
+	conf := StatusServerConfig{
+		NumWorkers: 1,
+		Store:      store,
+	}
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+
+	// Update statuses
+	runningTicket := RequestNewTicket()
+	doneTicket := RequestNewTicket()
+	UpdateStatus(runningTicket, QueuedStatus, NoReason, nil, nil)
+	UpdateStatus(runningTicket, RunningStatus, NoReason, nil, nil)
+	UpdateStatus(doneTicket, SuccessStatus, NoReason, []byte("RESULT"), nil)
+	shutdownStatusServer()
+
+	// Simulate restart by reloading from the store
+	if !resetAndStartStatusServer(t, conf) {
+		return
+	}
+	shutdownStatusServer()
+	if statusStore.Len() != 2 {
+		t.Errorf("Persisted statuses should be reloaded. found=%v", statusStore.Len())
+	}
+	if record, err := GetStatus(runningTicket); err != nil || record.Status != RunningStatus {
+		t.Errorf("Running status should survive restart. record=%+v, err=%v", record, err)
+	}
+	if record, err := GetStatus(doneTicket); err != nil || record.Status != SuccessStatus || !reflect.DeepEqual(record.Payload, []byte("RESULT")) {
+		t.Errorf("Final status should survive restart. record=%+v, err=%v", record, err)
+	}
+	if newTicket := RequestNewTicket(); !isKnownTicket(runningTicket) || newTicket == runningTicket || newTicket == doneTicket {
+		t.Errorf("New tickets should not collide with persisted ones.")
+	}
+}
@@ -0,0 +1,164 @@
+package status
+
+import (
+	"sync"
+)
+
+/*
+	Status transition sent to subscribers
+*/
+type StatusUpdate struct {
+	Ticket     Ticket
+	Status     StatusCode
+	FailReason FailReasonCode
+	Payload    []byte
+	Errs       []error
+	Errors     []string
+	Attempts   int
+}
+
+func makeStatusUpdate(rec *StatusRecord) StatusUpdate {
+	return StatusUpdate{
+		Ticket:     rec.Id,
+		Status:     rec.Status,
+		FailReason: rec.FailReason,
+		Payload:    rec.Payload,
+		Errs:       rec.Errs,
+		Errors:     rec.Errors,
+		Attempts:   rec.Attempts,
+	}
+}
+
+/*
+	Structure of a subscription to a ticket
+*/
+type subscription struct {
+	ticket     Ticket
+	channel    chan StatusUpdate
+	cancelled  chan bool
+	cancelOnce *sync.Once
+	closed     bool
+}
+
+/*
+	Structure of subscription request (registered by listeners server)
+*/
+type subscriptionRequest struct {
+	subscription *subscription
+	registered   chan bool
+}
+
+/*
+	Subscriptions indexed by ticket
+*/
+type subscriptionRegistry struct {
+	subscriptions map[Ticket]map[*subscription]bool
+	lock          *sync.Mutex
+}
+
+var subscriptions *subscriptionRegistry = makeSubscriptionRegistry()
+
+func makeSubscriptionRegistry() *subscriptionRegistry {
+	return &subscriptionRegistry{
+		subscriptions: map[Ticket]map[*subscription]bool{},
+		lock:          &sync.Mutex{},
+	}
+}
+
+/*
+	Subscribes to all status transitions of a ticket
+	(channel is closed when the ticket is done or the subscription is cancelled)
+*/
+func SubscribeStatus(ticket Ticket) (<-chan StatusUpdate, func(), error) {
+	log.Debugf(subscriptionReceivedRequestLogMsg)
+
+	sub := &subscription{
+		ticket:     ticket,
+		channel:    make(chan StatusUpdate, DefaultChannelBufferSize),
+		cancelled:  make(chan bool),
+		cancelOnce: &sync.Once{},
+	}
+	request := &subscriptionRequest{
+		subscription: sub,
+		registered:   make(chan bool),
+	}
+
+	// Wait for subscription to be registered by listeners server
+	if _, err := listenersServerHandler.MakeRequest(request); err != nil {
+		close(sub.channel)
+		return sub.channel, func() {}, err
+	}
+	<-request.registered
+
+	cancel := func() {
+		subscriptions.cancel(sub)
+	}
+	return sub.channel, cancel, nil
+}
+
+/*
+	Registers subscription, and sends current status if any
+	Status record lock should be held
+*/
+func (registry *subscriptionRegistry) register(sub *subscription, statusRecord *StatusRecord) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if statusRecord.Status != NoStatus {
+		sub.channel <- makeStatusUpdate(statusRecord)
+	}
+	if statusRecord.isDone() {
+		sub.closed = true
+		close(sub.channel)
+		return
+	}
+
+	if registry.subscriptions[sub.ticket] == nil {
+		registry.subscriptions[sub.ticket] = map[*subscription]bool{}
+	}
+	registry.subscriptions[sub.ticket][sub] = true
+}
+
+func (registry *subscriptionRegistry) cancel(sub *subscription) {
+	sub.cancelOnce.Do(func() {
+		// Unblock any update being sent
+		close(sub.cancelled)
+
+		registry.lock.Lock()
+		defer registry.lock.Unlock()
+		delete(registry.subscriptions[sub.ticket], sub)
+		if len(registry.subscriptions[sub.ticket]) == 0 {
+			delete(registry.subscriptions, sub.ticket)
+		}
+		if !sub.closed {
+			sub.closed = true
+			close(sub.channel)
+		}
+	})
+}
+
+/*
+	Sends status update to all subscribers of the ticket
+	Status record lock should be held
+*/
+func (registry *subscriptionRegistry) fanOut(statusRecord *StatusRecord) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	update := makeStatusUpdate(statusRecord)
+	for sub := range registry.subscriptions[statusRecord.Id] {
+		select {
+		case sub.channel <- update:
+		case <-sub.cancelled:
+		}
+	}
+
+	// If final update, close all subscriptions
+	if statusRecord.isDone() {
+		for sub := range registry.subscriptions[statusRecord.Id] {
+			sub.closed = true
+			close(sub.channel)
+		}
+		delete(registry.subscriptions, statusRecord.Id)
+	}
+}
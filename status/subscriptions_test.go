@@ -0,0 +1,124 @@
+package status
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeStatusServerDown(t *testing.T) {
+	channel, cancel, err := SubscribeStatus(RequestNewTicket())
+	if err == nil {
+		t.Errorf("Subscribing while listeners server is down should fail.")
+		return
+	}
+	if _, isOpen := <-channel; isOpen {
+		t.Errorf("Subscribing while listeners server is down should close channel.")
+	}
+	cancel()
+}
+
+func TestSubscribeStatusTransitions(t *testing.T) {
+	// Single status worker so that updates are applied in order
+	if !resetAndStartBothServers(t, StatusServerConfig{NumWorkers: 1}, multipleWorkersListenersConfig(), false) {
+		return
+	}
+
+	ticket := RequestNewTicket()
+	channel, cancel, err := SubscribeStatus(ticket)
+	if err != nil {
+		t.Errorf("Subscribing should not fail. err=%v", err)
+		return
+	}
+	defer cancel()
+
+	// Each transition should be received in order, then channel should be closed
+	expectedStatuses := []StatusCode{QueuedStatus, RunningStatus, SuccessStatus}
+	go (func() {
+		for _, expectedStatus := range expectedStatuses {
+			UpdateStatus(ticket, expectedStatus, NoReason, []byte{byte(expectedStatus)}, nil)
+		}
+	})()
+	for _, expectedStatus := range expectedStatuses {
+		update, isOpen := <-channel
+		if !isOpen || update.Ticket != ticket || update.Status != expectedStatus || update.Payload[0] != byte(expectedStatus) {
+			t.Errorf("Subscriber should receive transitions in order. found=%+v, expected status=%v", update, expectedStatus)
+		}
+	}
+	if _, isOpen := <-channel; isOpen {
+		t.Errorf("Subscription channel should be closed after terminal status.")
+	}
+
+	// Late subscribers should only get terminal status
+	lateChannel, lateCancel, _ := SubscribeStatus(ticket)
+	if update, isOpen := <-lateChannel; !isOpen || update.Status != SuccessStatus {
+		t.Errorf("Late subscriber should get terminal status. found=%+v", update)
+	}
+	if _, isOpen := <-lateChannel; isOpen {
+		t.Errorf("Late subscription channel should be closed.")
+	}
+	lateCancel()
+
+	ShutdownServers()
+}
+
+func TestSubscribeStatusCancel(t *testing.T) {
+	if !resetAndStartBothServers(t, multipleWorkersStatusConfig(), multipleWorkersListenersConfig(), false) {
+		return
+	}
+
+	ticket := RequestNewTicket()
+	channel, cancel, _ := SubscribeStatus(ticket)
+	cancel()
+	cancel()
+	if _, isOpen := <-channel; isOpen {
+		t.Errorf("Subscription channel should be closed after cancelling.")
+	}
+
+	// Updates should go through without subscribers
+	UpdateStatus(ticket, QueuedStatus, NoReason, nil, nil)
+	UpdateStatus(ticket, FailedStatus, FailedReason, nil, nil)
+
+	ShutdownServers()
+}
+
+func TestSubscribeStatusCoalesced(t *testing.T) {
+	conf := StatusServerConfig{NumWorkers: 1, CoalesceWindow: 100 * time.Millisecond}
+	if !resetAndStartBothServers(t, conf, multipleWorkersListenersConfig(), false) {
+		return
+	}
+
+	ticket := RequestNewTicket()
+	channel, cancel, err := SubscribeStatus(ticket)
+	if err != nil {
+		t.Fatalf("Subscribing should not fail. err=%v", err)
+	}
+	defer cancel()
+
+	// Burst of non-terminal updates is received once with the latest status
+	UpdateStatus(ticket, QueuedStatus, NoReason, nil, nil)
+	UpdateStatus(ticket, RunningStatus, NoReason, nil, nil)
+	for attempt := 1; attempt <= 3; attempt++ {
+		ReportRetry(ticket, attempt, nil)
+	}
+	update := <-channel
+	if update.Status != RunningStatus || update.Attempts != 3 {
+		t.Errorf("Subscriber should receive latest coalesced status. found=%+v", update)
+	}
+	select {
+	case update := <-channel:
+		t.Errorf("Coalesced updates should only be received once. found=%+v", update)
+	case <-time.After(2 * conf.CoalesceWindow):
+	}
+
+	// Terminal update replaces pending update, and is never dropped
+	UpdateStatus(ticket, RunningStatus, NoReason, []byte("PENDING"), nil)
+	UpdateStatus(ticket, SuccessStatus, NoReason, []byte("RESULT"), nil)
+	if update, isOpen := <-channel; !isOpen || update.Status != SuccessStatus || string(update.Payload) != "RESULT" {
+		t.Errorf("Subscriber should receive terminal status right after latest status. found=%+v", update)
+	}
+	if _, isOpen := <-channel; isOpen {
+		t.Errorf("Subscription channel should be closed after terminal status.")
+	}
+
+	ShutdownServers()
+}
@@ -2,6 +2,7 @@ package status
 
 import (
 	"github.com/mngharbi/DMPC/core"
+	"sync"
 )
 
 /*
@@ -15,8 +16,89 @@ type Ticket string
 type TicketGenerator func() Ticket
 
 /*
-	Generates a new ticket through the core package utility
+	Source of ticket ids
+	(ids are opaque to the daemon, but should be collision resistant)
+*/
+type TicketIdGenerator func() string
+
+var (
+	ticketIdGenerator     TicketIdGenerator = core.GenerateUniqueId
+	ticketIdGeneratorLock *sync.RWMutex     = &sync.RWMutex{}
+)
+
+// Unique ids from the core package are used if nil
+func setTicketIdGenerator(generator TicketIdGenerator) {
+	if generator == nil {
+		generator = core.GenerateUniqueId
+	}
+	ticketIdGeneratorLock.Lock()
+	ticketIdGenerator = generator
+	ticketIdGeneratorLock.Unlock()
+}
+
+func generateTicketId() string {
+	ticketIdGeneratorLock.RLock()
+	generator := ticketIdGenerator
+	ticketIdGeneratorLock.RUnlock()
+	return generator()
+}
+
+/*
+	Set of tickets safe for concurrent use
+*/
+type ticketSet struct {
+	tickets map[Ticket]bool
+	lock    *sync.RWMutex
+}
+
+func makeTicketSet() *ticketSet {
+	return &ticketSet{
+		tickets: map[Ticket]bool{},
+		lock:    &sync.RWMutex{},
+	}
+}
+
+func (set *ticketSet) add(ticket Ticket) {
+	set.lock.Lock()
+	set.tickets[ticket] = true
+	set.lock.Unlock()
+}
+
+func (set *ticketSet) remove(ticket Ticket) {
+	set.lock.Lock()
+	delete(set.tickets, ticket)
+	set.lock.Unlock()
+}
+
+func (set *ticketSet) contains(ticket Ticket) bool {
+	set.lock.RLock()
+	defer set.lock.RUnlock()
+	return set.tickets[ticket]
+}
+
+/*
+	Tickets issued by this daemon (only those can be updated)
+*/
+var issuedTickets *ticketSet = makeTicketSet()
+
+/*
+	Generates and registers a new ticket through the configured id generator
+	(tickets already known, including ones reloaded from persistence, are never reused)
 */
 func RequestNewTicket() Ticket {
-	return Ticket(core.GenerateUniqueId())
+	for {
+		ticket := Ticket(generateTicketId())
+		if !isKnownTicket(ticket) {
+			issuedTickets.add(ticket)
+			metrics.ticketCreated(ticket, statusServerSingleton.now())
+			return ticket
+		}
+	}
+}
+
+func isKnownTicket(ticket Ticket) bool {
+	if issuedTickets.contains(ticket) || expiredTickets.contains(ticket) {
+		return true
+	}
+	return statusStore != nil && statusStore.Get(makeStatusEmptyRecord(ticket), statusMemstoreId) != nil
 }
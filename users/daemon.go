@@ -1,10 +1,15 @@
 package users
 
 import (
+	"context"
+	"errors"
 	"github.com/mngharbi/DMPC/core"
 	"github.com/mngharbi/gofarm"
 	"github.com/mngharbi/memstore"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 /*
@@ -12,6 +17,76 @@ import (
 */
 type Requester func(*core.VerifiedSigners, []byte) (chan *UserResponse, []error)
 
+/*
+	Requester failing every request, for deployments running without the users subsystem
+*/
+func NoopRequester(*core.VerifiedSigners, []byte) (chan *UserResponse, []error) {
+	return nil, []error{errors.New(usersUnavailableErrorMsg)}
+}
+
+/*
+	Determines if requests can't be resolved by the requester (nil or no-op)
+*/
+func (requester Requester) IsNoop() bool {
+	return requester == nil || reflect.ValueOf(requester).Pointer() == reflect.ValueOf(NoopRequester).Pointer()
+}
+
+/*
+	Makes a request and waits for its response unless the context is done first
+	(no request is made if the context is already done, and the context error is returned)
+	Not ok if the response channel was closed without a response
+*/
+func (requester Requester) RequestWithContext(ctx context.Context, signers *core.VerifiedSigners, rawRequest []byte) (*UserResponse, bool, []error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, []error{err}
+	}
+	channel, errs := requester(signers, rawRequest)
+	if len(errs) != 0 {
+		return nil, false, errs
+	}
+	select {
+	case response, ok := <-channel:
+		return response, ok, nil
+	case <-ctx.Done():
+		return nil, false, []error{ctx.Err()}
+	}
+}
+
+/*
+	Errors
+*/
+const (
+	serverNotRunningErrorMsg string = "Users server is not running"
+	usersUnavailableErrorMsg string = "Users subsystem is not available"
+)
+
+/*
+	Error returned when a request can't be handed to the server (not running or shutting down)
+	Unlike errors in the request itself, making the same request later can succeed
+*/
+type UnavailableError struct {
+	Err error
+}
+
+func (err *UnavailableError) Error() string {
+	return err.Err.Error()
+}
+
+/*
+	Determines if errors returned by a requester only come from the server being unavailable
+*/
+func IsUnavailable(errs []error) bool {
+	if len(errs) == 0 {
+		return false
+	}
+	for _, err := range errs {
+		if _, ok := err.(*UnavailableError); !ok {
+			return false
+		}
+	}
+	return true
+}
+
 /*
 	Logging
 */
@@ -26,6 +101,9 @@ var (
 
 type Config struct {
 	NumWorkers int
+
+	// Source of current time for key expiry checks (system clock if nil)
+	Clock core.Clock
 }
 
 func provisionServerOnce() {
@@ -47,28 +125,59 @@ func StartServer(
 		serverHandler.ResetServer()
 		serverHandler.InitServer(&serverSingleton)
 	}
-	return serverHandler.StartServer(gofarm.Config{NumWorkers: conf.NumWorkers})
+	serverSingleton.clock = core.ClockOrSystem(conf.Clock)
+	if err := serverHandler.StartServer(gofarm.Config{NumWorkers: conf.NumWorkers}); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&serverRunning, 1)
+	return nil
 }
 
 func ShutdownServer() {
 	provisionServerOnce()
+	atomic.StoreInt32(&serverRunning, 0)
 	serverHandler.ShutdownServer()
 }
 
+/*
+	Checks that the server is accepting requests (without making one)
+*/
+func Probe() error {
+	if atomic.LoadInt32(&serverRunning) == 0 {
+		return errors.New(serverNotRunningErrorMsg)
+	}
+	return nil
+}
+
 func MakeUnverifiedRequest(signers *core.VerifiedSigners, rawRequest []byte) (chan *UserResponse, []error) {
 	log.Debugf(receivedRequestLogMsg)
-	return makeEncodedRequest(signers, rawRequest, true)
+	return makeEncodedRequest(signers, rawRequest, true, false)
 }
 
 func MakeRequest(signers *core.VerifiedSigners, rawRequest []byte) (chan *UserResponse, []error) {
 	log.Debugf(receivedRequestLogMsg)
-	return makeEncodedRequest(signers, rawRequest, false)
+	return makeEncodedRequest(signers, rawRequest, false, false)
 }
 
-func makeEncodedRequest(signers *core.VerifiedSigners, rawRequest []byte, skipPermissions bool) (chan *UserResponse, []error) {
+/*
+	Dry run requests go through the same checks and respond with the would-be result,
+	without changing any record
+*/
+func MakeUnverifiedDryRunRequest(signers *core.VerifiedSigners, rawRequest []byte) (chan *UserResponse, []error) {
+	log.Debugf(receivedDryRunRequestLogMsg)
+	return makeEncodedRequest(signers, rawRequest, true, true)
+}
+
+func MakeDryRunRequest(signers *core.VerifiedSigners, rawRequest []byte) (chan *UserResponse, []error) {
+	log.Debugf(receivedDryRunRequestLogMsg)
+	return makeEncodedRequest(signers, rawRequest, false, true)
+}
+
+func makeEncodedRequest(signers *core.VerifiedSigners, rawRequest []byte, skipPermissions bool, dryRun bool) (chan *UserResponse, []error) {
 	// Build request object
 	rqPtr := &UserRequest{}
 	rqPtr.skipPermissions = skipPermissions
+	rqPtr.dryRun = dryRun
 	decodingError := rqPtr.Decode(rawRequest)
 	if decodingError != nil {
 		return nil, []error{decodingError}
@@ -90,11 +199,11 @@ func makeRequest(rqPtr *UserRequest) (chan *UserResponse, []error) {
 	// Make request to server
 	nativeResponseChannel, err := serverHandler.MakeRequest(rqPtr)
 	if err != nil {
-		return nil, []error{err}
+		return nil, []error{&UnavailableError{Err: err}}
 	}
 
-	// Pass through result
-	responseChannel := make(chan *UserResponse)
+	// Pass through result (buffered so that abandoned responses don't block)
+	responseChannel := make(chan *UserResponse, 1)
 	go func() {
 		nativeResponse, ok := <-nativeResponseChannel
 		if ok {
@@ -114,6 +223,12 @@ func makeRequest(rqPtr *UserRequest) (chan *UserResponse, []error) {
 type server struct {
 	isInitialized bool
 	store         *memstore.Memstore
+	ids           *userIdSet
+	clock         core.Clock
+}
+
+func (sv *server) now() time.Time {
+	return core.ClockOrSystem(sv.clock).Now()
 }
 
 // Indexes used to store users
@@ -131,10 +246,19 @@ func getIndexes() (res []string) {
 var serverSingleton server
 var serverHandler *gofarm.ServerHandler
 
+// Held for reading while requests run, and for writing by exports and imports
+var storeLock *sync.RWMutex = &sync.RWMutex{}
+
+// Set while the server is started (read by probes)
+var serverRunning int32
+
 func (sv *server) Start(_ gofarm.Config, isFirstStart bool) error {
 	// Initialize store (only if starting for the first time)
 	if isFirstStart {
+		storeLock.Lock()
 		sv.store = memstore.New(getIndexes())
+		sv.ids = makeUserIdSet()
+		storeLock.Unlock()
 	}
 	log.Debugf(daemonStartLogMsg)
 	return nil
@@ -150,6 +274,9 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 
 	rq := (*request).(*UserRequest)
 
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
 	/*
 		Handle record level locking
 	*/
@@ -165,7 +292,7 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 	}
 
 	// Add write lock for user record if updating
-	if rq.Type == UpdateRequest {
+	if rq.Type == UpdateRequest || rq.Type == RotateKeysRequest {
 		lockNeeds = append(lockNeeds, core.LockNeed{true, rq.Data.Id})
 	}
 
@@ -205,7 +332,7 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 		if !rq.skipPermissions && certifierIndex == -1 {
 			return failRequest(CertifierUnknownError)
 		}
-		if subjectIndex == -1 && (rq.Type == ReadRequest || rq.Type == UpdateRequest) {
+		if subjectIndex == -1 && (rq.Type == ReadRequest || rq.Type == UpdateRequest || rq.Type == RotateKeysRequest) {
 			return failRequest(SubjectUnknownError)
 		}
 	}
@@ -224,14 +351,35 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 			// Then fail with certifier permissions error
 			return failRequest(CertifierPermissionsError)
 		}
+
+		// Inactive issuers can't make requests
+		if !userRecords[issuerIndex].IsActive() {
+			_, isUnlocked := unlockUsers(sv, lockNeeds)
+			if !isUnlocked {
+				return failRequest(UnlockingFailedError)
+			}
+			return failRequest(IssuerInactiveError)
+		}
+
+		// Issuers need permission for every field they update (unless updating their own keys)
+		if (rq.Type == UpdateRequest || rq.Type == RotateKeysRequest) && !userRecords[issuerIndex].isAuthorized(rq) {
+			_, isUnlocked := unlockUsers(sv, lockNeeds)
+			if !isUnlocked {
+				return failRequest(UnlockingFailedError)
+			}
+			return failRequest(IssuerPermissionsError)
+		}
 	}
 
 	/*
 		Run request
 	*/
 	responseData := []*UserObject{}
+	var encodingErr error
+	unknownFieldsFailure := false
+	var updateResult *UpdateResult
 	switch rq.Type {
-	case UpdateRequest:
+	case UpdateRequest, RotateKeysRequest:
 		// Determine memstore update mode
 		isIndexUpdated := false
 		for _, updatedFieldName := range rq.Fields {
@@ -244,37 +392,59 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 		// Make search record
 		searchRecordPtr := (&rq.Data).makeSearchByIdRecord()
 
-		// Atomically apply request to record in memstore
+		// Atomically apply request to record in memstore (or to a copy for dry runs)
 		updateFunc := func(obj memstore.Item) (memstore.Item, bool) {
 			objCopy := obj.(*userRecord)
-			objCopy.applyUpdateRequest(rq)
+			updateResult = objCopy.applyUpdateRequest(rq)
 			return objCopy, true
 		}
 		var modifiedRecord *userRecord
-		if isIndexUpdated {
+		if rq.dryRun {
+			if modifiedRecord, encodingErr = userRecords[subjectIndex].copy(); encodingErr == nil {
+				updateFunc(modifiedRecord)
+			}
+		} else if isIndexUpdated {
 			modifiedRecord = sv.store.UpdateWithIndexes(searchRecordPtr, "id", updateFunc).(*userRecord)
 		} else {
 			modifiedRecord = sv.store.UpdateData(searchRecordPtr, "id", updateFunc).(*userRecord)
 		}
 
+		// Fail if any field was not recognized (including fields removed during sanitization)
+		if len(rq.droppedFields) != 0 {
+			unknownFieldsFailure = true
+		}
+		if updateResult != nil {
+			if len(updateResult.Unknown) != 0 {
+				unknownFieldsFailure = true
+			}
+			updateResult.Unknown = append(append([]string{}, rq.droppedFields...), updateResult.Unknown...)
+		}
+
 		// Add user modified to response
-		modifiedObject := &UserObject{}
-		modifiedObject.createFromRecord(modifiedRecord)
-		responseData = append(responseData, modifiedObject)
+		if encodingErr == nil {
+			modifiedObject := &UserObject{}
+			encodingErr = modifiedObject.createFromRecord(modifiedRecord)
+			responseData = append(responseData, modifiedObject)
+		}
 
 	case CreateRequest:
 		// Generate record
 		newUser := &userRecord{
-			lock: &sync.RWMutex{},
+			History:  &updateHistory{},
+			lock:     &sync.RWMutex{},
+			dataLock: &sync.RWMutex{},
 		}
 		newUser.create(rq)
 
-		// Add to memstore
-		sv.store.Add(newUser)
+		// Add to memstore (unless dry running)
+		if !rq.dryRun {
+			sv.store.Add(newUser)
+			sv.ids.add(newUser.Id)
+		}
 
 		// Add user created to response
 		createdObject := &UserObject{}
-		createdObject.createFromRecord(newUser)
+		encodingErr = createdObject.createFromRecord(newUser)
 		responseData = append(responseData, createdObject)
 
 	case ReadRequest:
@@ -292,7 +462,9 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 		// Transform records requested into objects and add to response
 		for _, userRecordIndex := range usersRequestedIds {
 			createdObject := &UserObject{}
-			createdObject.createFromRecord(userRecords[userRecordIndex])
+			if encodingErr = createdObject.createFromRecord(userRecords[userRecordIndex]); encodingErr != nil {
+				break
+			}
 			responseData = append(responseData, createdObject)
 		}
 	}
@@ -305,21 +477,40 @@ func (sv *server) Work(request *gofarm.Request) *gofarm.Response {
 		return failRequest(UnlockingFailedError)
 	}
 
+	// Fail if any record couldn't be encoded into an object
+	if encodingErr != nil {
+		return failRequest(RecordEncodingError)
+	}
+
+	// Fail if any updated field was not recognized (valid fields are still applied)
+	if unknownFieldsFailure {
+		return failUpdateRequest(UnknownFieldsError, updateResult)
+	}
+
 	// Request is done, return response generated
-	return successRequest(responseData)
+	return successUpdateRequest(responseData, updateResult)
 }
 
 func failRequest(responseCode int) *gofarm.Response {
+	return failUpdateRequest(responseCode, nil)
+}
+
+func failUpdateRequest(responseCode int, updateResult *UpdateResult) *gofarm.Response {
 	log.Debugf(failRequestLogMsg)
 	userRespPtr := &UserResponse{
 		Result: responseCode,
 		Data:   []UserObject{},
+		Update: updateResult,
 	}
 	var nativeResp gofarm.Response = userRespPtr
 	return &nativeResp
 }
 
 func successRequest(responseData []*UserObject) *gofarm.Response {
+	return successUpdateRequest(responseData, nil)
+}
+
+func successUpdateRequest(responseData []*UserObject, updateResult *UpdateResult) *gofarm.Response {
 	log.Debugf(successRequestLogMsg)
 	var objectDataCopy []UserObject
 	for _, objectPtr := range responseData {
@@ -329,6 +520,7 @@ func successRequest(responseData []*UserObject) *gofarm.Response {
 	userRespPtr := &UserResponse{
 		Result: Success,
 		Data:   objectDataCopy,
+		Update: updateResult,
 	}
 	var nativeResp gofarm.Response = userRespPtr
 	return &nativeResp
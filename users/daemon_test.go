@@ -1,10 +1,12 @@
 package users
 
 import (
+	"context"
 	"github.com/mngharbi/DMPC/core"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 /*
@@ -22,7 +24,44 @@ func TestStartShutdown(t *testing.T) {
 	if !resetAndStartServer(t, multipleWorkersConfig()) {
 		return
 	}
+	if err := Probe(); err != nil {
+		t.Errorf("Probe should succeed while server is running. err=%v", err)
+	}
 	ShutdownServer()
+	if err := Probe(); err == nil {
+		t.Errorf("Probe should fail after server is shutdown.")
+	}
+}
+
+func TestRequestWithContextCancelled(t *testing.T) {
+	// Lookup that never responds
+	requestsMade := 0
+	var requester Requester = func(*core.VerifiedSigners, []byte) (chan *UserResponse, []error) {
+		requestsMade++
+		return make(chan *UserResponse), nil
+	}
+
+	// Cancelling mid-lookup returns promptly
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	lookupDone := make(chan []error, 1)
+	go (func() {
+		_, _, errs := requester.RequestWithContext(ctx, nil, []byte{})
+		lookupDone <- errs
+	})()
+	select {
+	case errs := <-lookupDone:
+		if len(errs) != 1 || errs[0] != context.Canceled {
+			t.Errorf("Cancelled lookup should fail with cancellation error. errs=%v", errs)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Cancelled lookup should return promptly.")
+	}
+
+	// No lookup is made once cancelled
+	if _, ok, errs := requester.RequestWithContext(ctx, nil, []byte{}); ok || len(errs) != 1 || errs[0] != context.Canceled || requestsMade != 1 {
+		t.Errorf("Lookup should not be made with cancelled context. errs=%v, requests=%v", errs, requestsMade)
+	}
 }
 
 func TestMalformattedRequest(t *testing.T) {
@@ -398,6 +437,85 @@ func TestEncKeyUpdateRequest(t *testing.T) {
 	ShutdownServer()
 }
 
+func TestRotateKeysRequest(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+
+	// Create issuer and certifier with key update permissions
+	if !createIssuerAndCertifier(t,
+		true, true, true, true, true, true,
+		true, true, true, true, true, true,
+	) {
+		return
+	}
+	// Create user
+	userid := "USER"
+	originalUserObjectPtr, success := createUser(
+		t, false, "ISSUER", "CERTIFIER", userid, false, false, false, false, false, false,
+	)
+	if !success {
+		return
+	}
+
+	encKey := core.GeneratePublicKey()
+	signKey := core.GeneratePublicKey()
+	makeRotationRequest := func(encKeyJson string, signKeyJson string) []byte {
+		timestamp := getJanuaryDate(30)
+		return []byte(`{
+			"type": 3,
+			` + generateJsonForTimePtr("timestamp", &timestamp) + `
+			"data": {
+				"id": "` + userid + `",
+				"encKey": ` + encKeyJson + `,
+				"signKey": ` + signKeyJson + `
+			}
+		}`)
+	}
+
+	// One invalid key should fail the whole rotation
+	if _, errs := MakeRequest(generateSigners("ISSUER", "CERTIFIER"), makeRotationRequest(jsonPemEncodeKey(encKey), `"INVALID"`)); len(errs) == 0 {
+		t.Errorf("Key rotation with an invalid key should fail.")
+	}
+	serverResponsePtr, ok, success := makeAndGetUserReadRequest(t, "ISSUER", "CERTIFIER", []string{userid})
+	if !success {
+		return
+	}
+	if !ok || len(serverResponsePtr.Data) != 1 || !reflect.DeepEqual(*originalUserObjectPtr, serverResponsePtr.Data[0]) {
+		t.Errorf("Failed key rotation should not affect any key.\n expected=%+v\n result=%+v", *originalUserObjectPtr, serverResponsePtr)
+	}
+
+	// Valid rotation replaces both keys
+	channel, errs := MakeRequest(generateSigners("ISSUER", "CERTIFIER"), makeRotationRequest(jsonPemEncodeKey(encKey), jsonPemEncodeKey(signKey)))
+	if len(errs) != 0 {
+		t.Errorf("Valid key rotation should go through. errs=%v", errs)
+		return
+	}
+	serverResponsePtr, ok = <-channel
+	if !ok || serverResponsePtr.Result != Success || len(serverResponsePtr.Data) != 1 {
+		t.Errorf("Valid key rotation should succeed, result:%v", serverResponsePtr)
+		return
+	}
+	rotatedObject := serverResponsePtr.Data[0]
+	if rotatedObject.EncKey != pemEncodeKey(encKey) || rotatedObject.SignKey != pemEncodeKey(signKey) || rotatedObject.UpdatedAt != getJanuaryDate(30) {
+		t.Errorf("Key rotation should replace both keys.\n result=%+v", rotatedObject)
+	}
+
+	// Old signing key still verifies right after rotation
+	if rotatedObject.PreviousSignKey != originalUserObjectPtr.SignKey {
+		t.Errorf("Rotated signing key should be in the response. found=%v", rotatedObject.PreviousSignKey)
+	}
+	verifyingKeys, err := rotatedObject.VerifyingSignKeys(getJanuaryDate(30))
+	if err != nil || len(verifyingKeys) != 2 || !reflect.DeepEqual(verifyingKeys[1], originalUserObjectPtr.signKeyObject) {
+		t.Errorf("Rotated signing key should still verify. found=%v, err=%v", verifyingKeys, err)
+	}
+	if verifyingKeys, err = rotatedObject.VerifyingSignKeys(getJanuaryDate(31)); err != nil || len(verifyingKeys) != 1 {
+		t.Errorf("Rotated signing key should not verify after retention period. found=%v, err=%v", verifyingKeys, err)
+	}
+
+	ShutdownServer()
+}
+
 func TestSignKeyUpdateRequest(t *testing.T) {
 	if !resetAndStartServer(t, multipleWorkersConfig()) {
 		return
@@ -495,6 +613,54 @@ func TestSignKeyUpdateRequest(t *testing.T) {
 	ShutdownServer()
 }
 
+func TestIssuerKeyUpdatePermissions(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+
+	// Create issuer with only enc key update permissions, and certifier with all permissions
+	if !createIssuerAndCertifier(t,
+		true, true, true, true, false, true,
+		true, true, true, true, true, true,
+	) {
+		return
+	}
+	userid := "USER"
+	if _, success := createUser(
+		t, false, "ISSUER", "CERTIFIER", userid, false, false, false, false, false, false,
+	); !success {
+		return
+	}
+	encKeyStringJson := strings.Trim(jsonPemEncodeKey(core.GeneratePublicKey()), `"`)
+	signKeyStringJson := strings.Trim(jsonPemEncodeKey(core.GeneratePublicKey()), `"`)
+
+	// Issuer can update encKey
+	serverResponsePtr, ok, success := makeAndGetUserUpdateRequest(
+		t, "ISSUER", "CERTIFIER", []string{"encKey"}, getJanuaryDate(2), &userid, &encKeyStringJson, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	if !success {
+		return
+	}
+	if !ok || serverResponsePtr.Result != Success {
+		t.Errorf("Update request to encKey with issuer permissions should succeed, result:%v", *serverResponsePtr)
+	}
+
+	// Issuer can't update signKey, alone or with encKey
+	for _, fields := range [][]string{{"signKey"}, {"encKey", "signKey"}} {
+		serverResponsePtr, ok, success = makeAndGetUserUpdateRequest(
+			t, "ISSUER", "CERTIFIER", fields, getJanuaryDate(3), &userid, &encKeyStringJson, &signKeyStringJson, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+		)
+		if !success {
+			return
+		}
+		if !ok || serverResponsePtr.Result != IssuerPermissionsError {
+			t.Errorf("Update request without issuer permissions should fail, fields:%v, result:%v", fields, *serverResponsePtr)
+		}
+	}
+
+	ShutdownServer()
+}
+
 func TestPermissionsUpdateRequest(t *testing.T) {
 	if !resetAndStartServer(t, multipleWorkersConfig()) {
 		return
@@ -597,6 +763,7 @@ func TestPermissionsUpdateRequest(t *testing.T) {
 		switch permissionType {
 		case "permissions.channel.add":
 			expectedAfterUpdatesPermission = &expectedAfterUpdates.Permissions.Channel.Add
+			expectedAfterUpdates.Permissions.Channel.AddUpdatedAt = getJanuaryDate(30)
 		case "permissions.user.add":
 			expectedAfterUpdatesPermission = &expectedAfterUpdates.Permissions.User.Add
 		case "permissions.user.remove":
@@ -618,6 +785,182 @@ func TestPermissionsUpdateRequest(t *testing.T) {
 	ShutdownServer()
 }
 
+func TestUpdateRequestResult(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+	if !createIssuerAndCertifier(t,
+		true, true, true, true, true, true,
+		true, true, true, true, true, true,
+	) {
+		return
+	}
+	userid := "USER"
+	if _, success := createUser(t, false, "ISSUER", "CERTIFIER", userid, false, false, false, false, false, false); !success {
+		return
+	}
+
+	// Update channel add permission
+	granted := true
+	request := generateUserUpdateRequest(
+		[]string{"permissions.channel.add"}, getJanuaryDate(20), &userid, nil, nil, &granted, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	channel, _ := MakeRequest(generateSigners("ISSUER", "CERTIFIER"), request)
+	<-channel
+
+	// Update newer than the user add permission but older than the channel add permission
+	request = generateUserUpdateRequest(
+		[]string{"permissions.user.add", "permissions.channel.add", "UNKNOWN"}, getJanuaryDate(18), &userid, nil, nil, &granted, &granted, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	channel, errs := MakeRequest(generateSigners("ISSUER", "CERTIFIER"), request)
+	if len(errs) != 0 {
+		t.Errorf("Valid update request should go through. errs=%v", errs)
+		return
+	}
+	serverResponsePtr := <-channel
+	expected := &UpdateResult{
+		Applied: []string{"permissions.user.add"},
+		Skipped: []string{"permissions.channel.add"},
+		Unknown: []string{"UNKNOWN"},
+	}
+	if serverResponsePtr.Result != UnknownFieldsError || !reflect.DeepEqual(serverResponsePtr.Update, expected) {
+		t.Errorf("Update response with unknown fields should fail and report which fields took effect.\n result=%+v\n expected=%+v", serverResponsePtr.Update, expected)
+	}
+
+	// Update without unknown fields
+	request = generateUserUpdateRequest(
+		[]string{"permissions.user.remove"}, getJanuaryDate(21), &userid, nil, nil, nil, nil, &granted, nil, nil, nil, nil, nil, nil, nil,
+	)
+	channel, _ = MakeRequest(generateSigners("ISSUER", "CERTIFIER"), request)
+	serverResponsePtr = <-channel
+	expected = &UpdateResult{
+		Applied: []string{"permissions.user.remove"},
+		Skipped: []string{},
+		Unknown: []string{},
+	}
+	if serverResponsePtr.Result != Success || !reflect.DeepEqual(serverResponsePtr.Update, expected) {
+		t.Errorf("Update response without unknown fields should succeed.\n result=%+v\n expected=%+v", serverResponsePtr.Update, expected)
+	}
+
+	ShutdownServer()
+}
+
+func TestUpdateRequestUnknownFields(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+	if !createIssuerAndCertifier(t,
+		true, true, true, true, true, true,
+		true, true, true, true, true, true,
+	) {
+		return
+	}
+	userid := "USER"
+	if _, success := createUser(t, false, "ISSUER", "CERTIFIER", userid, false, false, false, false, false, false); !success {
+		return
+	}
+
+	// Misspelled permission alongside a valid one
+	granted := true
+	request := generateUserUpdateRequest(
+		[]string{"permissions.channel.add", "permissions.user.addd"}, getJanuaryDate(20), &userid, nil, nil, &granted, &granted, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	channel, errs := MakeRequest(generateSigners("ISSUER", "CERTIFIER"), request)
+	if len(errs) != 0 {
+		t.Errorf("Update request with unknown field should go through. errs=%v", errs)
+		return
+	}
+	if serverResponsePtr := <-channel; serverResponsePtr.Result != UnknownFieldsError {
+		t.Errorf("Update with unknown field should fail. result=%v", serverResponsePtr.Result)
+	}
+
+	// Valid fields are still applied
+	serverResponsePtr, ok, _ := makeAndGetUserReadRequest(t, "ISSUER", "CERTIFIER", []string{userid})
+	if !ok || len(serverResponsePtr.Data) != 1 || !serverResponsePtr.Data[0].Permissions.Channel.Add || serverResponsePtr.Data[0].Permissions.User.Add {
+		t.Errorf("Only known fields should be applied. result=%+v", serverResponsePtr)
+	}
+
+	ShutdownServer()
+}
+
+func TestDryRunRequests(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+
+	// Create issuer and certifier allowed to create users and update permissions
+	if !createIssuerAndCertifier(t,
+		true, true, true, true, true, true,
+		true, true, true, true, true, true,
+	) {
+		return
+	}
+
+	// Create user and certifier without permissions
+	userid := "USER"
+	originalUserObjectPtr, success := createUser(
+		t, false, "ISSUER", "CERTIFIER", userid, false, false, false, false, false, false,
+	)
+	if !success {
+		return
+	}
+	if _, success = createUser(t, false, "ISSUER", "CERTIFIER", "UNAUTHORIZED_CERTIFIER", false, false, false, false, false, false); !success {
+		return
+	}
+
+	makeDryRun := func(certifierId string, request []byte) *UserResponse {
+		channel, errs := MakeDryRunRequest(generateSigners("ISSUER", certifierId), request)
+		if len(errs) != 0 {
+			t.Errorf("Valid dry run request should go through. errs=%v", errs)
+			return &UserResponse{}
+		}
+		return <-channel
+	}
+	checkUnchanged := func(context string) {
+		serverResponsePtr, ok, _ := makeAndGetUserReadRequest(t, "ISSUER", "CERTIFIER", []string{userid})
+		if !ok || serverResponsePtr.Result != Success || len(serverResponsePtr.Data) != 1 || !reflect.DeepEqual(*originalUserObjectPtr, serverResponsePtr.Data[0]) {
+			t.Errorf("Dry run should not change the record (%v).\n expected=%+v\n result=%+v", context, *originalUserObjectPtr, serverResponsePtr)
+		}
+	}
+
+	// Permission update reports the would-be record
+	granted := true
+	permissionUpdate := generateUserUpdateRequest(
+		[]string{"permissions.user.add"}, getJanuaryDate(30), &userid, nil, nil, nil, &granted, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	serverResponsePtr := makeDryRun("CERTIFIER", permissionUpdate)
+	expectedAfterUpdate := *originalUserObjectPtr
+	expectedAfterUpdate.Permissions.User.Add = true
+	expectedAfterUpdate.UpdatedAt = getJanuaryDate(30)
+	if serverResponsePtr.Result != Success || len(serverResponsePtr.Data) != 1 || !reflect.DeepEqual(expectedAfterUpdate, serverResponsePtr.Data[0]) {
+		t.Errorf("Dry run permission update should report the would-be record.\n expected=%+v\n result=%+v", expectedAfterUpdate, serverResponsePtr)
+	}
+	checkUnchanged("permission update")
+
+	// Validation failures are reported
+	if serverResponsePtr = makeDryRun("UNAUTHORIZED_CERTIFIER", permissionUpdate); serverResponsePtr.Result != CertifierPermissionsError {
+		t.Errorf("Dry run by a certifier without permission should fail. result=%+v", serverResponsePtr)
+	}
+	invalidFieldsUpdate := generateUserUpdateRequest(
+		[]string{"UNKNOWN"}, getJanuaryDate(30), &userid, nil, nil, nil, &granted, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	if _, errs := MakeDryRunRequest(generateSigners("ISSUER", "CERTIFIER"), invalidFieldsUpdate); len(errs) == 0 {
+		t.Errorf("Dry run without valid fields should fail validation.")
+	}
+	checkUnchanged("failed updates")
+
+	// Created user is reported but not stored
+	createRequest, createdObjectPtr := generateUserCreateRequest("DRY_RUN_USER", false, false, false, false, false, false)
+	if serverResponsePtr = makeDryRun("CERTIFIER", createRequest); serverResponsePtr.Result != Success || len(serverResponsePtr.Data) != 1 || serverResponsePtr.Data[0].Id != createdObjectPtr.Id {
+		t.Errorf("Dry run create should report the would-be user. result=%+v", serverResponsePtr)
+	}
+	if serverResponsePtr, ok, _ := makeAndGetUserReadRequest(t, "ISSUER", "CERTIFIER", []string{"DRY_RUN_USER"}); !ok || serverResponsePtr.Result != SubjectUnknownError {
+		t.Errorf("Dry run create should not add the user. result=%+v", serverResponsePtr)
+	}
+
+	ShutdownServer()
+}
+
 func TestDisableUpdateRequest(t *testing.T) {
 	if !resetAndStartServer(t, multipleWorkersConfig()) {
 		return
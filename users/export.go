@@ -0,0 +1,162 @@
+/*
+	Snapshots of the user store for backups
+*/
+
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+/*
+	Export format versions understood
+*/
+const UsersExportVersion float64 = 0.1
+
+var supportedUsersExportVersions map[float64]bool = map[float64]bool{
+	UsersExportVersion: true,
+}
+
+/*
+	Errors
+*/
+const (
+	storeUninitializedErrorMsg       string = "Users store is not initialized"
+	unsupportedExportVersionErrorMsg string = "Unsupported users export version"
+	exportedIdMissingErrorMsg        string = "Exported user id missing"
+)
+
+/*
+	Exported structure of the user store
+	(records use their JSON form, which validates keys when decoded)
+*/
+type usersExport struct {
+	Version float64           `json:"version"`
+	Records []json.RawMessage `json:"records"`
+}
+
+/*
+	Ids of users in store (memstore can't be iterated)
+*/
+type userIdSet struct {
+	ids  map[string]bool
+	lock *sync.Mutex
+}
+
+func makeUserIdSet() *userIdSet {
+	return &userIdSet{
+		ids:  map[string]bool{},
+		lock: &sync.Mutex{},
+	}
+}
+
+func (set *userIdSet) add(id string) {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	set.ids[id] = true
+}
+
+func (set *userIdSet) sorted() []string {
+	set.lock.Lock()
+	defer set.lock.Unlock()
+	ids := []string{}
+	for id := range set.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+/*
+	Serializes all user records at a point in time
+*/
+func ExportUsers() ([]byte, error) {
+	// Exclusive store lock prevents any record from changing during the export
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if serverSingleton.store == nil {
+		return nil, errors.New(storeUninitializedErrorMsg)
+	}
+
+	export := usersExport{
+		Version: UsersExportVersion,
+		Records: []json.RawMessage{},
+	}
+	for _, id := range serverSingleton.ids.sorted() {
+		recordItem := serverSingleton.store.Get(makeSearchByIdRecord(id), "id")
+		if recordItem == nil {
+			continue
+		}
+		exported, err := recordItem.(*userRecord).export()
+		if err != nil {
+			return nil, err
+		}
+		export.Records = append(export.Records, exported)
+	}
+	return json.Marshal(export)
+}
+
+/*
+	Restores user records from an export (existing records with the same ids are replaced)
+	Nothing is restored if any record is invalid
+*/
+func ImportUsers(raw []byte) error {
+	export := usersExport{}
+	if err := json.Unmarshal(raw, &export); err != nil {
+		return err
+	}
+	if !supportedUsersExportVersions[export.Version] {
+		return errors.New(unsupportedExportVersionErrorMsg)
+	}
+
+	// Rebuild all records before changing the store
+	records := []*userRecord{}
+	for _, exported := range export.Records {
+		record := &userRecord{}
+		if err := json.Unmarshal(exported, record); err != nil {
+			return err
+		}
+		if len(record.Id) == 0 {
+			return errors.New(exportedIdMissingErrorMsg)
+		}
+		records = append(records, record)
+	}
+
+	storeLock.Lock()
+	defer storeLock.Unlock()
+
+	if serverSingleton.store == nil {
+		return errors.New(storeUninitializedErrorMsg)
+	}
+	for _, record := range records {
+		serverSingleton.store.Delete(record, "id")
+		serverSingleton.store.Add(record)
+		serverSingleton.ids.add(record.Id)
+	}
+	return nil
+}
+
+func (record *userRecord) export() (json.RawMessage, error) {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+	return json.Marshal(record)
+}
+
+/*
+	Deep copy of a record (used to run requests without changing the stored record)
+*/
+func (record *userRecord) copy() (*userRecord, error) {
+	exported, err := record.export()
+	if err != nil {
+		return nil, err
+	}
+	copied := &userRecord{}
+	if err := json.Unmarshal(exported, copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
@@ -0,0 +1,118 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportImportUsers(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+
+	// Populate store
+	if !createUnverifiedUser(t, "USER_1", true, false, true, false, true, false) ||
+		!createUnverifiedUser(t, "USER_2", false, true, false, true, false, true) {
+		return
+	}
+	exported, err := ExportUsers()
+	if err != nil {
+		t.Errorf("Export should not fail. err=%v", err)
+		return
+	}
+	ShutdownServer()
+
+	// Clear store
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+	serverResponsePtr, ok, success := makeAndGetUserReadRequest(t, "USER_1", "USER_1", []string{"USER_1"})
+	if !success {
+		return
+	}
+	if !ok || serverResponsePtr.Result != IssuerUnknownError {
+		t.Errorf("Store should be empty before import. result=%v", *serverResponsePtr)
+	}
+
+	// Restore and compare
+	if err := ImportUsers(exported); err != nil {
+		t.Errorf("Import should not fail. err=%v", err)
+		return
+	}
+	reexported, err := ExportUsers()
+	if err != nil || !bytes.Equal(exported, reexported) {
+		t.Errorf("Imported records should match exported ones.\n exported: %s\n imported: %s\n err=%v", exported, reexported, err)
+	}
+	serverResponsePtr, ok, success = makeAndGetUserReadRequest(t, "USER_1", "USER_1", []string{"USER_2"})
+	if !success {
+		return
+	}
+	if !ok || serverResponsePtr.Result != Success || len(serverResponsePtr.Data) != 1 ||
+		!serverResponsePtr.Data[0].Permissions.User.Add {
+		t.Errorf("Imported records should be readable. result=%v", *serverResponsePtr)
+	}
+
+	ShutdownServer()
+}
+
+func TestImportUsersInvalid(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+	if !createUnverifiedUser(t, "USER", true, true, true, true, true, true) {
+		return
+	}
+	exported, _ := ExportUsers()
+
+	// Unknown version
+	export := usersExport{}
+	json.Unmarshal(exported, &export)
+	export.Version = UsersExportVersion + 1
+	raw, _ := json.Marshal(export)
+	if err := ImportUsers(raw); err == nil || err.Error() != unsupportedExportVersionErrorMsg {
+		t.Errorf("Import should reject unknown version. err=%v", err)
+	}
+
+	// Unparseable key
+	raw = bytes.Replace(exported, []byte(`"key":"`), []byte(`"key":"INVALID`), 1)
+	if err := ImportUsers(raw); err == nil {
+		t.Errorf("Import should reject unparseable keys.")
+	}
+
+	ShutdownServer()
+}
+
+func TestPublicKeyring(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+	for _, id := range []string{"ACTIVE", "INACTIVE", "REVOKED", "EXPIRED"} {
+		if !createUnverifiedUser(t, id, false, false, false, false, false, false) {
+			return
+		}
+	}
+	getRecord := func(id string) *userRecord {
+		return serverSingleton.store.Get(makeSearchByIdRecord(id), "id").(*userRecord)
+	}
+	getRecord("INACTIVE").Active.Ok = false
+	getRecord("REVOKED").EncKey.Revoked = true
+	getRecord("EXPIRED").EncKey.ExpiresAt = time.Now().Add(-time.Hour)
+
+	keyring := PublicKeyring()
+	activeKey := getRecord("ACTIVE").EncKey.Key
+	if len(keyring) != 1 || keyring["ACTIVE"] == nil || !reflect.DeepEqual(*keyring["ACTIVE"], activeKey) {
+		t.Fatalf("Keyring should only have keys of active users that are usable. keyring=%v", keyring)
+	}
+
+	// Changing returned keys doesn't change the store
+	keyring["ACTIVE"].E = 3
+	keyring["ACTIVE"].N.SetInt64(1)
+	if storedKey := getRecord("ACTIVE").EncKey.Key; storedKey.E == 3 || storedKey.N.BitLen() <= 1 {
+		t.Errorf("Keyring keys should be copies.")
+	}
+
+	ShutdownServer()
+}
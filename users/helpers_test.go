@@ -153,7 +153,8 @@ func generateUserCreateRequest(
 		signKeyObject: signKey,
 		Permissions: PermissionsObject{
 			Channel: ChannelPermissionsObject{
-				Add: channelAddPermission,
+				Add:          channelAddPermission,
+				AddUpdatedAt: defaultDate,
 			},
 			User: UserPermissionsObject{
 				Add:               userAddPermission,
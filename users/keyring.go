@@ -0,0 +1,51 @@
+/*
+	Public encryption keys of users for building multi-recipient operations
+*/
+
+package users
+
+import (
+	"crypto/rsa"
+	"math/big"
+	"time"
+)
+
+/*
+	Returns current encryption keys of active users by user id
+	(revoked and expired keys are excluded, and keys are copies that can be changed freely)
+*/
+func PublicKeyring() map[string]*rsa.PublicKey {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+
+	keyring := map[string]*rsa.PublicKey{}
+	if serverSingleton.store == nil {
+		return keyring
+	}
+	now := serverSingleton.now()
+	for _, id := range serverSingleton.ids.sorted() {
+		recordItem := serverSingleton.store.Get(makeSearchByIdRecord(id), "id")
+		if recordItem == nil {
+			continue
+		}
+		if key := recordItem.(*userRecord).recipientKey(now); key != nil {
+			keyring[id] = key
+		}
+	}
+	return keyring
+}
+
+/*
+	Copy of the encryption key if the user can receive operations at the time given
+*/
+func (record *userRecord) recipientKey(now time.Time) *rsa.PublicKey {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+	if !record.Active.Ok || record.EncKey.Revoked || record.EncKey.IsExpired(now) {
+		return nil
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).Set(record.EncKey.Key.N),
+		E: record.EncKey.Key.E,
+	}
+}
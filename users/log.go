@@ -4,10 +4,11 @@ package users
 	Logging messages
 */
 const (
-	daemonStartLogMsg     string = "Users daemon started"
-	daemonShutdownLogMsg  string = "Users daemon shutdown"
-	receivedRequestLogMsg string = "Users received request"
-	runningRequestLogMsg  string = "Users running request"
-	successRequestLogMsg  string = "Users request has succeeded"
-	failRequestLogMsg     string = "Users request has failed"
+	daemonStartLogMsg           string = "Users daemon started"
+	daemonShutdownLogMsg        string = "Users daemon shutdown"
+	receivedRequestLogMsg       string = "Users received request"
+	receivedDryRunRequestLogMsg string = "Users received dry run request"
+	runningRequestLogMsg        string = "Users running request"
+	successRequestLogMsg        string = "Users request has succeeded"
+	failRequestLogMsg           string = "Users request has failed"
 )
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/mngharbi/DMPC/core"
+	"sort"
 	"time"
 )
 
@@ -26,6 +27,8 @@ const (
 */
 type ChannelPermissionsObject struct {
 	Add bool `json:"add"`
+	// Time add permission was last changed (ignored in requests)
+	AddUpdatedAt time.Time `json:"addUpdatedAt"`
 }
 
 type UserPermissionsObject struct {
@@ -43,14 +46,25 @@ type UserObject struct {
 	Id     string `json:"id"`
 	EncKey string `json:"encKey"`
 	// @TODO: Make it possible to pass this directly
-	encKeyObject  *rsa.PublicKey
-	SignKey       string `json:"signKey"`
-	signKeyObject *rsa.PublicKey
-	Permissions   PermissionsObject `json:"permissions"`
-	Active        bool              `json:"active"`
-	CreatedAt     time.Time         `json:"createdAt"`
-	DisabledAt    time.Time         `json:"disabledAt"`
-	UpdatedAt     time.Time         `json:"updatedAt"`
+	encKeyObject     *rsa.PublicKey
+	SignKey          string `json:"signKey"`
+	signKeyObject    *rsa.PublicKey
+	EncKeyRevoked    bool      `json:"encKeyRevoked"`
+	SignKeyRevoked   bool      `json:"signKeyRevoked"`
+	EncKeyExpiresAt  time.Time `json:"encKeyExpiresAt"`
+	SignKeyExpiresAt time.Time `json:"signKeyExpiresAt"`
+	// Signing key replaced by the last rotation (still verifies for a while, empty if none)
+	PreviousSignKey          string            `json:"previousSignKey,omitempty"`
+	PreviousSignKeyRevokedAt time.Time         `json:"previousSignKeyRevokedAt"`
+	Permissions              PermissionsObject `json:"permissions"`
+	Active                   bool              `json:"active"`
+	CreatedAt                time.Time         `json:"createdAt"`
+	DisabledAt               time.Time         `json:"disabledAt"`
+	UpdatedAt                time.Time         `json:"updatedAt"`
+	DeactivatedBy            string            `json:"deactivatedBy"`
+	DeactivatedAt            time.Time         `json:"deactivatedAt"`
+	ReactivatedBy            string            `json:"reactivatedBy"`
+	ReactivatedAt            time.Time         `json:"reactivatedAt"`
 }
 
 /*
@@ -60,18 +74,25 @@ const (
 	CreateRequest = iota
 	UpdateRequest
 	ReadRequest
+	RotateKeysRequest
 )
 
+// Fields updated by key rotation requests (applied together or not at all)
+var rotateKeysFields []string = []string{"encKey", "signKey"}
+
 // @TODO: Change Type to enumerated type
 type UserRequest struct {
 	Type      int        `json:"type"`
 	Fields    []string   `json:"fields"`
-	Data      UserObject `json:"data"`
+	Data      UserObject `json:"data"` // Updates only need the fields named in Fields
 	Timestamp time.Time  `json:"timestamp"`
+	Sequence  uint64     `json:"sequence"` // Optional, orders updates before timestamps
 	signers   *core.VerifiedSigners
 
 	// Private settings
 	skipPermissions bool
+	dryRun          bool     // Validated and run without changing the store
+	droppedFields   []string // Unrecognized fields removed during sanitization
 }
 
 /*
@@ -84,12 +105,18 @@ const (
 	SubjectUnknownError
 	CertifierPermissionsError
 	UnlockingFailedError
+	RecordEncodingError
+	UnknownFieldsError
+	IssuerInactiveError
+	IssuerPermissionsError
 )
 
 type UserResponse struct {
 	Result int `json:"result"`
 	// @TODO: Consider returning pointers after benchmarking
 	Data []UserObject `json:"data"`
+	// Fields that took effect (only for update and key rotation requests, also kept when failing on unknown fields)
+	Update *UpdateResult `json:"update,omitempty"`
 }
 
 /*
@@ -110,7 +137,7 @@ func (rq *UserRequest) sanitizeAndCheckParams() []error {
 	res := []error{}
 
 	// Verify type, issuer, and certifier
-	if !(CreateRequest <= rq.Type && rq.Type <= ReadRequest) {
+	if !(CreateRequest <= rq.Type && rq.Type <= RotateKeysRequest) {
 		res = append(res, errors.New(unknownRequestTypeErrorMsg))
 	}
 
@@ -130,12 +157,12 @@ func (rq *UserRequest) sanitizeAndCheckParams() []error {
 	case CreateRequest:
 		rq.Fields = []string{}
 
-		if parsedKey, err := core.PublicStringToAsymKey(rq.Data.EncKey); err == nil {
+		if parsedKey, err := core.PublicStringToRsaKey(rq.Data.EncKey); err == nil {
 			rq.Data.encKeyObject = parsedKey
 		} else {
 			res = append(res, err)
 		}
-		if parsedKey, err := core.PublicStringToAsymKey(rq.Data.SignKey); err == nil {
+		if parsedKey, err := core.PublicStringToRsaKey(rq.Data.SignKey); err == nil {
 			rq.Data.signKeyObject = parsedKey
 		} else {
 			res = append(res, err)
@@ -151,14 +178,14 @@ func (rq *UserRequest) sanitizeAndCheckParams() []error {
 		rq.sanitizeFieldsUpdated()
 
 		if contains(rq.Fields, "encKey") {
-			if parsedKey, err := core.PublicStringToAsymKey(rq.Data.EncKey); err == nil {
+			if parsedKey, err := core.PublicStringToRsaKey(rq.Data.EncKey); err == nil {
 				rq.Data.encKeyObject = parsedKey
 			} else {
 				res = append(res, err)
 			}
 		}
 		if contains(rq.Fields, "signKey") {
-			if parsedKey, err := core.PublicStringToAsymKey(rq.Data.SignKey); err == nil {
+			if parsedKey, err := core.PublicStringToRsaKey(rq.Data.SignKey); err == nil {
 				rq.Data.signKeyObject = parsedKey
 			} else {
 				res = append(res, err)
@@ -169,6 +196,25 @@ func (rq *UserRequest) sanitizeAndCheckParams() []error {
 			res = append(res, errors.New(noFieldsUpdatedErrorMsg))
 		}
 
+	/*
+		For key rotation requests:
+			* Update both keys
+			* Parse both public keys
+	*/
+	case RotateKeysRequest:
+		rq.Fields = append([]string{}, rotateKeysFields...)
+
+		if parsedKey, err := core.PublicStringToRsaKey(rq.Data.EncKey); err == nil {
+			rq.Data.encKeyObject = parsedKey
+		} else {
+			res = append(res, err)
+		}
+		if parsedKey, err := core.PublicStringToRsaKey(rq.Data.SignKey); err == nil {
+			rq.Data.signKeyObject = parsedKey
+		} else {
+			res = append(res, err)
+		}
+
 	/*
 		For read requests:
 			* Check there are user ids requested
@@ -185,13 +231,57 @@ func (rq *UserRequest) sanitizeAndCheckParams() []error {
 var sanitizeFieldsUpdatedAllowed map[string]bool = map[string]bool{
 	"encKey":                             true,
 	"signKey":                            true,
+	"encKey.revoke":                      true,
+	"signKey.revoke":                     true,
 	"permissions.channel.add":            true,
 	"permissions.user.add":               true,
 	"permissions.user.remove":            true,
 	"permissions.user.encKeyUpdate":      true,
 	"permissions.user.signKeyUpdate":     true,
 	"permissions.user.permissionsUpdate": true,
-	"active": true,
+	"active":                             true,
+	"deactivate":                         true,
+	"reactivate":                         true,
+}
+
+/*
+	Order in which updated fields are applied, regardless of the order they're listed in:
+	  - Key revocations, then key updates (a key revoked and set in the same request stays revoked)
+	  - Permissions
+	  - Deactivation, then activity, then reactivation
+
+	Fields not listed keep their relative order after these
+*/
+var fieldsUpdatedOrder []string = []string{
+	"encKey.revoke",
+	"signKey.revoke",
+	"encKey",
+	"signKey",
+	"permissions.channel.add",
+	"permissions.user.add",
+	"permissions.user.remove",
+	"permissions.user.encKeyUpdate",
+	"permissions.user.signKeyUpdate",
+	"permissions.user.permissionsUpdate",
+	"deactivate",
+	"active",
+	"reactivate",
+}
+
+func sortFieldsUpdated(fields []string) []string {
+	rank := func(field string) int {
+		for index, ordered := range fieldsUpdatedOrder {
+			if ordered == field {
+				return index
+			}
+		}
+		return len(fieldsUpdatedOrder)
+	}
+	sorted := append([]string{}, fields...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	return sorted
 }
 
 func (rq *UserRequest) sanitizeFieldsUpdated() {
@@ -199,6 +289,8 @@ func (rq *UserRequest) sanitizeFieldsUpdated() {
 	for _, field := range rq.Fields {
 		if sanitizeFieldsUpdatedAllowed[field] {
 			newSlice = append(newSlice, field)
+		} else if !contains(rq.droppedFields, field) {
+			rq.droppedFields = append(rq.droppedFields, field)
 		}
 	}
 	rq.Fields = newSlice
@@ -206,9 +298,10 @@ func (rq *UserRequest) sanitizeFieldsUpdated() {
 
 /*
 	User request encoding
+	(canonical since requests are signed as operation payloads)
 */
 func (usr *UserRequest) Encode() ([]byte, error) {
-	jsonStream, err := json.Marshal(usr)
+	jsonStream, err := core.CanonicalJSON(usr)
 
 	if err != nil {
 		return nil, err
@@ -257,9 +257,10 @@ func TestDecodeAndVerifyInvalidFieldsUpdateRequest(t *testing.T) {
 	}
 
 	expected := UserRequest{
-		Type:    UpdateRequest,
-		Fields:  []string{"active"},
-		signers: signers,
+		Type:          UpdateRequest,
+		Fields:        []string{"active"},
+		signers:       signers,
+		droppedFields: []string{"randomParam"},
 	}
 
 	if !reflect.DeepEqual(rq, expected) {
@@ -2,6 +2,11 @@ package users
 
 import (
 	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"github.com/mngharbi/DMPC/core"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -11,45 +16,93 @@ import (
 	Keeps track of granual timestamps for changes
 */
 type keyRecord struct {
-	Key       rsa.PublicKey
-	UpdatedAt time.Time
+	Key       rsa.PublicKey `json:"key"`
+	Revoked   bool          `json:"revoked"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+	Sequence  uint64        `json:"sequence"`
+	// Key replaced by the last rotation (kept to verify in-flight operations)
+	Previous          *rsa.PublicKey `json:"previous"`
+	PreviousRevokedAt time.Time      `json:"previousRevokedAt"`
 }
+
+/*
+	How long keys replaced by a rotation are still accepted
+*/
+const rotatedKeyRetention time.Duration = 5 * time.Minute
+
 type booleanRecord struct {
-	Ok        bool
-	UpdatedAt time.Time
+	Ok        bool      `json:"ok"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Sequence  uint64    `json:"sequence"`
 }
 
 type permissionsRecord struct {
-	Channel   channelPermissionsRecord
-	User      userPermissionsRecord
-	UpdatedAt time.Time
+	Channel   channelPermissionsRecord `json:"channel"`
+	User      userPermissionsRecord    `json:"user"`
+	UpdatedAt time.Time                `json:"updatedAt"`
 }
 
 type channelPermissionsRecord struct {
-	Add       booleanRecord
-	UpdatedAt time.Time
+	Add       booleanRecord `json:"add"`
+	UpdatedAt time.Time     `json:"updatedAt"`
 }
 
 type userPermissionsRecord struct {
-	Add               booleanRecord
-	Remove            booleanRecord
-	EncKeyUpdate      booleanRecord
-	SignKeyUpdate     booleanRecord
-	PermissionsUpdate booleanRecord
-	UpdatedAt         time.Time
+	Add               booleanRecord `json:"add"`
+	Remove            booleanRecord `json:"remove"`
+	EncKeyUpdate      booleanRecord `json:"encKeyUpdate"`
+	SignKeyUpdate     booleanRecord `json:"signKeyUpdate"`
+	PermissionsUpdate booleanRecord `json:"permissionsUpdate"`
+	UpdatedAt         time.Time     `json:"updatedAt"`
+}
+
+/*
+	Explicit activity change (who requested it and when)
+*/
+type activityChangeRecord struct {
+	By string    `json:"by"`
+	At time.Time `json:"at"`
 }
 
 type userRecord struct {
-	Id          string
-	EncKey      keyRecord
-	SignKey     keyRecord
-	Permissions permissionsRecord
-	Active      booleanRecord
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	lock        *sync.RWMutex
+	Id           string               `json:"id"`
+	EncKey       keyRecord            `json:"encKey"`
+	SignKey      keyRecord            `json:"signKey"`
+	Permissions  permissionsRecord    `json:"permissions"`
+	Active       booleanRecord        `json:"active"`
+	Deactivation activityChangeRecord `json:"deactivation"`
+	Reactivation activityChangeRecord `json:"reactivation"`
+	CreatedAt    time.Time            `json:"createdAt"`
+	UpdatedAt    time.Time            `json:"updatedAt"`
+	History      *updateHistory       `json:"history"`
+	lock         *sync.RWMutex
+	dataLock     *sync.RWMutex
+}
+
+/*
+	Append only history of update events applied to a record
+*/
+type UpdateEvent struct {
+	Field     string    `json:"field"`
+	OldValue  string    `json:"oldValue"`
+	NewValue  string    `json:"newValue"`
+	Timestamp time.Time `json:"timestamp"`
+	Sequence  uint64    `json:"sequence"`
+	Applied   bool      `json:"applied"`
 }
 
+type updateHistory struct {
+	Events []UpdateEvent `json:"events"`
+	// Latest timestamp of applied events dropped from history (zero if none)
+	CompactedUntil time.Time `json:"compactedUntil"`
+}
+
+/*
+	Maximum number of events kept in a record history (oldest events are dropped first)
+*/
+const maxHistoryEvents int = 1024
+
 func (rec *userRecord) Less(index string, than interface{}) bool {
 	switch index {
 	case "id":
@@ -59,73 +112,564 @@ func (rec *userRecord) Less(index string, than interface{}) bool {
 }
 
 /*
-	Record update (run in a mutex context)
+	Key fingerprints
+*/
+func (record *userRecord) EncKeyFingerprint() string {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+	return core.KeyFingerprint(&record.EncKey.Key)
+}
+
+func (record *userRecord) SignKeyFingerprint() string {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+	return core.KeyFingerprint(&record.SignKey.Key)
+}
+
+/*
+	Update history
+*/
+func (record *userRecord) HistoryForField(field string) []UpdateEvent {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+
+	events := []UpdateEvent{}
+	if record.History == nil {
+		return events
+	}
+	for _, event := range record.History.Events {
+		if event.Field == field {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// String value of a record field used in history (keys are fingerprinted)
+func (record *userRecord) fieldValue(field string) (string, bool) {
+	switch field {
+	case "active", "deactivate", "reactivate":
+		return strconv.FormatBool(record.Active.Ok), true
+	case "encKey":
+		return core.KeyFingerprint(&record.EncKey.Key), true
+	case "signKey":
+		return core.KeyFingerprint(&record.SignKey.Key), true
+	case "encKey.revoke":
+		return strconv.FormatBool(record.EncKey.Revoked), true
+	case "signKey.revoke":
+		return strconv.FormatBool(record.SignKey.Revoked), true
+	case "permissions.channel.add":
+		return strconv.FormatBool(record.Permissions.Channel.Add.Ok), true
+	case "permissions.user.add":
+		return strconv.FormatBool(record.Permissions.User.Add.Ok), true
+	case "permissions.user.remove":
+		return strconv.FormatBool(record.Permissions.User.Remove.Ok), true
+	case "permissions.user.encKeyUpdate":
+		return strconv.FormatBool(record.Permissions.User.EncKeyUpdate.Ok), true
+	case "permissions.user.signKeyUpdate":
+		return strconv.FormatBool(record.Permissions.User.SignKeyUpdate.Ok), true
+	case "permissions.user.permissionsUpdate":
+		return strconv.FormatBool(record.Permissions.User.PermissionsUpdate.Ok), true
+	}
+	return "", false
+}
+
+// String value of a requested field used in history (keys are fingerprinted)
+func (usr *UserObject) fieldValue(field string) (string, bool) {
+	switch field {
+	case "active":
+		return strconv.FormatBool(usr.Active), true
+	case "deactivate":
+		return strconv.FormatBool(false), true
+	case "reactivate":
+		return strconv.FormatBool(true), true
+	case "encKey":
+		return core.KeyFingerprint(usr.encKeyObject), true
+	case "signKey":
+		return core.KeyFingerprint(usr.signKeyObject), true
+	case "encKey.revoke", "signKey.revoke":
+		return strconv.FormatBool(true), true
+	case "permissions.channel.add":
+		return strconv.FormatBool(usr.Permissions.Channel.Add), true
+	case "permissions.user.add":
+		return strconv.FormatBool(usr.Permissions.User.Add), true
+	case "permissions.user.remove":
+		return strconv.FormatBool(usr.Permissions.User.Remove), true
+	case "permissions.user.encKeyUpdate":
+		return strconv.FormatBool(usr.Permissions.User.EncKeyUpdate), true
+	case "permissions.user.signKeyUpdate":
+		return strconv.FormatBool(usr.Permissions.User.SignKeyUpdate), true
+	case "permissions.user.permissionsUpdate":
+		return strconv.FormatBool(usr.Permissions.User.PermissionsUpdate), true
+	}
+	return "", false
+}
+
+/*
+	Permission queries
+*/
+func (record *userRecord) readBoolean(boolRec *booleanRecord) bool {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+	return boolRec.Ok
+}
+
+func (record *userRecord) IsActive() bool {
+	return record.readBoolean(&record.Active)
+}
+
+func (record *userRecord) CanAddChannel() bool {
+	return record.readBoolean(&record.Permissions.Channel.Add)
+}
+
+func (record *userRecord) CanAddUser() bool {
+	return record.readBoolean(&record.Permissions.User.Add)
+}
+
+func (record *userRecord) CanRemoveUser() bool {
+	return record.readBoolean(&record.Permissions.User.Remove)
+}
+
+func (record *userRecord) CanUpdateEncKey() bool {
+	return record.readBoolean(&record.Permissions.User.EncKeyUpdate)
+}
+
+func (record *userRecord) CanUpdateSignKey() bool {
+	return record.readBoolean(&record.Permissions.User.SignKeyUpdate)
+}
+
+func (record *userRecord) CanUpdatePermissions() bool {
+	return record.readBoolean(&record.Permissions.User.PermissionsUpdate)
+}
+
+/*
+	Historical permission queries
+*/
+const (
+	unknownPermissionErrorMsg string = "Unknown permission field"
+	userNotCreatedErrorMsg    string = "User did not exist at the time requested"
+	historyCompactedErrorMsg  string = "History no longer covers the time requested"
+)
+
+/*
+	Permission value as of a past time, reconstructed from applied events in history
+	(the newest event by sequence and timestamp, like when applying, among the ones issued by then,
+	or the value before the first applied event if there are none)
+*/
+func (record *userRecord) PermissionAt(field string, at time.Time) (bool, error) {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+
+	currentValue, known := record.fieldValue(field)
+	if !known || !strings.HasPrefix(field, "permissions.") {
+		return false, errors.New(unknownPermissionErrorMsg)
+	}
+	if at.Before(record.CreatedAt) {
+		return false, errors.New(userNotCreatedErrorMsg)
+	}
+
+	if record.History == nil {
+		return strconv.ParseBool(currentValue)
+	}
+	if at.Before(record.History.CompactedUntil) {
+		return false, errors.New(historyCompactedErrorMsg)
+	}
+
+	value := currentValue
+	var latest *UpdateEvent
+	initialized := false
+	for i := range record.History.Events {
+		event := &record.History.Events[i]
+		if event.Field != field || !event.Applied {
+			continue
+		}
+		if !initialized {
+			value = event.OldValue
+			initialized = true
+		}
+		if !event.Timestamp.After(at) &&
+			(latest == nil || isNewerUpdate(event.Sequence, event.Timestamp, latest.Sequence, latest.Timestamp)) {
+			latest = event
+		}
+	}
+	if latest != nil {
+		value = latest.NewValue
+	}
+	return strconv.ParseBool(value)
+}
+
+/*
+	Record JSON encoding (keys are PEM encoded)
+*/
+type keyRecordJson struct {
+	Key               string    `json:"key"`
+	Revoked           bool      `json:"revoked"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+	Sequence          uint64    `json:"sequence"`
+	Previous          string    `json:"previous,omitempty"`
+	PreviousRevokedAt time.Time `json:"previousRevokedAt"`
+}
+
+func (keyRec keyRecord) MarshalJSON() ([]byte, error) {
+	keyEncoded, err := core.PublicAsymKeyToString(&keyRec.Key)
+	if err != nil {
+		return nil, err
+	}
+	var previousEncoded string
+	if keyRec.Previous != nil {
+		if previousEncoded, err = core.PublicAsymKeyToString(keyRec.Previous); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(&keyRecordJson{
+		Key:               keyEncoded,
+		Revoked:           keyRec.Revoked,
+		ExpiresAt:         keyRec.ExpiresAt,
+		UpdatedAt:         keyRec.UpdatedAt,
+		Sequence:          keyRec.Sequence,
+		Previous:          previousEncoded,
+		PreviousRevokedAt: keyRec.PreviousRevokedAt,
+	})
+}
+
+func (keyRec *keyRecord) UnmarshalJSON(stream []byte) error {
+	var decoded keyRecordJson
+	if err := json.Unmarshal(stream, &decoded); err != nil {
+		return err
+	}
+	key, err := core.PublicStringToRsaKey(decoded.Key)
+	if err != nil {
+		return err
+	}
+	keyRec.Key = *key
+	keyRec.Revoked = decoded.Revoked
+	keyRec.ExpiresAt = decoded.ExpiresAt
+	keyRec.UpdatedAt = decoded.UpdatedAt
+	keyRec.Sequence = decoded.Sequence
+	keyRec.Previous = nil
+	if len(decoded.Previous) != 0 {
+		if keyRec.Previous, err = core.PublicStringToRsaKey(decoded.Previous); err != nil {
+			return err
+		}
+	}
+	keyRec.PreviousRevokedAt = decoded.PreviousRevokedAt
+	return nil
+}
+
+func (record *userRecord) UnmarshalJSON(stream []byte) error {
+	type userRecordJson userRecord
+	if err := json.Unmarshal(stream, (*userRecordJson)(record)); err != nil {
+		return err
+	}
+	if record.History == nil {
+		record.History = &updateHistory{}
+	}
+	record.lock = &sync.RWMutex{}
+	record.dataLock = &sync.RWMutex{}
+	return nil
+}
+
+/*
+	Summary of an update request application
+	(fields that took effect, fields skipped because the record has more recent values,
+	and fields that were not recognized)
 */
-func (record *userRecord) applyUpdateRequest(req *UserRequest) {
-	for _, field := range req.Fields {
+type UpdateResult struct {
+	Applied []string `json:"applied"`
+	Skipped []string `json:"skipped"`
+	Unknown []string `json:"unknown"`
+}
+
+func newUpdateResult() *UpdateResult {
+	return &UpdateResult{
+		Applied: []string{},
+		Skipped: []string{},
+		Unknown: []string{},
+	}
+}
+
+func (result *UpdateResult) add(field string, applied bool) {
+	if applied {
+		result.Applied = append(result.Applied, field)
+	} else {
+		result.Skipped = append(result.Skipped, field)
+	}
+}
+
+/*
+	Record update
+	(data lock is held for the whole update, and record timestamps only move forward
+	so that concurrent updates yield the same result as serial ones)
+	Returns which fields were applied, skipped, or unrecognized (valid fields are still applied)
+	Request data only needs to populate the fields named in the request fields
+	Fields named more than once are only applied once, and in the canonical order of fields
+	Key rotations update both keys or neither
+*/
+func (record *userRecord) applyUpdateRequest(req *UserRequest) *UpdateResult {
+	record.dataLock.Lock()
+	defer record.dataLock.Unlock()
+
+	result := newUpdateResult()
+	if req.Type == RotateKeysRequest {
+		applied := record.applyKeyRotation(req)
+		for _, field := range rotateKeysFields {
+			result.add(field, applied)
+		}
+		return result
+	}
+
+	for _, field := range sortFieldsUpdated(uniqueStrings(req.Fields)) {
+		oldValue, known := record.fieldValue(field)
+		if !known {
+			result.Unknown = append(result.Unknown, field)
+			continue
+		}
+		newValue, _ := req.Data.fieldValue(field)
+		applied := false
+
+		// Each field only reads its own sub-field of the request data
 		switch field {
 		case "active":
-			if record.Active.update(req.Data.Active, req.Timestamp) {
-				record.UpdatedAt = req.Timestamp
+			// Explicitly deactivated users can only be reactivated explicitly
+			if !req.Data.Active || !record.isDeactivated() {
+				applied = record.Active.update(req.Data.Active, req.Sequence, req.Timestamp)
 			}
+		case "deactivate":
+			applied = record.changeActivity(false, &record.Deactivation, req)
+		case "reactivate":
+			applied = record.changeActivity(true, &record.Reactivation, req)
 		case "encKey":
-			if record.EncKey.update(*req.Data.encKeyObject, req.Timestamp) {
-				record.UpdatedAt = req.Timestamp
+			if req.Data.encKeyObject != nil {
+				applied = record.EncKey.update(*req.Data.encKeyObject, req.Data.EncKeyExpiresAt, req.Sequence, req.Timestamp)
 			}
 		case "signKey":
-			if record.SignKey.update(*req.Data.signKeyObject, req.Timestamp) {
-				record.UpdatedAt = req.Timestamp
+			if req.Data.signKeyObject != nil {
+				applied = record.SignKey.update(*req.Data.signKeyObject, req.Data.SignKeyExpiresAt, req.Sequence, req.Timestamp)
 			}
+		case "encKey.revoke":
+			applied = record.EncKey.revoke(req.Sequence, req.Timestamp)
+		case "signKey.revoke":
+			applied = record.SignKey.revoke(req.Sequence, req.Timestamp)
 		case "permissions.channel.add":
-			if record.Permissions.Channel.Add.update(req.Data.Permissions.Channel.Add, req.Timestamp) {
-				record.UpdatedAt = req.Timestamp
-				record.Permissions.UpdatedAt = req.Timestamp
-				record.Permissions.Channel.UpdatedAt = req.Timestamp
-			}
+			applied = record.applyChannelPermissionUpdate(&record.Permissions.Channel.Add, req.Data.Permissions.Channel.Add, req.Sequence, req.Timestamp)
+		case "permissions.user.add":
+			applied = record.applyUserPermissionUpdate(&record.Permissions.User.Add, req.Data.Permissions.User.Add, req.Sequence, req.Timestamp)
+		case "permissions.user.remove":
+			applied = record.applyUserPermissionUpdate(&record.Permissions.User.Remove, req.Data.Permissions.User.Remove, req.Sequence, req.Timestamp)
+		case "permissions.user.encKeyUpdate":
+			applied = record.applyUserPermissionUpdate(&record.Permissions.User.EncKeyUpdate, req.Data.Permissions.User.EncKeyUpdate, req.Sequence, req.Timestamp)
+		case "permissions.user.signKeyUpdate":
+			applied = record.applyUserPermissionUpdate(&record.Permissions.User.SignKeyUpdate, req.Data.Permissions.User.SignKeyUpdate, req.Sequence, req.Timestamp)
+		case "permissions.user.permissionsUpdate":
+			applied = record.applyUserPermissionUpdate(&record.Permissions.User.PermissionsUpdate, req.Data.Permissions.User.PermissionsUpdate, req.Sequence, req.Timestamp)
+		}
 
-		case "permissions.user.add", "permissions.user.remove", "permissions.user.encKeyUpdate", "permissions.user.signKeyUpdate", "permissions.user.permissionsUpdate":
-			var perm *booleanRecord
-			var reqVal bool
-			switch field {
-			case "permissions.user.add":
-				perm = &record.Permissions.User.Add
-				reqVal = req.Data.Permissions.User.Add
-			case "permissions.user.remove":
-				perm = &record.Permissions.User.Remove
-				reqVal = req.Data.Permissions.User.Remove
-			case "permissions.user.encKeyUpdate":
-				perm = &record.Permissions.User.EncKeyUpdate
-				reqVal = req.Data.Permissions.User.EncKeyUpdate
-			case "permissions.user.signKeyUpdate":
-				perm = &record.Permissions.User.SignKeyUpdate
-				reqVal = req.Data.Permissions.User.SignKeyUpdate
-			case "permissions.user.permissionsUpdate":
-				perm = &record.Permissions.User.PermissionsUpdate
-				reqVal = req.Data.Permissions.User.PermissionsUpdate
-			}
+		if applied {
+			updateTimestamp(&record.UpdatedAt, req.Timestamp)
+		}
 
-			if perm.update(reqVal, req.Timestamp) {
-				record.UpdatedAt = req.Timestamp
-				record.Permissions.UpdatedAt = req.Timestamp
-				record.Permissions.User.UpdatedAt = req.Timestamp
-			}
+		record.recordEvent(field, oldValue, newValue, req.Sequence, req.Timestamp, applied)
+		result.add(field, applied)
+	}
+
+	return result
+}
+
+/*
+	Atomic key rotation
+	(both keys need to be valid and older than the request, and replaced keys are
+	retained with their revoke timestamp)
+	Data lock should be held
+*/
+func (record *userRecord) applyKeyRotation(req *UserRequest) bool {
+	oldValues := map[string]string{}
+	for _, field := range rotateKeysFields {
+		oldValues[field], _ = record.fieldValue(field)
+	}
+
+	applied := req.Data.encKeyObject != nil &&
+		req.Data.signKeyObject != nil &&
+		record.EncKey.isOlderThan(req.Sequence, req.Timestamp) &&
+		record.SignKey.isOlderThan(req.Sequence, req.Timestamp)
+	if applied {
+		record.EncKey.rotate(*req.Data.encKeyObject, req.Data.EncKeyExpiresAt, req.Sequence, req.Timestamp)
+		record.SignKey.rotate(*req.Data.signKeyObject, req.Data.SignKeyExpiresAt, req.Sequence, req.Timestamp)
+		updateTimestamp(&record.UpdatedAt, req.Timestamp)
+	}
+
+	for _, field := range rotateKeysFields {
+		newValue := ""
+		if applied {
+			newValue, _ = req.Data.fieldValue(field)
 		}
+		record.recordEvent(field, oldValues[field], newValue, req.Sequence, req.Timestamp, applied)
+	}
+	return applied
+}
+
+/*
+	Record event in history (dropping the oldest events past the maximum)
+	Data lock should be held
+*/
+func (record *userRecord) recordEvent(field string, oldValue string, newValue string, sequence uint64, timestamp time.Time, applied bool) {
+	if record.History == nil {
+		record.History = &updateHistory{}
+	}
+	record.History.Events = append(record.History.Events, UpdateEvent{
+		Field:     field,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Timestamp: timestamp,
+		Sequence:  sequence,
+		Applied:   applied,
+	})
+	record.History.compact()
+}
+
+/*
+	Drops the oldest events past the maximum
+	(queries before the latest applied event dropped can't be answered anymore)
+*/
+func (history *updateHistory) compact() {
+	excess := len(history.Events) - maxHistoryEvents
+	if excess <= 0 {
+		return
+	}
+	for _, event := range history.Events[:excess] {
+		if event.Applied {
+			updateTimestamp(&history.CompactedUntil, event.Timestamp)
+		}
+	}
+	kept := copy(history.Events, history.Events[excess:])
+	history.Events = history.Events[:kept]
+}
+
+/*
+	Explicit activity changes (record the issuer and timestamp if applied)
+	Data lock should be held
+*/
+func (record *userRecord) changeActivity(active bool, change *activityChangeRecord, req *UserRequest) bool {
+	if !record.Active.update(active, req.Sequence, req.Timestamp) {
+		return false
+	}
+	change.At = req.Timestamp
+	change.By = ""
+	if req.signers != nil {
+		change.By = req.signers.IssuerId
+	}
+	return true
+}
+
+func (record *userRecord) isDeactivated() bool {
+	return record.Deactivation.At.After(record.Reactivation.At)
+}
+
+/*
+	Permission updates (also move permission group timestamps forward if applied)
+	Data lock should be held
+*/
+func (record *userRecord) applyChannelPermissionUpdate(perm *booleanRecord, val bool, sequence uint64, timestamp time.Time) bool {
+	if !perm.update(val, sequence, timestamp) {
+		return false
+	}
+	updateTimestamp(&record.Permissions.UpdatedAt, timestamp)
+	updateTimestamp(&record.Permissions.Channel.UpdatedAt, timestamp)
+	return true
+}
+
+func (record *userRecord) applyUserPermissionUpdate(perm *booleanRecord, val bool, sequence uint64, timestamp time.Time) bool {
+	if !perm.update(val, sequence, timestamp) {
+		return false
+	}
+	updateTimestamp(&record.Permissions.UpdatedAt, timestamp)
+	updateTimestamp(&record.Permissions.User.UpdatedAt, timestamp)
+	return true
+}
+
+func updateTimestamp(timestamp *time.Time, val time.Time) {
+	if val.After(*timestamp) {
+		*timestamp = val
+	}
+}
+
+/*
+	Updates are ordered by sequence number when both have one,
+	and by timestamp otherwise (or if sequence numbers are equal)
+*/
+func isNewerUpdate(sequence uint64, time time.Time, currentSequence uint64, currentTime time.Time) bool {
+	if sequence != 0 && currentSequence != 0 && sequence != currentSequence {
+		return sequence > currentSequence
 	}
+	return time.After(currentTime)
 }
 
-func (perm *booleanRecord) update(val bool, time time.Time) bool {
-	if time.After(perm.UpdatedAt) {
+func (perm *booleanRecord) update(val bool, sequence uint64, time time.Time) bool {
+	if isNewerUpdate(sequence, time, perm.Sequence, perm.UpdatedAt) {
 		perm.Ok = val
 		perm.UpdatedAt = time
+		perm.Sequence = sequence
 		return true
 	}
 	return false
 }
 
-func (keyRec *keyRecord) update(val rsa.PublicKey, time time.Time) bool {
-	if time.After(keyRec.UpdatedAt) {
+func (keyRec *keyRecord) isOlderThan(sequence uint64, time time.Time) bool {
+	return isNewerUpdate(sequence, time, keyRec.Sequence, keyRec.UpdatedAt)
+}
+
+func (keyRec *keyRecord) update(val rsa.PublicKey, expiresAt time.Time, sequence uint64, time time.Time) bool {
+	if keyRec.isOlderThan(sequence, time) {
 		keyRec.Key = val
+		keyRec.Revoked = false
+		keyRec.ExpiresAt = expiresAt
 		keyRec.UpdatedAt = time
+		keyRec.Sequence = sequence
+		return true
+	}
+	return false
+}
+
+func (keyRec *keyRecord) rotate(val rsa.PublicKey, expiresAt time.Time, sequence uint64, time time.Time) bool {
+	previous := keyRec.Key
+	if !keyRec.update(val, expiresAt, sequence, time) {
+		return false
+	}
+	keyRec.Previous = &previous
+	keyRec.PreviousRevokedAt = time
+	return true
+}
+
+/*
+	Key replaced by the last rotation if still retained at the time given
+*/
+func (keyRec *keyRecord) RetainedPreviousKey(now time.Time) *rsa.PublicKey {
+	if keyRec.Previous == nil || !isRetainedAt(keyRec.PreviousRevokedAt, now) {
+		return nil
+	}
+	return keyRec.Previous
+}
+
+func isRetainedAt(revokedAt time.Time, now time.Time) bool {
+	return now.Before(revokedAt.Add(rotatedKeyRetention))
+}
+
+/*
+	Keys without an expiry never expire
+*/
+func (keyRec *keyRecord) IsExpired(now time.Time) bool {
+	return isExpiredAt(keyRec.ExpiresAt, now)
+}
+
+func isExpiredAt(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+func (keyRec *keyRecord) revoke(sequence uint64, time time.Time) bool {
+	if keyRec.isOlderThan(sequence, time) {
+		keyRec.Revoked = true
+		keyRec.UpdatedAt = time
+		keyRec.Sequence = sequence
 		return true
 	}
 	return false
@@ -135,43 +679,46 @@ func (keyRec *keyRecord) update(val rsa.PublicKey, time time.Time) bool {
 	Create user record from creation request
 */
 func (record *userRecord) create(req *UserRequest) {
+	record.dataLock.Lock()
+	defer record.dataLock.Unlock()
+
 	// Id
 	record.Id = req.Data.Id
 
 	// Active
-	record.Active.update(req.Data.Active, req.Timestamp)
+	record.Active.update(req.Data.Active, req.Sequence, req.Timestamp)
 
 	/*
 		Keys
 	*/
 
 	// Encryption key
-	record.EncKey.update(*req.Data.encKeyObject, req.Timestamp)
+	record.EncKey.update(*req.Data.encKeyObject, req.Data.EncKeyExpiresAt, req.Sequence, req.Timestamp)
 
 	// Signature key
-	record.SignKey.update(*req.Data.signKeyObject, req.Timestamp)
+	record.SignKey.update(*req.Data.signKeyObject, req.Data.SignKeyExpiresAt, req.Sequence, req.Timestamp)
 
 	/*
 		Permissions
 	*/
 
 	// Permissions: Channel add
-	record.Permissions.Channel.Add.update(req.Data.Permissions.Channel.Add, req.Timestamp)
+	record.Permissions.Channel.Add.update(req.Data.Permissions.Channel.Add, req.Sequence, req.Timestamp)
 
 	// Permissions: User add
-	record.Permissions.User.Add.update(req.Data.Permissions.User.Add, req.Timestamp)
+	record.Permissions.User.Add.update(req.Data.Permissions.User.Add, req.Sequence, req.Timestamp)
 
 	// Permissions: User remove
-	record.Permissions.User.Remove.update(req.Data.Permissions.User.Remove, req.Timestamp)
+	record.Permissions.User.Remove.update(req.Data.Permissions.User.Remove, req.Sequence, req.Timestamp)
 
 	// Permissions: User Encryption Key Update
-	record.Permissions.User.EncKeyUpdate.update(req.Data.Permissions.User.EncKeyUpdate, req.Timestamp)
+	record.Permissions.User.EncKeyUpdate.update(req.Data.Permissions.User.EncKeyUpdate, req.Sequence, req.Timestamp)
 
 	// Permissions: User Signature Key Update
-	record.Permissions.User.SignKeyUpdate.update(req.Data.Permissions.User.SignKeyUpdate, req.Timestamp)
+	record.Permissions.User.SignKeyUpdate.update(req.Data.Permissions.User.SignKeyUpdate, req.Sequence, req.Timestamp)
 
 	// Permissions: User Permissions Update
-	record.Permissions.User.PermissionsUpdate.update(req.Data.Permissions.User.PermissionsUpdate, req.Timestamp)
+	record.Permissions.User.PermissionsUpdate.update(req.Data.Permissions.User.PermissionsUpdate, req.Sequence, req.Timestamp)
 
 	/*
 		Timestamps
@@ -187,6 +734,9 @@ func (record *userRecord) create(req *UserRequest) {
 	Check permissions on request
 */
 func (record *userRecord) isAuthorized(req *UserRequest) bool {
+	record.dataLock.RLock()
+	defer record.dataLock.RUnlock()
+
 	result := true
 
 	switch req.Type {
@@ -194,7 +744,7 @@ func (record *userRecord) isAuthorized(req *UserRequest) bool {
 		// For creation we need to check user add permission
 		result = record.Permissions.User.Add.Ok
 
-	case UpdateRequest:
+	case UpdateRequest, RotateKeysRequest:
 		isSameUser := req.Data.Id == record.Id
 
 		for _, field := range req.Fields {
@@ -202,11 +752,11 @@ func (record *userRecord) isAuthorized(req *UserRequest) bool {
 				break
 			}
 			switch field {
-			case "active":
+			case "active", "deactivate", "reactivate":
 				result = record.Permissions.User.Remove.Ok
-			case "encKey":
+			case "encKey", "encKey.revoke":
 				result = record.Permissions.User.EncKeyUpdate.Ok || isSameUser
-			case "signKey":
+			case "signKey", "signKey.revoke":
 				result = record.Permissions.User.SignKeyUpdate.Ok || isSameUser
 			case "permissions.channel.add", "permissions.user.add",
 				"permissions.user.remove", "permissions.user.encKeyUpdate",
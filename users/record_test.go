@@ -1,8 +1,13 @@
 package users
 
 import (
+	"crypto/rsa"
+	"encoding/json"
 	"github.com/mngharbi/DMPC/core"
+	"math/rand"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 )
@@ -56,6 +61,8 @@ func testRecord(permissionDefault bool) userRecord {
 		Active:    generateBoolRecord(true),
 		CreatedAt: testRecordTime(),
 		UpdatedAt: testRecordTime(),
+		History:   &updateHistory{},
+		dataLock:  &sync.RWMutex{},
 	}
 }
 
@@ -108,6 +115,39 @@ func TestUpdateRequestActiveSkipped(t *testing.T) {
 	}
 }
 
+func TestUpdateRequestDeactivateReactivate(t *testing.T) {
+	obj := testRecord(true)
+
+	// Explicit deactivation records issuer
+	req := testRequest(UpdateRequest, false)
+	req.Fields = []string{"deactivate"}
+	req.signers = generateGenericSigners()
+	obj.applyUpdateRequest(&req)
+	if obj.Active.Ok || obj.Deactivation.By != "ISSUER_ID" || !obj.Deactivation.At.Equal(testReqTime()) {
+		t.Errorf("Deactivation failed. record=%+v", obj)
+	}
+
+	// Later generic update can't reactivate
+	req = testRequest(UpdateRequest, false)
+	req.Timestamp = testReqTime().Add(time.Hour)
+	req.Fields = []string{"active"}
+	req.Data.Active = true
+	obj.applyUpdateRequest(&req)
+	if obj.Active.Ok {
+		t.Errorf("Generic update should not reactivate deactivated user.")
+	}
+
+	// Explicit reactivation
+	req = testRequest(UpdateRequest, false)
+	req.Timestamp = testReqTime().Add(2 * time.Hour)
+	req.Fields = []string{"reactivate"}
+	req.signers = generateSigners("OTHER_ISSUER", "CERTIFIER_ID")
+	obj.applyUpdateRequest(&req)
+	if !obj.Active.Ok || obj.Reactivation.By != "OTHER_ISSUER" || !obj.Reactivation.At.Equal(req.Timestamp) {
+		t.Errorf("Reactivation failed. record=%+v", obj)
+	}
+}
+
 func TestUpdateRequestEncKey(t *testing.T) {
 	obj := testRecord(true)
 
@@ -178,6 +218,95 @@ func TestUpdateRequestSignKeySkipped(t *testing.T) {
 	}
 }
 
+func TestUpdateRequestSignKeyExpiry(t *testing.T) {
+	obj := testRecord(true)
+	obj.SignKey.ExpiresAt = testReqTime()
+
+	expected := obj
+	expected.SignKey.Key = *core.GeneratePublicKey()
+	expected.SignKey.ExpiresAt = testReqTime().AddDate(1, 0, 0)
+	expected.SignKey.UpdatedAt = testReqTime()
+	expected.UpdatedAt = testReqTime()
+
+	req := testRequest(UpdateRequest, false)
+	req.Data.signKeyObject = &expected.SignKey.Key
+	req.Data.SignKeyExpiresAt = expected.SignKey.ExpiresAt
+	req.Fields = []string{"signKey"}
+
+	obj.applyUpdateRequest(&req)
+
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("Updating signing key with new expiry failed.\n result: %v\n expected: %v\n", obj, expected)
+	}
+}
+
+func TestUpdateRequestSequence(t *testing.T) {
+	obj := testRecord(true)
+	obj.SignKey.Sequence = 5
+	obj.Permissions.User.Add.Sequence = 5
+
+	// Higher sequence wins despite an earlier timestamp
+	req := testRequest(UpdateRequest, true)
+	req.Sequence = 6
+	req.Data.signKeyObject = core.GeneratePublicKey()
+	req.Fields = []string{"signKey"}
+	obj.applyUpdateRequest(&req)
+	if !reflect.DeepEqual(obj.SignKey.Key, *req.Data.signKeyObject) || obj.SignKey.Sequence != 6 || obj.SignKey.UpdatedAt != testReqPastTime() {
+		t.Errorf("Update with higher sequence should apply despite earlier timestamp. found=%+v", obj.SignKey)
+	}
+
+	// Lower sequence loses despite a later timestamp
+	expected := obj
+	req = testRequest(UpdateRequest, false)
+	req.Sequence = 4
+	req.Data.Permissions.User.Add = false
+	req.Fields = []string{"permissions.user.add"}
+	obj.applyUpdateRequest(&req)
+	if !reflect.DeepEqual(obj.Permissions, expected.Permissions) {
+		t.Errorf("Update with lower sequence should be skipped despite later timestamp.\nfound=%+v\nexpected=%+v", obj.Permissions, expected.Permissions)
+	}
+
+	// Equal sequences fall back to timestamps
+	req.Sequence = 5
+	obj.applyUpdateRequest(&req)
+	if obj.Permissions.User.Add.Ok || obj.Permissions.User.Add.UpdatedAt != testReqTime() {
+		t.Errorf("Update with equal sequence and later timestamp should apply. found=%+v", obj.Permissions.User.Add)
+	}
+	req = testRequest(UpdateRequest, true)
+	req.Sequence = 5
+	req.Data.Permissions.User.Add = true
+	req.Fields = []string{"permissions.user.add"}
+	obj.applyUpdateRequest(&req)
+	if obj.Permissions.User.Add.Ok {
+		t.Errorf("Update with equal sequence and earlier timestamp should be skipped. found=%+v", obj.Permissions.User.Add)
+	}
+
+	// Absent sequences fall back to timestamps
+	req.Sequence = 0
+	obj.applyUpdateRequest(&req)
+	if obj.Permissions.User.Add.Ok {
+		t.Errorf("Update without sequence and earlier timestamp should be skipped. found=%+v", obj.Permissions.User.Add)
+	}
+}
+
+func TestKeyRecordExpiry(t *testing.T) {
+	keyRec := generateKeyRecord()
+
+	// No expiry set never expires
+	if keyRec.IsExpired(testReqTime().AddDate(100, 0, 0)) {
+		t.Errorf("Key without expiry should never expire.")
+	}
+
+	// Expiry set
+	keyRec.ExpiresAt = testReqTime()
+	if keyRec.IsExpired(testRecordTime()) {
+		t.Errorf("Key should not be expired before its expiry.")
+	}
+	if !keyRec.IsExpired(testReqTime()) || !keyRec.IsExpired(testReqTime().AddDate(0, 0, 1)) {
+		t.Errorf("Key should be expired starting at its expiry.")
+	}
+}
+
 func TestUpdateRequestPermissionsChannelAdd(t *testing.T) {
 	obj := testRecord(true)
 
@@ -215,6 +344,86 @@ func TestUpdateRequestPermissionsChannelAddSkipped(t *testing.T) {
 	}
 }
 
+func TestUpdateRequestSinglePermission(t *testing.T) {
+	obj := testRecord(true)
+
+	// Only the updated permission changes, others keep their values and timestamps
+	expected := obj
+	expected.Permissions.User.Remove.Ok = false
+	expected.Permissions.User.Remove.UpdatedAt = testReqTime()
+	expected.Permissions.User.UpdatedAt = testReqTime()
+	expected.Permissions.UpdatedAt = testReqTime()
+	expected.UpdatedAt = testReqTime()
+
+	// Data is left zero valued (missing keys are not applied)
+	req := testRequest(UpdateRequest, false)
+	req.Fields = []string{"permissions.user.remove", "encKey"}
+
+	obj.applyUpdateRequest(&req)
+
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("Updating single permission failed.\n result: %v\n expected: %v\n", obj, expected)
+	}
+}
+
+func TestUpdateResult(t *testing.T) {
+	obj := testRecord(false)
+	obj.Active.UpdatedAt = testReqTime().Add(time.Hour)
+
+	req := testRequest(UpdateRequest, false)
+	req.Data.Permissions.User.Add = true
+	req.Data.Active = false
+	req.Fields = []string{"permissions.user.add", "active", "unknown"}
+
+	result := obj.applyUpdateRequest(&req)
+	expected := &UpdateResult{
+		Applied: []string{"permissions.user.add"},
+		Skipped: []string{"active"},
+		Unknown: []string{"unknown"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Update result should list applied, stale, and unknown fields.\n result: %+v\n expected: %+v\n", result, expected)
+	}
+
+	// Key rotation applies both keys or neither
+	rotation := testRequest(RotateKeysRequest, false)
+	result = obj.applyUpdateRequest(&rotation)
+	if !reflect.DeepEqual(result.Skipped, rotateKeysFields) || len(result.Applied) != 0 {
+		t.Errorf("Rotation without keys should skip both keys. result: %+v", result)
+	}
+	rotation.Data.encKeyObject = core.GeneratePublicKey()
+	rotation.Data.signKeyObject = core.GeneratePublicKey()
+	result = obj.applyUpdateRequest(&rotation)
+	if !reflect.DeepEqual(result.Applied, rotateKeysFields) || len(result.Skipped) != 0 {
+		t.Errorf("Rotation should apply both keys. result: %+v", result)
+	}
+}
+
+func TestUpdateRequestDuplicateFields(t *testing.T) {
+	obj := testRecord(false)
+
+	req := testRequest(UpdateRequest, false)
+	req.Data.Permissions.User.Add = true
+	req.Data.Active = false
+	req.Fields = []string{"permissions.user.add", "active", "permissions.user.add", "unknown", "unknown"}
+
+	unknownFields := obj.applyUpdateRequest(&req).Unknown
+
+	if !obj.Permissions.User.Add.Ok || obj.Active.Ok {
+		t.Errorf("Fields should be updated.\n result: %v\n", obj)
+	}
+	if !reflect.DeepEqual(unknownFields, []string{"unknown"}) {
+		t.Errorf("Duplicate unknown field should be returned once. unknownFields=%v", unknownFields)
+	}
+	fields := []string{}
+	for _, event := range obj.History.Events {
+		fields = append(fields, event.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"permissions.user.add", "active"}) {
+		t.Errorf("Duplicate field should be applied exactly once, in first occurrence order. fields=%v", fields)
+	}
+}
+
 func TestUpdateRequestPermissionsUserAdd(t *testing.T) {
 	obj := testRecord(true)
 
@@ -409,15 +618,48 @@ func TestUpdateRequestInvalidUpdate(t *testing.T) {
 	req.Data.Permissions.User.PermissionsUpdate = false
 	req.Fields = []string{"random"}
 
-	obj.applyUpdateRequest(&req)
+	unknownFields := obj.applyUpdateRequest(&req).Unknown
 
 	if !reflect.DeepEqual(obj, expected) {
 		t.Errorf("Update succeeded despite fields updated being invalid.\n result: %v\n expected: %v\n", obj, expected)
 	}
+	if !reflect.DeepEqual(unknownFields, []string{"random"}) {
+		t.Errorf("Invalid field should be reported. found=%v", unknownFields)
+	}
+}
+
+func TestUpdateRequestMixedFields(t *testing.T) {
+	obj := testRecord(true)
+
+	expected := obj
+	expected.Active.Ok = false
+	expected.Active.UpdatedAt = testReqTime()
+	expected.Permissions.User.Add.Ok = false
+	expected.Permissions.User.Add.UpdatedAt = testReqTime()
+	expected.Permissions.User.UpdatedAt = testReqTime()
+	expected.Permissions.UpdatedAt = testReqTime()
+	expected.UpdatedAt = testReqTime()
+
+	req := testRequest(UpdateRequest, false)
+	req.Data.Active = false
+	req.Data.Permissions.User.Add = false
+	req.Fields = []string{"active", "permissions.user.addd", "permissions.user.add", "random"}
+
+	unknownFields := obj.applyUpdateRequest(&req).Unknown
+
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("Valid fields should be updated despite invalid ones.\n result: %v\n expected: %v\n", obj, expected)
+	}
+	if !reflect.DeepEqual(unknownFields, []string{"permissions.user.addd", "random"}) {
+		t.Errorf("Invalid fields should be reported. found=%v", unknownFields)
+	}
 }
 
 func TestCreateRequest(t *testing.T) {
-	obj := userRecord{}
+	obj := userRecord{
+		History:  &updateHistory{},
+		dataLock: &sync.RWMutex{},
+	}
 
 	expected := testRecord(true)
 
@@ -568,3 +810,425 @@ func TestAuthorizationUpdate(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyFingerprints(t *testing.T) {
+	record := testRecord(true)
+	if record.EncKeyFingerprint() != core.KeyFingerprint(&record.EncKey.Key) ||
+		record.SignKeyFingerprint() != core.KeyFingerprint(&record.SignKey.Key) {
+		t.Errorf("Record key fingerprints should match core fingerprints.")
+	}
+	if record.EncKeyFingerprint() == record.SignKeyFingerprint() {
+		t.Errorf("Different keys should have different fingerprints.")
+	}
+
+	copiedRecord := testRecord(true)
+	copiedRecord.EncKey = record.EncKey
+	if copiedRecord.EncKeyFingerprint() != record.EncKeyFingerprint() {
+		t.Errorf("Identical keys should have identical fingerprints.")
+	}
+}
+
+func TestRecordJson(t *testing.T) {
+	record := testRecord(true)
+	record.lock = &sync.RWMutex{}
+	record.Permissions.User.Remove.Ok = false
+	record.Permissions.User.Remove.UpdatedAt = testReqTime()
+	record.Active.UpdatedAt = testReqPastTime()
+	record.SignKey.Previous = core.GeneratePublicKey()
+	record.SignKey.PreviousRevokedAt = testReqTime()
+
+	recordJson, err := json.Marshal(&record)
+	if err != nil {
+		t.Errorf("Record JSON encoding failed. err=%v", err)
+		return
+	}
+
+	var decodedRecord userRecord
+	if err := json.Unmarshal(recordJson, &decodedRecord); err != nil {
+		t.Errorf("Record JSON decoding failed. err=%v", err)
+		return
+	}
+	if !reflect.DeepEqual(record, decodedRecord) {
+		t.Errorf("Record JSON round trip mismatch.\nfound=%+v\nexpected=%+v", decodedRecord, record)
+	}
+
+	// Malformed key should fail decoding
+	if err := json.Unmarshal([]byte(`{"encKey":{"key":"INVALID"}}`), &decodedRecord); err == nil {
+		t.Errorf("Record JSON decoding should fail with malformed key.")
+	}
+}
+
+func TestConcurrentUpdateRequests(t *testing.T) {
+	fields := []string{
+		"active",
+		"encKey",
+		"signKey",
+		"permissions.channel.add",
+		"permissions.user.add",
+		"permissions.user.remove",
+		"permissions.user.encKeyUpdate",
+		"permissions.user.signKeyUpdate",
+		"permissions.user.permissionsUpdate",
+	}
+	keys := []rsa.PublicKey{*core.GeneratePublicKey(), *core.GeneratePublicKey()}
+
+	// Make update requests with distinct timestamps and random fields/values
+	requests := []*UserRequest{}
+	for i := 0; i < 200; i++ {
+		req := testRequest(UpdateRequest, false)
+		req.Timestamp = testRecordTime().Add(time.Duration(1+rand.Intn(1000000)) * time.Second)
+		for _, field := range fields {
+			if rand.Intn(2) == 0 {
+				req.Fields = append(req.Fields, field)
+			}
+		}
+		req.Data.Active = rand.Intn(2) == 0
+		req.Data.encKeyObject = &keys[rand.Intn(len(keys))]
+		req.Data.signKeyObject = &keys[rand.Intn(len(keys))]
+		req.Data.Permissions.Channel.Add = rand.Intn(2) == 0
+		req.Data.Permissions.User.Add = rand.Intn(2) == 0
+		req.Data.Permissions.User.Remove = rand.Intn(2) == 0
+		req.Data.Permissions.User.EncKeyUpdate = rand.Intn(2) == 0
+		req.Data.Permissions.User.SignKeyUpdate = rand.Intn(2) == 0
+		req.Data.Permissions.User.PermissionsUpdate = rand.Intn(2) == 0
+		requests = append(requests, &req)
+	}
+
+	// Apply concurrently
+	initialRecord := testRecord(true)
+	concurrentRecord := initialRecord
+	concurrentRecord.History = &updateHistory{}
+	concurrentRecord.dataLock = &sync.RWMutex{}
+	var wg sync.WaitGroup
+	wg.Add(len(requests))
+	for _, req := range requests {
+		go func(req *UserRequest) {
+			defer wg.Done()
+			concurrentRecord.applyUpdateRequest(req)
+			concurrentRecord.EncKeyFingerprint()
+		}(req)
+	}
+	wg.Wait()
+
+	// Apply serially ordered by timestamp
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Timestamp.Before(requests[j].Timestamp)
+	})
+	serialRecord := initialRecord
+	serialRecord.History = &updateHistory{}
+	serialRecord.dataLock = &sync.RWMutex{}
+	for _, req := range requests {
+		serialRecord.applyUpdateRequest(req)
+	}
+
+	// History order depends on scheduling, only compare number of events
+	if len(concurrentRecord.History.Events) != len(serialRecord.History.Events) {
+		t.Errorf("Concurrent updates history length mismatch. found=%v, expected=%v", len(concurrentRecord.History.Events), len(serialRecord.History.Events))
+	}
+	concurrentRecord.History = nil
+	serialRecord.History = nil
+
+	if !reflect.DeepEqual(concurrentRecord, serialRecord) {
+		t.Errorf("Concurrent updates should match serial updates.\nfound=%+v\nexpected=%+v", concurrentRecord, serialRecord)
+	}
+}
+
+func TestUpdateRequestKeyRevoke(t *testing.T) {
+	obj := testRecord(true)
+	expected := obj
+
+	// Stale revoke should not affect newer key
+	req := testRequest(UpdateRequest, true)
+	req.Fields = []string{"encKey.revoke", "signKey.revoke"}
+	obj.applyUpdateRequest(&req)
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("Stale key revoke should be skipped.\nfound=%+v\nexpected=%+v", obj, expected)
+	}
+
+	// Recent revoke
+	req = testRequest(UpdateRequest, false)
+	req.Fields = []string{"signKey.revoke"}
+	obj.applyUpdateRequest(&req)
+	expected.SignKey.Revoked = true
+	expected.SignKey.UpdatedAt = testReqTime()
+	expected.UpdatedAt = testReqTime()
+	if !reflect.DeepEqual(obj, expected) {
+		t.Errorf("Recent key revoke should apply.\nfound=%+v\nexpected=%+v", obj, expected)
+	}
+
+	// Newer key should clear revocation
+	req = testRequest(UpdateRequest, false)
+	req.Timestamp = testReqTime().Add(time.Hour)
+	req.Fields = []string{"signKey"}
+	newKey := core.GeneratePublicKey()
+	req.Data.signKeyObject = newKey
+	obj.applyUpdateRequest(&req)
+	if obj.SignKey.Revoked || !reflect.DeepEqual(obj.SignKey.Key, *newKey) {
+		t.Errorf("Newer key update should replace revoked key. found=%+v", obj.SignKey)
+	}
+}
+
+func TestUpdateRequestFieldsOrder(t *testing.T) {
+	// Key set before its revocation, and activation before deactivation
+	newKey := core.GeneratePublicKey()
+	fields := []string{"active", "signKey", "deactivate", "signKey.revoke"}
+	for _, order := range [][]string{fields, {"signKey.revoke", "deactivate", "signKey", "active"}} {
+		obj := testRecord(true)
+		previousKey := obj.SignKey.Key
+		req := testRequest(UpdateRequest, false)
+		req.Fields = order
+		req.Data.signKeyObject = newKey
+		req.Data.Active = true
+		result := obj.applyUpdateRequest(&req)
+
+		// Revocation and deactivation are applied first, and win
+		if !obj.SignKey.Revoked || !reflect.DeepEqual(obj.SignKey.Key, previousKey) || obj.Active.Ok {
+			t.Errorf("Revocation and deactivation should win regardless of order. order=%v, found=%+v", order, obj)
+		}
+		expectedApplied := []string{"signKey.revoke", "deactivate"}
+		expectedSkipped := []string{"signKey", "active"}
+		if !reflect.DeepEqual(result.Applied, expectedApplied) || !reflect.DeepEqual(result.Skipped, expectedSkipped) {
+			t.Errorf("Fields should be applied in canonical order. order=%v, result=%+v", order, result)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	obj := testRecord(true)
+	oldEncKey := obj.EncKey.Key
+	oldSignKey := obj.SignKey.Key
+
+	req := testRequest(RotateKeysRequest, false)
+	req.Data.encKeyObject = core.GeneratePublicKey()
+	req.Data.signKeyObject = core.GeneratePublicKey()
+	obj.applyUpdateRequest(&req)
+
+	if !reflect.DeepEqual(obj.EncKey.Key, *req.Data.encKeyObject) || !reflect.DeepEqual(obj.SignKey.Key, *req.Data.signKeyObject) {
+		t.Errorf("Key rotation should replace both keys. found=%+v", obj)
+	}
+	if obj.UpdatedAt != testReqTime() || obj.EncKey.UpdatedAt != testReqTime() || obj.SignKey.UpdatedAt != testReqTime() {
+		t.Errorf("Key rotation should move timestamps forward. found=%+v", obj)
+	}
+
+	// Old keys are retained for a while
+	if previous := obj.SignKey.RetainedPreviousKey(testReqTime()); previous == nil || !reflect.DeepEqual(*previous, oldSignKey) {
+		t.Errorf("Rotated signing key should be retained. found=%v", previous)
+	}
+	if previous := obj.EncKey.RetainedPreviousKey(testReqTime().Add(rotatedKeyRetention / 2)); previous == nil || !reflect.DeepEqual(*previous, oldEncKey) {
+		t.Errorf("Rotated encryption key should be retained. found=%v", previous)
+	}
+	if obj.SignKey.PreviousRevokedAt != testReqTime() {
+		t.Errorf("Rotated key should keep its revoke timestamp. found=%v", obj.SignKey.PreviousRevokedAt)
+	}
+	if previous := obj.SignKey.RetainedPreviousKey(testReqTime().Add(rotatedKeyRetention)); previous != nil {
+		t.Errorf("Rotated key should not be retained after retention period. found=%v", previous)
+	}
+
+	// History records both keys
+	if len(obj.HistoryForField("encKey")) != 1 || len(obj.HistoryForField("signKey")) != 1 {
+		t.Errorf("Key rotation should be recorded for both keys. found=%+v", obj.History.Events)
+	}
+}
+
+func TestKeyRotationAtomic(t *testing.T) {
+	obj := testRecord(true)
+	expectedEncKey := obj.EncKey
+	expectedSignKey := obj.SignKey
+
+	// One invalid key
+	req := testRequest(RotateKeysRequest, false)
+	req.Data.encKeyObject = core.GeneratePublicKey()
+	obj.applyUpdateRequest(&req)
+	if !reflect.DeepEqual(obj.EncKey, expectedEncKey) || !reflect.DeepEqual(obj.SignKey, expectedSignKey) || obj.UpdatedAt != testRecordTime() {
+		t.Errorf("Key rotation with an invalid key should not rotate any key.\nfound=%+v", obj)
+	}
+
+	// One key more recent than the request
+	obj.SignKey.UpdatedAt = testReqTime()
+	expectedSignKey = obj.SignKey
+	req.Data.signKeyObject = core.GeneratePublicKey()
+	obj.applyUpdateRequest(&req)
+	if !reflect.DeepEqual(obj.EncKey, expectedEncKey) || !reflect.DeepEqual(obj.SignKey, expectedSignKey) {
+		t.Errorf("Key rotation stale for one key should not rotate any key.\nfound=%+v", obj)
+	}
+
+	// Skipped rotations are still recorded
+	for _, field := range rotateKeysFields {
+		events := obj.HistoryForField(field)
+		if len(events) != 2 || events[0].Applied || events[1].Applied {
+			t.Errorf("Skipped key rotation should be recorded as not applied. field=%v, events=%+v", field, events)
+		}
+	}
+}
+
+func TestPermissionQueries(t *testing.T) {
+	queries := map[string]struct {
+		query  func(*userRecord) bool
+		record func(*userRecord) *booleanRecord
+	}{
+		"active":            {(*userRecord).IsActive, func(r *userRecord) *booleanRecord { return &r.Active }},
+		"channelAdd":        {(*userRecord).CanAddChannel, func(r *userRecord) *booleanRecord { return &r.Permissions.Channel.Add }},
+		"userAdd":           {(*userRecord).CanAddUser, func(r *userRecord) *booleanRecord { return &r.Permissions.User.Add }},
+		"userRemove":        {(*userRecord).CanRemoveUser, func(r *userRecord) *booleanRecord { return &r.Permissions.User.Remove }},
+		"encKeyUpdate":      {(*userRecord).CanUpdateEncKey, func(r *userRecord) *booleanRecord { return &r.Permissions.User.EncKeyUpdate }},
+		"signKeyUpdate":     {(*userRecord).CanUpdateSignKey, func(r *userRecord) *booleanRecord { return &r.Permissions.User.SignKeyUpdate }},
+		"permissionsUpdate": {(*userRecord).CanUpdatePermissions, func(r *userRecord) *booleanRecord { return &r.Permissions.User.PermissionsUpdate }},
+	}
+
+	for name, query := range queries {
+		for _, permission := range []bool{true, false} {
+			// Only set permission queried and set the rest to the opposite value
+			obj := testRecord(!permission)
+			obj.Active.Ok = !permission
+			query.record(&obj).Ok = permission
+			if query.query(&obj) != permission {
+				t.Errorf("Permission query mismatch. query=%v, expected=%v", name, permission)
+			}
+		}
+	}
+}
+
+func TestPermissionAt(t *testing.T) {
+	obj := testRecord(false)
+	field := "permissions.user.add"
+	firstChange := testRecordTime().Add(24 * time.Hour)
+	secondChange := firstChange.Add(24 * time.Hour)
+
+	// Grant then revoke permission
+	for _, change := range []struct {
+		at    time.Time
+		value bool
+	}{{firstChange, true}, {secondChange, false}} {
+		req := testRequest(UpdateRequest, false)
+		req.Timestamp = change.at
+		req.Fields = []string{field}
+		req.Data.Permissions.User.Add = change.value
+		obj.applyUpdateRequest(&req)
+	}
+
+	expected := map[time.Time]bool{
+		testRecordTime():                 false,
+		firstChange.Add(-time.Second):    false,
+		firstChange:                      true,
+		secondChange.Add(-time.Second):   true,
+		secondChange:                     false,
+		secondChange.Add(24 * time.Hour): false,
+	}
+	for at, expectedValue := range expected {
+		if value, err := obj.PermissionAt(field, at); err != nil || value != expectedValue {
+			t.Errorf("Permission value mismatch. at=%v, found=%v, expected=%v, err=%v", at, value, expectedValue, err)
+		}
+	}
+
+	// Permissions never changed keep their current value
+	if value, err := obj.PermissionAt("permissions.channel.add", firstChange); err != nil || value {
+		t.Errorf("Unchanged permission should keep its value. found=%v, err=%v", value, err)
+	}
+
+	// Sequenced updates are ordered by sequence even if issued earlier
+	sequencedField := "permissions.user.remove"
+	sequencedChange := firstChange.Add(-12 * time.Hour)
+	for i, change := range []struct {
+		at    time.Time
+		value bool
+	}{{firstChange, true}, {sequencedChange, false}} {
+		req := testRequest(UpdateRequest, false)
+		req.Timestamp = change.at
+		req.Sequence = uint64(i + 1)
+		req.Fields = []string{sequencedField}
+		req.Data.Permissions.User.Remove = change.value
+		obj.applyUpdateRequest(&req)
+	}
+	for at, expectedValue := range map[time.Time]bool{
+		sequencedChange.Add(-time.Second): false,
+		sequencedChange:                   false,
+		firstChange:                       false,
+		secondChange:                      false,
+	} {
+		if value, err := obj.PermissionAt(sequencedField, at); err != nil || value != expectedValue {
+			t.Errorf("Sequenced permission value mismatch. at=%v, found=%v, expected=%v, err=%v", at, value, expectedValue, err)
+		}
+	}
+
+	// Invalid queries
+	if _, err := obj.PermissionAt("active", firstChange); err == nil {
+		t.Errorf("Non permission field should fail.")
+	}
+	if _, err := obj.PermissionAt(field, testReqPastTime()); err == nil {
+		t.Errorf("Query before user creation should fail.")
+	}
+}
+
+func TestHistoryCompaction(t *testing.T) {
+	obj := testRecord(false)
+	field := "permissions.user.add"
+	start := testRecordTime().Add(time.Hour)
+
+	// Alternate permission past the maximum history size
+	total := maxHistoryEvents + 10
+	for i := 0; i < total; i++ {
+		req := testRequest(UpdateRequest, false)
+		req.Timestamp = start.Add(time.Duration(i) * time.Minute)
+		req.Fields = []string{field}
+		req.Data.Permissions.User.Add = i%2 == 0
+		obj.applyUpdateRequest(&req)
+	}
+	if len(obj.History.Events) != maxHistoryEvents {
+		t.Fatalf("History should be capped. found=%v, expected=%v", len(obj.History.Events), maxHistoryEvents)
+	}
+	if obj.History.Events[0].Timestamp != start.Add(10*time.Minute) {
+		t.Errorf("Oldest events should be dropped first. found=%v", obj.History.Events[0].Timestamp)
+	}
+
+	// Queries after the dropped events are still answered
+	if value, err := obj.PermissionAt(field, start.Add(9*time.Minute+time.Second)); err != nil || value {
+		t.Errorf("Permission after compaction mismatch. found=%v, err=%v", value, err)
+	}
+	if value, err := obj.PermissionAt(field, start.Add(10*time.Minute)); err != nil || !value {
+		t.Errorf("Permission after compaction mismatch. found=%v, err=%v", value, err)
+	}
+	if _, err := obj.PermissionAt(field, start.Add(8*time.Minute)); err == nil {
+		t.Errorf("Query before compacted events should fail.")
+	}
+}
+
+func TestUpdateHistory(t *testing.T) {
+	obj := testRecord(true)
+	oldEncKeyFingerprint := obj.EncKeyFingerprint()
+
+	// Winning update
+	req := testRequest(UpdateRequest, false)
+	req.Fields = []string{"active", "encKey", "unknown"}
+	req.Data.Active = false
+	newKey := core.GeneratePublicKey()
+	req.Data.encKeyObject = newKey
+	obj.applyUpdateRequest(&req)
+
+	// Losing update
+	staleReq := testRequest(UpdateRequest, true)
+	staleReq.Fields = []string{"active"}
+	staleReq.Data.Active = true
+	obj.applyUpdateRequest(&staleReq)
+
+	expectedActiveHistory := []UpdateEvent{
+		{Field: "active", OldValue: "true", NewValue: "false", Timestamp: testReqTime(), Applied: true},
+		{Field: "active", OldValue: "false", NewValue: "true", Timestamp: testReqPastTime(), Applied: false},
+	}
+	if activeHistory := obj.HistoryForField("active"); !reflect.DeepEqual(activeHistory, expectedActiveHistory) {
+		t.Errorf("Active field history mismatch.\nfound=%+v\nexpected=%+v", activeHistory, expectedActiveHistory)
+	}
+
+	expectedEncKeyHistory := []UpdateEvent{
+		{Field: "encKey", OldValue: oldEncKeyFingerprint, NewValue: core.KeyFingerprint(newKey), Timestamp: testReqTime(), Applied: true},
+	}
+	if encKeyHistory := obj.HistoryForField("encKey"); !reflect.DeepEqual(encKeyHistory, expectedEncKeyHistory) {
+		t.Errorf("Encryption key history mismatch.\nfound=%+v\nexpected=%+v", encKeyHistory, expectedEncKeyHistory)
+	}
+
+	if unknownHistory := obj.HistoryForField("unknown"); len(unknownHistory) != 0 {
+		t.Errorf("Unknown fields should not be recorded in history. found=%+v", unknownHistory)
+	}
+	if len(obj.History.Events) != 3 {
+		t.Errorf("History should have one event per field processed. found=%+v", obj.History.Events)
+	}
+}
@@ -5,6 +5,7 @@
 package users
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"errors"
 	"github.com/mngharbi/DMPC/core"
@@ -29,6 +30,8 @@ func GenerateCreateRequest(userObject *UserObject, timestamp time.Time) *UserReq
 const (
 	signingKeyRequestFailureErrorMsg string = "Unable to make request to retrieve signing key"
 	signingKeyNotFoundErrorMsg       string = "Unable to find signing key for keys provided"
+	signingKeyRevokedErrorMsg        string = "Signing key revoked for keys provided"
+	signingKeyExpiredErrorMsg        string = "Signing key expired for keys provided"
 )
 
 func GetSigningKeysById(ids []string) ([]*rsa.PublicKey, error) {
@@ -51,21 +54,84 @@ func GetSigningKeysById(ids []string) ([]*rsa.PublicKey, error) {
 		return nil, errors.New(signingKeyNotFoundErrorMsg)
 	} else {
 		var keys []*rsa.PublicKey
+		now := serverSingleton.now()
 		for _, userObject := range resp.Data {
+			if userObject.SignKeyRevoked {
+				return nil, errors.New(signingKeyRevokedErrorMsg)
+			}
+			if userObject.IsSignKeyExpired(now) {
+				return nil, errors.New(signingKeyExpiredErrorMsg)
+			}
 			keys = append(keys, userObject.signKeyObject)
 		}
 		return keys, nil
 	}
 }
 
+/*
+	Key expiry checks (keys without an expiry never expire)
+*/
+func (usr *UserObject) IsEncKeyExpired(now time.Time) bool {
+	return isExpiredAt(usr.EncKeyExpiresAt, now)
+}
+
+func (usr *UserObject) IsSignKeyExpired(now time.Time) bool {
+	return isExpiredAt(usr.SignKeyExpiresAt, now)
+}
+
+/*
+	Signing keys accepted at the time given, current key first
+	(the key replaced by the last rotation is still accepted for a while,
+	keys are decoded from their encoding so decoded responses can be used)
+*/
+func (usr *UserObject) VerifyingSignKeys(now time.Time) ([]crypto.PublicKey, error) {
+	signKey, err := core.PublicStringToAsymKey(usr.SignKey)
+	if err != nil {
+		return nil, err
+	}
+	keys := []crypto.PublicKey{signKey}
+	if len(usr.PreviousSignKey) != 0 && isRetainedAt(usr.PreviousSignKeyRevokedAt, now) {
+		previousSignKey, err := core.PublicStringToAsymKey(usr.PreviousSignKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, previousSignKey)
+	}
+	return keys, nil
+}
+
 // Make a user object from a user record
-func (usr *UserObject) createFromRecord(rec *userRecord) {
+func (usr *UserObject) createFromRecord(rec *userRecord) error {
+	rec.dataLock.RLock()
+	defer rec.dataLock.RUnlock()
+
+	encKeyString, err := core.PublicAsymKeyToString(&rec.EncKey.Key)
+	if err != nil {
+		return err
+	}
+	signKeyString, err := core.PublicAsymKeyToString(&rec.SignKey.Key)
+	if err != nil {
+		return err
+	}
+
 	usr.Id = rec.Id
 	usr.encKeyObject = &rec.EncKey.Key
-	usr.EncKey = core.PublicAsymKeyToString(&rec.EncKey.Key)
+	usr.EncKey = encKeyString
 	usr.signKeyObject = &rec.SignKey.Key
-	usr.SignKey = core.PublicAsymKeyToString(&rec.SignKey.Key)
+	usr.SignKey = signKeyString
+	usr.EncKeyRevoked = rec.EncKey.Revoked
+	usr.SignKeyRevoked = rec.SignKey.Revoked
+	usr.EncKeyExpiresAt = rec.EncKey.ExpiresAt
+	usr.SignKeyExpiresAt = rec.SignKey.ExpiresAt
+	usr.PreviousSignKey = ""
+	if rec.SignKey.Previous != nil {
+		if usr.PreviousSignKey, err = core.PublicAsymKeyToString(rec.SignKey.Previous); err != nil {
+			return err
+		}
+	}
+	usr.PreviousSignKeyRevokedAt = rec.SignKey.PreviousRevokedAt
 	usr.Permissions.Channel.Add = rec.Permissions.Channel.Add.Ok
+	usr.Permissions.Channel.AddUpdatedAt = rec.Permissions.Channel.Add.UpdatedAt
 	usr.Permissions.User.Add = rec.Permissions.User.Add.Ok
 	usr.Permissions.User.Remove = rec.Permissions.User.Remove.Ok
 	usr.Permissions.User.EncKeyUpdate = rec.Permissions.User.EncKeyUpdate.Ok
@@ -75,8 +141,13 @@ func (usr *UserObject) createFromRecord(rec *userRecord) {
 	if usr.Active {
 		usr.DisabledAt = rec.Active.UpdatedAt
 	}
+	usr.DeactivatedBy = rec.Deactivation.By
+	usr.DeactivatedAt = rec.Deactivation.At
+	usr.ReactivatedBy = rec.Reactivation.By
+	usr.ReactivatedAt = rec.Reactivation.At
 	usr.CreatedAt = rec.CreatedAt
 	usr.UpdatedAt = rec.UpdatedAt
+	return nil
 }
 
 // Make a dummy user record pointer for search from a user object
@@ -101,3 +172,16 @@ func contains(s []string, e string) bool {
 	}
 	return false
 }
+
+// Removes duplicates keeping first occurrences in order
+func uniqueStrings(s []string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, a := range s {
+		if !seen[a] {
+			seen[a] = true
+			res = append(res, a)
+		}
+	}
+	return res
+}
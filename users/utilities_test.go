@@ -49,3 +49,47 @@ func TestGetSigningKeysById(t *testing.T) {
 
 	ShutdownServer()
 }
+
+func TestGetRevokedSigningKeysById(t *testing.T) {
+	if !resetAndStartServer(t, multipleWorkersConfig()) {
+		return
+	}
+
+	// Create issuer and certifier
+	if !createIssuerAndCertifier(t,
+		false, true, false, false, true, false,
+		false, true, false, false, true, false,
+	) {
+		return
+	}
+
+	// Create user
+	userId := "USER"
+	if _, success := createUser(
+		t, false, "ISSUER", "CERTIFIER", userId, false, false, false, false, false, false,
+	); !success {
+		return
+	}
+	if _, err := GetSigningKeysById([]string{userId}); err != nil {
+		t.Errorf("Getting signing key should not fail before revoking. err=%+v", err)
+	}
+
+	// Revoke signing key
+	serverResponsePtr, ok, success := makeAndGetUserUpdateRequest(
+		t, "ISSUER", "CERTIFIER", []string{"signKey.revoke"}, getJanuaryDate(30), &userId, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil,
+	)
+	if !success {
+		return
+	}
+	if !ok || serverResponsePtr.Result != Success || !serverResponsePtr.Data[0].SignKeyRevoked {
+		t.Errorf("Revoking signing key should succeed, result:%v", *serverResponsePtr)
+		return
+	}
+
+	// Signing key should be refused for verification
+	if keys, err := GetSigningKeysById([]string{"ISSUER", userId}); err == nil {
+		t.Errorf("Getting revoked signing key should fail. keys=%+v", keys)
+	}
+
+	ShutdownServer()
+}